@@ -0,0 +1,453 @@
+// Package callgraph builds call graphs (graphs of which defs call
+// which other defs) from the defs and refs in a store.
+//
+// Toolchains don't emit call graphs. Instead, the caller of a ref is
+// derived from the ref's position: it is the innermost def whose
+// definition (the byte range DefStart..DefEnd) contains the ref, in
+// the same file and source unit as the ref. By default, refs whose
+// kind is graph.RefKindCall are treated as calls, as are refs with no
+// kind (because most toolchains don't classify refs).
+package callgraph
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// A Direction is the direction in which a call graph is built from
+// its root def.
+type Direction string
+
+const (
+	// Callers builds a graph of the defs that call the root def (and,
+	// at greater depths, the defs that call them).
+	Callers Direction = "callers"
+
+	// Callees builds a graph of the defs that the root def calls
+	// (and, at greater depths, the defs that they call).
+	Callees Direction = "callees"
+)
+
+// Options configures how a call graph is built.
+type Options struct {
+	// Direction is the direction in which to follow calls from the
+	// root def.
+	Direction Direction
+
+	// Depth is the maximum number of calls between the root def and
+	// any other def in the graph. It must be at least 1.
+	Depth int
+
+	// RefKinds are the kinds of refs that are treated as calls. If
+	// empty, refs of kind graph.RefKindCall and refs with no kind are
+	// treated as calls.
+	RefKinds []string
+
+	// Resolver, if set, is used to find the version of a callee in
+	// another repo (whose commit ID is not known from the refs to
+	// it), so that its callees can be added to the graph. Without a
+	// resolver, such defs are leaves of callee graphs.
+	Resolver *store.DefResolver
+}
+
+// A Graph is a call graph rooted at a def.
+type Graph struct {
+	// Root is the def that the graph was built from.
+	Root graph.DefKey
+
+	// Nodes are the defs in the graph, sorted by their depth (the root
+	// is first) and then by their def key.
+	Nodes []*Node
+
+	// Edges are the calls between defs in the graph, sorted by caller
+	// and then by callee.
+	Edges []*Edge
+}
+
+// A Node is a def in a call graph.
+type Node struct {
+	graph.DefKey
+
+	// Name and Kind are the def's name and kind. They are empty if
+	// the def was not found in the store (e.g., if it is in a repo
+	// that the store doesn't contain).
+	Name string `json:",omitempty"`
+	Kind string `json:",omitempty"`
+
+	// Depth is the number of calls between the root def and this
+	// def.
+	Depth int
+}
+
+// An Edge is a call from one def to another in a call graph.
+type Edge struct {
+	Caller graph.DefKey
+	Callee graph.DefKey
+
+	// Refs are the call sites (refs in the caller to the callee).
+	Refs []*graph.Ref
+}
+
+// Build builds the call graph of the def with the given key in s. The
+// store may be a store at any level (e.g., a TreeStore or a
+// MultiRepoStore); the root def key must include the fields (such as
+// Repo and CommitID) that are needed to find the def at that level.
+func Build(s store.UnitStore, root graph.DefKey, opt Options) (*Graph, error) {
+	if opt.Direction != Callers && opt.Direction != Callees {
+		return nil, fmt.Errorf("invalid call graph direction %q (valid directions are %s and %s)", opt.Direction, Callers, Callees)
+	}
+	if opt.Depth < 1 {
+		return nil, errors.New("call graph depth must be at least 1")
+	}
+
+	b := &builder{
+		s:        s,
+		opt:      opt,
+		nodes:    map[graph.DefKey]*Node{},
+		edges:    map[[2]graph.DefKey]*Edge{},
+		fileDefs: map[fileKey][]*graph.Def{},
+	}
+	rootDef, err := b.def(root)
+	if err != nil {
+		return nil, err
+	}
+	if rootDef == nil {
+		return nil, fmt.Errorf("def %+v not found", root)
+	}
+	root = rootDef.DefKey // use the key as the store fills it in
+	b.addNode(root, rootDef, 0)
+
+	// Add the defs at each depth, breadth-first, so that each def's
+	// depth is its distance from the root.
+	frontier := []graph.DefKey{root}
+	for depth := 1; depth <= opt.Depth && len(frontier) > 0; depth++ {
+		var next []graph.DefKey
+		for _, key := range frontier {
+			var found []graph.DefKey
+			if opt.Direction == Callers {
+				found, err = b.callers(key, depth)
+			} else {
+				found, err = b.callees(key, depth)
+			}
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, found...)
+		}
+		frontier = next
+	}
+
+	g := &Graph{Root: root}
+	for _, n := range b.nodes {
+		g.Nodes = append(g.Nodes, n)
+	}
+	sort.Sort(nodesByDepth(g.Nodes))
+	for _, e := range b.edges {
+		sort.Sort(graph.Refs(e.Refs))
+		g.Edges = append(g.Edges, e)
+	}
+	sort.Sort(edgesByKey(g.Edges))
+	return g, nil
+}
+
+// EnclosingDef returns the innermost def in defs whose definition (in
+// file) contains the byte range start..end, or nil if there is none.
+// Defs with an empty definition range are ignored.
+func EnclosingDef(defs []*graph.Def, file string, start, end uint32) *graph.Def {
+	var inner *graph.Def
+	for _, def := range defs {
+		if def.File != file || def.DefEnd <= def.DefStart || start < def.DefStart || end > def.DefEnd {
+			continue
+		}
+		if inner == nil || def.DefEnd-def.DefStart < inner.DefEnd-inner.DefStart {
+			inner = def
+		}
+	}
+	return inner
+}
+
+type builder struct {
+	s   store.UnitStore
+	opt Options
+
+	nodes map[graph.DefKey]*Node
+	edges map[[2]graph.DefKey]*Edge
+
+	// fileDefs caches the defs in each file (see enclosingDef).
+	fileDefs map[fileKey][]*graph.Def
+}
+
+// fileKey identifies a file in a source unit.
+type fileKey struct {
+	graph.DefKey // only the repo, commit ID, and unit fields are set
+	file         string
+}
+
+// addNode adds a node for the def with the given key (if it isn't
+// already in the graph) and returns whether it was added. The def may
+// be nil if it is not known.
+func (b *builder) addNode(key graph.DefKey, def *graph.Def, depth int) bool {
+	if _, present := b.nodes[key]; present {
+		return false
+	}
+	n := &Node{DefKey: key, Depth: depth}
+	if def != nil {
+		n.Name, n.Kind = def.Name, def.Kind
+	}
+	b.nodes[key] = n
+	return true
+}
+
+func (b *builder) addEdge(caller, callee graph.DefKey, ref *graph.Ref) {
+	k := [2]graph.DefKey{caller, callee}
+	e, present := b.edges[k]
+	if !present {
+		e = &Edge{Caller: caller, Callee: callee}
+		b.edges[k] = e
+	}
+	e.Refs = append(e.Refs, ref)
+}
+
+// isCall returns whether ref is a call (according to the builder's
+// options).
+func (b *builder) isCall(ref *graph.Ref) bool {
+	if ref.Def {
+		return false
+	}
+	if len(b.opt.RefKinds) == 0 {
+		return ref.Kind == graph.RefKindCall || ref.Kind == ""
+	}
+	for _, kind := range b.opt.RefKinds {
+		if ref.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// callers adds the defs that call the def with the given key (and the
+// edges from them) to the graph. It returns the keys of the defs that
+// were newly added.
+func (b *builder) callers(key graph.DefKey, depth int) ([]graph.DefKey, error) {
+	refs, err := b.s.Refs(store.ByRefDef(graph.RefDefKey{
+		DefRepo:     key.Repo,
+		DefUnitType: key.UnitType,
+		DefUnit:     key.Unit,
+		DefPath:     key.Path,
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	var added []graph.DefKey
+	for _, ref := range refs {
+		if !b.isCall(ref) {
+			continue
+		}
+		if key.CommitID != "" && ref.Repo == key.Repo && ref.CommitID != key.CommitID {
+			// The ref is in another version of the def's repo.
+			continue
+		}
+		caller, err := b.enclosingDef(ref)
+		if err != nil {
+			return nil, err
+		}
+		if caller == nil {
+			// The call is not in a def (e.g., it is at the top level
+			// of a file).
+			continue
+		}
+		if b.addNode(caller.DefKey, caller, depth) {
+			added = append(added, caller.DefKey)
+		}
+		b.addEdge(caller.DefKey, key, ref)
+	}
+	return added, nil
+}
+
+// callees adds the defs that the def with the given key calls (and
+// the edges to them) to the graph. It returns the keys of the defs
+// that were newly added.
+func (b *builder) callees(key graph.DefKey, depth int) ([]graph.DefKey, error) {
+	def, err := b.def(key)
+	if err != nil {
+		return nil, err
+	}
+	if def == nil || def.DefEnd <= def.DefStart {
+		// The def's calls can't be found without its definition.
+		return nil, nil
+	}
+
+	refs, err := b.s.Refs(append(refScope(def.DefKey), store.ByFiles(true, def.File))...)
+	if err != nil {
+		return nil, err
+	}
+
+	var added []graph.DefKey
+	for _, ref := range refs {
+		if !b.isCall(ref) || ref.Start < def.DefStart || ref.End > def.DefEnd {
+			continue
+		}
+		if caller, err := b.enclosingDef(ref); err != nil {
+			return nil, err
+		} else if caller == nil || caller.DefKey != def.DefKey {
+			// The call is in a def nested in this def. (Compare
+			// against the def's own key, because key has no commit
+			// ID if the def was resolved by the Resolver.)
+			continue
+		}
+		callee := calleeKey(ref)
+		if b.addNode(callee, nil, depth) {
+			if calleeDef, err := b.def(callee); err != nil {
+				return nil, err
+			} else if calleeDef != nil {
+				n := b.nodes[callee]
+				n.Name, n.Kind = calleeDef.Name, calleeDef.Kind
+			}
+			added = append(added, callee)
+		}
+		b.addEdge(key, callee, ref)
+	}
+	return added, nil
+}
+
+// def returns the def with the given key, or nil if it is not in the
+// store.
+func (b *builder) def(key graph.DefKey) (*graph.Def, error) {
+	if key.Repo != "" && key.CommitID == "" {
+		// The def is in another repo, at an unknown version.
+		if b.opt.Resolver == nil {
+			return nil, nil
+		}
+		def, err := b.opt.Resolver.Resolve(graph.RefDefKey{
+			DefRepo:     key.Repo,
+			DefUnitType: key.UnitType,
+			DefUnit:     key.Unit,
+			DefPath:     key.Path,
+		})
+		if store.IsDefNotResolved(err) {
+			return nil, nil
+		}
+		return def, err
+	}
+
+	fs := []store.DefFilter{store.ByDefPath(key.Path)}
+	for _, f := range scope(key) {
+		fs = append(fs, f)
+	}
+	defs, err := b.s.Defs(fs...)
+	if err != nil || len(defs) == 0 {
+		return nil, err
+	}
+	return defs[0], nil
+}
+
+// enclosingDef returns the innermost def that contains ref (see
+// EnclosingDef).
+func (b *builder) enclosingDef(ref *graph.Ref) (*graph.Def, error) {
+	k := fileKey{
+		DefKey: graph.DefKey{Repo: ref.Repo, CommitID: ref.CommitID, UnitType: ref.UnitType, Unit: ref.Unit},
+		file:   ref.File,
+	}
+	defs, present := b.fileDefs[k]
+	if !present {
+		fs := []store.DefFilter{store.ByFiles(true, ref.File)}
+		for _, f := range scope(k.DefKey) {
+			fs = append(fs, f)
+		}
+		var err error
+		defs, err = b.s.Defs(fs...)
+		if err != nil {
+			return nil, err
+		}
+		b.fileDefs[k] = defs
+	}
+	return EnclosingDef(defs, ref.File, ref.Start, ref.End), nil
+}
+
+// calleeKey returns the key of the def that ref refers to. The commit
+// ID is only known if the def is in the same repo as the ref.
+func calleeKey(ref *graph.Ref) graph.DefKey {
+	k := ref.DefKey()
+	if k.Repo == "" {
+		k.Repo = ref.Repo
+	}
+	if k.Repo == ref.Repo {
+		k.CommitID = ref.CommitID
+	}
+	if k.UnitType == "" && k.Unit == "" {
+		k.UnitType, k.Unit = ref.UnitType, ref.Unit
+	}
+	return k
+}
+
+type scopeFilter interface {
+	store.DefFilter
+	store.RefFilter
+}
+
+// scope returns filters that restrict a query to the repo, commit ID,
+// and source unit of key (for each of these fields that is set).
+// Stores below the multi-repo level leave the fields for their own
+// level and the levels above it empty.
+func scope(key graph.DefKey) []scopeFilter {
+	var fs []scopeFilter
+	if key.Repo != "" {
+		fs = append(fs, store.ByRepos(key.Repo))
+	}
+	if key.CommitID != "" {
+		fs = append(fs, store.ByCommitIDs(key.CommitID))
+	}
+	if key.UnitType != "" && key.Unit != "" {
+		fs = append(fs, store.ByUnits(unit.ID2{Type: key.UnitType, Name: key.Unit}))
+	}
+	return fs
+}
+
+func refScope(key graph.DefKey) []store.RefFilter {
+	var fs []store.RefFilter
+	for _, f := range scope(key) {
+		fs = append(fs, f)
+	}
+	return fs
+}
+
+func compareKeys(a, b graph.DefKey) int {
+	for _, f := range [][2]string{
+		{a.Repo, b.Repo}, {a.CommitID, b.CommitID}, {a.UnitType, b.UnitType}, {a.Unit, b.Unit}, {a.Path, b.Path},
+	} {
+		if f[0] < f[1] {
+			return -1
+		} else if f[0] > f[1] {
+			return 1
+		}
+	}
+	return 0
+}
+
+type nodesByDepth []*Node
+
+func (v nodesByDepth) Len() int      { return len(v) }
+func (v nodesByDepth) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v nodesByDepth) Less(i, j int) bool {
+	if v[i].Depth != v[j].Depth {
+		return v[i].Depth < v[j].Depth
+	}
+	return compareKeys(v[i].DefKey, v[j].DefKey) < 0
+}
+
+type edgesByKey []*Edge
+
+func (v edgesByKey) Len() int      { return len(v) }
+func (v edgesByKey) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v edgesByKey) Less(i, j int) bool {
+	if c := compareKeys(v[i].Caller, v[j].Caller); c != 0 {
+		return c < 0
+	}
+	return compareKeys(v[i].Callee, v[j].Callee) < 0
+}
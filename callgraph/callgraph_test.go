@@ -0,0 +1,176 @@
+package callgraph
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestEnclosingDef(t *testing.T) {
+	defs := []*graph.Def{
+		{DefKey: graph.DefKey{Path: "outer"}, File: "f", DefStart: 0, DefEnd: 100},
+		{DefKey: graph.DefKey{Path: "inner"}, File: "f", DefStart: 10, DefEnd: 20},
+		{DefKey: graph.DefKey{Path: "empty"}, File: "f", DefStart: 30, DefEnd: 30},
+		{DefKey: graph.DefKey{Path: "other"}, File: "g", DefStart: 0, DefEnd: 100},
+	}
+	tests := []struct {
+		file       string
+		start, end uint32
+		want       string // def path, or empty for none
+	}{
+		{"f", 5, 6, "outer"},
+		{"f", 10, 20, "inner"},
+		{"f", 15, 25, "outer"},
+		{"f", 30, 30, "outer"},
+		{"f", 100, 110, ""},
+		{"g", 10, 20, "other"},
+		{"h", 10, 20, ""},
+	}
+	for _, test := range tests {
+		var got string
+		if def := EnclosingDef(defs, test.file, test.start, test.end); def != nil {
+			got = def.Path
+		}
+		if got != test.want {
+			t.Errorf("EnclosingDef(%s, %d, %d): got %q, want %q", test.file, test.start, test.end, got, test.want)
+		}
+	}
+}
+
+func TestBuild(t *testing.T) {
+	mrs := store.NewFSMultiRepoStore(rwvfs.Walkable(rwvfs.Sub(rwvfs.Map(map[string]string{}), "/testdata")), nil)
+	u := &unit.SourceUnit{Key: unit.Key{Type: "t", Name: "u"}, Info: unit.Info{Files: []string{"f"}}}
+	importVersion := func(repo string, data graph.Output) {
+		if err := mrs.Import(repo, "c", u, data); err != nil {
+			t.Fatal(err)
+		}
+		if err := mrs.Index(repo, "c"); err != nil {
+			t.Fatal(err)
+		}
+		if err := mrs.CreateVersion(repo, "c", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	importVersion("r1", graph.Output{
+		Defs: []*graph.Def{
+			{DefKey: graph.DefKey{Path: "A"}, Name: "A", File: "f", DefStart: 0, DefEnd: 30},
+			{DefKey: graph.DefKey{Path: "B"}, Name: "B", File: "f", DefStart: 30, DefEnd: 60},
+			{DefKey: graph.DefKey{Path: "C"}, Name: "C", File: "f", DefStart: 60, DefEnd: 90},
+		},
+		Refs: []*graph.Ref{
+			{DefPath: "A", File: "f", Start: 1, End: 2, Def: true},
+			{DefPath: "B", File: "f", Start: 10, End: 11, Kind: graph.RefKindCall},
+			{DefPath: "B", File: "f", Start: 12, End: 13},
+			{DefPath: "C", File: "f", Start: 20, End: 21},
+			{DefPath: "C", File: "f", Start: 40, End: 41, Kind: graph.RefKindCall},
+			{DefPath: "A", File: "f", Start: 70, End: 71, Kind: graph.RefKindRead},
+		},
+	})
+	importVersion("r2", graph.Output{
+		Defs: []*graph.Def{
+			{DefKey: graph.DefKey{Path: "D"}, Name: "D", File: "f", DefStart: 0, DefEnd: 20},
+		},
+		Refs: []*graph.Ref{
+			{DefRepo: "r1", DefUnitType: "t", DefUnit: "u", DefPath: "C", File: "f", Start: 5, End: 6},
+		},
+	})
+
+	key := func(repo, path string) graph.DefKey {
+		return graph.DefKey{Repo: repo, CommitID: "c", UnitType: "t", Unit: "u", Path: path}
+	}
+	type edge struct{ caller, callee string }
+	tests := []struct {
+		root      graph.DefKey
+		opt       Options
+		wantNodes []string
+		wantEdges []edge
+	}{
+		{
+			root:      key("r1", "A"),
+			opt:       Options{Direction: Callees, Depth: 1},
+			wantNodes: []string{"A", "B", "C"},
+			wantEdges: []edge{{"A", "B"}, {"A", "C"}},
+		},
+		{
+			root:      key("r1", "A"),
+			opt:       Options{Direction: Callees, Depth: 2},
+			wantNodes: []string{"A", "B", "C"},
+			wantEdges: []edge{{"A", "B"}, {"A", "C"}, {"B", "C"}},
+		},
+		{
+			root:      key("r1", "C"),
+			opt:       Options{Direction: Callers, Depth: 1},
+			wantNodes: []string{"C", "A", "B", "D"},
+			wantEdges: []edge{{"A", "C"}, {"B", "C"}, {"D", "C"}},
+		},
+		{
+			root:      key("r1", "C"),
+			opt:       Options{Direction: Callers, Depth: 3, RefKinds: []string{graph.RefKindCall}},
+			wantNodes: []string{"C", "B", "A"},
+			wantEdges: []edge{{"A", "B"}, {"B", "C"}},
+		},
+		{
+			// C is in another repo, so its version must be resolved
+			// before its callees can be found.
+			root:      key("r2", "D"),
+			opt:       Options{Direction: Callees, Depth: 2, RefKinds: []string{"", graph.RefKindRead}, Resolver: store.NewDefResolver(mrs)},
+			wantNodes: []string{"D", "C", "A"},
+			wantEdges: []edge{{"C", "A"}, {"D", "C"}},
+		},
+		{
+			root:      key("r2", "D"),
+			opt:       Options{Direction: Callees, Depth: 2, RefKinds: []string{"", graph.RefKindRead}},
+			wantNodes: []string{"D", ""},
+			wantEdges: []edge{{"D", "C"}},
+		},
+	}
+	for _, test := range tests {
+		label := string(test.opt.Direction) + " of " + test.root.Path
+		g, err := Build(mrs, test.root, test.opt)
+		if err != nil {
+			t.Errorf("%s: %s", label, err)
+			continue
+		}
+		var nodes []string
+		for _, n := range g.Nodes {
+			nodes = append(nodes, n.Name)
+		}
+		if !reflect.DeepEqual(nodes, test.wantNodes) {
+			t.Errorf("%s: got nodes %v, want %v", label, nodes, test.wantNodes)
+		}
+		var edges []edge
+		for _, e := range g.Edges {
+			edges = append(edges, edge{e.Caller.Path, e.Callee.Path})
+		}
+		if !reflect.DeepEqual(edges, test.wantEdges) {
+			t.Errorf("%s: got edges %v, want %v", label, edges, test.wantEdges)
+		}
+	}
+
+	g, err := Build(mrs, key("r1", "A"), Options{Direction: Callees, Depth: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Edges) == 0 || len(g.Edges[0].Refs) != 2 {
+		t.Errorf("got edges %v, want 2 call sites from A to B", g.Edges)
+	}
+	var buf bytes.Buffer
+	if err := g.WriteDOT(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`n0 [label="A", tooltip="r1@c u t: A", style=bold];`, `n0 -> n1 [label="2"];`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("got DOT output %q, want it to contain %q", buf.String(), want)
+		}
+	}
+
+	if _, err := Build(mrs, key("r1", "Z"), Options{Direction: Callers, Depth: 1}); err == nil {
+		t.Error("Build with a nonexistent root def: got nil error")
+	}
+}
@@ -0,0 +1,67 @@
+package callgraph
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// WriteDOT writes g to w in the Graphviz DOT format. Each node is
+// labeled with its def's name (or path, if the name is unknown), and
+// each edge with more than one call site is labeled with the number
+// of call sites.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	var buf bytes.Buffer
+	buf.WriteString("digraph callgraph {\n")
+
+	ids := make(map[graph.DefKey]string, len(g.Nodes))
+	for i, n := range g.Nodes {
+		id := fmt.Sprintf("n%d", i)
+		ids[n.DefKey] = id
+
+		label := n.Name
+		if label == "" {
+			label = n.Path
+		}
+		fmt.Fprintf(&buf, "\t%s [label=%s, tooltip=%s", id, dotQuote(label), dotQuote(keyString(n.DefKey)))
+		if n.DefKey == g.Root {
+			buf.WriteString(", style=bold")
+		}
+		buf.WriteString("];\n")
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&buf, "\t%s -> %s", ids[e.Caller], ids[e.Callee])
+		if len(e.Refs) > 1 {
+			fmt.Fprintf(&buf, " [label=\"%d\"]", len(e.Refs))
+		}
+		buf.WriteString(";\n")
+	}
+
+	buf.WriteString("}\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// keyString returns a human-readable representation of a def key.
+func keyString(k graph.DefKey) string {
+	s := k.Path
+	if k.Unit != "" {
+		s = k.Unit + " " + k.UnitType + ": " + s
+	}
+	if k.Repo != "" || k.CommitID != "" {
+		repo := k.Repo
+		if k.CommitID != "" {
+			repo += "@" + k.CommitID
+		}
+		s = repo + " " + s
+	}
+	return s
+}
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// dotQuote returns s as a DOT quoted string.
+func dotQuote(s string) string { return `"` + dotEscaper.Replace(s) + `"` }
@@ -0,0 +1,127 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/go-flags"
+
+	"sourcegraph.com/sourcegraph/srclib/callgraph"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func init() {
+	cliInit = append(cliInit, func(cli *flags.Command) {
+		c, err := cli.AddCommand("callgraph",
+			"show the callers or callees of a def",
+			"The callgraph command builds the call graph of a def from the defs and refs in a store: the defs that call it (--direction=callers) or that it calls (--direction=callees), up to --depth calls away. The caller of each ref is the innermost def that contains it.",
+			&callgraphCmd,
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
+		g, err := c.AddGroup("Store", "", &callgraphCmd.store)
+		if err != nil {
+			log.Fatal(err)
+		}
+		setStoreRootDefault(g)
+	})
+}
+
+type CallgraphCmd struct {
+	Def      string `long:"def" description:"path of the def" required:"yes"`
+	Repo     string `long:"repo" description:"repo of the def"`
+	CommitID string `long:"commit" description:"commit ID of the def"`
+	UnitType string `long:"unit-type" description:"source unit type of the def"`
+	Unit     string `long:"unit" description:"source unit of the def"`
+
+	Direction string `long:"direction" description:"build the graph of the def's callers or callees" default:"callers"`
+	Depth     int    `long:"depth" description:"max number of calls between the def and the other defs in the graph" default:"1"`
+	Kind      string `long:"kind" description:"treat refs of these comma-separated kinds as calls (default: call and unspecified)"`
+
+	Format string `long:"format" description:"output format ('json' or 'dot')" default:"json"`
+
+	store StoreCmd // options for opening the store (see init)
+}
+
+var callgraphCmd CallgraphCmd
+
+func (c *CallgraphCmd) Execute(args []string) error {
+	if (c.UnitType != "" && c.Unit == "") || (c.UnitType == "" && c.Unit != "") {
+		return errors.New("must specify either both or neither of --unit-type and --unit")
+	}
+	opt := callgraph.Options{Direction: callgraph.Direction(c.Direction), Depth: c.Depth}
+	if c.Kind != "" {
+		opt.RefKinds = strings.Split(c.Kind, ",")
+		for _, kind := range opt.RefKinds {
+			if !graph.IsValidRefKind(kind) {
+				return fmt.Errorf("invalid --kind %q (valid kinds are %s)", kind, strings.Join(graph.RefKinds, ", "))
+			}
+		}
+	}
+
+	s, err := c.store.store()
+	if err != nil {
+		return err
+	}
+	us, ok := s.(store.UnitStore)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement listing defs and refs", s)
+	}
+	if mrs, ok := s.(store.MultiRepoStore); ok {
+		opt.Resolver = store.NewDefResolver(mrs)
+	}
+
+	root, err := c.rootDef(us)
+	if err != nil {
+		return err
+	}
+	g, err := callgraph.Build(us, root.DefKey, opt)
+	if err != nil {
+		return err
+	}
+
+	switch c.Format {
+	case "json":
+		PrintJSON(g, "  ")
+	case "dot":
+		return g.WriteDOT(os.Stdout)
+	default:
+		return fmt.Errorf("invalid --format %q (valid formats are json and dot)", c.Format)
+	}
+	return nil
+}
+
+// rootDef returns the def specified by the command's options. It
+// returns an error if the options match more than one def.
+func (c *CallgraphCmd) rootDef(s store.UnitStore) (*graph.Def, error) {
+	fs := []store.DefFilter{store.ByDefPath(c.Def)}
+	if c.Repo != "" {
+		fs = append(fs, store.ByRepos(c.Repo))
+	}
+	if c.CommitID != "" {
+		fs = append(fs, store.ByCommitIDs(c.CommitID))
+	}
+	if c.UnitType != "" && c.Unit != "" {
+		fs = append(fs, store.ByUnits(unit.ID2{Type: c.UnitType, Name: c.Unit}))
+	}
+	defs, err := s.Defs(fs...)
+	if err != nil {
+		return nil, err
+	}
+	switch len(defs) {
+	case 0:
+		return nil, fmt.Errorf("no def found with path %q", c.Def)
+	case 1:
+		return defs[0], nil
+	}
+	for _, def := range defs {
+		log.Printf("# Matching def: %+v", def.DefKey)
+	}
+	return nil, fmt.Errorf("%d defs found with path %q (use --repo, --commit, --unit-type, and --unit to choose one)", len(defs), c.Def)
+}
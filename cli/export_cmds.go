@@ -0,0 +1,251 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"sourcegraph.com/sourcegraph/go-flags"
+
+	"sourcegraph.com/sourcegraph/srclib/config"
+	"sourcegraph.com/sourcegraph/srclib/export"
+	"sourcegraph.com/sourcegraph/srclib/export/ctags"
+	"sourcegraph.com/sourcegraph/srclib/export/lsif"
+	"sourcegraph.com/sourcegraph/srclib/export/scip"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/plan"
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+func init() {
+	cliInit = append(cliInit, func(cli *flags.Command) {
+		c, err := cli.AddCommand("export",
+			"export graph data to other formats",
+			"The export subcommands convert the graph data of a version in a store (at --root), or in the build data of the current repo (with --cache), into formats that other tools consume. Files are read from the version's checkout (at --dir) where needed, so it must be checked out at the exported commit.",
+			&exportCmd,
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
+		g, err := c.AddGroup("Store", "", &exportCmd.store)
+		if err != nil {
+			log.Fatal(err)
+		}
+		setStoreRootDefault(g)
+
+		_, err = c.AddCommand("lsif",
+			"export an LSIF dump",
+			"The lsif command writes an LSIF dump (for code intelligence in editors and code hosts) of a version's source units, defs, refs, and docs.",
+			&exportLSIFCmd,
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		_, err = c.AddCommand("scip",
+			"export a SCIP index",
+			"The scip command writes a SCIP index (for precise code navigation in code hosts) of a version's source units, defs, refs, and docs.",
+			&exportSCIPCmd,
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		_, err = c.AddCommand("ctags",
+			"export a ctags file",
+			"The ctags command writes an extended ctags file (for jumping to definitions in Vim and other editors) of a version's non-local defs, with their kinds, scopes, signatures (if the toolchain provides a DefFormatter), and exported and test fields.",
+			&exportCtagsCmd,
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		_, err = c.AddCommand("etags",
+			"export an Emacs etags file",
+			"The etags command writes an Emacs etags (TAGS) file of a version's non-local defs.",
+			&exportEtagsCmd,
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
+	})
+}
+
+type ExportCmd struct {
+	Repo     string `long:"repo" description:"repo of the version to export (required for MultiRepoStores)"`
+	CommitID string `long:"commit" description:"commit ID of the version to export" required:"yes"`
+	Dir      string `long:"dir" description:"dir where the version is checked out" default:"."`
+	Output   string `short:"o" long:"output" description:"file to write (default: stdout)"`
+	Cache    bool   `long:"cache" description:"export the build data in the current repo's .srclib-cache instead of the data in the store"`
+
+	store StoreCmd // options for opening the store (see init)
+}
+
+var exportCmd ExportCmd
+
+func (c *ExportCmd) Execute(args []string) error { return nil }
+
+// load loads the graph data of the version to export.
+func (c *ExportCmd) load() (*export.Data, error) {
+	if c.Cache {
+		return c.loadBuildData()
+	}
+	s, err := c.store.store()
+	if err != nil {
+		return nil, err
+	}
+	rs, ok := s.(store.RepoStore)
+	if !ok {
+		return nil, fmt.Errorf("store (type %T) does not implement exporting", s)
+	}
+	return export.Load(rs, c.Repo, c.CommitID)
+}
+
+// loadBuildData loads the graph data of the version to export from
+// the build data of the current repo (as Import would, but without
+// writing it to a store).
+func (c *ExportCmd) loadBuildData() (*export.Data, error) {
+	bdfs, err := GetBuildDataFS(c.CommitID)
+	if err != nil {
+		return nil, err
+	}
+	if bdfs == nil {
+		return nil, fmt.Errorf("no build data for commit %s (the current dir must be in a repo)", c.CommitID)
+	}
+	treeConfig, err := config.ReadCached(bdfs)
+	if err != nil {
+		return nil, fmt.Errorf("error calling config.ReadCached: %s", err)
+	}
+	mf, err := plan.CreateMakefile(".", nil, "", treeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error calling plan.Makefile: %s", err)
+	}
+
+	d := &export.Data{Repo: c.Repo, CommitID: c.CommitID}
+	for _, t := range graphDataTargets(mf) {
+		var data graph.Output
+		if err := readJSONFileFS(bdfs, t.file, &data); err == errEmptyJSONFile || os.IsNotExist(err) {
+			log.Printf("Warning: no build data for unit %s %s.", t.unit.Type, t.unit.Name)
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("error reading JSON file %s for unit %s %s: %s", t.file, t.unit.Type, t.unit.Name, err)
+		}
+		d.Add(t.unit, &data)
+	}
+	if len(d.Units) == 0 {
+		return nil, fmt.Errorf("no build data for commit %s (run `srclib make` first)", c.CommitID)
+	}
+	return d, nil
+}
+
+// write calls writeTo with the output file (or stdout). The output
+// file is removed if writeTo fails.
+func (c *ExportCmd) write(writeTo func(io.Writer) error) error {
+	if c.Output == "" {
+		return writeTo(os.Stdout)
+	}
+	f, err := os.Create(c.Output)
+	if err != nil {
+		return err
+	}
+	if err := writeTo(f); err != nil {
+		f.Close()
+		os.Remove(c.Output)
+		return err
+	}
+	return f.Close()
+}
+
+type ExportLSIFCmd struct{}
+
+var exportLSIFCmd ExportLSIFCmd
+
+func (c *ExportLSIFCmd) Execute(args []string) error {
+	d, err := exportCmd.load()
+	if err != nil {
+		return err
+	}
+	dir, err := filepath.Abs(exportCmd.Dir)
+	if err != nil {
+		return err
+	}
+	opt := lsif.Options{
+		ProjectRoot: "file://" + filepath.ToSlash(dir),
+		ReadFile:    export.DirReadFile(dir),
+	}
+	return exportCmd.write(func(w io.Writer) error {
+		skipped, err := lsif.Write(w, d, opt)
+		for _, file := range skipped {
+			log.Printf("# Skipped %s (file not found in %s).", file, dir)
+		}
+		return err
+	})
+}
+
+type ExportSCIPCmd struct{}
+
+var exportSCIPCmd ExportSCIPCmd
+
+func (c *ExportSCIPCmd) Execute(args []string) error {
+	d, err := exportCmd.load()
+	if err != nil {
+		return err
+	}
+	dir, err := filepath.Abs(exportCmd.Dir)
+	if err != nil {
+		return err
+	}
+	opt := scip.Options{
+		ProjectRoot: "file://" + filepath.ToSlash(dir),
+		ReadFile:    export.DirReadFile(dir),
+		ToolVersion: Version,
+	}
+	return exportCmd.write(func(w io.Writer) error {
+		skipped, err := scip.Write(w, d, opt)
+		for _, file := range skipped {
+			log.Printf("# Skipped %s (file not found in %s).", file, dir)
+		}
+		return err
+	})
+}
+
+type ExportCtagsCmd struct{}
+
+var exportCtagsCmd ExportCtagsCmd
+
+func (c *ExportCtagsCmd) Execute(args []string) error {
+	return writeTags(ctags.Write)
+}
+
+type ExportEtagsCmd struct{}
+
+var exportEtagsCmd ExportEtagsCmd
+
+func (c *ExportEtagsCmd) Execute(args []string) error {
+	return writeTags(ctags.WriteEtags)
+}
+
+// writeTags writes a tags file of the version to export with write.
+func writeTags(write func(io.Writer, *export.Data, ctags.Options) ([]string, error)) error {
+	d, err := exportCmd.load()
+	if err != nil {
+		return err
+	}
+	dir, err := filepath.Abs(exportCmd.Dir)
+	if err != nil {
+		return err
+	}
+	opt := ctags.Options{
+		ReadFile:       export.DirReadFile(dir),
+		ProgramVersion: Version,
+	}
+	return exportCmd.write(func(w io.Writer) error {
+		skipped, err := write(w, d, opt)
+		for _, file := range skipped {
+			log.Printf("# Skipped %s (file not found in %s).", file, dir)
+		}
+		return err
+	})
+}
@@ -0,0 +1,129 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"sourcegraph.com/sourcegraph/go-flags"
+
+	"sourcegraph.com/sourcegraph/srclib/config"
+	"sourcegraph.com/sourcegraph/srclib/dep"
+	"sourcegraph.com/sourcegraph/srclib/depgraph"
+	"sourcegraph.com/sourcegraph/srclib/plan"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func init() {
+	cliInit = append(cliInit, func(cli *flags.Command) {
+		c, err := cli.AddCommand("graph",
+			"graphs of a repo's source units",
+			"The graph subcommands build graphs of the source units in the current repo from its build data (in .srclib-cache).",
+			&graphCmd,
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		depsC, err := c.AddCommand("deps",
+			"show the dependency graph of source units",
+			"The deps command builds the graph of which source units in the current repo depend on which other units, and on which units (or repos, with --repos) outside the repo. Deps are taken from the resolutions output by depresolve, or from the units' Dependencies fields if their deps were not resolved. Cycles of units that depend on each other are reported.",
+			&graphDepsCmd,
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
+		SetDefaultCommitIDOpt(depsC)
+	})
+}
+
+type GraphCmd struct{}
+
+var graphCmd GraphCmd
+
+func (c *GraphCmd) Execute(args []string) error { return nil }
+
+type GraphDepsCmd struct {
+	Repo     string `long:"repo" description:"URI of the current repo (to tell its units from units in other repos)"`
+	CommitID string `long:"commit" description:"commit ID of the build data"`
+	Repos    bool   `long:"repos" description:"show deps on units in other repos as deps on the repos"`
+
+	Format string `long:"format" description:"output format ('json', 'dot', or 'graphml')" default:"json"`
+}
+
+var graphDepsCmd GraphDepsCmd
+
+func (c *GraphDepsCmd) Execute(args []string) error {
+	if c.Format != "json" && c.Format != "dot" && c.Format != "graphml" {
+		return fmt.Errorf("invalid --format %q (valid formats are json, dot, and graphml)", c.Format)
+	}
+
+	units, err := c.unitDeps()
+	if err != nil {
+		return err
+	}
+	g := depgraph.Build(c.Repo, units, depgraph.Options{Repos: c.Repos})
+	for _, u := range g.Unresolved {
+		log.Printf("# Unresolved dependency of %s: %s", u.From, u.Error)
+	}
+	for _, cycle := range g.Cycles {
+		log.Printf("# Dependency cycle: %v", cycle)
+	}
+
+	switch c.Format {
+	case "json":
+		PrintJSON(g, "  ")
+	case "dot":
+		return g.WriteDOT(os.Stdout)
+	case "graphml":
+		return g.WriteGraphML(os.Stdout)
+	}
+	return nil
+}
+
+// unitDeps reads the source units and the resolutions of their deps
+// from the build data.
+func (c *GraphDepsCmd) unitDeps() ([]depgraph.UnitDeps, error) {
+	bdfs, err := GetBuildDataFS(c.CommitID)
+	if err != nil {
+		return nil, err
+	}
+	if bdfs == nil {
+		return nil, fmt.Errorf("no build data for commit %s (the current dir must be in a repo)", c.CommitID)
+	}
+	treeConfig, err := config.ReadCached(bdfs)
+	if err != nil {
+		return nil, fmt.Errorf("error calling config.ReadCached: %s", err)
+	}
+	mf, err := plan.CreateMakefile(".", nil, "", treeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error calling plan.Makefile: %s", err)
+	}
+
+	resolutions := map[unit.ID2][]*dep.Resolution{}
+	for _, rule := range mf.Rules {
+		rule, ok := rule.(*dep.ResolveDepsRule)
+		if !ok {
+			continue
+		}
+		var res []*dep.Resolution
+		if err := readJSONFileFS(bdfs, rule.Target(), &res); err == errEmptyJSONFile || os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("error reading JSON file %s for unit %s %s: %s", rule.Target(), rule.Unit.Type, rule.Unit.Name, err)
+		}
+		if res == nil {
+			res = []*dep.Resolution{}
+		}
+		resolutions[rule.Unit.ID2()] = res
+	}
+
+	units := make([]depgraph.UnitDeps, len(treeConfig.SourceUnits))
+	for i, u := range treeConfig.SourceUnits {
+		units[i] = depgraph.UnitDeps{Unit: u, Resolutions: resolutions[u.ID2()]}
+	}
+	if len(units) == 0 {
+		return nil, fmt.Errorf("no source units in the build data for commit %s (run `srclib make` first)", c.CommitID)
+	}
+	return units, nil
+}
@@ -2,6 +2,7 @@ package cli
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
@@ -11,6 +12,7 @@ import (
 	"sourcegraph.com/sourcegraph/srclib/graph"
 	"sourcegraph.com/sourcegraph/srclib/grapher"
 	"sourcegraph.com/sourcegraph/srclib/plan"
+	"sourcegraph.com/sourcegraph/srclib/srcpos"
 	"sourcegraph.com/sourcegraph/srclib/unit"
 )
 
@@ -64,36 +66,45 @@ func (c *EmitUnitDataCmd) Execute(args []string) error {
 }
 
 type NormalizeGraphDataCmd struct {
-	UnitType string `long:"unit-type" description:"source unit type (e.g., GoPackage)"`
-	Dir      string `long:"dir" description:"directory of source unit (SourceUnit.Dir field)"`
-	Multi    bool   `long:"multi" description:"the input contains graph data for multiple units; output will be split into different files per source unit"`
-	DataDir  string `long:"data-dir" description:"output data dir"`
+	UnitType       string `long:"unit-type" description:"source unit type (e.g., GoPackage)"`
+	Dir            string `long:"dir" description:"directory of source unit (SourceUnit.Dir field)"`
+	Multi          bool   `long:"multi" description:"the input contains graph data for multiple units; output will be split into different files per source unit"`
+	DataDir        string `long:"data-dir" description:"output data dir"`
+	MaxBuffered    int    `long:"max-buffered" description:"max number of graph data records to hold in memory, in total for all source units (more are sorted in temporary files)" default:"100000"`
+	OffsetEncoding string `long:"offset-encoding" description:"encoding of the offsets in the graph data ('bytes', 'runes', or 'utf-16'); if empty, the default for the unit type"`
+	Repair         bool   `long:"repair" description:"drop or fix invalid records (such as duplicates) instead of failing"`
+	Diagnostics    string `long:"diagnostics" description:"file to write the repairs made by --repair to (JSON); with --multi, a file per source unit is written to the data dir"`
 }
 
 var normalizeGraphDataCmd NormalizeGraphDataCmd
 
+// Execute normalizes the graph data that a grapher wrote to stdin,
+// either as a single graph.Output JSON object or as newline-delimited
+// graph.Record JSON objects (the streaming output protocol). The
+// graph data is sorted with bounded memory (see grapher.Normalizer).
 func (c *NormalizeGraphDataCmd) Execute(args []string) error {
 	in := os.Stdin
-
-	var o *graph.Output
-	if err := json.NewDecoder(in).Decode(&o); err != nil {
-		return err
+	opt := grapher.NormalizeOptions{MaxBuffered: c.MaxBuffered, OffsetEncoding: srcpos.Encoding(c.OffsetEncoding)}
+	if opt.OffsetEncoding != "" && !srcpos.IsValidEncoding(opt.OffsetEncoding) {
+		return fmt.Errorf("invalid --offset-encoding %q (valid encodings are %v)", c.OffsetEncoding, srcpos.Encodings)
 	}
 
+	opt.Repair = c.Repair
+
 	if !c.Multi {
-		if err := grapher.NormalizeData(c.UnitType, c.Dir, o); err != nil {
-			return err
+		repairs := &repairLog{path: c.Diagnostics}
+		if c.Repair {
+			opt.OnRepair = repairs.add
 		}
-
-		data, err := json.MarshalIndent(o, "", "  ")
-		if err != nil {
+		n := grapher.NewNormalizer(c.UnitType, c.Dir, opt)
+		defer n.Close()
+		if err := graph.DecodeRecords(in, n.Add); err != nil {
 			return err
 		}
-
-		if _, err := os.Stdout.Write(data); err != nil {
+		if err := n.WriteOutput(os.Stdout); err != nil {
 			return err
 		}
-		return nil
+		return repairs.close()
 	}
 
 	// If `graph` emits multiple source units, in this case, don't
@@ -101,71 +112,155 @@ func (c *NormalizeGraphDataCmd) Execute(args []string) error {
 	// instead write to multiple .graph.json files (one for each
 	// source unit). This is a HACK.
 
-	// graphPerUnit maps source unit names to the graph data of
-	// that unit.
-	graphPerUnit := make(map[string]*graph.Output)
-	initUnitGraph := func(unitName string) {
-		if _, ok := graphPerUnit[unitName]; !ok {
-			graphPerUnit[unitName] = &graph.Output{}
-		}
-	}
+	// The normalizers of all units share the --max-buffered budget
+	// and the cache of the source files' line tables, so that memory
+	// use doesn't grow with the number of units.
+	opt.Budget = grapher.NewBudget(c.MaxBuffered)
+	opt.LineTables = grapher.NewLineTables(0)
 
-	// Split the graph data per source unit.
-	for _, d := range o.Defs {
-		if d.Unit == "" {
-			log.Printf("skip def with empty unit: %v", d)
-			continue
+	// graphPerUnit maps source unit names to the normalizer of the
+	// graph data of that unit.
+	graphPerUnit := make(map[string]*grapher.Normalizer)
+	repairsPerUnit := make(map[string]*repairLog)
+	defer func() {
+		for _, n := range graphPerUnit {
+			n.Close()
 		}
-		initUnitGraph(d.Unit)
-		graphPerUnit[d.Unit].Defs = append(graphPerUnit[d.Unit].Defs, d)
-	}
-	for _, r := range o.Refs {
-		if r.Unit == "" {
-			log.Printf("skip ref with empty unit: %v", r)
-			continue
+	}()
+	unitGraph := func(unitName string) *grapher.Normalizer {
+		n, ok := graphPerUnit[unitName]
+		if !ok {
+			opt := opt
+			if c.Repair {
+				repairs := &repairLog{path: filepath.ToSlash(filepath.Join(c.DataDir, plan.SourceUnitDataFilename(grapher.RepairsDataType, &unit.SourceUnit{Key: unit.Key{Name: unitName, Type: c.UnitType}})))}
+				opt.OnRepair = repairs.add
+				repairsPerUnit[unitName] = repairs
+			}
+			n = grapher.NewNormalizer(c.UnitType, c.Dir, opt)
+			graphPerUnit[unitName] = n
 		}
-		initUnitGraph(r.Unit)
-		graphPerUnit[r.Unit].Refs = append(graphPerUnit[r.Unit].Refs, r)
+		return n
 	}
-	for _, d := range o.Docs {
-		if d.DocUnit == "" {
-			log.Printf("skip doc with empty unit: %v", d)
-			continue
+
+	// Split the graph data per source unit.
+	err := graph.DecodeRecords(in, func(rec *graph.Record) error {
+		var unitName string
+		switch {
+		case rec.Def != nil:
+			unitName = rec.Def.Unit
+		case rec.Ref != nil:
+			unitName = rec.Ref.Unit
+		case rec.Doc != nil:
+			unitName = rec.Doc.DocUnit
+		case rec.Ann != nil:
+			unitName = rec.Ann.Unit
+		case rec.Relation != nil:
+			unitName = rec.Relation.From.Unit
 		}
-		initUnitGraph(d.DocUnit)
-		graphPerUnit[d.DocUnit].Docs = append(graphPerUnit[d.DocUnit].Docs, d)
-	}
-	for _, a := range o.Anns {
-		if a.Unit == "" {
-			log.Printf("skip ann with empty unit: %v", a)
-			continue
+		if unitName == "" {
+			log.Printf("skip record with empty unit: %+v", rec)
+			return nil
 		}
-		initUnitGraph(a.Unit)
-		graphPerUnit[a.Unit].Anns = append(graphPerUnit[a.Unit].Anns, a)
+		return unitGraph(unitName).Add(rec)
+	})
+	if err != nil {
+		return err
 	}
 
 	// Write the graph data to a separate file for each source unit.
-	for unitName, graphData := range graphPerUnit {
-		if err := grapher.NormalizeData(c.UnitType, c.Dir, graphData); err != nil {
-			log.Printf("skipping unit %s because failed to normalize data: %s", unitName, err)
-			continue
-		}
-
+	for unitName, n := range graphPerUnit {
 		path := filepath.ToSlash(filepath.Join(c.DataDir, plan.SourceUnitDataFilename(&graph.Output{}, &unit.SourceUnit{Key: unit.Key{Name: unitName, Type: c.UnitType}})))
-		graphFile, err := os.Create(path)
-		if err != nil {
+		if err := writeNormalizedGraphData(path, n); err != nil {
+			if _, ok := err.(grapher.MultiError); ok {
+				log.Printf("skipping unit %s because failed to normalize data: %s", unitName, err)
+				continue
+			}
 			return err
 		}
+		if repairs := repairsPerUnit[unitName]; repairs != nil {
+			if err := repairs.close(); err != nil {
+				return err
+			}
+		}
+	}
 
-		data, err := json.MarshalIndent(graphData, "", "  ")
-		if err != nil {
-			return err
+	return nil
+}
+
+// writeNormalizedGraphData writes the normalized graph data of a
+// source unit to the file at path. If the data is invalid, the file
+// is not created.
+func writeNormalizedGraphData(path string, n *grapher.Normalizer) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := n.WriteOutput(f); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+// A repairLog records the repairs made to the graph data of a source
+// unit in --repair mode. If path is set, the repairs are written to
+// the file at path (as a JSON array of grapher.Repair objects), which
+// is only created if there are any repairs; otherwise, they are
+// logged.
+type repairLog struct {
+	path string
+	f    *os.File
+	n    int
+	err  error
+}
+
+func (l *repairLog) add(r *grapher.Repair) {
+	l.n++
+	if l.path == "" {
+		data, _ := json.Marshal(r.Record)
+		log.Printf("# repaired graph data (%s: %s): %s", r.Action, r.Problem, data)
+		return
+	}
+	if l.err != nil {
+		return
+	}
+
+	sep := ",\n  "
+	if l.f == nil {
+		if l.f, l.err = os.Create(l.path); l.err != nil {
+			return
 		}
+		sep = "[\n  "
+	}
+	data, err := json.MarshalIndent(r, "  ", "  ")
+	if err != nil {
+		l.err = err
+		return
+	}
+	_, l.err = l.f.Write(append([]byte(sep), data...))
+}
 
-		if _, err := graphFile.Write(data); err != nil {
+// close finishes writing the repairs file, or removes the repairs file
+// from a previous run if there were no repairs.
+func (l *repairLog) close() error {
+	if l.path == "" {
+		return nil
+	}
+	if l.f == nil {
+		if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
 			return err
 		}
+		return l.err
 	}
-
-	return nil
+	if l.err == nil {
+		_, l.err = l.f.Write([]byte("\n]\n"))
+	}
+	if err := l.f.Close(); l.err == nil {
+		l.err = err
+	}
+	if l.err == nil {
+		log.Printf("# repaired %d invalid graph data records (see %s)", l.n, l.path)
+	}
+	return l.err
 }
@@ -297,10 +297,11 @@ func lintGraphOutput(baseDir, repoURI, unitType, unitName, path string, checkFil
 	// Fill in implied fields.
 	grapher.PopulateImpliedFields(repoURI, "", unitType, unitName, &o)
 
-	// Check that defs and refs are unique.
+	// Check that defs, refs, docs, and relations are unique.
 	addMultiErrorAsIssues(grapher.ValidateDefs(o.Defs))
 	addMultiErrorAsIssues(grapher.ValidateRefs(o.Refs))
 	addMultiErrorAsIssues(grapher.ValidateDocs(o.Docs))
+	addMultiErrorAsIssues(grapher.ValidateRelations(o.Relations))
 
 	// TODO(sqs): check that docs point to valid defs in the same source unit
 
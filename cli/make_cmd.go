@@ -15,6 +15,7 @@ import (
 	"sourcegraph.com/sourcegraph/srclib"
 	"sourcegraph.com/sourcegraph/srclib/buildstore"
 	"sourcegraph.com/sourcegraph/srclib/config"
+	"sourcegraph.com/sourcegraph/srclib/grapher"
 	"sourcegraph.com/sourcegraph/srclib/plan"
 )
 
@@ -37,6 +38,8 @@ type MakeCmd struct {
 
 	Parallel int `short:"j" long:"jobs" description:"allow N parallel jobs" value-name:"N" default-mask:"GOMAXPROCS"`
 
+	Repair bool `long:"repair" description:"drop or fix invalid graph data records instead of failing (repairs are recorded in *.graph-repairs.json files; graph data that is already up to date is not rebuilt)"`
+
 	Dir Directory `short:"C" long:"directory" description:"change to DIR before doing anything" value-name:"DIR"`
 
 	Args struct {
@@ -64,6 +67,16 @@ func (c *MakeCmd) Execute(args []string) error {
 	if err != nil {
 		return err
 	}
+	if c.Repair {
+		for _, rule := range mf.Rules {
+			switch rule := rule.(type) {
+			case *grapher.GraphUnitRule:
+				rule.Repair = true
+			case *grapher.GraphMultiUnitsRule:
+				rule.Repair = true
+			}
+		}
+	}
 
 	goals := c.Args.Goals
 	if len(goals) == 0 {
@@ -2,6 +2,7 @@ package cli
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -23,11 +24,14 @@ import (
 	"sort"
 
 	"sourcegraph.com/sourcegraph/go-flags"
-	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/makex"
+	"sourcegraph.com/sourcegraph/srclib"
 	"sourcegraph.com/sourcegraph/srclib/config"
+	"sourcegraph.com/sourcegraph/srclib/docrender"
 	"sourcegraph.com/sourcegraph/srclib/graph"
 	"sourcegraph.com/sourcegraph/srclib/grapher"
 	"sourcegraph.com/sourcegraph/srclib/plan"
+	"sourcegraph.com/sourcegraph/srclib/srcpos"
 	"sourcegraph.com/sourcegraph/srclib/store"
 	"sourcegraph.com/sourcegraph/srclib/unit"
 )
@@ -42,22 +46,28 @@ func init() {
 		if err != nil {
 			log.Fatal(err)
 		}
-		lrepo, _ := OpenLocalRepo()
-		if lrepo != nil && lrepo.RootDir != "" {
-			absDir, err := os.Getwd()
-			if err != nil {
-				log.Fatal(err)
-			}
-			relDir, err := filepath.Rel(absDir, lrepo.RootDir)
-			if err == nil {
-				SetOptionDefaultValue(storeC.Group, "root", filepath.Join(relDir, store.SrclibStoreDir))
-			}
-		}
+		setStoreRootDefault(storeC.Group)
 
 		InitStoreCmds(storeC)
 	})
 }
 
+// setStoreRootDefault sets the default value of the --root option (in
+// group g) to the store dir of the local repo, if any.
+func setStoreRootDefault(g *flags.Group) {
+	lrepo, _ := OpenLocalRepo()
+	if lrepo != nil && lrepo.RootDir != "" {
+		absDir, err := os.Getwd()
+		if err != nil {
+			log.Fatal(err)
+		}
+		relDir, err := filepath.Rel(absDir, lrepo.RootDir)
+		if err == nil {
+			SetOptionDefaultValue(g, "root", filepath.Join(relDir, store.SrclibStoreDir))
+		}
+	}
+}
+
 func InitStoreCmds(c *flags.Command) {
 	importC, err := c.AddCommand("import",
 		"import data",
@@ -132,6 +142,70 @@ func InitStoreCmds(c *flags.Command) {
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	_, err = c.AddCommand("relations",
+		"list a def's supertypes, subtypes, and implementers",
+		"The relations command lists the relations (implements, extends, and overrides) from and to a def, across all source units and repos in the store. Relations from the def (its supertypes and the methods it overrides) are only listed if --commit, --unit-type, and --unit (and --repo, in a multi-repo store) are set.",
+		&storeRelationsCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("doc",
+		"show a def's docs",
+		"The doc command renders the docs of the defs with the given path (in the given repo, commit, and source unit, if set) as plain text for viewing in a terminal, or as sanitized HTML. Intra-doc links to other defs are resolved using the store.",
+		&storeDocCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("migrate-repo-paths",
+		"change the layout of repos in a MultiRepoStore",
+		"The migrate-repo-paths command moves all repos in a MultiRepoStore (at --root) from one directory layout to another. The store must not be used while the migration is running.",
+		&storeMigrateRepoPathsCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("stats",
+		"show store statistics",
+		"The stats command summarizes the contents of the store (counts of units, defs, refs and docs, broken refs, and bytes on disk for data and each index) per repo, version and source unit.",
+		&storeStatsCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	exportC, err := c.AddCommand("export",
+		"export a version to an archive",
+		"The export command writes the data (units, defs, refs, docs, relations and annotations) for a single repo version to a portable archive file, which can be loaded into any store with the load command.",
+		&storeExportCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	SetDefaultCommitIDOpt(exportC)
+
+	_, err = c.AddCommand("load",
+		"load an archive",
+		"The load command imports an archive written by the export command into the store.",
+		&storeLoadCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = c.AddCommand("gc-blobs",
+		"remove unreferenced blobs",
+		"The gc-blobs command removes the def data blobs in a MultiRepoStore (created when its config sets dedupBlobs) that are no longer referenced by any def, and reports the store's dedup ratio. It must not be run while data is being imported into the store.",
+		&storeGCBlobsCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
 // OpenStore is called by all of the store subcommands to open the
@@ -139,9 +213,11 @@ func InitStoreCmds(c *flags.Command) {
 var OpenStore func() (interface{}, error) = storeCmd.store
 
 type StoreCmd struct {
-	Type   string `short:"t" long:"type" description:"the (multi-)repo store type to use (RepoStore, MultiRepoStore, etc.)" default:"RepoStore"`
+	Type   string `short:"t" long:"type" description:"the (multi-)repo store type to use (RepoStore, MultiRepoStore, Union)" default:"RepoStore"`
 	Root   string `short:"r" long:"root" description:"the root of the store (repo clone dir for RepoStore, global path for MultiRepoStore, etc.)" default:".srclib-store"`
 	Config string `long:"config" description:"(rarely used) JSON-encoded config for extra config, specific to each store type"`
+
+	IndexCacheSize int64 `long:"index-cache-size" description:"max approximate size (in bytes) of loaded indexes to keep in memory (0 disables the cache)" default:"268435456"`
 }
 
 var storeCmd StoreCmd
@@ -151,23 +227,131 @@ func (c *StoreCmd) Execute(args []string) error { return nil }
 // store returns the store specified by StoreCmd's Type and Root
 // options.
 func (c *StoreCmd) store() (interface{}, error) {
-	fs := rwvfs.OS(c.Root)
+	store.SetIndexCacheMaxBytes(c.IndexCacheSize)
 
-	type createParents interface {
-		CreateParentDirs(bool)
-	}
-	if fs, ok := fs.(createParents); ok {
-		fs.CreateParentDirs(true)
+	if c.Type == "Union" {
+		return c.unionStore()
 	}
 
+	fs := store.OSFS(c.Root)
+
 	switch c.Type {
 	case "RepoStore":
-		return store.NewFSRepoStore(rwvfs.Walkable(fs)), nil
+		return store.NewFSRepoStore(fs), nil
 	case "MultiRepoStore":
-		return store.NewFSMultiRepoStore(rwvfs.Walkable(fs), nil), nil
+		var conf multiRepoStoreConfig
+		if c.Config != "" {
+			if err := json.Unmarshal([]byte(c.Config), &conf); err != nil {
+				return nil, fmt.Errorf("parsing MultiRepoStore config: %s", err)
+			}
+		}
+		repoPaths, err := repoPathsByName(conf.RepoPaths)
+		if err != nil {
+			return nil, err
+		}
+		return store.NewFSMultiRepoStore(fs, &store.FSMultiRepoStoreConf{RepoPaths: repoPaths, DedupBlobs: conf.DedupBlobs}), nil
+	default:
+		return nil, fmt.Errorf("unrecognized store --type value: %q (valid values are RepoStore, MultiRepoStore, Union)", c.Type)
+	}
+}
+
+// multiRepoStoreConfig is the --config value for the MultiRepoStore
+// store type.
+type multiRepoStoreConfig struct {
+	// RepoPaths is the layout of the repos in the store: "default"
+	// (the default) or "hashed".
+	RepoPaths string `json:"repoPaths,omitempty"`
+
+	// DedupBlobs is whether imports store def data once in a
+	// content-addressed blob store (see
+	// store.FSMultiRepoStoreConf.DedupBlobs).
+	DedupBlobs bool `json:"dedupBlobs,omitempty"`
+}
+
+// repoPathsByName returns the store.RepoPaths layout with the given
+// name.
+func repoPathsByName(name string) (store.RepoPaths, error) {
+	switch name {
+	case "", "default":
+		return store.DefaultRepoPaths, nil
+	case "hashed":
+		return store.HashedRepoPaths, nil
+	}
+	return nil, fmt.Errorf("unrecognized repo paths layout: %q (valid values are default, hashed)", name)
+}
+
+// unionStoreConfig is the --config value for the Union store type.
+type unionStoreConfig struct {
+	// Roots are the stores whose results are merged. Each root is
+	// either a string (the root dir of a MultiRepoStore) or an object
+	// with "type", "root", and (optionally) "config" fields.
+	Roots []unionStoreRoot `json:"roots"`
+
+	// Precedence determines which root's data is used when more than
+	// one root contains the same repo and commit ID: "first" (the
+	// default) or "last".
+	Precedence string `json:"precedence,omitempty"`
+}
+
+type unionStoreRoot struct {
+	Type   string          `json:"type,omitempty"`
+	Root   string          `json:"root"`
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+func (r *unionStoreRoot) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &r.Root); err == nil {
+		return nil
+	}
+	type root unionStoreRoot // prevent recursion
+	return json.Unmarshal(data, (*root)(r))
+}
+
+// unionStore returns a MultiRepoStore that merges the results of the
+// stores listed in the Union store's config.
+func (c *StoreCmd) unionStore() (interface{}, error) {
+	if c.Config == "" {
+		return nil, errors.New(`the Union store type requires --config '{"roots": [...]}'`)
+	}
+	var conf unionStoreConfig
+	if err := json.Unmarshal([]byte(c.Config), &conf); err != nil {
+		return nil, fmt.Errorf("parsing Union store config: %s", err)
+	}
+	if len(conf.Roots) == 0 {
+		return nil, errors.New("Union store config has no roots")
+	}
+
+	stores := make([]store.MultiRepoStore, len(conf.Roots))
+	for i, root := range conf.Roots {
+		rc := StoreCmd{Type: root.Type, Root: root.Root, IndexCacheSize: c.IndexCacheSize}
+		if rc.Type == "" {
+			rc.Type = "MultiRepoStore"
+		}
+		if len(root.Config) != 0 {
+			rc.Config = string(root.Config)
+		}
+		s, err := rc.store()
+		if err != nil {
+			return nil, fmt.Errorf("Union store root %q: %s", root.Root, err)
+		}
+		mrs, ok := s.(store.MultiRepoStore)
+		if !ok {
+			return nil, fmt.Errorf("Union store root %q: store type %s is not a MultiRepoStore", root.Root, rc.Type)
+		}
+		stores[i] = mrs
+	}
+
+	switch conf.Precedence {
+	case "", "first":
+	case "last":
+		for i, j := 0, len(stores)-1; i < j; i, j = i+1, j-1 {
+			stores[i], stores[j] = stores[j], stores[i]
+		}
 	default:
-		return nil, fmt.Errorf("unrecognized store --type value: %q (valid values are RepoStore, MultiRepoStore)", c.Type)
+		return nil, fmt.Errorf("unrecognized Union store precedence: %q (valid values are first, last)", conf.Precedence)
 	}
+
+	return store.NewUnionMultiRepoStore(stores...), nil
 }
 
 type StoreImportCmd struct {
@@ -220,6 +404,9 @@ type ImportOpt struct {
 	Unit     string `long:"unit" description:"only import source units with this name"`
 	UnitType string `long:"unit-type" description:"only import source units with this type"`
 	CommitID string `long:"commit" description:"commit ID of commit whose data to import"`
+	Branch   string `long:"branch" description:"branch that the commit is on (recorded in the version's metadata)"`
+
+	LockTimeout time.Duration `long:"lock-timeout" description:"age after which another importer's lock on the version is considered stale" default:"1h"`
 
 	Verbose bool
 }
@@ -238,6 +425,29 @@ func Import(buildDataFS vfs.FileSystem, stor interface{}, opt ImportOpt) error {
 		return fmt.Errorf("error calling plan.Makefile: %s", err)
 	}
 
+	// Hold the version's write lock for the whole import so that
+	// concurrent imports of the same version (e.g., by other
+	// processes) fail instead of interleaving their writes.
+	if !opt.DryRun {
+		var unlock func() error
+		switch l := stor.(type) {
+		case store.RepoLocker:
+			unlock, err = l.LockVersion(opt.CommitID, opt.LockTimeout)
+		case store.MultiRepoLocker:
+			unlock, err = l.LockVersion(opt.Repo, opt.CommitID, opt.LockTimeout)
+		}
+		if err != nil {
+			return err
+		}
+		if unlock != nil {
+			defer func() {
+				if err := unlock(); err != nil {
+					log.Printf("Warning: failed to release lock on %s@%s: %s", opt.Repo, opt.CommitID, err)
+				}
+			}()
+		}
+	}
+
 	// hasIndexableData is set if at least one source unit's graph data is
 	// successfully imported to the graph store.
 	//
@@ -253,7 +463,16 @@ func Import(buildDataFS vfs.FileSystem, stor interface{}, opt ImportOpt) error {
 		hasIndexableData bool
 	)
 
-	importGraphData := func(graphFile string, sourceUnit *unit.SourceUnit) error {
+	// meta is recorded with the version once all of the data is
+	// imported.
+	meta := &store.VersionMeta{
+		ImportedAt:    time.Now().UTC(),
+		Branch:        opt.Branch,
+		SrclibVersion: Version,
+	}
+	toolchains := map[string]struct{}{}
+
+	importGraphData := func(graphFile string, sourceUnit *unit.SourceUnit, tool *srclib.ToolRef) error {
 		var data graph.Output
 		if err := readJSONFileFS(buildDataFS, graphFile, &data); err != nil {
 			if err == errEmptyJSONFile {
@@ -299,41 +518,30 @@ func Import(buildDataFS vfs.FileSystem, stor interface{}, opt ImportOpt) error {
 
 		mu.Lock()
 		hasIndexableData = true
+		meta.NumUnits++
+		meta.NumDefs += len(data.Defs)
+		meta.NumRefs += len(data.Refs)
+		if tool != nil {
+			toolchains[tool.Toolchain] = struct{}{}
+		}
 		mu.Unlock()
 
 		return nil
 	}
 
 	par := parallel.NewRun(10)
-	for _, rule_ := range mf.Rules {
-		rule := rule_
-		switch rule := rule.(type) {
-		case *grapher.GraphUnitRule:
-			if (opt.Unit != "" && rule.Unit.Name != opt.Unit) || (opt.UnitType != "" && rule.Unit.Type != opt.UnitType) {
-				continue
-			}
-			par.Acquire()
-			go func() {
-				defer par.Release()
-				if err := importGraphData(rule.Target(), rule.Unit); err != nil {
-					par.Error(err)
-				}
-			}()
-		case *grapher.GraphMultiUnitsRule:
-			for target_, sourceUnit_ := range rule.Targets() {
-				target, sourceUnit := target_, sourceUnit_
-				if (opt.Unit != "" && sourceUnit.Name != opt.Unit) || (opt.UnitType != "" && sourceUnit.Type != opt.UnitType) {
-					continue
-				}
-				par.Acquire()
-				go func() {
-					defer par.Release()
-					if err := importGraphData(target, sourceUnit); err != nil {
-						par.Error(err)
-					}
-				}()
-			}
+	for _, t_ := range graphDataTargets(mf) {
+		t := t_
+		if (opt.Unit != "" && t.unit.Name != opt.Unit) || (opt.UnitType != "" && t.unit.Type != opt.UnitType) {
+			continue
 		}
+		par.Acquire()
+		go func() {
+			defer par.Release()
+			if err := importGraphData(t.file, t.unit, t.tool); err != nil {
+				par.Error(err)
+			}
+		}()
 	}
 	if err := par.Wait(); err != nil {
 		return err
@@ -355,13 +563,18 @@ func Import(buildDataFS vfs.FileSystem, stor interface{}, opt ImportOpt) error {
 		}
 	}
 
+	for toolchain := range toolchains {
+		meta.Toolchains = append(meta.Toolchains, toolchain)
+	}
+	sort.Strings(meta.Toolchains)
+
 	switch imp := stor.(type) {
 	case store.RepoImporter:
-		if err := imp.CreateVersion(opt.CommitID); err != nil {
+		if err := imp.CreateVersion(opt.CommitID, meta); err != nil {
 			return fmt.Errorf("error running store.RepoImporter.CreateVersion: %s", err)
 		}
 	case store.MultiRepoImporter:
-		if err := imp.CreateVersion(opt.Repo, opt.CommitID); err != nil {
+		if err := imp.CreateVersion(opt.Repo, opt.CommitID, meta); err != nil {
 			return fmt.Errorf("error running store.MultiRepoImporter.CreateVersion: %s", err)
 		}
 	}
@@ -369,6 +582,37 @@ func Import(buildDataFS vfs.FileSystem, stor interface{}, opt ImportOpt) error {
 	return nil
 }
 
+// A graphDataTarget is a graph data file in the build data, and the
+// source unit whose graph data it holds.
+type graphDataTarget struct {
+	file string
+	unit *unit.SourceUnit
+	tool *srclib.ToolRef // toolchain that produced the file
+}
+
+// graphDataTargets returns the graph data files that are the targets
+// of the rules in mf.
+func graphDataTargets(mf *makex.Makefile) []graphDataTarget {
+	var targets []graphDataTarget
+	for _, rule := range mf.Rules {
+		switch rule := rule.(type) {
+		case *grapher.GraphUnitRule:
+			targets = append(targets, graphDataTarget{file: rule.Target(), unit: rule.Unit, tool: rule.Tool})
+		case *grapher.GraphMultiUnitsRule:
+			ruleTargets := rule.Targets()
+			files := make([]string, 0, len(ruleTargets))
+			for file := range ruleTargets {
+				files = append(files, file)
+			}
+			sort.Strings(files) // for a deterministic order
+			for _, file := range files {
+				targets = append(targets, graphDataTarget{file: file, unit: ruleTargets[file], tool: rule.Tool})
+			}
+		}
+	}
+	return targets
+}
+
 // sample imports sample data (when the --sample option is given).
 func (c *StoreImportCmd) sample(s interface{}) error {
 	dataString := []byte(`"abcdabcdabcdabcdabcdcdabcdabcdabcdabcdabcdabcdabcdabcdcdabcdabcdabcdabcdabcdabcdabcdabcdcdabcdabcdabcdabcdabcdabcdabcdabcdcdabcdabcdabcdabcdabcdabcdabcdabcdcdabcdabcdabcdabcdabcdabcdabcdabcdcdabcdabcdabcdabcdabcdabcdabcdabcdcdabcdabcdabcd"`)
@@ -519,13 +763,20 @@ func (c *StoreImportCmd) sample(s interface{}) error {
 	}
 	log.Printf("Index took %s (~%s per def/ref)", time.Since(start), time.Duration(int64(time.Since(start))/int64(len(data.Defs)+len(data.Refs))))
 
+	meta := &store.VersionMeta{
+		ImportedAt:    time.Now().UTC(),
+		SrclibVersion: Version,
+		NumUnits:      1,
+		NumDefs:       len(data.Defs),
+		NumRefs:       len(data.Refs),
+	}
 	switch imp := s.(type) {
 	case store.RepoImporter:
-		if err := imp.CreateVersion(c.CommitID); err != nil {
+		if err := imp.CreateVersion(c.CommitID, meta); err != nil {
 			return err
 		}
 	case store.MultiRepoImporter:
-		if err := imp.CreateVersion(c.Repo, c.CommitID); err != nil {
+		if err := imp.CreateVersion(c.Repo, c.CommitID, meta); err != nil {
 			return err
 		}
 	}
@@ -835,6 +1086,11 @@ type StoreVersionsCmd struct {
 	CommitIDPrefix string `long:"commit" description:"commit ID prefix"`
 
 	RepoCommitIDs string `long:"repo-commits" description:"comma-separated list of repo@commitID specifiers"`
+
+	Branch string `long:"branch" description:"only show versions imported from this branch"`
+	Newest int    `long:"newest" description:"only show the N most recently imported versions"`
+
+	Format string `long:"format" description:"output format ('text' or 'json')" default:"text"`
 }
 
 func (c *StoreVersionsCmd) filters() []store.VersionFilter {
@@ -850,12 +1106,22 @@ func (c *StoreVersionsCmd) filters() []store.VersionFilter {
 	if c.RepoCommitIDs != "" {
 		fs = append(fs, makeRepoCommitIDsFilter(c.RepoCommitIDs))
 	}
+	if c.Branch != "" {
+		fs = append(fs, store.ByBranch(c.Branch))
+	}
+	if c.Newest != 0 {
+		fs = append(fs, store.NewestVersions(c.Newest))
+	}
 	return fs
 }
 
 var storeVersionsCmd StoreVersionsCmd
 
 func (c *StoreVersionsCmd) Execute(args []string) error {
+	if c.Newest < 0 {
+		return errors.New("--newest must be >= 0")
+	}
+
 	s, err := OpenStore()
 	if err != nil {
 		return err
@@ -870,11 +1136,29 @@ func (c *StoreVersionsCmd) Execute(args []string) error {
 	if err != nil {
 		return err
 	}
+	if c.Format == "json" {
+		PrintJSON(versions, "  ")
+		return nil
+	}
 	for _, version := range versions {
 		if version.Repo != "" {
 			colorable.Print(version.Repo, "\t")
 		}
-		colorable.Println(version.CommitID)
+		colorable.Print(version.CommitID)
+		if m := version.Meta; m != nil {
+			colorable.Printf("\t%s", m.ImportedAt.Format(time.RFC3339))
+			if m.Branch != "" {
+				colorable.Printf("\tbranch=%s", m.Branch)
+			}
+			if m.SrclibVersion != "" {
+				colorable.Printf("\tsrclib=%s", m.SrclibVersion)
+			}
+			if len(m.Toolchains) > 0 {
+				colorable.Printf("\ttoolchains=%s", strings.Join(m.Toolchains, ","))
+			}
+			colorable.Printf("\t%d units, %d defs, %d refs", m.NumUnits, m.NumDefs, m.NumRefs)
+		}
+		colorable.Println()
 	}
 	return nil
 }
@@ -946,12 +1230,19 @@ type StoreDefsCmd struct {
 
 	Query string `long:"query"`
 
-	Limit  int `short:"n" long:"limit" description:"max results to return (0 for all)"`
-	Offset int `long:"offset" description:"results offset (0 to start with first results)"`
+	Format string `long:"format" description:"output format ('json', or 'text' to print each def's qualified name and type)" default:"json"`
+
+	Limit  int    `short:"n" long:"limit" description:"max results to return (0 for all)"`
+	Offset int    `long:"offset" description:"results offset (0 to start with first results)"`
+	After  string `long:"after" description:"only return results after this cursor (printed after each full page)"`
+
+	Explain bool `long:"explain" description:"print how the store executed the query (stores opened, indexes used, data read) to stderr"`
 
 	// If Filter is non-nil, it is applied along with the above
 	// filters.
 	Filter store.DefFilter
+
+	trace *store.Trace // set if Explain
 }
 
 func (c *StoreDefsCmd) filters() []store.DefFilter {
@@ -983,6 +1274,12 @@ func (c *StoreDefsCmd) filters() []store.DefFilter {
 	if c.Filter != nil {
 		fs = append(fs, c.Filter)
 	}
+	if c.trace != nil {
+		fs = append(fs, store.TraceTo(c.trace))
+	}
+	if c.After != "" {
+		fs = append(fs, store.After(parseCursorFlag(c.After)))
+	}
 	if c.Limit != 0 || c.Offset != 0 {
 		fs = append(fs, store.Limit(c.Limit, c.Offset))
 	}
@@ -991,15 +1288,84 @@ func (c *StoreDefsCmd) filters() []store.DefFilter {
 
 var storeDefsCmd StoreDefsCmd
 
+// parseCursorFlag parses the value of an --after flag, exiting if it
+// is not a valid cursor.
+func parseCursorFlag(s string) *store.Cursor {
+	c, err := store.ParseCursor(s)
+	if err != nil {
+		log.Fatalf("invalid --after cursor: %s", err)
+	}
+	return c
+}
+
 func (c *StoreDefsCmd) Execute(args []string) error {
+	if c.Explain {
+		c.trace = &store.Trace{}
+	}
+	t0 := time.Now()
 	defs, err := c.Get()
 	if err != nil {
 		return err
 	}
-	PrintJSON(defs, "  ")
+	elapsed := time.Since(t0)
+	switch c.Format {
+	case "json":
+		PrintJSON(defs, "  ")
+	case "text":
+		for _, def := range defs {
+			colorable.Println(formatDef(def))
+		}
+	default:
+		return fmt.Errorf("invalid --format %q (valid formats are json and text)", c.Format)
+	}
+	if c.Limit != 0 && len(defs) == c.Limit {
+		log.Printf("# Next page: --after=%s", store.DefCursor(defs[len(defs)-1]))
+	}
+	if c.Explain {
+		logExplanation(c.trace, len(defs), elapsed)
+	}
+	logIndexCacheStats()
 	return nil
 }
 
+// formatDef formats a def as its keyword, scope-qualified name, and
+// type (e.g., "func (*T).M(x int)"), followed by its location. Defs
+// that have no DefFormatter (see graph.LookupDefFormatter) are
+// formatted as their kind and name.
+func formatDef(def *graph.Def) string {
+	var s string
+	if f := graph.LookupDefFormatter(def); f != nil {
+		s = f.Name(graph.ScopeQualified) + f.NameAndTypeSeparator() + f.Type(graph.ScopeQualified)
+		if kw := f.DefKeyword(); kw != "" {
+			s = kw + " " + s
+		}
+	} else {
+		s = strings.TrimSpace(def.Kind + " " + def.Name)
+	}
+	return fmt.Sprintf("%s\t%s:%d-%d", s, def.File, def.DefStart, def.DefEnd)
+}
+
+// logExplanation prints the spans recorded in t while executing a
+// query, followed by a summary.
+func logExplanation(t *store.Trace, numResults int, elapsed time.Duration) {
+	log.Printf("# Query plan:")
+	for _, s := range t.Spans() {
+		log.Printf("#  - %s", s)
+	}
+	files, bytes := t.DataRead()
+	log.Printf("# %d results in %s; read %d data files (%s)", numResults, elapsed, files, bytesString(uint64(bytes)))
+}
+
+// logIndexCacheStats prints the index cache statistics (in verbose
+// mode).
+func logIndexCacheStats() {
+	if !GlobalOpt.Verbose {
+		return
+	}
+	st := store.GetIndexCacheStats()
+	log.Printf("# Index cache: %d hits, %d misses, %d evictions; %d loads in %s; %d indexes (%d of %d bytes)", st.Hits, st.Misses, st.Evictions, st.Loads, st.LoadTime, st.Entries, st.Bytes, st.MaxBytes)
+}
+
 func (c *StoreDefsCmd) Get() ([]*graph.Def, error) {
 	s, err := OpenStore()
 	if err != nil {
@@ -1030,18 +1396,29 @@ type StoreRefsCmd struct {
 	Start uint32 `long:"start"`
 	End   uint32 `long:"end"`
 
+	Line    int    `long:"line" description:"only show refs that start on this line (1-based) of --file (which is read from the current repo)"`
+	Col     int    `long:"col" description:"only show refs that contain this column (1-based) of --line"`
+	ColUnit string `long:"col-unit" description:"unit of --col ('bytes', 'runes', or 'utf-16')" default:"bytes"`
+
 	DefRepo     string `long:"def-repo"`
 	DefUnitType string `long:"def-unit-type" `
 	DefUnit     string `long:"def-unit"`
 	DefPath     string `long:"def-path"`
 
+	Kind string `long:"kind" description:"only show refs of these comma-separated kinds (call, read, write, type-reference, import, inherit, override)"`
+
 	Broken   bool `long:"broken" description:"only show refs that point to nonexistent defs"`
 	Coverage bool `long:"coverage" description:"print a coverage summary (resolved refs, broken refs, total refs)"`
 
 	Format string `long:"format" description:"output format ('json' or 'none')" default:"json"`
 
-	Limit  int `short:"n" long:"limit" description:"max results to return (0 for all)"`
-	Offset int `long:"offset" description:"results offset (0 to start with first results)"`
+	Limit  int    `short:"n" long:"limit" description:"max results to return (0 for all)"`
+	Offset int    `long:"offset" description:"results offset (0 to start with first results)"`
+	After  string `long:"after" description:"only return results after this cursor (printed after each full page)"`
+
+	Explain bool `long:"explain" description:"print how the store executed the query (stores opened, indexes used, data read) to stderr"`
+
+	trace *store.Trace // set if Explain
 }
 
 func (c *StoreRefsCmd) filters() []store.RefFilter {
@@ -1074,6 +1451,9 @@ func (c *StoreRefsCmd) filters() []store.RefFilter {
 			return ref.End <= c.End
 		}))
 	}
+	if c.Line != 0 || c.Col != 0 {
+		fs = append(fs, c.positionFilter())
+	}
 	if c.DefPath != "" {
 		fs = append(fs, store.ByRefDef(graph.RefDefKey{
 			DefRepo:     c.DefRepo,
@@ -1099,23 +1479,95 @@ func (c *StoreRefsCmd) filters() []store.RefFilter {
 			})))
 		}
 	}
+	if c.Kind != "" {
+		kinds := strings.Split(c.Kind, ",")
+		for _, kind := range kinds {
+			if !graph.IsValidRefKind(kind) {
+				log.Fatalf("invalid --kind %q (valid kinds are %s)", kind, strings.Join(graph.RefKinds, ", "))
+			}
+		}
+		fs = append(fs, store.ByRefKind(kinds...))
+	}
+	if c.trace != nil {
+		fs = append(fs, store.TraceTo(c.trace))
+	}
+	if c.After != "" {
+		fs = append(fs, store.After(parseCursorFlag(c.After)))
+	}
 	if c.Limit != 0 || c.Offset != 0 {
 		fs = append(fs, store.Limit(c.Limit, c.Offset))
 	}
 	return fs
 }
 
+// positionFilter returns a filter that matches refs that start on
+// --line of --file or, if --col is set, that contain that position.
+func (c *StoreRefsCmd) positionFilter() store.RefFilter {
+	if c.File == "" {
+		log.Fatal("must specify --file with --line")
+	}
+	if c.Line < 1 {
+		log.Fatalf("invalid --line %d (lines are numbered from 1, and --col requires --line)", c.Line)
+	}
+	if c.Col < 0 {
+		log.Fatalf("invalid --col %d (columns are numbered from 1)", c.Col)
+	}
+	enc := srcpos.Encoding(c.ColUnit)
+	if !srcpos.IsValidEncoding(enc) {
+		log.Fatalf("invalid --col-unit %q (valid units are %v)", c.ColUnit, srcpos.Encodings)
+	}
+
+	file := path.Clean(c.File)
+	if lrepo, _ := OpenLocalRepo(); lrepo != nil && lrepo.RootDir != "" {
+		file = filepath.Join(lrepo.RootDir, file)
+	}
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	table := srcpos.NewTable(src)
+
+	if c.Col == 0 {
+		start, end, err := table.LineOffsets(c.Line - 1)
+		if err != nil {
+			log.Fatalf("%s: %s", c.File, err)
+		}
+		return store.RefFilterFunc(func(ref *graph.Ref) bool {
+			return ref.Start >= start && ref.Start <= end
+		})
+	}
+	off, err := table.Offset(srcpos.Position{Line: c.Line - 1, Column: c.Col - 1}, enc)
+	if err != nil {
+		log.Fatalf("%s: %s", c.File, err)
+	}
+	return store.RefFilterFunc(func(ref *graph.Ref) bool {
+		return ref.Start == off || (ref.Start < off && off < ref.End)
+	})
+}
+
 var storeRefsCmd StoreRefsCmd
 
 func (c *StoreRefsCmd) Execute(args []string) error {
+	if c.Explain {
+		c.trace = &store.Trace{}
+	}
+	t0 := time.Now()
 	refs, err := c.Get()
 	if err != nil {
 		return err
 	}
+	elapsed := time.Since(t0)
 	switch c.Format {
 	case "json":
 		PrintJSON(refs, "  ")
 	}
+	if c.Limit != 0 && len(refs) == c.Limit {
+		log.Printf("# Next page: --after=%s", store.RefCursor(refs[len(refs)-1]))
+	}
+	if c.Explain {
+		logExplanation(c.trace, len(refs), elapsed)
+	}
+	logIndexCacheStats()
 	return nil
 }
 
@@ -1225,3 +1677,394 @@ func makeRepoCommitIDsFilter(repoCommitIDs string) interface {
 	}
 	return store.ByRepoCommitIDs(vs...)
 }
+
+type StoreRelationsCmd struct {
+	Repo     string `long:"repo" description:"repo of the def"`
+	CommitID string `long:"commit" description:"commit ID of the def"`
+	UnitType string `long:"unit-type" description:"source unit type of the def"`
+	Unit     string `long:"unit" description:"source unit of the def"`
+	Path     string `long:"path" description:"path of the def" required:"yes"`
+
+	Kind string `long:"kind" description:"only show relations of these comma-separated kinds (implements, extends, overrides)"`
+
+	Format string `long:"format" description:"output format ('text' or 'json')" default:"text"`
+}
+
+var storeRelationsCmd StoreRelationsCmd
+
+func (c *StoreRelationsCmd) Execute(args []string) error {
+	if (c.UnitType != "" && c.Unit == "") || (c.UnitType == "" && c.Unit != "") {
+		return errors.New("must specify either both or neither of --unit-type and --unit")
+	}
+	var kindFilter []store.RelationFilter
+	if c.Kind != "" {
+		kinds := strings.Split(c.Kind, ",")
+		for _, kind := range kinds {
+			if !graph.IsValidRelationKind(kind) {
+				return fmt.Errorf("invalid --kind %q (valid kinds are %s)", kind, strings.Join(graph.RelationKinds, ", "))
+			}
+		}
+		kindFilter = append(kindFilter, store.ByRelationKinds(kinds...))
+	}
+
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+	rs, ok := s.(store.RelationStore)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement listing relations", s)
+	}
+
+	var from []*graph.Relation
+	if _, isMulti := s.(store.MultiRepoStore); c.CommitID != "" && c.Unit != "" && (c.Repo != "" || !isMulti) {
+		key := graph.DefKey{Repo: c.Repo, CommitID: c.CommitID, UnitType: c.UnitType, Unit: c.Unit, Path: c.Path}
+		from, err = rs.Relations(append(kindFilter, store.ByRelationFrom(key))...)
+		if err != nil {
+			return err
+		}
+	} else {
+		log.Printf("# Not listing relations from the def (--commit, --unit-type, --unit, and, in a multi-repo store, --repo are required).")
+	}
+	to, err := rs.Relations(append(kindFilter, store.ByRelationTo(graph.RefDefKey{
+		DefRepo:     c.Repo,
+		DefUnitType: c.UnitType,
+		DefUnit:     c.Unit,
+		DefPath:     c.Path,
+	}))...)
+	if err != nil {
+		return err
+	}
+
+	if c.Format == "json" {
+		PrintJSON(struct{ From, To []*graph.Relation }{from, to}, "  ")
+		return nil
+	}
+
+	printSection := func(title string, rels []*graph.Relation, kind string, outgoing bool) {
+		var keys []graph.DefKey
+		for _, rel := range rels {
+			if rel.Kind != kind {
+				continue
+			}
+			if outgoing {
+				keys = append(keys, rel.ToDefKey())
+			} else {
+				keys = append(keys, rel.From)
+			}
+		}
+		if len(keys) == 0 {
+			return
+		}
+		colorable.Println(title + ":")
+		for _, key := range keys {
+			repo := key.Repo
+			if key.CommitID != "" {
+				repo += "@" + key.CommitID
+			}
+			colorable.Printf("\t%s\t%s %s\t%s\n", repo, key.Unit, key.UnitType, key.Path)
+		}
+	}
+	printSection("Implements", from, graph.RelationImplements, true)
+	printSection("Extends", from, graph.RelationExtends, true)
+	printSection("Overrides", from, graph.RelationOverrides, true)
+	printSection("Implementers", to, graph.RelationImplements, false)
+	printSection("Subtypes", to, graph.RelationExtends, false)
+	printSection("Overridden by", to, graph.RelationOverrides, false)
+	if len(from) == 0 && len(to) == 0 {
+		log.Printf("# No relations found.")
+	}
+	return nil
+}
+
+type StoreDocCmd struct {
+	Repo     string `long:"repo" description:"repo of the def"`
+	CommitID string `long:"commit" description:"commit ID of the def"`
+	UnitType string `long:"unit-type" description:"source unit type of the def"`
+	Unit     string `long:"unit" description:"source unit of the def"`
+	Def      string `long:"def" description:"path of the def" required:"yes"`
+
+	Format string `long:"format" description:"output format ('text' or 'html')" default:"text"`
+}
+
+var storeDocCmd StoreDocCmd
+
+func (c *StoreDocCmd) Execute(args []string) error {
+	if (c.UnitType != "" && c.Unit == "") || (c.UnitType == "" && c.Unit != "") {
+		return errors.New("must specify either both or neither of --unit-type and --unit")
+	}
+	render := docrender.Text
+	switch c.Format {
+	case "text":
+	case "html":
+		render = docrender.HTML
+	default:
+		return fmt.Errorf("invalid --format %q (valid formats are text and html)", c.Format)
+	}
+
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+	us, ok := s.(store.UnitStore)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement listing defs", s)
+	}
+
+	fs := []store.DefFilter{store.ByDefPath(c.Def)}
+	if c.Repo != "" {
+		fs = append(fs, store.ByRepos(c.Repo))
+	}
+	if c.CommitID != "" {
+		fs = append(fs, store.ByCommitIDs(c.CommitID))
+	}
+	if c.Unit != "" {
+		fs = append(fs, store.ByUnits(unit.ID2{Type: c.UnitType, Name: c.Unit}))
+	}
+	defs, err := us.Defs(fs...)
+	if err != nil {
+		return err
+	}
+	if len(defs) == 0 {
+		return fmt.Errorf("no def found with path %q", c.Def)
+	}
+
+	opt := docrender.Options{Links: docrender.NewStoreLinkResolver(us)}
+	for i, def := range defs {
+		doc := docrender.Preferred(def.Docs)
+		if doc == nil {
+			log.Printf("# No docs for %s %s %s.", def.Unit, def.UnitType, def.Path)
+			continue
+		}
+		out, err := render(def, doc, opt)
+		if err != nil {
+			return fmt.Errorf("rendering docs of %s %s %s: %s", def.Unit, def.UnitType, def.Path, err)
+		}
+		if c.Format == "html" {
+			colorable.Println(out)
+			continue
+		}
+		if i > 0 {
+			colorable.Println()
+		}
+		colorable.Println(formatDef(def))
+		colorable.Println()
+		colorable.Println(out)
+	}
+	return nil
+}
+
+type StoreExportCmd struct {
+	Repo     string `long:"repo" description:"repo to export (required for MultiRepoStores)"`
+	CommitID string `long:"commit" description:"commit ID of the version to export"`
+	Output   string `short:"o" long:"output" description:"archive file to write" required:"yes"`
+}
+
+var storeExportCmd StoreExportCmd
+
+func (c *StoreExportCmd) Execute(args []string) error {
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+
+	rs, ok := s.(store.RepoStore)
+	if !ok {
+		return fmt.Errorf("store (type %T) does not implement exporting", s)
+	}
+
+	f, err := os.Create(c.Output)
+	if err != nil {
+		return err
+	}
+	m, err := store.ExportVersion(f, rs, c.Repo, c.CommitID)
+	if err != nil {
+		f.Close()
+		os.Remove(c.Output)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if GlobalOpt.Verbose {
+		log.Printf("# Exported %d units of %s@%s to %s", len(m.Units), m.Repo, m.CommitID, c.Output)
+	}
+	return nil
+}
+
+type StoreLoadCmd struct {
+	Repo string `long:"repo" description:"repo to load the archive's data into (default: the repo recorded in the archive)"`
+
+	Args struct {
+		File string `name:"FILE" description:"archive file written by the export command"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+var storeLoadCmd StoreLoadCmd
+
+func (c *StoreLoadCmd) Execute(args []string) error {
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(c.Args.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	m, err := store.LoadArchive(f, s, c.Repo)
+	if err != nil {
+		return err
+	}
+	if GlobalOpt.Verbose {
+		log.Printf("# Loaded %d units of %s@%s from %s", len(m.Units), m.Repo, m.CommitID, c.Args.File)
+	}
+	return nil
+}
+
+type StoreMigrateRepoPathsCmd struct {
+	From string `long:"from" description:"current repo paths layout (default or hashed)" default:"default"`
+	To   string `long:"to" description:"new repo paths layout (default or hashed)" required:"yes"`
+}
+
+var storeMigrateRepoPathsCmd StoreMigrateRepoPathsCmd
+
+func (c *StoreMigrateRepoPathsCmd) Execute(args []string) error {
+	if storeCmd.Type != "MultiRepoStore" {
+		return fmt.Errorf("migrate-repo-paths requires --type=MultiRepoStore (got %q)", storeCmd.Type)
+	}
+	from, err := repoPathsByName(c.From)
+	if err != nil {
+		return err
+	}
+	to, err := repoPathsByName(c.To)
+	if err != nil {
+		return err
+	}
+
+	repos, err := store.MigrateRepoPaths(store.OSFS(storeCmd.Root), from, to)
+	if err != nil {
+		return err
+	}
+	log.Printf("# Migrated %d repos from %s to %s layout.", len(repos), c.From, c.To)
+	return nil
+}
+
+type StoreStatsCmd struct {
+	Largest int    `long:"largest" description:"number of largest source units to list" default:"10"`
+	Units   bool   `long:"units" description:"show stats for each source unit (text output only)"`
+	Format  string `long:"format" description:"output format ('text' or 'json')" default:"text"`
+}
+
+var storeStatsCmd StoreStatsCmd
+
+func (c *StoreStatsCmd) Execute(args []string) error {
+	if c.Largest < 0 {
+		return errors.New("--largest must be >= 0")
+	}
+
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+
+	st, err := store.Stats(s, c.Largest)
+	if err != nil {
+		return err
+	}
+	if c.Format == "json" {
+		PrintJSON(st, "  ")
+		return nil
+	}
+
+	printCounts := func(indent, label string, sc store.StatsCounts) {
+		colorable.Printf("%s%s\t%d units, %d defs, %d refs, %d docs, %d anns", indent, label, sc.NumUnits, sc.NumDefs, sc.NumRefs, sc.NumDocs, sc.NumAnns)
+		if sc.NumBrokenRefs > 0 {
+			colorable.Printf(", %d broken refs (%.1f%%)", sc.NumBrokenRefs, 100*sc.BrokenRefRatio)
+		}
+		colorable.Printf("\tdata %s, indexes %s\n", bytesString(uint64(sc.DataBytes)), bytesString(uint64(sc.IndexBytes)))
+	}
+	printIndexes := func(indent string, indexes map[string]int64) {
+		names := make([]string, 0, len(indexes))
+		for name := range indexes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			colorable.Printf("%s\tindex %s\t%s\n", indent, name, bytesString(uint64(indexes[name])))
+		}
+	}
+
+	for _, rs := range st.Repos {
+		repoIndent := ""
+		if rs.Repo != "" {
+			printCounts("", rs.Repo, rs.StatsCounts)
+			printIndexes("", rs.Indexes)
+			repoIndent = "\t"
+		}
+		for _, vs := range rs.Versions {
+			printCounts(repoIndent, vs.CommitID, vs.StatsCounts)
+			printIndexes(repoIndent, vs.Indexes)
+			if c.Units {
+				for _, us := range vs.Units {
+					printCounts(repoIndent+"\t", us.Unit.Name+" "+us.Unit.Type, us.StatsCounts)
+					printIndexes(repoIndent+"\t", us.Indexes)
+				}
+			}
+		}
+	}
+
+	if len(st.LargestUnits) > 0 {
+		colorable.Println()
+		colorable.Println("Largest source units:")
+		for _, us := range st.LargestUnits {
+			label := fmt.Sprintf("%s %s", us.Unit.Name, us.Unit.Type)
+			if us.Repo != "" {
+				label = fmt.Sprintf("%s@%s: %s", us.Repo, us.CommitID, label)
+			} else if us.CommitID != "" {
+				label = fmt.Sprintf("%s: %s", us.CommitID, label)
+			}
+			colorable.Printf("\t%s\t%s\n", label, bytesString(uint64(us.DataBytes+us.IndexBytes)))
+		}
+	}
+
+	colorable.Println()
+	printIndexes("", st.Indexes)
+	printCounts("", "Total", st.StatsCounts)
+	if st.Blobs != nil {
+		printBlobStats(*st.Blobs)
+	}
+	return nil
+}
+
+// printBlobStats prints a summary of a store's def data blobs.
+func printBlobStats(st store.BlobStats) {
+	colorable.Printf("Blobs\t%d blobs (%s) referenced by %d defs (%s); dedup ratio %.2f\n", st.NumBlobs, bytesString(uint64(st.Bytes)), st.NumRefs, bytesString(uint64(st.RefBytes)), st.DedupRatio)
+}
+
+type StoreGCBlobsCmd struct {
+	DryRun bool `short:"n" long:"dry-run" description:"only report the blobs that would be removed"`
+}
+
+var storeGCBlobsCmd StoreGCBlobsCmd
+
+func (c *StoreGCBlobsCmd) Execute(args []string) error {
+	s, err := OpenStore()
+	if err != nil {
+		return err
+	}
+
+	res, err := store.GCBlobs(s, c.DryRun)
+	if err != nil {
+		return err
+	}
+	verb := "Removed"
+	if c.DryRun {
+		verb = "Would remove"
+	}
+	log.Printf("# %s %d unreferenced blobs (%s).", verb, res.Removed, bytesString(uint64(res.RemovedBytes)))
+	printBlobStats(res.After)
+	return nil
+}
@@ -0,0 +1,387 @@
+// Package depgraph builds the dependency graph of a repo's source
+// units: which of the repo's units depend on which other units, and
+// on which units (or repos) outside the repo.
+//
+// A unit's deps are taken from the resolutions of its raw deps (the
+// output of its toolchain's depresolve tool), if any. Otherwise they
+// are taken from the deps listed in the unit's Dependencies field
+// (which the scanner emits), which only identify units in other repos
+// if the scanner knows their repos.
+package depgraph
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"sourcegraph.com/sourcegraph/srclib/dep"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// A UnitDeps is a source unit in the repo and the resolutions of its
+// deps.
+type UnitDeps struct {
+	Unit *unit.SourceUnit
+
+	// Resolutions are the resolutions of the unit's raw deps. If nil
+	// (e.g., because the deps were not resolved), the deps in the
+	// unit's Dependencies field are used instead.
+	Resolutions []*dep.Resolution
+}
+
+// Options configures how a dependency graph is built.
+type Options struct {
+	// Repos, if true, makes deps on units in other repos deps on the
+	// repos themselves (so that each other repo is a single node).
+	Repos bool
+}
+
+// A Graph is the dependency graph of a repo's source units.
+type Graph struct {
+	// Repo is the repo whose units the graph was built from.
+	Repo string `json:",omitempty"`
+
+	// Nodes are the units and repos in the graph. The repo's units
+	// are first, followed by the units and repos that they depend on
+	// in other repos, each sorted by their key.
+	Nodes []*Node
+
+	// Edges are the deps between the nodes, sorted by the order of
+	// their From and To nodes.
+	Edges []*Edge
+
+	// Cycles are the sets of the repo's units that depend on each
+	// other (directly or indirectly). Each is a strongly connected
+	// component of the graph with more than one node, in the order
+	// of the nodes.
+	Cycles [][]NodeKey `json:",omitempty"`
+
+	// Unresolved are the deps that could not be resolved to a unit or
+	// repo.
+	Unresolved []*Unresolved `json:",omitempty"`
+}
+
+// A NodeKey identifies a node: a source unit, or a repo if Unit is
+// empty.
+type NodeKey struct {
+	Repo     string `json:",omitempty"`
+	UnitType string `json:",omitempty"`
+	Unit     string `json:",omitempty"`
+}
+
+// IsRepo reports whether k identifies a repo (as opposed to a unit).
+func (k NodeKey) IsRepo() bool { return k.Unit == "" }
+
+func (k NodeKey) String() string {
+	if k.IsRepo() {
+		return k.Repo
+	}
+	s := k.UnitType + " " + k.Unit
+	if k.Repo != "" {
+		s = k.Repo + " " + s
+	}
+	return s
+}
+
+// A Node is a unit or repo in a dependency graph.
+type Node struct {
+	NodeKey
+
+	// External is whether the node is in a repo other than the
+	// graph's.
+	External bool `json:",omitempty"`
+}
+
+// An Edge is a dep of a unit in the repo on another unit or repo.
+type Edge struct {
+	From, To NodeKey
+
+	// Version is the version of the To node that the From unit
+	// depends on, if known.
+	Version string `json:",omitempty"`
+
+	// Cycle is whether the edge is part of one of the graph's cycles.
+	Cycle bool `json:",omitempty"`
+}
+
+// An Unresolved is a dep that could not be resolved.
+type Unresolved struct {
+	From  NodeKey
+	Raw   interface{} `json:",omitempty"`
+	Error string
+}
+
+// Build builds the dependency graph of the source units in repo (the
+// repo's URI, which may be empty if it is not known).
+func Build(repo string, units []UnitDeps, opt Options) *Graph {
+	b := &builder{
+		g:     &Graph{Repo: repo},
+		opt:   opt,
+		nodes: map[NodeKey]*Node{},
+		edges: map[[2]NodeKey]*Edge{},
+	}
+	for _, ud := range units {
+		b.node(b.unitKey(ud.Unit.Type, ud.Unit.Name), false)
+	}
+	for _, ud := range units {
+		from := b.unitKey(ud.Unit.Type, ud.Unit.Name)
+		if ud.Resolutions != nil {
+			for _, r := range ud.Resolutions {
+				b.addResolution(from, ud.Unit, r)
+			}
+		} else {
+			for _, k := range ud.Unit.Dependencies {
+				if k != nil {
+					b.addDepKey(from, ud.Unit, k)
+				}
+			}
+		}
+	}
+	b.finish()
+	return b.g
+}
+
+type builder struct {
+	g     *Graph
+	opt   Options
+	nodes map[NodeKey]*Node
+	edges map[[2]NodeKey]*Edge
+}
+
+func (b *builder) unitKey(unitType, unit string) NodeKey {
+	return NodeKey{Repo: b.g.Repo, UnitType: unitType, Unit: unit}
+}
+
+func (b *builder) node(k NodeKey, external bool) {
+	if _, present := b.nodes[k]; !present {
+		n := &Node{NodeKey: k, External: external}
+		b.nodes[k] = n
+		b.g.Nodes = append(b.g.Nodes, n)
+	}
+}
+
+// edge adds an edge from a unit in the repo to the unit (or, if unit
+// is empty, the repo) with the given key. The repo is external if it
+// is not the graph's repo.
+func (b *builder) edge(from NodeKey, repo, unitType, unit, version string) {
+	external := repo != b.g.Repo
+	to := NodeKey{Repo: repo, UnitType: unitType, Unit: unit}
+	if external && (b.opt.Repos || unit == "") {
+		to = NodeKey{Repo: repo}
+	}
+	if to.IsRepo() && !external {
+		return // a dep of the repo on itself
+	}
+	if to == from {
+		return
+	}
+	b.node(to, external)
+	if e, present := b.edges[[2]NodeKey{from, to}]; present {
+		if e.Version == "" {
+			e.Version = version
+		}
+		return
+	}
+	e := &Edge{From: from, To: to, Version: version}
+	b.edges[[2]NodeKey{from, to}] = e
+	b.g.Edges = append(b.g.Edges, e)
+}
+
+func (b *builder) unresolved(from NodeKey, raw interface{}, err string) {
+	b.g.Unresolved = append(b.g.Unresolved, &Unresolved{From: from, Raw: raw, Error: err})
+}
+
+// addResolution adds the edge for the resolution of a dep of unit u.
+func (b *builder) addResolution(from NodeKey, u *unit.SourceUnit, r *dep.Resolution) {
+	if r == nil {
+		return
+	}
+	if r.Error != "" || r.Target == nil {
+		err := r.Error
+		if err == "" {
+			err = "no resolution target"
+		}
+		b.unresolved(from, r.Raw, err)
+		return
+	}
+	t := r.Target
+
+	repo := b.g.Repo
+	if t.ToRepoCloneURL != "" {
+		uri, err := graph.TryMakeURI(t.ToRepoCloneURL)
+		if err != nil {
+			b.unresolved(from, r.Raw, err.Error())
+			return
+		}
+		repo = uri
+	}
+	unitType := t.ToUnitType
+	if unitType == "" && t.ToUnit != "" {
+		unitType = u.Type
+	}
+	version := t.ToVersionString
+	if version == "" {
+		version = t.ToRevSpec
+	}
+	b.edge(from, repo, unitType, t.ToUnit, version)
+}
+
+// addDepKey adds the edge for a dep of unit u that is listed in its
+// Dependencies field.
+func (b *builder) addDepKey(from NodeKey, u *unit.SourceUnit, k *unit.Key) {
+	unitType := k.Type
+	if unitType == "" {
+		unitType = u.Type
+	}
+	switch k.Repo {
+	case "":
+		b.edge(from, b.g.Repo, unitType, k.Name, k.Version)
+	case unit.UnitRepoUnresolved:
+		// The dep is only known to be in the repo if the repo has a
+		// unit with its name.
+		if _, present := b.nodes[b.unitKey(unitType, k.Name)]; !present {
+			raw, _ := json.Marshal(k)
+			b.unresolved(from, json.RawMessage(raw), fmt.Sprintf("unresolved dependency on %s %s", unitType, k.Name))
+			return
+		}
+		b.edge(from, b.g.Repo, unitType, k.Name, k.Version)
+	default:
+		b.edge(from, k.Repo, unitType, k.Name, k.Version)
+	}
+}
+
+// finish sorts the graph's nodes and edges and finds its cycles.
+func (b *builder) finish() {
+	sort.Sort(nodesByKey(b.g.Nodes))
+	order := make(map[NodeKey]int, len(b.g.Nodes))
+	for i, n := range b.g.Nodes {
+		order[n.NodeKey] = i
+	}
+	sort.Sort(edgesByNodes{b.g.Edges, order})
+
+	for _, scc := range stronglyConnected(b.g.Nodes, b.g.Edges, order) {
+		if len(scc) < 2 {
+			continue
+		}
+		inSCC := make(map[NodeKey]bool, len(scc))
+		for _, k := range scc {
+			inSCC[k] = true
+		}
+		for _, e := range b.g.Edges {
+			if inSCC[e.From] && inSCC[e.To] {
+				e.Cycle = true
+			}
+		}
+		b.g.Cycles = append(b.g.Cycles, scc)
+	}
+	sort.Sort(cyclesByFirstNode{b.g.Cycles, order})
+}
+
+// stronglyConnected returns the strongly connected components of the
+// graph (using Tarjan's algorithm). The nodes in each component are
+// sorted by their order.
+func stronglyConnected(nodes []*Node, edges []*Edge, order map[NodeKey]int) [][]NodeKey {
+	succ := make(map[NodeKey][]NodeKey, len(nodes))
+	for _, e := range edges {
+		succ[e.From] = append(succ[e.From], e.To)
+	}
+
+	var (
+		index   = make(map[NodeKey]int, len(nodes))
+		lowlink = make(map[NodeKey]int, len(nodes))
+		onStack = make(map[NodeKey]bool, len(nodes))
+		stack   []NodeKey
+		sccs    [][]NodeKey
+	)
+	var visit func(k NodeKey)
+	visit = func(k NodeKey) {
+		index[k] = len(index)
+		lowlink[k] = index[k]
+		stack = append(stack, k)
+		onStack[k] = true
+		for _, s := range succ[k] {
+			if _, visited := index[s]; !visited {
+				visit(s)
+				if lowlink[s] < lowlink[k] {
+					lowlink[k] = lowlink[s]
+				}
+			} else if onStack[s] && index[s] < lowlink[k] {
+				lowlink[k] = index[s]
+			}
+		}
+		if lowlink[k] == index[k] {
+			var scc []NodeKey
+			for {
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[top] = false
+				scc = append(scc, top)
+				if top == k {
+					break
+				}
+			}
+			sort.Sort(keysByOrder{scc, order})
+			sccs = append(sccs, scc)
+		}
+	}
+	for _, n := range nodes {
+		if _, visited := index[n.NodeKey]; !visited {
+			visit(n.NodeKey)
+		}
+	}
+	return sccs
+}
+
+type nodesByKey []*Node
+
+func (v nodesByKey) Len() int      { return len(v) }
+func (v nodesByKey) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v nodesByKey) Less(i, j int) bool {
+	a, b := v[i], v[j]
+	if a.External != b.External {
+		return !a.External
+	}
+	if a.Repo != b.Repo {
+		return a.Repo < b.Repo
+	}
+	if a.UnitType != b.UnitType {
+		return a.UnitType < b.UnitType
+	}
+	return a.Unit < b.Unit
+}
+
+type edgesByNodes struct {
+	edges []*Edge
+	order map[NodeKey]int
+}
+
+func (v edgesByNodes) Len() int      { return len(v.edges) }
+func (v edgesByNodes) Swap(i, j int) { v.edges[i], v.edges[j] = v.edges[j], v.edges[i] }
+func (v edgesByNodes) Less(i, j int) bool {
+	a, b := v.edges[i], v.edges[j]
+	if a.From != b.From {
+		return v.order[a.From] < v.order[b.From]
+	}
+	return v.order[a.To] < v.order[b.To]
+}
+
+type keysByOrder struct {
+	keys  []NodeKey
+	order map[NodeKey]int
+}
+
+func (v keysByOrder) Len() int           { return len(v.keys) }
+func (v keysByOrder) Swap(i, j int)      { v.keys[i], v.keys[j] = v.keys[j], v.keys[i] }
+func (v keysByOrder) Less(i, j int) bool { return v.order[v.keys[i]] < v.order[v.keys[j]] }
+
+type cyclesByFirstNode struct {
+	cycles [][]NodeKey
+	order  map[NodeKey]int
+}
+
+func (v cyclesByFirstNode) Len() int      { return len(v.cycles) }
+func (v cyclesByFirstNode) Swap(i, j int) { v.cycles[i], v.cycles[j] = v.cycles[j], v.cycles[i] }
+func (v cyclesByFirstNode) Less(i, j int) bool {
+	return v.order[v.cycles[i][0]] < v.order[v.cycles[j][0]]
+}
@@ -0,0 +1,169 @@
+package depgraph
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/dep"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// testUnits returns units a, b, c, and d in repo example.com/r, where
+// a, b, and c depend on each other in a cycle. The deps of a, b, and d
+// are resolved, and c's are listed in its Dependencies field.
+func testUnits() []UnitDeps {
+	u := func(name string, deps ...*unit.Key) *unit.SourceUnit {
+		return &unit.SourceUnit{Key: unit.Key{Type: "GoPackage", Name: name}, Info: unit.Info{Dependencies: deps}}
+	}
+	return []UnitDeps{
+		{
+			Unit: u("a"),
+			Resolutions: []*dep.Resolution{
+				{Raw: "b", Target: &dep.ResolvedTarget{ToUnit: "b"}},
+				{Raw: "z", Target: &dep.ResolvedTarget{ToRepoCloneURL: "https://github.com/x/y.git", ToUnit: "github.com/x/y/z", ToUnitType: "GoPackage", ToVersionString: "v1"}},
+				{Raw: "bad", Error: "not found"},
+			},
+		},
+		{
+			Unit: u("b"),
+			Resolutions: []*dep.Resolution{
+				{Raw: "c", Target: &dep.ResolvedTarget{ToRepoCloneURL: "https://example.com/r", ToUnit: "c", ToUnitType: "GoPackage"}},
+			},
+		},
+		{
+			Unit: u("c",
+				&unit.Key{Repo: unit.UnitRepoUnresolved, Name: "a"},
+				&unit.Key{Repo: unit.UnitRepoUnresolved, Name: "nope"},
+				&unit.Key{Repo: "github.com/q/w", Name: "w"},
+			),
+		},
+		{Unit: u("d"), Resolutions: []*dep.Resolution{}},
+	}
+}
+
+func TestBuild(t *testing.T) {
+	g := Build("example.com/r", testUnits(), Options{})
+
+	key := func(name string) NodeKey { return NodeKey{Repo: "example.com/r", UnitType: "GoPackage", Unit: name} }
+	a, b, c, d := key("a"), key("b"), key("c"), key("d")
+	w := NodeKey{Repo: "github.com/q/w", UnitType: "GoPackage", Unit: "w"}
+	z := NodeKey{Repo: "github.com/x/y", UnitType: "GoPackage", Unit: "github.com/x/y/z"}
+
+	wantNodes := []*Node{{NodeKey: a}, {NodeKey: b}, {NodeKey: c}, {NodeKey: d}, {NodeKey: w, External: true}, {NodeKey: z, External: true}}
+	if !reflect.DeepEqual(g.Nodes, wantNodes) {
+		t.Errorf("got nodes %s, want %s", asJSON(g.Nodes), asJSON(wantNodes))
+	}
+	wantEdges := []*Edge{
+		{From: a, To: b, Cycle: true},
+		{From: a, To: z, Version: "v1"},
+		{From: b, To: c, Cycle: true},
+		{From: c, To: a, Cycle: true},
+		{From: c, To: w},
+	}
+	if !reflect.DeepEqual(g.Edges, wantEdges) {
+		t.Errorf("got edges %s, want %s", asJSON(g.Edges), asJSON(wantEdges))
+	}
+	if want := [][]NodeKey{{a, b, c}}; !reflect.DeepEqual(g.Cycles, want) {
+		t.Errorf("got cycles %v, want %v", g.Cycles, want)
+	}
+	if len(g.Unresolved) != 2 || g.Unresolved[0].From != a || g.Unresolved[0].Error != "not found" || g.Unresolved[1].From != c {
+		t.Errorf("got unresolved %s, want a's error and c's dep on nope", asJSON(g.Unresolved))
+	}
+}
+
+func TestBuild_repos(t *testing.T) {
+	g := Build("example.com/r", testUnits(), Options{Repos: true})
+
+	var external []NodeKey
+	for _, n := range g.Nodes {
+		if n.External {
+			external = append(external, n.NodeKey)
+		}
+	}
+	if want := []NodeKey{{Repo: "github.com/q/w"}, {Repo: "github.com/x/y"}}; !reflect.DeepEqual(external, want) {
+		t.Errorf("got external nodes %v, want %v", external, want)
+	}
+}
+
+func TestBuild_noCycles(t *testing.T) {
+	units := testUnits()[:2] // a and b, where b depends on c but c doesn't depend on a
+	g := Build("example.com/r", units, Options{})
+	if len(g.Cycles) != 0 {
+		t.Errorf("got cycles %v, want none", g.Cycles)
+	}
+	for _, e := range g.Edges {
+		if e.Cycle {
+			t.Errorf("got edge %v marked as part of a cycle", e)
+		}
+	}
+}
+
+// outputTestGraph returns a graph with a cycle, an edge with a
+// version, and an external repo.
+func outputTestGraph() *Graph {
+	return Build("r", []UnitDeps{
+		{
+			Unit: &unit.SourceUnit{Key: unit.Key{Type: "t", Name: "a"}, Info: unit.Info{Dependencies: []*unit.Key{{Name: "b"}, {Repo: "o", Name: "x", Version: "v1"}}}},
+		},
+		{
+			Unit: &unit.SourceUnit{Key: unit.Key{Type: "t", Name: "b"}, Info: unit.Info{Dependencies: []*unit.Key{{Name: "a"}}}},
+		},
+	}, Options{Repos: true})
+}
+
+func TestGraph_WriteDOT(t *testing.T) {
+	var buf bytes.Buffer
+	if err := outputTestGraph().WriteDOT(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `digraph deps {
+	n0 [label="t a", shape=box];
+	n1 [label="t b", shape=box];
+	n2 [label="o", shape=ellipse, style=dashed];
+	n0 -> n1 [color=red];
+	n0 -> n2 [label="v1"];
+	n1 -> n0 [color=red];
+}
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got DOT\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestGraph_WriteGraphML(t *testing.T) {
+	var buf bytes.Buffer
+	if err := outputTestGraph().WriteGraphML(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<graphml xmlns="http://graphml.graphdrawing.org/xmlns">
+  <key id="repo" for="node" attr.name="repo" attr.type="string"/>
+  <key id="unitType" for="node" attr.name="unitType" attr.type="string"/>
+  <key id="unit" for="node" attr.name="unit" attr.type="string"/>
+  <key id="external" for="node" attr.name="external" attr.type="boolean"/>
+  <key id="version" for="edge" attr.name="version" attr.type="string"/>
+  <key id="cycle" for="edge" attr.name="cycle" attr.type="boolean"/>
+  <graph id="deps" edgedefault="directed">
+    <node id="n0"><data key="repo">r</data><data key="unitType">t</data><data key="unit">a</data></node>
+    <node id="n1"><data key="repo">r</data><data key="unitType">t</data><data key="unit">b</data></node>
+    <node id="n2"><data key="repo">o</data><data key="external">true</data></node>
+    <edge source="n0" target="n1"><data key="cycle">true</data></edge>
+    <edge source="n0" target="n2"><data key="version">v1</data></edge>
+    <edge source="n1" target="n0"><data key="cycle">true</data></edge>
+  </graph>
+</graphml>
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got GraphML\n%s\nwant\n%s", got, want)
+	}
+}
+
+func asJSON(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,122 @@
+package depgraph
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteDOT writes g to w in the Graphviz DOT format. The repo's units
+// are boxes, and nodes in other repos are ellipses (dashed if they are
+// repos). Edges that are part of cycles are red, and edges are labeled
+// with the version depended on, if known.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	var buf bytes.Buffer
+	buf.WriteString("digraph deps {\n")
+
+	ids := g.nodeIDs()
+	for i, n := range g.Nodes {
+		label, shape := n.UnitType+" "+n.Unit, "box"
+		if n.External {
+			shape = "ellipse"
+			if n.IsRepo() {
+				label = n.Repo
+			} else {
+				label = n.Repo + "\n" + label
+			}
+		}
+		fmt.Fprintf(&buf, "\tn%d [label=%s, shape=%s", i, dotQuote(label), shape)
+		if n.IsRepo() {
+			buf.WriteString(", style=dashed")
+		}
+		buf.WriteString("];\n")
+	}
+	for _, e := range g.Edges {
+		var attrs []string
+		if e.Version != "" {
+			attrs = append(attrs, "label="+dotQuote(e.Version))
+		}
+		if e.Cycle {
+			attrs = append(attrs, "color=red")
+		}
+		fmt.Fprintf(&buf, "\t%s -> %s", ids[e.From], ids[e.To])
+		if len(attrs) > 0 {
+			fmt.Fprintf(&buf, " [%s]", strings.Join(attrs, ", "))
+		}
+		buf.WriteString(";\n")
+	}
+
+	buf.WriteString("}\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// WriteGraphML writes g to w in the GraphML format. The nodes' keys
+// and the edges' versions and cycle membership are GraphML data
+// attributes.
+func (g *Graph) WriteGraphML(w io.Writer) error {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n")
+	for _, k := range []struct{ id, domain, typ string }{
+		{"repo", "node", "string"},
+		{"unitType", "node", "string"},
+		{"unit", "node", "string"},
+		{"external", "node", "boolean"},
+		{"version", "edge", "string"},
+		{"cycle", "edge", "boolean"},
+	} {
+		fmt.Fprintf(&buf, "  <key id=%q for=%q attr.name=%q attr.type=%q/>\n", k.id, k.domain, k.id, k.typ)
+	}
+	buf.WriteString(`  <graph id="deps" edgedefault="directed">` + "\n")
+
+	ids := g.nodeIDs()
+	for i, n := range g.Nodes {
+		fmt.Fprintf(&buf, "    <node id=\"n%d\">", i)
+		writeGraphMLData(&buf, "repo", n.Repo)
+		writeGraphMLData(&buf, "unitType", n.UnitType)
+		writeGraphMLData(&buf, "unit", n.Unit)
+		if n.External {
+			writeGraphMLData(&buf, "external", "true")
+		}
+		buf.WriteString("</node>\n")
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&buf, "    <edge source=%q target=%q>", ids[e.From], ids[e.To])
+		writeGraphMLData(&buf, "version", e.Version)
+		if e.Cycle {
+			writeGraphMLData(&buf, "cycle", "true")
+		}
+		buf.WriteString("</edge>\n")
+	}
+
+	buf.WriteString("  </graph>\n</graphml>\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// writeGraphMLData writes a data element, unless the value is empty.
+func writeGraphMLData(buf *bytes.Buffer, key, value string) {
+	if value == "" {
+		return
+	}
+	fmt.Fprintf(buf, "<data key=%q>", key)
+	xml.EscapeText(buf, []byte(value))
+	buf.WriteString("</data>")
+}
+
+// nodeIDs returns the IDs of the nodes in the DOT and GraphML output.
+func (g *Graph) nodeIDs() map[NodeKey]string {
+	ids := make(map[NodeKey]string, len(g.Nodes))
+	for i, n := range g.Nodes {
+		ids[n.NodeKey] = fmt.Sprintf("n%d", i)
+	}
+	return ids
+}
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// dotQuote returns s as a DOT quoted string.
+func dotQuote(s string) string { return `"` + dotEscaper.Replace(s) + `"` }
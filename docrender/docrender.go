@@ -0,0 +1,154 @@
+// Package docrender renders the docs of defs (DefDocs), which
+// toolchains emit in various formats, as sanitized HTML or as plain
+// text.
+//
+// All formats are first converted to HTML, which is then sanitized:
+// only a safe subset of elements and attributes is kept, and links
+// and images are only kept if their URLs have a safe scheme. Plain
+// text is rendered from the sanitized HTML.
+//
+// Intra-doc links (links in a def's docs to other defs) are written
+// as "[Target]" in Markdown and plain text docs, where Target is the
+// path (or name) of the other def. They are resolved by a
+// LinkResolver, such as one that looks up defs in a store (see
+// NewStoreLinkResolver). Unresolved intra-doc links are left as they
+// are.
+package docrender
+
+import (
+	"fmt"
+	"path"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// Doc formats that can be rendered.
+const (
+	HTMLFormat     = "text/html"
+	MarkdownFormat = "text/markdown"
+	TextFormat     = "text/plain"
+
+	// Formats that are rendered as one of the above formats.
+	xMarkdownFormat = "text/x-markdown"
+	rstFormat       = "text/x-rst" // rendered as plain text
+)
+
+// IsSupportedFormat reports whether docs in the given format can be
+// rendered.
+func IsSupportedFormat(format string) bool {
+	switch format {
+	case HTMLFormat, MarkdownFormat, xMarkdownFormat, TextFormat, rstFormat:
+		return true
+	}
+	return false
+}
+
+// Preferred returns the doc that renders best (Markdown, then HTML,
+// then plain text) among the nonempty docs in supported formats, or
+// nil if there is none.
+func Preferred(docs []*graph.DefDoc) *graph.DefDoc {
+	rank := func(doc *graph.DefDoc) int {
+		switch doc.Format {
+		case MarkdownFormat, xMarkdownFormat:
+			return 4
+		case HTMLFormat:
+			return 3
+		case TextFormat:
+			return 2
+		case rstFormat:
+			return 1
+		}
+		return 0
+	}
+	var best *graph.DefDoc
+	for _, doc := range docs {
+		if doc != nil && doc.Data != "" && rank(doc) > 0 && (best == nil || rank(doc) > rank(best)) {
+			best = doc
+		}
+	}
+	return best
+}
+
+// A LinkResolver resolves the target of an intra-doc link in the docs
+// of def from to the def that it refers to. It returns nil (and no
+// error) if there is no such def.
+type LinkResolver interface {
+	ResolveLink(from *graph.Def, target string) (*graph.Def, error)
+}
+
+// Options configures how docs are rendered.
+type Options struct {
+	// Links resolves intra-doc links. If nil, intra-doc links are not
+	// resolved.
+	Links LinkResolver
+
+	// DefURL returns the URL that a resolved intra-doc link links to.
+	// If nil, DefURL (the function) is used.
+	DefURL func(graph.DefKey) string
+}
+
+// DefURL returns the (relative) URL of a def, of the form
+// /REPO/.UNITTYPE/UNIT/.def/PATH.
+func DefURL(k graph.DefKey) string {
+	return path.Join("/", k.Repo, "."+k.UnitType, k.Unit, ".def", k.Path)
+}
+
+// HTML renders doc, which is a doc of def (which may be nil if
+// intra-doc links are not resolved), as sanitized HTML.
+func HTML(def *graph.Def, doc *graph.DefDoc, opt Options) (string, error) {
+	r := &renderer{def: def, opt: opt}
+	var html string
+	switch doc.Format {
+	case HTMLFormat:
+		html = doc.Data
+	case MarkdownFormat, xMarkdownFormat:
+		html = r.markdown(doc.Data)
+	case TextFormat, rstFormat:
+		html = r.text(doc.Data)
+	default:
+		return "", fmt.Errorf("unsupported doc format %q", doc.Format)
+	}
+	if r.err != nil {
+		return "", r.err
+	}
+	return Sanitize(html), nil
+}
+
+// Text renders doc, which is a doc of def (which may be nil if
+// intra-doc links are not resolved), as plain text (for example, for
+// display in a terminal).
+func Text(def *graph.Def, doc *graph.DefDoc, opt Options) (string, error) {
+	html, err := HTML(def, doc, opt)
+	if err != nil {
+		return "", err
+	}
+	return HTMLToText(html), nil
+}
+
+// renderer converts docs to (unsanitized) HTML.
+type renderer struct {
+	def *graph.Def
+	opt Options
+	err error // the first error resolving a link
+}
+
+// link returns the URL of the def that the intra-doc link target
+// refers to, or "" if it is not resolved.
+func (r *renderer) link(target string) string {
+	if r.opt.Links == nil || r.def == nil || r.err != nil {
+		return ""
+	}
+	def, err := r.opt.Links.ResolveLink(r.def, target)
+	if err != nil {
+		r.err = err
+		return ""
+	}
+	if def == nil {
+		return ""
+	}
+	defURL := r.opt.DefURL
+	if defURL == nil {
+		defURL = DefURL
+	}
+	return defURL(def.DefKey)
+}
@@ -0,0 +1,199 @@
+package docrender
+
+import (
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+func TestSanitize(t *testing.T) {
+	tests := map[string]string{
+		"<p>a <b>b</b></p>":                                          "<p>a <b>b</b></p>",
+		"<P CLASS=x onclick='f()'>a</P>":                             "<p>a</p>",
+		"<script>alert(1)</script>a":                                 "a",
+		"<div><style>p{}</style><span>a</span></div>":                "<div><span>a</span></div>",
+		"<blink>a</blink>":                                           "a",
+		"a<br>b<hr/>":                                                "a<br>b<hr>",
+		`<a href="https://example.com/?a=1&amp;b=2" title="t">a</a>`: `<a href="https://example.com/?a=1&amp;b=2" title="t">a</a>`,
+		`<a href="javascript:alert(1)">a</a>`:                        "<a>a</a>",
+		`<a href=" JavaScript&#58;alert(1)">a</a>`:                   "<a>a</a>",
+		`<img src="x.png" alt="x" onerror="f()">`:                    `<img src="x.png" alt="x">`,
+		"<p>a &lt; b &amp;&nbsp;c &bogus;</p>":                       "<p>a &lt; b &amp;\u00a0c &amp;bogus;</p>",
+		"<p>a<!-- c --></p>":                                         "<p>a</p>",
+		"<ul><li>a<li>b</ul>":                                        "<ul><li>a<li>b</li></li></ul>",
+		"<p>unclosed <em>tags":                                       "<p>unclosed <em>tags</em></p>",
+		"<p>1 < 2</p>":                                               "<p>1 &lt; 2&lt;/p&gt;</p>",
+	}
+	for src, want := range tests {
+		if got := Sanitize(src); got != want {
+			t.Errorf("Sanitize(%q):\ngot  %q\nwant %q", src, got, want)
+		}
+	}
+}
+
+func TestHTML(t *testing.T) {
+	tests := []struct {
+		format, data string
+		want         string
+	}{
+		{
+			format: "text/markdown",
+			data: `# Title
+
+Some *emphasis*, **strong**, ` + "`code <x>`" + `, a snake_case_name, and
+a [link](https://example.com "T") and <https://example.com/a>.
+A hard\
+break and <b>raw HTML</b> and [evil](javascript:alert).
+
+- one
+- two
+  continued
+  1. nested
+
+> quoted
+
+` + "```go\nfunc f() {}\n```",
+			want: `<h1>Title</h1>
+<p>Some <em>emphasis</em>, <strong>strong</strong>, <code>code &lt;x&gt;</code>, a snake_case_name, and
+a <a href="https://example.com" title="T">link</a> and <a href="https://example.com/a">https://example.com/a</a>.
+A hard<br>
+break and &lt;b&gt;raw HTML&lt;/b&gt; and <a>evil</a>.</p>
+<ul>
+<li>one</li>
+<li>two
+continued
+<ol>
+<li>nested</li>
+</ol>
+</li>
+</ul>
+<blockquote>
+<p>quoted</p>
+</blockquote>
+<pre><code>func f() {}
+</code></pre>
+`,
+		},
+		{
+			format: "text/plain",
+			data:   "Para <1>, see https://example.com.\n\n\tcode\n\t  indented\n\nPara 2.",
+			want: `<p>Para &lt;1&gt;, see <a href="https://example.com">https://example.com</a>.</p>
+<pre>code
+  indented
+</pre>
+<p>Para 2.</p>
+`,
+		},
+		{
+			format: "text/html",
+			data:   `<p onclick="x">a</p><script>b</script>`,
+			want:   "<p>a</p>",
+		},
+	}
+	for _, test := range tests {
+		got, err := HTML(nil, &graph.DefDoc{Format: test.format, Data: test.data}, Options{})
+		if err != nil {
+			t.Errorf("%s: %s", test.format, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s:\ngot\n%s\nwant\n%s", test.format, got, test.want)
+		}
+	}
+
+	if _, err := HTML(nil, &graph.DefDoc{Format: "application/pdf"}, Options{}); err == nil {
+		t.Error("got no error for unsupported format")
+	}
+}
+
+func TestHTML_linkParens(t *testing.T) {
+	tests := map[string]string{
+		"[x](https://en.wikipedia.org/wiki/Go_(language))": `<p><a href="https://en.wikipedia.org/wiki/Go_(language)">x</a></p>` + "\n",
+		"[x](https://example.com/a_(b)_(c) \"T (t)\") y)":  `<p><a href="https://example.com/a_(b)_(c)" title="T (t)">x</a> y)</p>` + "\n",
+		"([x](https://example.com/a))":                     `<p>(<a href="https://example.com/a">x</a>)</p>` + "\n",
+		"[x](https://example.com/(a)":                      "<p>[x](https://example.com/(a)</p>\n",
+	}
+	for data, want := range tests {
+		got, err := HTML(nil, &graph.DefDoc{Format: "text/markdown", Data: data}, Options{})
+		if err != nil {
+			t.Errorf("%q: %s", data, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%q: got %q, want %q", data, got, want)
+		}
+	}
+}
+
+func TestText(t *testing.T) {
+	doc := &graph.DefDoc{Format: "text/html", Data: `<p>Some   <em>text</em>
+wrapped.</p><ul><li>a</li><li>b<ol start="3"><li>c</li></ol></li></ul><pre>  x
+    y</pre><blockquote><p>q1</p><p>q2</p></blockquote><p>See <a href="https://example.com">the site</a>, <a href="#x">here</a>.</p>`}
+	got, err := Text(nil, doc, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `Some text wrapped.
+
+- a
+- b
+  3. c
+
+  x
+    y
+
+| q1
+|
+| q2
+
+See the site <https://example.com>, here.`
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+type mapLinkResolver map[string]*graph.Def
+
+func (m mapLinkResolver) ResolveLink(from *graph.Def, target string) (*graph.Def, error) {
+	return m[target], nil
+}
+
+func TestHTML_intraDocLinks(t *testing.T) {
+	def := &graph.Def{DefKey: graph.DefKey{Repo: "r", UnitType: "t", Unit: "u", Path: "F"}}
+	opt := Options{Links: mapLinkResolver{
+		"T.M": {DefKey: graph.DefKey{Repo: "r", UnitType: "t", Unit: "u", Path: "T/M"}},
+	}}
+	tests := []struct {
+		format, data, want string
+	}{
+		{
+			format: "text/markdown",
+			data:   "Calls [T.M], not [U], [x](T.M), or [T.M](http://x).",
+			want:   `<p>Calls <a href="/r/.t/u/.def/T/M">T.M</a>, not [U], <a href="T.M">x</a>, or <a href="http://x">T.M</a>.</p>` + "\n",
+		},
+		{
+			format: "text/plain",
+			data:   "Calls [T.M], not [U] or [T.M.",
+			want:   `<p>Calls <a href="/r/.t/u/.def/T/M">T.M</a>, not [U] or [T.M.</p>` + "\n",
+		},
+	}
+	for _, test := range tests {
+		got, err := HTML(def, &graph.DefDoc{Format: test.format, Data: test.data}, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("%s:\ngot  %q\nwant %q", test.format, got, test.want)
+		}
+	}
+}
+
+func TestPreferred(t *testing.T) {
+	docs := []*graph.DefDoc{{Format: "text/plain", Data: "a"}, {Format: "text/markdown"}, {Format: "text/html", Data: "<p>a</p>"}, {Format: "x/y", Data: "a"}}
+	if got := Preferred(docs); got != docs[2] {
+		t.Errorf("got %+v, want the HTML doc", got)
+	}
+	if got := Preferred(nil); got != nil {
+		t.Errorf("got %+v, want nil", got)
+	}
+}
@@ -0,0 +1,483 @@
+package docrender
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// markdown converts a Markdown doc to HTML. It supports the commonly
+// used subset of Markdown: paragraphs, ATX headings, fenced and
+// indented code blocks, block quotes, lists, horizontal rules, and
+// inline code, emphasis, links, images, and autolinks. Raw HTML is
+// escaped (and so shown as it is written).
+func (r *renderer) markdown(src string) string {
+	var buf bytes.Buffer
+	r.mdBlocks(&buf, strings.Split(strings.Replace(src, "\r\n", "\n", -1), "\n"))
+	return buf.String()
+}
+
+var (
+	mdHeading    = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	mdRule       = regexp.MustCompile(`^ {0,3}(?:(?:-[ \t]*){3,}|(?:\*[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	mdFence      = regexp.MustCompile("^ {0,3}(```+|~~~+)")
+	mdListItem   = regexp.MustCompile(`^( {0,3})([-*+]|\d{1,9}[.)])( +|$)`)
+	mdBlockquote = regexp.MustCompile(`^ {0,3}> ?`)
+)
+
+// mdBlocks writes the HTML of the block-level elements in lines.
+func (r *renderer) mdBlocks(buf *bytes.Buffer, lines []string) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case isBlank(line):
+			i++
+
+		case mdFence.MatchString(line):
+			fence := strings.TrimLeft(mdFence.FindStringSubmatch(line)[1], " ")
+			indent := len(line) - len(strings.TrimLeft(line, " "))
+			i++
+			var code []string
+			for ; i < len(lines); i++ {
+				if t := strings.TrimSpace(lines[i]); strings.HasPrefix(t, fence) && strings.Trim(t, fence[:1]) == "" {
+					i++
+					break
+				}
+				code = append(code, trimIndent(lines[i], indent))
+			}
+			writeCodeBlock(buf, code)
+
+		case mdHeading.MatchString(line):
+			m := mdHeading.FindStringSubmatch(line)
+			tag := "h" + strconv.Itoa(len(m[1]))
+			buf.WriteString("<" + tag + ">")
+			r.mdInline(buf, m[2])
+			buf.WriteString("</" + tag + ">\n")
+			i++
+
+		case mdRule.MatchString(line):
+			buf.WriteString("<hr>\n")
+			i++
+
+		case mdBlockquote.MatchString(line):
+			var quoted []string
+			for ; i < len(lines) && !isBlank(lines[i]); i++ {
+				quoted = append(quoted, mdBlockquote.ReplaceAllString(lines[i], ""))
+			}
+			buf.WriteString("<blockquote>\n")
+			r.mdBlocks(buf, quoted)
+			buf.WriteString("</blockquote>\n")
+
+		case mdListItem.MatchString(line):
+			i = r.mdList(buf, lines, i)
+
+		case strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t"):
+			var code []string
+			for ; i < len(lines) && (isBlank(lines[i]) || strings.HasPrefix(lines[i], "    ") || strings.HasPrefix(lines[i], "\t")); i++ {
+				code = append(code, trimIndent(lines[i], 4))
+			}
+			for len(code) > 0 && isBlank(code[len(code)-1]) {
+				code = code[:len(code)-1]
+			}
+			writeCodeBlock(buf, code)
+
+		default:
+			var para []string
+			for ; i < len(lines) && !isBlank(lines[i]) && (len(para) == 0 || !startsMDBlock(lines[i])); i++ {
+				para = append(para, strings.TrimLeft(lines[i], " \t"))
+			}
+			buf.WriteString("<p>")
+			r.mdInline(buf, strings.Join(para, "\n"))
+			buf.WriteString("</p>\n")
+		}
+	}
+}
+
+// startsMDBlock reports whether line starts a block that interrupts a
+// paragraph.
+func startsMDBlock(line string) bool {
+	return mdFence.MatchString(line) || mdHeading.MatchString(line) || mdRule.MatchString(line) ||
+		mdBlockquote.MatchString(line) || mdListItem.MatchString(line)
+}
+
+// mdList writes the list that starts at lines[start] and returns the
+// index of the line after it. The list's items are the lines that
+// start with the same kind of list marker, and each item contains the
+// lines after its marker that are indented (or that continue its
+// paragraph).
+func (r *renderer) mdList(buf *bytes.Buffer, lines []string, start int) int {
+	first := mdListItem.FindStringSubmatch(lines[start])
+	ordered := first[2][0] >= '0' && first[2][0] <= '9'
+	delim := first[2][len(first[2])-1:]
+	sameKind := func(m []string) bool {
+		if m == nil {
+			return false
+		}
+		if ordered {
+			return m[2][0] >= '0' && m[2][0] <= '9' && strings.HasSuffix(m[2], delim)
+		}
+		return m[2] == delim
+	}
+
+	if ordered {
+		if n, _ := strconv.Atoi(strings.TrimRight(first[2], ".)")); n != 1 {
+			buf.WriteString(`<ol start="` + strconv.Itoa(n) + `">` + "\n")
+		} else {
+			buf.WriteString("<ol>\n")
+		}
+	} else {
+		buf.WriteString("<ul>\n")
+	}
+
+	i := start
+	for i < len(lines) {
+		m := mdListItem.FindStringSubmatch(lines[i])
+		if !sameKind(m) {
+			break
+		}
+		width := len(m[0])
+		if m[3] == "" {
+			width++ // the item's first line is empty
+		}
+		item := []string{lines[i][len(m[0]):]}
+		i++
+		for i < len(lines) {
+			line := lines[i]
+			if isBlank(line) {
+				// The item continues if the next nonblank line is
+				// indented.
+				j := i
+				for j < len(lines) && isBlank(lines[j]) {
+					j++
+				}
+				if j == len(lines) || indentWidth(lines[j]) < width {
+					break
+				}
+				item = append(item, "")
+				i++
+				continue
+			}
+			if indentWidth(line) >= width {
+				item = append(item, trimIndent(line, width))
+			} else if !isBlank(item[len(item)-1]) && !startsMDBlock(line) {
+				item = append(item, strings.TrimLeft(line, " \t")) // lazy continuation
+			} else {
+				break
+			}
+			i++
+		}
+
+		buf.WriteString("<li>")
+		if len(item) == 1 || !containsBlank(item) {
+			// A tight item's paragraph is not wrapped in <p>.
+			var rest []string
+			var para []string
+			for j, line := range item {
+				if j > 0 && startsMDBlock(line) {
+					rest = item[j:]
+					break
+				}
+				para = append(para, strings.TrimLeft(line, " \t"))
+			}
+			r.mdInline(buf, strings.Join(para, "\n"))
+			if len(rest) > 0 {
+				buf.WriteString("\n")
+				r.mdBlocks(buf, rest)
+			}
+		} else {
+			buf.WriteString("\n")
+			r.mdBlocks(buf, item)
+		}
+		buf.WriteString("</li>\n")
+
+		// Skip blank lines between items.
+		j := i
+		for j < len(lines) && isBlank(lines[j]) {
+			j++
+		}
+		if j < len(lines) && sameKind(mdListItem.FindStringSubmatch(lines[j])) {
+			i = j
+		}
+	}
+
+	if ordered {
+		buf.WriteString("</ol>\n")
+	} else {
+		buf.WriteString("</ul>\n")
+	}
+	return i
+}
+
+// mdInline writes the HTML of the inline elements in s.
+func (r *renderer) mdInline(buf *bytes.Buffer, s string) {
+	for len(s) > 0 {
+		switch c := s[0]; {
+		case c == '\\' && len(s) > 1 && strings.IndexByte(mdPunct, s[1]) != -1:
+			buf.WriteString(html.EscapeString(s[1:2]))
+			s = s[2:]
+			continue
+
+		case c == '\\' && len(s) > 1 && s[1] == '\n':
+			buf.WriteString("<br>\n")
+			s = s[2:]
+			continue
+
+		case c == ' ' && strings.HasPrefix(s, "  \n"):
+			buf.WriteString("<br>\n")
+			s = strings.TrimLeft(s, " ")[1:]
+			continue
+
+		case c == '`':
+			n := len(s) - len(strings.TrimLeft(s, "`"))
+			if end := strings.Index(s[n:], s[:n]); end != -1 && !strings.HasPrefix(s[n+end+n:], "`") {
+				code := strings.TrimSpace(strings.Replace(s[n:n+end], "\n", " ", -1))
+				buf.WriteString("<code>" + html.EscapeString(code) + "</code>")
+				s = s[n+end+n:]
+				continue
+			}
+			buf.WriteString(s[:n])
+			s = s[n:]
+			continue
+
+		case c == '<':
+			if m := mdAutolink.FindStringSubmatch(s); m != nil {
+				writeLink(buf, m[1], html.EscapeString(m[1]), "")
+				s = s[len(m[0]):]
+				continue
+			}
+
+		case c == '!' && strings.HasPrefix(s, "!["):
+			if text, dest, title, n, ok := mdLink(s[1:]); ok {
+				buf.WriteString(`<img src="` + html.EscapeString(dest) + `" alt="` + html.EscapeString(text) + `"`)
+				if title != "" {
+					buf.WriteString(` title="` + html.EscapeString(title) + `"`)
+				}
+				buf.WriteString(">")
+				s = s[1+n:]
+				continue
+			}
+
+		case c == '[':
+			if text, dest, title, n, ok := mdLink(s); ok {
+				var inner bytes.Buffer
+				r.mdInline(&inner, text)
+				writeLink(buf, dest, inner.String(), title)
+				s = s[n:]
+				continue
+			}
+			if m := intraDocLink.FindStringSubmatch(s); m != nil && !strings.HasPrefix(s[len(m[0]):], "(") && !strings.HasPrefix(s[len(m[0]):], "[") {
+				if href := r.link(m[1]); href != "" {
+					writeLink(buf, href, html.EscapeString(m[1]), "")
+					s = s[len(m[0]):]
+					continue
+				}
+			}
+
+		case c == '*' || c == '_':
+			n := len(s) - len(strings.TrimLeft(s, s[:1]))
+			if n, inner, ok := mdEmphasis(s); ok {
+				tag := "em"
+				if n == 2 {
+					tag = "strong"
+				}
+				buf.WriteString("<" + tag + ">")
+				r.mdInline(buf, inner)
+				buf.WriteString("</" + tag + ">")
+				s = s[n+len(inner)+n:]
+				continue
+			}
+			buf.WriteString(s[:n])
+			s = s[n:]
+			continue
+		}
+
+		// Write the text up to the next special character.
+		// (Underscores inside words are not special.)
+		n := 1
+		for n < len(s) && (strings.IndexByte("\\ `<![*_", s[n]) == -1 || (s[n] == '_' && isWordChar(s[n-1]))) {
+			n++
+		}
+		buf.WriteString(html.EscapeString(s[:n]))
+		s = s[n:]
+	}
+}
+
+const mdPunct = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+
+var (
+	mdAutolink   = regexp.MustCompile(`^<((?:https?|ftp|mailto):[^\s<>]+)>`)
+	intraDocLink = regexp.MustCompile(`^\[([\pL_$][\pL\pN_$]*(?:(?:\.|/|::|#)[\pL\pN_$]+)*(?:\(\))?)\]`)
+)
+
+// mdLink parses an inline link, "[text](dest "title")", at the start
+// of s. It returns the number of bytes of s that it spans.
+func mdLink(s string) (text, dest, title string, n int, ok bool) {
+	// Find the "]" that matches the "[".
+	depth, i := 0, 0
+	for ; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] == '[' {
+			depth++
+		} else if s[i] == ']' {
+			if depth--; depth == 0 {
+				break
+			}
+		}
+	}
+	if i >= len(s)-1 || s[i+1] != '(' {
+		return "", "", "", 0, false
+	}
+	text = s[1:i]
+
+	// Find the ")" that ends the link. As in CommonMark, parentheses
+	// in the destination must be balanced (as in
+	// "https://en.wikipedia.org/wiki/Go_(language)"); those in the
+	// quoted title are ignored.
+	end, depth, quote, space := -1, 0, byte(0), false
+	for j := i + 2; j < len(s) && end == -1; j++ {
+		switch c := s[j]; {
+		case c == '\\':
+			j++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == ' ' || c == '\t' || c == '\n':
+			space = true
+		case space && (c == '"' || c == '\''):
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			if depth == 0 {
+				end = j
+			}
+			depth--
+		}
+	}
+	if end == -1 {
+		return "", "", "", 0, false
+	}
+	inner := strings.TrimSpace(s[i+2 : end])
+	if inner == "" {
+		return "", "", "", 0, false
+	}
+	dest = inner
+	if sp := strings.IndexAny(inner, " \t\n"); sp != -1 {
+		dest = inner[:sp]
+		title = strings.TrimSpace(inner[sp:])
+		if len(title) < 2 || (title[0] != '"' && title[0] != '\'') || title[len(title)-1] != title[0] {
+			return "", "", "", 0, false
+		}
+		title = title[1 : len(title)-1]
+	}
+	dest = strings.TrimSuffix(strings.TrimPrefix(dest, "<"), ">")
+	return text, dest, title, end + 1, true
+}
+
+// mdEmphasis parses emphasis ("*text*" or "_text_") or strong emphasis
+// ("**text**" or "__text__") at the start of s. It returns the length
+// of the delimiter and the emphasized text.
+func mdEmphasis(s string) (n int, inner string, ok bool) {
+	d := s[:1]
+	n = 1
+	if strings.HasPrefix(s, d+d) {
+		n = 2
+	}
+	delim := s[:n]
+	if len(s) <= n || s[n] == ' ' || s[n] == '\n' {
+		return 0, "", false
+	}
+	for i := n; i+n <= len(s); i++ {
+		if s[i] == '`' {
+			// Skip code spans.
+			if end := strings.IndexByte(s[i+1:], '`'); end != -1 {
+				i += end + 1
+			}
+			continue
+		}
+		if !strings.HasPrefix(s[i:], delim) || s[i-1] == ' ' || s[i-1] == '\n' {
+			continue
+		}
+		if n == 1 && strings.HasPrefix(s[i:], d+d) {
+			i++ // strong emphasis inside emphasis
+			continue
+		}
+		// Underscores only delimit emphasis at word boundaries.
+		if d == "_" && i+n < len(s) && isWordChar(s[i+n]) {
+			continue
+		}
+		return n, s[n:i], true
+	}
+	return 0, "", false
+}
+
+// writeLink writes a link to href with the given inner HTML.
+func writeLink(buf *bytes.Buffer, href, inner, title string) {
+	buf.WriteString(`<a href="` + html.EscapeString(href) + `"`)
+	if title != "" {
+		buf.WriteString(` title="` + html.EscapeString(title) + `"`)
+	}
+	buf.WriteString(">" + inner + "</a>")
+}
+
+func writeCodeBlock(buf *bytes.Buffer, lines []string) {
+	buf.WriteString("<pre><code>")
+	for _, line := range lines {
+		buf.WriteString(html.EscapeString(line) + "\n")
+	}
+	buf.WriteString("</code></pre>\n")
+}
+
+func isBlank(line string) bool { return strings.TrimSpace(line) == "" }
+
+func containsBlank(lines []string) bool {
+	for _, line := range lines {
+		if isBlank(line) {
+			return true
+		}
+	}
+	return false
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// indentWidth returns the width of the leading whitespace of line
+// (with tabs to multiples of 4).
+func indentWidth(line string) int {
+	w := 0
+	for _, c := range line {
+		switch c {
+		case ' ':
+			w++
+		case '\t':
+			w += 4 - w%4
+		default:
+			return w
+		}
+	}
+	return w
+}
+
+// trimIndent removes up to n columns of leading whitespace from line.
+func trimIndent(line string, n int) string {
+	w := 0
+	for i, c := range line {
+		if w >= n || (c != ' ' && c != '\t') {
+			return line[i:]
+		}
+		if c == '\t' {
+			w += 4 - w%4
+		} else {
+			w++
+		}
+	}
+	return ""
+}
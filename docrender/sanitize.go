@@ -0,0 +1,203 @@
+package docrender
+
+import (
+	"bytes"
+	"encoding/xml"
+	"html"
+	"io"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// allowedElements are the elements that are kept by Sanitize, and
+// their allowed attributes.
+var allowedElements = map[string][]string{
+	"a": {"href", "title"}, "abbr": {"title"}, "b": nil, "blockquote": nil,
+	"br": nil, "code": nil, "dd": nil, "del": nil, "div": nil, "dl": nil,
+	"dt": nil, "em": nil, "h1": nil, "h2": nil, "h3": nil, "h4": nil,
+	"h5": nil, "h6": nil, "hr": nil, "i": nil, "img": {"src", "alt", "title"},
+	"ins": nil, "kbd": nil, "li": nil, "ol": {"start"}, "p": nil, "pre": nil,
+	"q": nil, "s": nil, "samp": nil, "small": nil, "span": nil, "strong": nil,
+	"sub": nil, "sup": nil, "table": nil, "tbody": nil, "td": {"colspan", "rowspan"},
+	"tfoot": nil, "th": {"colspan", "rowspan"}, "thead": nil, "tr": nil,
+	"tt": nil, "u": nil, "ul": nil, "var": nil,
+}
+
+// droppedElements are the elements that are removed by Sanitize along
+// with their contents. (Other elements that are not allowed are
+// removed, but their contents are kept.)
+var droppedElements = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true,
+	"applet": true, "noscript": true, "template": true, "textarea": true,
+	"select": true, "head": true, "title": true, "frameset": true, "svg": true, "math": true,
+}
+
+// voidElements are the allowed elements that have no end tag.
+var voidElements = map[string]bool{"br": true, "hr": true, "img": true}
+
+// urlAttrs are the attributes whose values are URLs.
+var urlAttrs = map[string]bool{"href": true, "src": true}
+
+// Sanitize returns src (an HTML fragment) with all elements and
+// attributes that are not known to be safe removed, and with its tags
+// balanced. Links and images are kept only if their URLs are relative
+// or have the http, https, mailto, or ftp scheme. Text that can't be
+// parsed as HTML is escaped.
+func Sanitize(src string) string {
+	var buf bytes.Buffer
+	walkHTML(src, func(tok xml.Token) {
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			buf.WriteString("<" + tok.Name.Local)
+			for _, a := range tok.Attr {
+				buf.WriteString(" " + a.Name.Local + `="` + html.EscapeString(a.Value) + `"`)
+			}
+			buf.WriteString(">")
+		case xml.EndElement:
+			buf.WriteString("</" + tok.Name.Local + ">")
+		case xml.CharData:
+			buf.WriteString(html.EscapeString(string(tok)))
+		}
+	})
+	return buf.String()
+}
+
+// walkHTML parses src (an HTML fragment) and calls f with each of its
+// safe elements (with only their safe attributes, and lowercase names)
+// and with its text. The end elements of void elements are omitted.
+// If src can't be parsed, the rest of it is treated as text.
+func walkHTML(src string, f func(xml.Token)) {
+	src = replaceInvalidChars(src)
+	d := xml.NewDecoder(strings.NewReader(src))
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+
+	type elem struct {
+		name string
+		keep bool
+	}
+	var (
+		stack   []elem
+		dropped int // depth inside dropped elements
+		offset  int64
+	)
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			if err != io.EOF && dropped == 0 && offset < int64(len(src)) {
+				f(xml.CharData(src[offset:]))
+			}
+			break
+		}
+		offset = d.InputOffset()
+
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(tok.Name.Local)
+			if tok.Name.Space != "" {
+				name = "" // namespaced elements are not HTML
+			}
+			if dropped > 0 || droppedElements[name] {
+				dropped++
+				stack = append(stack, elem{name: name})
+				continue
+			}
+			attrNames, keep := allowedElements[name]
+			stack = append(stack, elem{name: name, keep: keep})
+			if !keep {
+				continue
+			}
+			e := xml.StartElement{Name: xml.Name{Local: name}}
+			for _, a := range tok.Attr {
+				aname := strings.ToLower(a.Name.Local)
+				if a.Name.Space != "" || !contains(attrNames, aname) {
+					continue
+				}
+				if urlAttrs[aname] && !isSafeURL(a.Value) {
+					continue
+				}
+				e.Attr = append(e.Attr, xml.Attr{Name: xml.Name{Local: aname}, Value: a.Value})
+			}
+			f(e)
+		case xml.EndElement:
+			if len(stack) == 0 {
+				continue
+			}
+			e := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if dropped > 0 {
+				dropped--
+				continue
+			}
+			if e.keep && !voidElements[e.name] {
+				f(xml.EndElement{Name: xml.Name{Local: e.name}})
+			}
+		case xml.CharData:
+			if dropped == 0 {
+				f(tok.Copy())
+			}
+		}
+	}
+
+	// Close the elements that are still open.
+	for i := len(stack) - 1; i >= 0; i-- {
+		if e := stack[i]; e.keep && !voidElements[e.name] && i < len(stack)-dropped {
+			f(xml.EndElement{Name: xml.Name{Local: e.name}})
+		}
+	}
+}
+
+// replaceInvalidChars replaces the invalid UTF-8 and the characters
+// that can't appear in XML (such as most control characters) in s
+// with U+FFFD.
+func replaceInvalidChars(s string) string {
+	valid := func(r rune, size int) bool {
+		return (r != utf8.RuneError || size > 1) && (r >= 0x20 || r == '\t' || r == '\n' || r == '\r') && r != 0xFFFE && r != 0xFFFF
+	}
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if !valid(r, size) {
+			break
+		}
+		i += size
+		if i == len(s) {
+			return s
+		}
+	}
+	var buf bytes.Buffer
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if valid(r, size) {
+			buf.WriteString(s[i : i+size])
+		} else {
+			buf.WriteRune(utf8.RuneError)
+		}
+		i += size
+	}
+	return buf.String()
+}
+
+// isSafeURL reports whether a link or image URL is relative or has a
+// safe scheme.
+func isSafeURL(s string) bool {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https", "mailto", "ftp":
+		return true
+	}
+	return false
+}
+
+func contains(strs []string, s string) bool {
+	for _, t := range strs {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package docrender
+
+import (
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+)
+
+// NewStoreLinkResolver returns a LinkResolver that resolves intra-doc
+// links to defs in s.
+//
+// A link target is resolved to the def (in the same repo and commit
+// as the def whose docs contain the link) whose path is the target, or
+// is the target with its language-specific separators (".", "#", and
+// "::") replaced by "/". Defs in the same source unit are preferred;
+// otherwise, the target must match exactly one def in another unit.
+func NewStoreLinkResolver(s store.UnitStore) LinkResolver {
+	return &storeLinkResolver{s: s}
+}
+
+type storeLinkResolver struct{ s store.UnitStore }
+
+var targetSeparators = strings.NewReplacer(".", "/", "#", "/", "::", "/")
+
+func (r *storeLinkResolver) ResolveLink(from *graph.Def, target string) (*graph.Def, error) {
+	target = strings.TrimSuffix(target, "()")
+	paths := []string{target}
+	if p := targetSeparators.Replace(target); p != target {
+		paths = append(paths, p)
+	}
+
+	// Look in the def's unit.
+	for _, p := range paths {
+		key := from.DefKey
+		key.Path = p
+		defs, err := r.s.Defs(store.ByDefKey(key), store.Limit(1, 0))
+		if err != nil {
+			return nil, err
+		}
+		if len(defs) == 1 {
+			return defs[0], nil
+		}
+	}
+
+	// Look in the other units in the def's version.
+	var fs []store.DefFilter
+	if from.Repo != "" {
+		fs = append(fs, store.ByRepos(from.Repo))
+	}
+	if from.CommitID != "" {
+		fs = append(fs, store.ByCommitIDs(from.CommitID))
+	}
+	for _, p := range paths {
+		defs, err := r.s.Defs(append(fs, store.ByDefPath(p), store.Limit(2, 0))...)
+		if err != nil {
+			return nil, err
+		}
+		if len(defs) == 1 && (defs[0].UnitType != from.UnitType || defs[0].Unit != from.Unit) {
+			return defs[0], nil
+		}
+	}
+	return nil, nil
+}
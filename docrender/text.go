@@ -0,0 +1,319 @@
+package docrender
+
+import (
+	"bytes"
+	"encoding/xml"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// text converts a plain text doc to HTML, in the way that godoc
+// converts Go doc comments: paragraphs are separated by blank lines,
+// and indented lines are preformatted. URLs are linked, as are
+// intra-doc links.
+func (r *renderer) text(src string) string {
+	lines := strings.Split(strings.Replace(src, "\r\n", "\n", -1), "\n")
+	var buf bytes.Buffer
+	for i := 0; i < len(lines); {
+		switch {
+		case isBlank(lines[i]):
+			i++
+
+		case lines[i][0] == ' ' || lines[i][0] == '\t':
+			var pre []string
+			for ; i < len(lines) && (isBlank(lines[i]) || lines[i][0] == ' ' || lines[i][0] == '\t'); i++ {
+				pre = append(pre, lines[i])
+			}
+			for len(pre) > 0 && isBlank(pre[len(pre)-1]) {
+				pre = pre[:len(pre)-1]
+			}
+			// Remove the common indentation.
+			indent := pre[0][:len(pre[0])-len(strings.TrimLeft(pre[0], " \t"))]
+			for _, line := range pre {
+				if !isBlank(line) {
+					for !strings.HasPrefix(line, indent) {
+						indent = indent[:len(indent)-1]
+					}
+				}
+			}
+			buf.WriteString("<pre>")
+			for _, line := range pre {
+				buf.WriteString(html.EscapeString(strings.TrimPrefix(line, indent)) + "\n")
+			}
+			buf.WriteString("</pre>\n")
+
+		default:
+			var para []string
+			for ; i < len(lines) && !isBlank(lines[i]) && lines[i][0] != ' ' && lines[i][0] != '\t'; i++ {
+				para = append(para, lines[i])
+			}
+			buf.WriteString("<p>")
+			r.textInline(&buf, strings.Join(para, "\n"))
+			buf.WriteString("</p>\n")
+		}
+	}
+	return buf.String()
+}
+
+var textURL = regexp.MustCompile(`(?:https?|ftp)://[^\s<>"]*[^\s<>".,:;!?)\]'}]`)
+
+// textInline writes the HTML of a paragraph of plain text.
+func (r *renderer) textInline(buf *bytes.Buffer, s string) {
+	for len(s) > 0 {
+		if loc := textURL.FindStringIndex(s); loc != nil && loc[0] == 0 {
+			writeLink(buf, s[:loc[1]], html.EscapeString(s[:loc[1]]), "")
+			s = s[loc[1]:]
+			continue
+		}
+		if m := intraDocLink.FindStringSubmatch(s); m != nil {
+			if href := r.link(m[1]); href != "" {
+				writeLink(buf, href, html.EscapeString(m[1]), "")
+				s = s[len(m[0]):]
+				continue
+			}
+		}
+
+		// Write the text up to the next URL or link.
+		n := len(s)
+		if i := strings.IndexByte(s[1:], '['); i != -1 {
+			n = 1 + i
+		}
+		if loc := textURL.FindStringIndex(s[1:]); loc != nil && 1+loc[0] < n {
+			n = 1 + loc[0]
+		}
+		buf.WriteString(html.EscapeString(s[:n]))
+		s = s[n:]
+	}
+}
+
+// blockElements are the elements that HTMLToText separates from the
+// surrounding text with blank lines.
+var blockElements = map[string]bool{
+	"blockquote": true, "dl": true, "div": true, "h1": true, "h2": true, "h3": true,
+	"h4": true, "h5": true, "h6": true, "hr": true, "ol": true, "p": true, "pre": true,
+	"table": true, "ul": true,
+}
+
+// HTMLToText converts an HTML fragment (such as the output of
+// Sanitize) to plain text. Blocks are separated by blank lines, list
+// items are bulleted or numbered and indented, block quotes are
+// prefixed with "| ", and links to absolute URLs are followed by the
+// URL (if it differs from the link's text).
+func HTMLToText(src string) string {
+	t := &textWriter{}
+	type list struct {
+		ordered bool
+		n       int
+	}
+	var (
+		lists []*list
+		links []string // hrefs of the open links
+		pre   int      // depth inside pre elements
+		cells int      // cells so far in the current table row
+	)
+	walkHTML(src, func(tok xml.Token) {
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			name := tok.Name.Local
+			if (name == "ul" || name == "ol") && len(lists) > 0 {
+				t.newline() // a nested list
+			} else if blockElements[name] {
+				t.blankLine()
+			}
+			switch name {
+			case "br":
+				t.newline()
+			case "hr":
+				t.write("---")
+				t.blankLine()
+			case "pre":
+				pre++
+			case "blockquote":
+				t.indent = append(t.indent, "| ")
+			case "ul", "ol":
+				l := &list{ordered: name == "ol", n: 1}
+				if name == "ol" {
+					for _, a := range tok.Attr {
+						if a.Name.Local == "start" {
+							if n, err := strconv.Atoi(a.Value); err == nil {
+								l.n = n
+							}
+						}
+					}
+				}
+				lists = append(lists, l)
+			case "li":
+				t.newline()
+				bullet := "- "
+				if len(lists) > 0 {
+					if l := lists[len(lists)-1]; l.ordered {
+						bullet = strconv.Itoa(l.n) + ". "
+						l.n++
+					}
+				}
+				t.write(bullet)
+				t.indent = append(t.indent, strings.Repeat(" ", len(bullet)))
+				t.bullet = true
+			case "dt":
+				t.newline()
+			case "tr":
+				t.newline()
+				cells = 0
+			case "dd":
+				t.newline()
+				t.indent = append(t.indent, "    ")
+			case "td", "th":
+				if cells > 0 {
+					t.write(" |")
+					t.space = true
+				}
+				cells++
+			case "img":
+				for _, a := range tok.Attr {
+					if a.Name.Local == "alt" {
+						t.text(a.Value)
+					}
+				}
+			case "a":
+				var href string
+				for _, a := range tok.Attr {
+					if a.Name.Local == "href" {
+						href = a.Value
+					}
+				}
+				links = append(links, href)
+				t.linkStart = t.buf.Len()
+			}
+
+		case xml.EndElement:
+			name := tok.Name.Local
+			switch name {
+			case "pre":
+				pre--
+			case "blockquote", "li", "dd":
+				if len(t.indent) > 0 {
+					t.indent = t.indent[:len(t.indent)-1]
+				}
+				if name != "blockquote" {
+					t.newline()
+				}
+			case "ul", "ol":
+				if lists = lists[:len(lists)-1]; len(lists) > 0 {
+					t.newline() // a nested list
+					return
+				}
+			case "dt":
+				t.newline()
+			case "a":
+				href := links[len(links)-1]
+				links = links[:len(links)-1]
+				text := strings.TrimSpace(t.buf.String()[t.linkStart:])
+				if (strings.Contains(href, "://") || strings.HasPrefix(href, "mailto:")) && href != text && "mailto:"+text != href {
+					t.write(" <" + href + ">")
+				}
+			}
+			if blockElements[name] {
+				t.blankLine()
+			}
+
+		case xml.CharData:
+			if pre > 0 {
+				t.pre(string(tok))
+			} else {
+				t.text(string(tok))
+			}
+		}
+	})
+	return strings.TrimRight(t.buf.String(), "\n")
+}
+
+// A textWriter writes the text of HTML, collapsing whitespace (outside
+// of pre elements) and indenting lines.
+type textWriter struct {
+	buf       bytes.Buffer
+	indent    []string // prefixes of each line
+	newlines  int      // pending newlines (written before the next text)
+	depth     int      // number of indents that the pending blank lines have
+	space     bool     // pending space
+	bullet    bool     // whether a list item's bullet was just written
+	linkStart int      // offset in buf of the text of the innermost link
+}
+
+func (t *textWriter) newline() { t.breakLine(1) }
+
+func (t *textWriter) blankLine() { t.breakLine(2) }
+
+// breakLine makes the next text be written after n newlines (unless
+// more are already pending).
+func (t *textWriter) breakLine(n int) {
+	if t.buf.Len() == 0 || t.bullet {
+		return
+	}
+	if t.newlines == 0 || len(t.indent) < t.depth {
+		t.depth = len(t.indent)
+	}
+	if n > t.newlines {
+		t.newlines = n
+	}
+	t.space = false
+}
+
+// write writes s (which contains no newlines) without collapsing its
+// whitespace.
+func (t *textWriter) write(s string) {
+	indent := strings.Join(t.indent, "")
+	switch {
+	case t.buf.Len() == 0:
+		t.buf.WriteString(indent)
+	case t.newlines > 0:
+		t.buf.WriteString("\n")
+		// Blank lines are only prefixed by the indents that enclose
+		// both the previous and the next text.
+		depth := t.depth
+		if len(t.indent) < depth {
+			depth = len(t.indent)
+		}
+		for i := 1; i < t.newlines; i++ {
+			t.buf.WriteString(strings.TrimRight(strings.Join(t.indent[:depth], ""), " ") + "\n")
+		}
+		t.buf.WriteString(indent)
+	case t.space && !t.bullet:
+		t.buf.WriteString(" ")
+	}
+	t.newlines = 0
+	t.space = false
+	t.bullet = false
+	t.buf.WriteString(s)
+}
+
+// text writes s, collapsing its whitespace.
+func (t *textWriter) text(s string) {
+	words := strings.FieldsFunc(s, unicode.IsSpace)
+	if len(s) > 0 && unicode.IsSpace(rune(s[0])) {
+		t.space = true
+	}
+	for i, w := range words {
+		if i > 0 {
+			t.space = true
+		}
+		t.write(w)
+	}
+	if len(words) > 0 && unicode.IsSpace(rune(s[len(s)-1])) {
+		t.space = true
+	}
+}
+
+// pre writes s, keeping its whitespace.
+func (t *textWriter) pre(s string) {
+	for i, line := range strings.Split(s, "\n") {
+		if i > 0 {
+			t.newlines++
+		}
+		if line != "" {
+			t.write(line)
+		}
+	}
+}
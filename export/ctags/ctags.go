@@ -0,0 +1,280 @@
+// Package ctags writes the defs in srclib graph data as tags files,
+// which editors use to jump to definitions by name.
+//
+// Write writes an extended (format 2) ctags file, as read by Vim and
+// most other editors. Each def that is not local is a tag, whose
+// address is the line of the def's name (the ref whose Def field is
+// true), if any, or else the start of its definition. Its extension
+// fields are:
+//
+//	kind       the def's kind
+//	line       the line number
+//	<kind>     the def's parent in its tree path (e.g., "type:T"), if any
+//	signature  the def's type, if it has a DefFormatter (or format strings)
+//	exported   "1" if the def is exported
+//	test       "1" if the def is defined in test code
+//
+// WriteEtags writes an Emacs etags file of the same defs.
+package ctags
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib/export"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/srcpos"
+)
+
+// Options configures how a tags file is written.
+type Options struct {
+	// ReadFile reads the files in the version (which are needed to
+	// convert byte offsets to line numbers).
+	ReadFile export.ReadFileFunc
+
+	// ProgramVersion is the version of srclib, which is recorded in
+	// the ctags file's header.
+	ProgramVersion string
+}
+
+// A tag is a def and its location in a file.
+type tag struct {
+	def  *graph.Def
+	name string
+	file string
+	line int // 1-based
+
+	// text is the text of the line up to the end of the def's name,
+	// and offset is the byte offset of the start of the line (both
+	// are used by etags).
+	text   string
+	offset int
+}
+
+// Write writes a ctags file of the defs in d to w. Files that don't
+// exist (i.e., for which ReadFile returns an error satisfying
+// os.IsNotExist) are omitted, and returned in skipped.
+func Write(w io.Writer, d *export.Data, opt Options) (skipped []string, err error) {
+	files, skipped, err := tags(d, opt)
+	if err != nil {
+		return nil, err
+	}
+	var all []*tag
+	for _, f := range files {
+		all = append(all, f...)
+	}
+	sort.Stable(tagsByName(all))
+
+	scopes := newScopes(d)
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "!_TAG_FILE_FORMAT\t2\t/extended format; --format=1 will not append ;\" to lines/\n")
+	fmt.Fprintf(bw, "!_TAG_FILE_SORTED\t1\t/0=unsorted, 1=sorted, 2=foldcase/\n")
+	fmt.Fprintf(bw, "!_TAG_PROGRAM_NAME\tsrclib\t//\n")
+	fmt.Fprintf(bw, "!_TAG_PROGRAM_URL\thttps://srclib.org\t//\n")
+	if opt.ProgramVersion != "" {
+		fmt.Fprintf(bw, "!_TAG_PROGRAM_VERSION\t%s\t//\n", opt.ProgramVersion)
+	}
+	for _, t := range all {
+		fmt.Fprintf(bw, "%s\t%s\t%d;\"", t.name, t.file, t.line)
+		if t.def.Kind != "" {
+			writeField(bw, "kind", t.def.Kind)
+		}
+		writeField(bw, "line", strconv.Itoa(t.line))
+		if kind, name := scopes.scope(t.def); name != "" {
+			writeField(bw, kind, name)
+		}
+		if sig := signature(t.def); sig != "" {
+			writeField(bw, "signature", sig)
+		}
+		if t.def.Exported {
+			writeField(bw, "exported", "1")
+		}
+		if t.def.Test {
+			writeField(bw, "test", "1")
+		}
+		bw.WriteByte('\n')
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	return skipped, nil
+}
+
+// WriteEtags writes an Emacs etags file of the defs in d to w. Files
+// that don't exist are omitted, and returned in skipped (as in Write).
+func WriteEtags(w io.Writer, d *export.Data, opt Options) (skipped []string, err error) {
+	files, skipped, err := tags(d, opt)
+	if err != nil {
+		return nil, err
+	}
+	bw := bufio.NewWriter(w)
+	for _, ts := range files {
+		// Each file's section is preceded by the size of its tags.
+		var sec bytes.Buffer
+		for _, t := range ts {
+			fmt.Fprintf(&sec, "%s\x7f%s\x01%d,%d\n", t.text, t.name, t.line, t.offset)
+		}
+		fmt.Fprintf(bw, "\x0c\n%s,%d\n", ts[0].file, sec.Len())
+		sec.WriteTo(bw)
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	return skipped, nil
+}
+
+// tags returns the tags of the defs in each file in d (that has
+// any), in the order of the defs in the file.
+func tags(d *export.Data, opt Options) (files [][]*tag, skipped []string, err error) {
+	for _, f := range d.Files() {
+		var defs []*graph.Def
+		for _, def := range f.Defs {
+			if !def.Local && validName(def.Name) {
+				defs = append(defs, def)
+			}
+		}
+		if len(defs) == 0 {
+			continue
+		}
+
+		src, err := opt.ReadFile(f.Name)
+		if os.IsNotExist(err) {
+			skipped = append(skipped, f.Name)
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+		table := srcpos.NewTable(src)
+
+		nameRefs, _ := d.NameRefs(f)
+		var ts []*tag
+		for _, def := range defs {
+			start, end := def.DefStart, def.DefStart
+			if ref := nameRefs[d.DefKey(def)]; ref != nil && ref.Start <= ref.End {
+				start, end = ref.Start, ref.End
+			}
+			line, err := table.Line(start)
+			if err == nil {
+				_, err = table.Line(end)
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %s (is the file from the exported commit?)", f.Name, err)
+			}
+			lineStart, lineEnd, _ := table.LineOffsets(line)
+			text := src[lineStart:end]
+			if end == start {
+				// There is no name ref, so use the whole line.
+				text = src[lineStart:lineEnd]
+			}
+			ts = append(ts, &tag{
+				def:    def,
+				name:   def.Name,
+				file:   f.Name,
+				line:   line + 1,
+				text:   strings.TrimSuffix(string(text), "\r"),
+				offset: int(lineStart),
+			})
+		}
+		sort.Stable(tagsByLine(ts))
+		files = append(files, ts)
+	}
+	return files, skipped, nil
+}
+
+// validName reports whether name can be a tag name, which must be
+// nonempty and can't contain tabs or newlines.
+func validName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, "\t\r\n\x7f\x01")
+}
+
+// signature returns the type of def, as formatted by its DefFormatter
+// (see graph.LookupDefFormatter), or "" if there is none.
+func signature(def *graph.Def) string {
+	f := graph.LookupDefFormatter(def)
+	if f == nil {
+		return ""
+	}
+	return f.Type(graph.Unqualified)
+}
+
+// writeField writes an extension field. Backslashes, tabs, and
+// newlines in the value are escaped.
+func writeField(w *bufio.Writer, key, value string) {
+	w.WriteString("\t")
+	w.WriteString(key)
+	w.WriteString(":")
+	w.WriteString(fieldValueEscaper.Replace(value))
+}
+
+var fieldValueEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\r", `\r`, "\n", `\n`)
+
+// scopes finds the parents of defs in their tree paths.
+type scopes map[scopeKey]*graph.Def
+
+type scopeKey struct{ unitType, unit, treePath string }
+
+func newScopes(d *export.Data) scopes {
+	s := scopes{}
+	for _, def := range d.Defs {
+		if def.TreePath != "" {
+			s[scopeKey{def.UnitType, def.Unit, def.TreePath}] = def
+		}
+	}
+	return s
+}
+
+// scope returns the kind and name of def's parent (the def whose tree
+// path is the longest prefix of def's that ends in a def name, as
+// opposed to a ghost component). The name is qualified with the
+// names of its parents (e.g., "A.B"). If the parent def is not in the
+// data, the kind is "scope".
+func (s scopes) scope(def *graph.Def) (kind, name string) {
+	parts := strings.Split(def.TreePath, "/")
+	parent := len(parts) - 2
+	for parent >= 0 && strings.HasPrefix(parts[parent], "-") {
+		parent--
+	}
+	if parent < 0 {
+		return "", ""
+	}
+	var names []string
+	for _, p := range parts[:parent+1] {
+		if p != "" && !strings.HasPrefix(p, "-") {
+			names = append(names, p)
+		}
+	}
+	if len(names) == 0 {
+		return "", ""
+	}
+	kind = "scope"
+	if p := s[scopeKey{def.UnitType, def.Unit, strings.Join(parts[:parent+1], "/")}]; p != nil && p.Kind != "" {
+		kind = p.Kind
+	}
+	return kind, strings.Join(names, ".")
+}
+
+type tagsByName []*tag
+
+func (v tagsByName) Len() int      { return len(v) }
+func (v tagsByName) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v tagsByName) Less(i, j int) bool {
+	if v[i].name != v[j].name {
+		return v[i].name < v[j].name
+	}
+	if v[i].file != v[j].file {
+		return v[i].file < v[j].file
+	}
+	return v[i].line < v[j].line
+}
+
+type tagsByLine []*tag
+
+func (v tagsByLine) Len() int           { return len(v) }
+func (v tagsByLine) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v tagsByLine) Less(i, j int) bool { return v[i].line < v[j].line }
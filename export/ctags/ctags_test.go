@@ -0,0 +1,126 @@
+package ctags
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/export"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func init() {
+	graph.RegisterMakeDefFormatter("CtagsTest", func(def *graph.Def) graph.DefFormatter {
+		return testFormatter{def}
+	})
+}
+
+// testFormatter formats the type of method defs as "(x int)".
+type testFormatter struct{ def *graph.Def }
+
+func (f testFormatter) Name(graph.Qualification) string { return f.def.Name }
+func (f testFormatter) Type(graph.Qualification) string {
+	if f.def.Kind == "method" {
+		return "(x int)"
+	}
+	return ""
+}
+func (testFormatter) NameAndTypeSeparator() string { return "" }
+func (testFormatter) Language() string             { return "Go" }
+func (testFormatter) DefKeyword() string           { return "func" }
+func (f testFormatter) Kind() string               { return f.def.Kind }
+
+// testData returns graph data with a type and a method (whose tree
+// path has a ghost component), a def without a name ref, a local def,
+// a test def, and a def in a file that doesn't exist.
+func testData() (*export.Data, export.ReadFileFunc) {
+	files := map[string]string{
+		"a/a.go":      "package a\n\ntype T struct{}\n\nfunc (T) M(x int) {}\n\nfunc F() {}\n",
+		"a/a_test.go": "package a\n\nfunc TestF() {}\n",
+	}
+	key := func(path string) graph.DefKey {
+		return graph.DefKey{Repo: "r", CommitID: "c", UnitType: "CtagsTest", Unit: "a", Path: path}
+	}
+	ref := func(path string, start, end uint32) *graph.Ref {
+		return &graph.Ref{DefRepo: "r", DefUnitType: "CtagsTest", DefUnit: "a", DefPath: path, Repo: "r", CommitID: "c", UnitType: "CtagsTest", Unit: "a", File: "a/a.go", Start: start, End: end, Def: true}
+	}
+	d := &export.Data{
+		Repo:     "r",
+		CommitID: "c",
+		Units: []*unit.SourceUnit{
+			{Key: unit.Key{Type: "CtagsTest", Name: "a"}, Info: unit.Info{Files: []string{"a/a.go", "a/a_test.go", "a/missing.go"}}},
+		},
+		Defs: []*graph.Def{
+			{DefKey: key("T"), TreePath: "T", Name: "T", Kind: "type", File: "a/a.go", DefStart: 11, DefEnd: 26, Exported: true},
+			{DefKey: key("T/M"), TreePath: "T/-m/M", Name: "M", Kind: "method", File: "a/a.go", DefStart: 28, DefEnd: 48, Exported: true},
+			{DefKey: key("T/M/x"), TreePath: "T/-m/M/x", Name: "x", Kind: "var", File: "a/a.go", DefStart: 39, DefEnd: 40, Local: true},
+			{DefKey: key("F"), TreePath: "F", Name: "F", Kind: "func", File: "a/a.go", DefStart: 50, DefEnd: 61},
+			{DefKey: key("TestF"), TreePath: "TestF", Name: "TestF", Kind: "func", File: "a/a_test.go", DefStart: 11, DefEnd: 26, Exported: true, Test: true},
+			{DefKey: key("Missing"), TreePath: "Missing", Name: "Missing", Kind: "func", File: "a/missing.go", DefStart: 0, DefEnd: 1},
+		},
+		Refs: []*graph.Ref{ref("T", 16, 17), ref("T/M", 37, 38), ref("T/M/x", 39, 40)},
+	}
+	readFile := func(file string) ([]byte, error) {
+		if data, present := files[file]; present {
+			return []byte(data), nil
+		}
+		return nil, &os.PathError{Op: "open", Path: file, Err: os.ErrNotExist}
+	}
+	return d, readFile
+}
+
+func TestWrite(t *testing.T) {
+	d, readFile := testData()
+	var buf bytes.Buffer
+	skipped, err := Write(&buf, d, Options{ReadFile: readFile, ProgramVersion: "v"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a/missing.go"}; !reflect.DeepEqual(skipped, want) {
+		t.Errorf("got skipped files %v, want %v", skipped, want)
+	}
+
+	want := "!_TAG_FILE_FORMAT\t2\t/extended format; --format=1 will not append ;\" to lines/\n" +
+		"!_TAG_FILE_SORTED\t1\t/0=unsorted, 1=sorted, 2=foldcase/\n" +
+		"!_TAG_PROGRAM_NAME\tsrclib\t//\n" +
+		"!_TAG_PROGRAM_URL\thttps://srclib.org\t//\n" +
+		"!_TAG_PROGRAM_VERSION\tv\t//\n" +
+		"F\ta/a.go\t7;\"\tkind:func\tline:7\n" +
+		"M\ta/a.go\t5;\"\tkind:method\tline:5\ttype:T\tsignature:(x int)\texported:1\n" +
+		"T\ta/a.go\t3;\"\tkind:type\tline:3\texported:1\n" +
+		"TestF\ta/a_test.go\t3;\"\tkind:func\tline:3\texported:1\ttest:1\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got tags file\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWriteEtags(t *testing.T) {
+	d, readFile := testData()
+	var buf bytes.Buffer
+	if _, err := WriteEtags(&buf, d, Options{ReadFile: readFile}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "\x0c\na/a.go,51\n" +
+		"type T\x7fT\x013,11\n" +
+		"func (T) M\x7fM\x015,28\n" +
+		"func F() {}\x7fF\x017,50\n" +
+		"\x0c\na/a_test.go,27\n" +
+		"func TestF() {}\x7fTestF\x013,11\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got etags file\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestWrite_offsetOutOfRange(t *testing.T) {
+	d, _ := testData()
+	_, err := Write(ioutil.Discard, d, Options{
+		ReadFile: func(file string) ([]byte, error) { return []byte("x"), nil },
+	})
+	if err == nil {
+		t.Error("got nil error, want an error for byte offsets past the end of the file")
+	}
+}
@@ -0,0 +1,149 @@
+// Package export reads the graph data of a version of a repo from a
+// store (or from a grapher's output), for conversion into formats that
+// other tools consume. The formats are implemented in its subpackages
+// (e.g., export/lsif and export/scip).
+package export
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// Data is the graph data of a version of a repo.
+type Data struct {
+	// Repo and CommitID identify the version. Repo is empty if the
+	// data was loaded from a RepoStore and no repo was specified.
+	Repo     string
+	CommitID string
+
+	Units []*unit.SourceUnit
+	Defs  []*graph.Def // docs are in the defs' Docs fields
+	Refs  []*graph.Ref
+
+	defs map[graph.DefKey]*graph.Def // see Def
+}
+
+// Load loads the graph data of a version from rs, which is either a
+// RepoStore or a MultiRepoStore. The repo is required if rs is a
+// MultiRepoStore.
+func Load(rs store.RepoStore, repo, commitID string) (*Data, error) {
+	if commitID == "" {
+		return nil, fmt.Errorf("export from %s: commit ID is required", rs)
+	}
+
+	// scope restricts all queries to the exported version.
+	var scope interface {
+		store.DefFilter
+		store.RefFilter
+		store.UnitFilter
+	} = store.ByCommitIDs(commitID)
+	if _, ok := rs.(store.MultiRepoStore); ok {
+		if repo == "" {
+			return nil, fmt.Errorf("export from %s: repo is required", rs)
+		}
+		scope = store.ByRepoCommitIDs(store.Version{Repo: repo, CommitID: commitID})
+	}
+
+	d := &Data{Repo: repo, CommitID: commitID}
+	var err error
+	if d.Units, err = rs.Units(scope); err != nil {
+		return nil, err
+	}
+	if len(d.Units) == 0 {
+		return nil, fmt.Errorf("export from %s: no source units in repo %q commit %q", rs, repo, commitID)
+	}
+	if d.Defs, err = rs.Defs(scope); err != nil {
+		return nil, err
+	}
+	if d.Refs, err = rs.Refs(scope); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Add adds a source unit's graph data (as it is output by a grapher,
+// before it is imported into a store) to d. The fields of the defs and
+// refs that are implied by the source unit are filled in, and docs are
+// added to their defs' Docs fields, as when the data is imported.
+func (d *Data) Add(u *unit.SourceUnit, out *graph.Output) {
+	d.Units = append(d.Units, u)
+
+	docsByPath := make(map[string]*graph.Doc, len(out.Docs))
+	for _, doc := range out.Docs {
+		docsByPath[doc.Path] = doc
+	}
+	for _, def := range out.Defs {
+		if def.UnitType == "" && def.Unit == "" {
+			def.UnitType, def.Unit = u.Type, u.Name
+		}
+		if doc, present := docsByPath[def.Path]; present {
+			def.Docs = append(def.Docs, &graph.DefDoc{Format: doc.Format, Data: doc.Data})
+		}
+		d.Defs = append(d.Defs, def)
+	}
+	for _, ref := range out.Refs {
+		if ref.UnitType == "" && ref.Unit == "" {
+			ref.UnitType, ref.Unit = u.Type, u.Name
+		}
+		d.Refs = append(d.Refs, ref)
+	}
+	d.defs = nil
+}
+
+// Def returns the def in d with the given key (as returned by DefKey
+// or RefDefKey), or nil if there is none.
+func (d *Data) Def(k graph.DefKey) *graph.Def {
+	if d.defs == nil {
+		d.defs = make(map[graph.DefKey]*graph.Def, len(d.Defs))
+		for _, def := range d.Defs {
+			d.defs[d.DefKey(def)] = def
+		}
+	}
+	return d.defs[k]
+}
+
+// DefKey returns the key of def, with the version's repo filled in if
+// the store left it empty. The commit ID is omitted.
+func (d *Data) DefKey(def *graph.Def) graph.DefKey {
+	k := def.DefKey
+	if k.Repo == "" {
+		k.Repo = d.Repo
+	}
+	k.CommitID = ""
+	return k
+}
+
+// RefDefKey returns the key of the def that ref refers to, with the
+// fields that the store left empty (because they are the same as the
+// ref's own) filled in. The commit ID is omitted, so that the key
+// equals the DefKey of the def if it is in the version.
+func (d *Data) RefDefKey(ref *graph.Ref) graph.DefKey {
+	k := ref.DefKey()
+	if k.Repo == "" {
+		k.Repo = ref.Repo
+	}
+	if k.Repo == "" {
+		k.Repo = d.Repo
+	}
+	if k.UnitType == "" && k.Unit == "" {
+		k.UnitType, k.Unit = ref.UnitType, ref.Unit
+	}
+	return k
+}
+
+// A ReadFileFunc reads a file (given by its path relative to the
+// repo's root dir) in the exported version of a repo.
+type ReadFileFunc func(file string) ([]byte, error)
+
+// DirReadFile returns a ReadFileFunc that reads files from the repo
+// checked out in dir.
+func DirReadFile(dir string) ReadFileFunc {
+	return func(file string) ([]byte, error) {
+		return ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(file)))
+	}
+}
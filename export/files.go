@@ -0,0 +1,123 @@
+package export
+
+import (
+	"path"
+	"sort"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// A File holds the defs and refs in a file.
+type File struct {
+	Name string
+
+	// UnitType and Unit identify the first source unit (in the order
+	// of Data.Units) that contains the file.
+	UnitType, Unit string
+
+	// Defs and Refs are sorted by their start offsets.
+	Defs []*graph.Def
+	Refs []*graph.Ref
+}
+
+// Files groups the defs and refs in d by file. The files listed in
+// d's source units are included even if they have no defs or refs.
+// The files are sorted by name.
+func (d *Data) Files() []*File {
+	files := map[string]*File{}
+	var names []string
+	get := func(name, unitType, unit string) *File {
+		f, present := files[name]
+		if !present {
+			f = &File{Name: name, UnitType: unitType, Unit: unit}
+			files[name] = f
+			names = append(names, name)
+		}
+		return f
+	}
+	for _, u := range d.Units {
+		for _, name := range u.Files {
+			get(name, u.Type, u.Name)
+		}
+	}
+	for _, def := range d.Defs {
+		f := get(def.File, def.UnitType, def.Unit)
+		f.Defs = append(f.Defs, def)
+	}
+	for _, ref := range d.Refs {
+		f := get(ref.File, ref.UnitType, ref.Unit)
+		f.Refs = append(f.Refs, ref)
+	}
+
+	sort.Strings(names)
+	sorted := make([]*File, len(names))
+	for i, name := range names {
+		f := files[name]
+		sort.Stable(defsByStart(f.Defs))
+		sort.Stable(refsByStart(f.Refs))
+		sorted[i] = f
+	}
+	return sorted
+}
+
+// NameRefs returns, for each def in f, the ref at the def's name (the
+// ref to the def whose Def field is true), if any. Formats that
+// distinguish a def's name from its whole definition use the name
+// ref's range as the def's range. It also returns f's other refs.
+func (d *Data) NameRefs(f *File) (nameRefs map[graph.DefKey]*graph.Ref, otherRefs []*graph.Ref) {
+	nameRefs = map[graph.DefKey]*graph.Ref{}
+	for _, ref := range f.Refs {
+		if k := d.RefDefKey(ref); ref.Def && nameRefs[k] == nil {
+			if def := d.Def(k); def != nil && def.File == f.Name {
+				nameRefs[k] = ref
+				continue
+			}
+		}
+		otherRefs = append(otherRefs, ref)
+	}
+	return nameRefs, otherRefs
+}
+
+type defsByStart []*graph.Def
+
+func (v defsByStart) Len() int           { return len(v) }
+func (v defsByStart) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v defsByStart) Less(i, j int) bool { return v[i].DefStart < v[j].DefStart }
+
+type refsByStart []*graph.Ref
+
+func (v refsByStart) Len() int           { return len(v) }
+func (v refsByStart) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v refsByStart) Less(i, j int) bool { return v[i].Start < v[j].Start }
+
+// A Language identifies the programming language of a file in the
+// formats that need it.
+type Language struct {
+	LSP  string // LSP language identifier (e.g., "go")
+	SCIP string // SCIP language name (e.g., "Go")
+}
+
+var languages = map[string]Language{
+	".c":     {"c", "C"},
+	".cpp":   {"cpp", "CPP"},
+	".cs":    {"csharp", "CSharp"},
+	".go":    {"go", "Go"},
+	".h":     {"c", "C"},
+	".java":  {"java", "Java"},
+	".js":    {"javascript", "JavaScript"},
+	".jsx":   {"javascriptreact", "JavaScriptReact"},
+	".php":   {"php", "PHP"},
+	".py":    {"python", "Python"},
+	".rb":    {"ruby", "Ruby"},
+	".rs":    {"rust", "Rust"},
+	".scala": {"scala", "Scala"},
+	".ts":    {"typescript", "TypeScript"},
+	".tsx":   {"typescriptreact", "TypeScriptReact"},
+}
+
+// FileLanguage returns the language of a file (based on its
+// extension), and false if it is not known.
+func FileLanguage(file string) (Language, bool) {
+	lang, ok := languages[path.Ext(file)]
+	return lang, ok
+}
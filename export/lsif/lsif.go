@@ -0,0 +1,404 @@
+// Package lsif writes srclib graph data as an LSIF (Language Server
+// Index Format) dump, which editors and code hosts use to provide
+// code intelligence (go-to-definition, find-references, and hovers)
+// without running a language server.
+//
+// A dump is a sequence of JSON-encoded vertices and edges, one per
+// line (see
+// https://microsoft.github.io/language-server-protocol/specifications/lsif/0.4.0/specification/).
+// Each source unit is a project, and each file is a document. Each
+// def's range is the range of the ref at its name (the ref whose Def
+// field is true), if any, or else its whole definition. Defs and the
+// refs to them share a result set, which has the def's docs as its
+// hover and a moniker that identifies the def across dumps.
+package lsif
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib/export"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/srcpos"
+)
+
+// Version is the version of LSIF that dumps conform to.
+const Version = "0.4.3"
+
+// MonikerScheme is the scheme of the monikers of defs.
+const MonikerScheme = "srclib"
+
+// Options configures how a dump is written.
+type Options struct {
+	// ProjectRoot is the URI of the repo's root dir (e.g.,
+	// "file:///home/alice/myrepo"). Document URIs are relative to it.
+	ProjectRoot string
+
+	// ReadFile reads the files in the version (which are needed to
+	// convert byte offsets to lines and characters).
+	ReadFile export.ReadFileFunc
+}
+
+// Write writes d as an LSIF dump to w. Files that don't exist (i.e.,
+// for which ReadFile returns an error satisfying os.IsNotExist) are
+// omitted from the dump, and returned in skipped.
+func Write(w io.Writer, d *export.Data, opt Options) (skipped []string, err error) {
+	bw := bufio.NewWriter(w)
+	e := &emitter{
+		d:          d,
+		opt:        opt,
+		enc:        json.NewEncoder(bw),
+		resultSets: map[graph.DefKey]*resultSet{},
+		packages:   map[string]int{},
+	}
+	if err := e.emit(); err != nil {
+		return nil, err
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	return e.skipped, nil
+}
+
+// A resultSet holds the ranges of a def (or an external def that is
+// referred to in the version), which are used to emit its definition
+// and reference results.
+type resultSet struct {
+	id   int
+	defs []docRange
+	refs []docRange
+}
+
+type docRange struct{ doc, rng int }
+
+type emitter struct {
+	d   *export.Data
+	opt Options
+	enc *json.Encoder
+
+	lastID int
+
+	resultSets map[graph.DefKey]*resultSet
+	rsOrder    []*resultSet // result sets in the order they were emitted
+	packages   map[string]int
+
+	skipped []string
+}
+
+func (e *emitter) nextID() int {
+	e.lastID++
+	return e.lastID
+}
+
+// vertex emits a vertex. The vertex's id, type, and label are set in
+// v, which must embed an element.
+func (e *emitter) vertex(label string, v interface{ setElement(element) }) (int, error) {
+	id := e.nextID()
+	v.setElement(element{ID: id, Type: "vertex", Label: label})
+	return id, e.enc.Encode(v)
+}
+
+func (e *emitter) edge(label string, ed edge) error {
+	ed.element = element{ID: e.nextID(), Type: "edge", Label: label}
+	return e.enc.Encode(ed)
+}
+
+func (e *emitter) emit() error {
+	if _, err := e.vertex("metaData", &metaData{
+		Version:          Version,
+		ProjectRoot:      e.opt.ProjectRoot,
+		PositionEncoding: "utf-16",
+		ToolInfo:         toolInfo{Name: "srclib"},
+	}); err != nil {
+		return err
+	}
+
+	// Emit a project for each source unit (in store order) and the
+	// documents for its files.
+	projects := map[unitKey]int{}
+	for _, u := range e.d.Units {
+		k := unitKey{u.Type, u.Name}
+		if _, present := projects[k]; present {
+			continue
+		}
+		var lang string
+		if len(u.Files) > 0 {
+			lang = languageID(u.Files[0])
+		}
+		id, err := e.vertex("project", &project{Kind: lang, Name: u.Name})
+		if err != nil {
+			return err
+		}
+		projects[k] = id
+	}
+	for _, f := range e.d.Files() {
+		projectID, present := projects[unitKey{f.UnitType, f.Unit}]
+		if !present {
+			// The file's unit is not in the version (which should
+			// not happen in a valid store).
+			continue
+		}
+		if err := e.emitDocument(f, projectID); err != nil {
+			return err
+		}
+	}
+
+	for _, rs := range e.rsOrder {
+		if err := e.emitResults(rs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type unitKey struct{ typ, name string }
+
+func (e *emitter) emitDocument(f *export.File, projectID int) error {
+	src, err := e.opt.ReadFile(f.Name)
+	if os.IsNotExist(err) {
+		e.skipped = append(e.skipped, f.Name)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	table := srcpos.NewTable(src)
+
+	docID, err := e.vertex("document", &document{
+		URI:        strings.TrimSuffix(e.opt.ProjectRoot, "/") + "/" + f.Name,
+		LanguageID: languageID(f.Name),
+	})
+	if err != nil {
+		return err
+	}
+	if err := e.edge("contains", edge{OutV: projectID, InVs: []int{docID}}); err != nil {
+		return err
+	}
+
+	// The refs at the defs' names are the defs' ranges.
+	defNameRefs, refs := e.d.NameRefs(f)
+
+	var rangeIDs []int
+	emitRange := func(start, end uint32, k graph.DefKey, isDef bool) error {
+		rng, err := e.rangeVertex(table, f.Name, start, end)
+		if err != nil {
+			return err
+		}
+		id, err := e.vertex("range", rng)
+		if err != nil {
+			return err
+		}
+		rangeIDs = append(rangeIDs, id)
+
+		rs, err := e.resultSet(k)
+		if err != nil {
+			return err
+		}
+		if isDef {
+			rs.defs = append(rs.defs, docRange{docID, id})
+		} else {
+			rs.refs = append(rs.refs, docRange{docID, id})
+		}
+		return e.edge("next", edge{OutV: id, InV: rs.id})
+	}
+	for _, def := range f.Defs {
+		k := e.d.DefKey(def)
+		start, end := def.DefStart, def.DefEnd
+		if ref := defNameRefs[k]; ref != nil {
+			start, end = ref.Start, ref.End
+		}
+		if err := emitRange(start, end, k, true); err != nil {
+			return err
+		}
+	}
+	for _, ref := range refs {
+		if err := emitRange(ref.Start, ref.End, e.d.RefDefKey(ref), false); err != nil {
+			return err
+		}
+	}
+
+	if len(rangeIDs) == 0 {
+		return nil
+	}
+	return e.edge("contains", edge{OutV: docID, InVs: rangeIDs})
+}
+
+func (e *emitter) rangeVertex(table *srcpos.Table, file string, start, end uint32) (*rangeV, error) {
+	startPos, err := table.Position(start, srcpos.UTF16)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s (is the file from the exported commit?)", file, err)
+	}
+	endPos, err := table.Position(end, srcpos.UTF16)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s (is the file from the exported commit?)", file, err)
+	}
+	return &rangeV{
+		Start: position{Line: startPos.Line, Character: startPos.Column},
+		End:   position{Line: endPos.Line, Character: endPos.Column},
+	}, nil
+}
+
+// resultSet returns the result set of the def with the given key,
+// emitting it (and its moniker and hover result) if it has not yet
+// been emitted.
+func (e *emitter) resultSet(k graph.DefKey) (*resultSet, error) {
+	if rs, present := e.resultSets[k]; present {
+		return rs, nil
+	}
+	id, err := e.vertex("resultSet", &plainVertex{})
+	if err != nil {
+		return nil, err
+	}
+	rs := &resultSet{id: id}
+	e.resultSets[k] = rs
+	e.rsOrder = append(e.rsOrder, rs)
+
+	def := e.d.Def(k)
+	kind := "import"
+	if def != nil {
+		kind = "export"
+		if !def.Exported {
+			kind = "local"
+		}
+	}
+	if def == nil || !def.Local {
+		if err := e.emitMoniker(rs.id, k, kind); err != nil {
+			return nil, err
+		}
+	}
+
+	if def != nil {
+		if contents, ok := hoverContents(def.Docs); ok {
+			hoverID, err := e.vertex("hoverResult", &hoverResult{Result: hover{Contents: contents}})
+			if err != nil {
+				return nil, err
+			}
+			if err := e.edge("textDocument/hover", edge{OutV: rs.id, InV: hoverID}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return rs, nil
+}
+
+// emitMoniker emits the moniker of the def with the given key, and
+// links it to the package information of the def's repo.
+func (e *emitter) emitMoniker(rsID int, k graph.DefKey, kind string) error {
+	monikerID, err := e.vertex("moniker", &moniker{
+		Scheme:     MonikerScheme,
+		Identifier: k.UnitType + ":" + k.Unit + ":" + k.Path,
+		Kind:       kind,
+	})
+	if err != nil {
+		return err
+	}
+	if err := e.edge("moniker", edge{OutV: rsID, InV: monikerID}); err != nil {
+		return err
+	}
+	if k.Repo == "" {
+		return nil
+	}
+
+	pkgID, present := e.packages[k.Repo]
+	if !present {
+		pkg := &packageInformation{Name: k.Repo, Manager: MonikerScheme}
+		if k.Repo == e.d.Repo {
+			pkg.Version = e.d.CommitID
+		}
+		if pkgID, err = e.vertex("packageInformation", pkg); err != nil {
+			return err
+		}
+		e.packages[k.Repo] = pkgID
+	}
+	return e.edge("packageInformation", edge{OutV: monikerID, InV: pkgID})
+}
+
+// emitResults emits the definition and reference results of a result
+// set (after all of the ranges in the dump have been emitted).
+func (e *emitter) emitResults(rs *resultSet) error {
+	if len(rs.defs) > 0 {
+		id, err := e.vertex("definitionResult", &plainVertex{})
+		if err != nil {
+			return err
+		}
+		if err := e.edge("textDocument/definition", edge{OutV: rs.id, InV: id}); err != nil {
+			return err
+		}
+		if err := e.emitItems(id, rs.defs, ""); err != nil {
+			return err
+		}
+	}
+
+	id, err := e.vertex("referenceResult", &plainVertex{})
+	if err != nil {
+		return err
+	}
+	if err := e.edge("textDocument/references", edge{OutV: rs.id, InV: id}); err != nil {
+		return err
+	}
+	if err := e.emitItems(id, rs.defs, "definitions"); err != nil {
+		return err
+	}
+	return e.emitItems(id, rs.refs, "references")
+}
+
+// emitItems emits an item edge from the result with the given ID to
+// the ranges in each document.
+func (e *emitter) emitItems(resultID int, ranges []docRange, property string) error {
+	var docs []int
+	byDoc := map[int][]int{}
+	for _, r := range ranges {
+		if _, present := byDoc[r.doc]; !present {
+			docs = append(docs, r.doc)
+		}
+		byDoc[r.doc] = append(byDoc[r.doc], r.rng)
+	}
+	for _, doc := range docs {
+		if err := e.edge("item", edge{OutV: resultID, InVs: byDoc[doc], Document: doc, Property: property}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hoverContents returns the hover contents for a def's docs, preferring
+// Markdown docs to plain text docs, and plain text docs to HTML docs.
+func hoverContents(docs []*graph.DefDoc) (markupContent, bool) {
+	var best *graph.DefDoc
+	rank := func(doc *graph.DefDoc) int {
+		switch doc.Format {
+		case "text/markdown":
+			return 3
+		case "text/plain":
+			return 2
+		case "text/html":
+			return 1
+		}
+		return 0
+	}
+	for _, doc := range docs {
+		if doc.Data != "" && rank(doc) > 0 && (best == nil || rank(doc) > rank(best)) {
+			best = doc
+		}
+	}
+	if best == nil {
+		return markupContent{}, false
+	}
+	kind := "markdown" // Markdown allows HTML
+	if best.Format == "text/plain" {
+		kind = "plaintext"
+	}
+	return markupContent{Kind: kind, Value: best.Data}, true
+}
+
+// languageID returns the LSP language identifier of a file, or
+// "plaintext" if it is not known.
+func languageID(file string) string {
+	if lang, ok := export.FileLanguage(file); ok {
+		return lang.LSP
+	}
+	return "plaintext"
+}
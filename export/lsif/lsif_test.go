@@ -0,0 +1,140 @@
+package lsif
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/export"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// testData returns graph data for a version with 2 source units, a
+// cross-unit ref, a ref to a def in another repo, docs, and a file
+// with multi-byte characters (to test the conversion of byte offsets
+// to UTF-16 characters).
+func testData() (*export.Data, map[string]string) {
+	files := map[string]string{
+		"a/a.go": "package a\n\n// Héllo 👋\nfunc Hello() { x := \"é👋\"; fmt.Println(x) }\n",
+		"b/b.go": "package b\n\nfunc B() { a.Hello() }\n",
+	}
+	d := &export.Data{
+		Repo:     "example.com/r",
+		CommitID: "c",
+		Units: []*unit.SourceUnit{
+			{Key: unit.Key{Type: "GoPackage", Name: "a"}, Info: unit.Info{Files: []string{"a/a.go"}}},
+			{Key: unit.Key{Type: "GoPackage", Name: "b"}, Info: unit.Info{Files: []string{"b/b.go", "b/missing.go"}}},
+		},
+		Defs: []*graph.Def{
+			{
+				DefKey: graph.DefKey{Repo: "example.com/r", CommitID: "c", UnitType: "GoPackage", Unit: "a", Path: "Hello"},
+				Name:   "Hello", File: "a/a.go", DefStart: 26, DefEnd: 72, Exported: true,
+				Docs: []*graph.DefDoc{{Format: "text/html", Data: "<p>Héllo 👋</p>"}, {Format: "text/plain", Data: "Héllo 👋"}},
+			},
+			{
+				DefKey: graph.DefKey{Repo: "example.com/r", CommitID: "c", UnitType: "GoPackage", Unit: "b", Path: "B"},
+				Name:   "B", File: "b/b.go", DefStart: 11, DefEnd: 33,
+			},
+		},
+		Refs: []*graph.Ref{
+			{DefRepo: "example.com/r", DefUnitType: "GoPackage", DefUnit: "a", DefPath: "Hello", Repo: "example.com/r", CommitID: "c", UnitType: "GoPackage", Unit: "a", File: "a/a.go", Start: 31, End: 36, Def: true},
+			{DefRepo: "fmt", DefUnitType: "GoPackage", DefUnit: "fmt", DefPath: "Println", Repo: "example.com/r", CommitID: "c", UnitType: "GoPackage", Unit: "a", File: "a/a.go", Start: 60, End: 67},
+			{DefRepo: "example.com/r", DefUnitType: "GoPackage", DefUnit: "b", DefPath: "B", Repo: "example.com/r", CommitID: "c", UnitType: "GoPackage", Unit: "b", File: "b/b.go", Start: 16, End: 17, Def: true},
+			{DefRepo: "example.com/r", DefUnitType: "GoPackage", DefUnit: "a", DefPath: "Hello", Repo: "example.com/r", CommitID: "c", UnitType: "GoPackage", Unit: "b", File: "b/b.go", Start: 24, End: 29},
+		},
+	}
+	return d, files
+}
+
+func TestWrite_golden(t *testing.T) {
+	d, files := testData()
+	var buf bytes.Buffer
+	skipped, err := Write(&buf, d, Options{
+		ProjectRoot: "file:///src/r",
+		ReadFile: func(file string) ([]byte, error) {
+			if data, present := files[file]; present {
+				return []byte(data), nil
+			}
+			return nil, &os.PathError{Op: "open", Path: file, Err: os.ErrNotExist}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"b/missing.go"}; !reflect.DeepEqual(skipped, want) {
+		t.Errorf("got skipped files %v, want %v", skipped, want)
+	}
+
+	checkValid(t, buf.Bytes())
+
+	golden := filepath.Join("testdata", "simple.lsif")
+	if *update {
+		if err := ioutil.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("got dump\n%s\nwant (from %s)\n%s", buf.Bytes(), golden, want)
+	}
+}
+
+func TestWrite_offsetOutOfRange(t *testing.T) {
+	d, _ := testData()
+	_, err := Write(ioutil.Discard, d, Options{
+		ReadFile: func(file string) ([]byte, error) { return []byte("x"), nil },
+	})
+	if err == nil {
+		t.Error("got nil error, want an error for byte offsets past the end of the file")
+	}
+}
+
+// checkValid checks that each line of dump is a vertex or edge with a
+// unique ID, and that each edge refers to vertices that precede it.
+func checkValid(t *testing.T, dump []byte) {
+	vertices := map[int]bool{}
+	s := bufio.NewScanner(bytes.NewReader(dump))
+	for s.Scan() {
+		var el struct {
+			ID    int
+			Type  string
+			Label string
+			OutV  int
+			InV   int
+			InVs  []int
+		}
+		if err := json.Unmarshal(s.Bytes(), &el); err != nil {
+			t.Fatalf("%s: %s", s.Bytes(), err)
+		}
+		if el.Label == "" {
+			t.Errorf("%s: no label", s.Bytes())
+		}
+		switch el.Type {
+		case "vertex":
+			if vertices[el.ID] {
+				t.Errorf("%s: duplicate ID", s.Bytes())
+			}
+			vertices[el.ID] = true
+		case "edge":
+			for _, v := range append([]int{el.OutV, el.InV}, el.InVs...) {
+				if v != 0 && !vertices[v] {
+					t.Errorf("%s: refers to vertex %d, which is not (yet) defined", s.Bytes(), v)
+				}
+			}
+		default:
+			t.Errorf("%s: invalid type", s.Bytes())
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package lsif
+
+// The types in this file are the JSON representations of the LSIF
+// vertices and edges that are emitted. Only the properties that srclib
+// data can provide are included.
+
+type element struct {
+	ID    int    `json:"id"`
+	Type  string `json:"type"`
+	Label string `json:"label"`
+}
+
+func (e *element) setElement(el element) { *e = el }
+
+type plainVertex struct{ element }
+
+type metaData struct {
+	element
+	Version          string   `json:"version"`
+	ProjectRoot      string   `json:"projectRoot"`
+	PositionEncoding string   `json:"positionEncoding"`
+	ToolInfo         toolInfo `json:"toolInfo"`
+}
+
+type toolInfo struct {
+	Name string `json:"name"`
+}
+
+type project struct {
+	element
+	Kind string `json:"kind"`
+	Name string `json:"name,omitempty"`
+}
+
+type document struct {
+	element
+	URI        string `json:"uri"`
+	LanguageID string `json:"languageId"`
+}
+
+type rangeV struct {
+	element
+	Start position `json:"start"`
+	End   position `json:"end"`
+}
+
+// A position is a zero-based line and a character offset (in UTF-16
+// code units) in the line.
+type position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type hoverResult struct {
+	element
+	Result hover `json:"result"`
+}
+
+type hover struct {
+	Contents markupContent `json:"contents"`
+}
+
+type markupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+type moniker struct {
+	element
+	Scheme     string `json:"scheme"`
+	Identifier string `json:"identifier"`
+	Kind       string `json:"kind"`
+}
+
+type packageInformation struct {
+	element
+	Name    string `json:"name"`
+	Manager string `json:"manager"`
+	Version string `json:"version,omitempty"`
+}
+
+type edge struct {
+	element
+	OutV     int    `json:"outV"`
+	InV      int    `json:"inV,omitempty"`
+	InVs     []int  `json:"inVs,omitempty"`
+	Document int    `json:"document,omitempty"`
+	Property string `json:"property,omitempty"`
+}
@@ -0,0 +1,3 @@
+package scip
+
+//go:generate gopathexec protoc -I$GOPATH/src -I$GOPATH/src/github.com/gogo/protobuf/protobuf -I. --gogo_out=. scip.proto
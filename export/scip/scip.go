@@ -0,0 +1,240 @@
+// Package scip writes srclib graph data as a SCIP (SCIP Code
+// Intelligence Protocol) index, which code hosts use to provide
+// precise code navigation.
+//
+// An index is a protobuf-encoded Index message (see scip.proto). Each
+// file is a document, whose occurrences are the defs and refs in the
+// file. Each def's occurrence has the range of the ref at its name (the
+// ref whose Def field is true), if any, and its whole definition as its
+// enclosing range. Defs and refs are identified by symbols derived from
+// their def keys (see Symbol).
+package scip
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/gogo/protobuf/proto"
+
+	"sourcegraph.com/sourcegraph/srclib/export"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/srcpos"
+)
+
+// Options configures how an index is created.
+type Options struct {
+	// ProjectRoot is the URI of the repo's root dir (e.g.,
+	// "file:///home/alice/myrepo"). Document paths are relative to it.
+	ProjectRoot string
+
+	// ReadFile reads the files in the version (which are needed to
+	// convert byte offsets to lines and characters).
+	ReadFile export.ReadFileFunc
+
+	// ToolVersion is the version of srclib, which is recorded in the
+	// index's metadata.
+	ToolVersion string
+}
+
+// Write writes d as a SCIP index to w. Files that don't exist are
+// omitted from the index and returned in skipped (as in Convert).
+func Write(w io.Writer, d *export.Data, opt Options) (skipped []string, err error) {
+	index, skipped, err := Convert(d, opt)
+	if err != nil {
+		return nil, err
+	}
+	data, err := proto.Marshal(index)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	return skipped, nil
+}
+
+// Convert converts d to a SCIP index. Files that don't exist (i.e., for
+// which ReadFile returns an error satisfying os.IsNotExist) are omitted
+// from the index, and returned in skipped.
+func Convert(d *export.Data, opt Options) (index *Index, skipped []string, err error) {
+	index = &Index{
+		Metadata: &Metadata{
+			ToolInfo:             &ToolInfo{Name: "srclib", Version: opt.ToolVersion},
+			ProjectRoot:          opt.ProjectRoot,
+			TextDocumentEncoding: TextEncoding_UTF8,
+		},
+	}
+	for _, f := range d.Files() {
+		src, err := opt.ReadFile(f.Name)
+		if os.IsNotExist(err) {
+			skipped = append(skipped, f.Name)
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+		doc, err := convertFile(d, f, srcpos.NewTable(src))
+		if err != nil {
+			return nil, nil, err
+		}
+		index.Documents = append(index.Documents, doc)
+	}
+	return index, skipped, nil
+}
+
+func convertFile(d *export.Data, f *export.File, table *srcpos.Table) (*Document, error) {
+	doc := &Document{
+		RelativePath:     f.Name,
+		PositionEncoding: PositionEncoding_UTF16CodeUnitOffsetFromLineStart,
+	}
+	if lang, ok := export.FileLanguage(f.Name); ok {
+		doc.Language = lang.SCIP
+	}
+
+	// Defs that are local to the file get local symbols.
+	locals := map[graph.DefKey]string{}
+	for _, def := range f.Defs {
+		if k := d.DefKey(def); def.Local {
+			if _, present := locals[k]; !present {
+				locals[k] = LocalSymbol(len(locals))
+			}
+		}
+	}
+	symbol := func(k graph.DefKey) string {
+		if s, present := locals[k]; present {
+			return s
+		}
+		return Symbol(k)
+	}
+
+	var occs []occurrence
+	nameRefs, refs := d.NameRefs(f)
+	for _, def := range f.Defs {
+		k := d.DefKey(def)
+		roles := SymbolRole_Definition
+		if def.Test {
+			roles |= SymbolRole_Test
+		}
+		occ := &Occurrence{Symbol: symbol(k), SymbolRoles: int32(roles)}
+		start, end := def.DefStart, def.DefEnd
+		if ref := nameRefs[k]; ref != nil {
+			enclosing, err := scipRange(table, f.Name, def.DefStart, def.DefEnd)
+			if err != nil {
+				return nil, err
+			}
+			occ.EnclosingRange = enclosing
+			start, end = ref.Start, ref.End
+		}
+		rng, err := scipRange(table, f.Name, start, end)
+		if err != nil {
+			return nil, err
+		}
+		occ.Range = rng
+		occs = append(occs, occurrence{start, occ})
+
+		info := &SymbolInformation{
+			Symbol:        occ.Symbol,
+			Documentation: documentation(def.Docs),
+			DisplayName:   def.Name,
+		}
+		if parent := path.Dir(k.Path); parent != "." && parent != "/" {
+			pk := k
+			pk.Path = parent
+			if d.Def(pk) != nil {
+				info.EnclosingSymbol = symbol(pk)
+			}
+		}
+		doc.Symbols = append(doc.Symbols, info)
+	}
+	for _, ref := range refs {
+		rng, err := scipRange(table, f.Name, ref.Start, ref.End)
+		if err != nil {
+			return nil, err
+		}
+		occ := &Occurrence{
+			Range:       rng,
+			Symbol:      symbol(d.RefDefKey(ref)),
+			SymbolRoles: int32(refRoles(ref)),
+		}
+		occs = append(occs, occurrence{ref.Start, occ})
+	}
+
+	sort.Stable(occurrencesByStart(occs))
+	for _, o := range occs {
+		doc.Occurrences = append(doc.Occurrences, o.occ)
+	}
+	return doc, nil
+}
+
+// refRoles returns the symbol roles of a ref's occurrence, which are
+// determined by the ref's kind.
+func refRoles(ref *graph.Ref) SymbolRole {
+	switch ref.Kind {
+	case graph.RefKindWrite:
+		return SymbolRole_WriteAccess
+	case graph.RefKindRead:
+		return SymbolRole_ReadAccess
+	case graph.RefKindImport:
+		return SymbolRole_Import
+	}
+	return SymbolRole_UnspecifiedSymbolRole
+}
+
+// documentation returns the documentation of a def's symbol, which
+// SCIP consumers render as Markdown. Markdown docs are preferred to
+// plain text docs, and plain text docs to HTML docs.
+func documentation(docs []*graph.DefDoc) []string {
+	var best *graph.DefDoc
+	rank := func(doc *graph.DefDoc) int {
+		switch doc.Format {
+		case "text/markdown":
+			return 3
+		case "text/plain":
+			return 2
+		case "text/html":
+			return 1
+		}
+		return 0
+	}
+	for _, doc := range docs {
+		if doc.Data != "" && rank(doc) > 0 && (best == nil || rank(doc) > rank(best)) {
+			best = doc
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	return []string{best.Data}
+}
+
+// scipRange returns the SCIP range of the byte offsets [start, end) in
+// a file.
+func scipRange(table *srcpos.Table, file string, start, end uint32) ([]int32, error) {
+	startPos, err := table.Position(start, srcpos.UTF16)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s (is the file from the exported commit?)", file, err)
+	}
+	endPos, err := table.Position(end, srcpos.UTF16)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s (is the file from the exported commit?)", file, err)
+	}
+	if startPos.Line == endPos.Line {
+		return []int32{int32(startPos.Line), int32(startPos.Column), int32(endPos.Column)}, nil
+	}
+	return []int32{int32(startPos.Line), int32(startPos.Column), int32(endPos.Line), int32(endPos.Column)}, nil
+}
+
+// An occurrence is an Occurrence and the byte offset it starts at,
+// which is used to sort a document's occurrences.
+type occurrence struct {
+	start uint32
+	occ   *Occurrence
+}
+
+type occurrencesByStart []occurrence
+
+func (v occurrencesByStart) Len() int           { return len(v) }
+func (v occurrencesByStart) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v occurrencesByStart) Less(i, j int) bool { return v[i].start < v[j].start }
@@ -0,0 +1,215 @@
+// Code generated by protoc-gen-gogo.
+// source: scip.proto
+// DO NOT EDIT!
+
+/*
+	Package scip is a generated protocol buffer package.
+
+	It is generated from these files:
+		scip.proto
+
+	It has these top-level messages:
+		Index
+		Metadata
+		ToolInfo
+		Document
+		SymbolInformation
+		Occurrence
+*/
+package scip
+
+import proto "github.com/gogo/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+// discarding unused import gogoproto "github.com/gogo/protobuf/gogoproto"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+type ProtocolVersion int32
+
+const (
+	ProtocolVersion_UnspecifiedProtocolVersion ProtocolVersion = 0
+)
+
+var ProtocolVersion_name = map[int32]string{
+	0: "UnspecifiedProtocolVersion",
+}
+var ProtocolVersion_value = map[string]int32{
+	"UnspecifiedProtocolVersion": 0,
+}
+
+func (x ProtocolVersion) String() string {
+	return proto.EnumName(ProtocolVersion_name, int32(x))
+}
+
+type TextEncoding int32
+
+const (
+	TextEncoding_UnspecifiedTextEncoding TextEncoding = 0
+	TextEncoding_UTF8                    TextEncoding = 1
+	TextEncoding_UTF16                   TextEncoding = 2
+)
+
+var TextEncoding_name = map[int32]string{
+	0: "UnspecifiedTextEncoding",
+	1: "UTF8",
+	2: "UTF16",
+}
+var TextEncoding_value = map[string]int32{
+	"UnspecifiedTextEncoding": 0,
+	"UTF8":                    1,
+	"UTF16":                   2,
+}
+
+func (x TextEncoding) String() string {
+	return proto.EnumName(TextEncoding_name, int32(x))
+}
+
+type PositionEncoding int32
+
+const (
+	PositionEncoding_UnspecifiedPositionEncoding      PositionEncoding = 0
+	PositionEncoding_UTF8CodeUnitOffsetFromLineStart  PositionEncoding = 1
+	PositionEncoding_UTF16CodeUnitOffsetFromLineStart PositionEncoding = 2
+	PositionEncoding_UTF32CodeUnitOffsetFromLineStart PositionEncoding = 3
+)
+
+var PositionEncoding_name = map[int32]string{
+	0: "UnspecifiedPositionEncoding",
+	1: "UTF8CodeUnitOffsetFromLineStart",
+	2: "UTF16CodeUnitOffsetFromLineStart",
+	3: "UTF32CodeUnitOffsetFromLineStart",
+}
+var PositionEncoding_value = map[string]int32{
+	"UnspecifiedPositionEncoding":      0,
+	"UTF8CodeUnitOffsetFromLineStart":  1,
+	"UTF16CodeUnitOffsetFromLineStart": 2,
+	"UTF32CodeUnitOffsetFromLineStart": 3,
+}
+
+func (x PositionEncoding) String() string {
+	return proto.EnumName(PositionEncoding_name, int32(x))
+}
+
+// SymbolRole is a bitset of the roles of an occurrence.
+type SymbolRole int32
+
+const (
+	SymbolRole_UnspecifiedSymbolRole SymbolRole = 0
+	SymbolRole_Definition            SymbolRole = 1
+	SymbolRole_Import                SymbolRole = 2
+	SymbolRole_WriteAccess           SymbolRole = 4
+	SymbolRole_ReadAccess            SymbolRole = 8
+	SymbolRole_Generated             SymbolRole = 16
+	SymbolRole_Test                  SymbolRole = 32
+	SymbolRole_ForwardDefinition     SymbolRole = 64
+)
+
+var SymbolRole_name = map[int32]string{
+	0:  "UnspecifiedSymbolRole",
+	1:  "Definition",
+	2:  "Import",
+	4:  "WriteAccess",
+	8:  "ReadAccess",
+	16: "Generated",
+	32: "Test",
+	64: "ForwardDefinition",
+}
+var SymbolRole_value = map[string]int32{
+	"UnspecifiedSymbolRole": 0,
+	"Definition":            1,
+	"Import":                2,
+	"WriteAccess":           4,
+	"ReadAccess":            8,
+	"Generated":             16,
+	"Test":                  32,
+	"ForwardDefinition":     64,
+}
+
+func (x SymbolRole) String() string {
+	return proto.EnumName(SymbolRole_name, int32(x))
+}
+
+// An Index is a complete SCIP index of a version of a repo.
+type Index struct {
+	Metadata        *Metadata            `protobuf:"bytes,1,opt,name=metadata" json:"metadata,omitempty"`
+	Documents       []*Document          `protobuf:"bytes,2,rep,name=documents" json:"documents,omitempty"`
+	ExternalSymbols []*SymbolInformation `protobuf:"bytes,3,rep,name=external_symbols" json:"external_symbols,omitempty"`
+}
+
+func (m *Index) Reset()         { *m = Index{} }
+func (m *Index) String() string { return proto.CompactTextString(m) }
+func (*Index) ProtoMessage()    {}
+
+type Metadata struct {
+	Version  ProtocolVersion `protobuf:"varint,1,opt,name=version,proto3,enum=scip.ProtocolVersion" json:"version,omitempty"`
+	ToolInfo *ToolInfo       `protobuf:"bytes,2,opt,name=tool_info" json:"tool_info,omitempty"`
+	// ProjectRoot is the URI of the repo's root dir.
+	ProjectRoot          string       `protobuf:"bytes,3,opt,name=project_root,proto3" json:"project_root,omitempty"`
+	TextDocumentEncoding TextEncoding `protobuf:"varint,4,opt,name=text_document_encoding,proto3,enum=scip.TextEncoding" json:"text_document_encoding,omitempty"`
+}
+
+func (m *Metadata) Reset()         { *m = Metadata{} }
+func (m *Metadata) String() string { return proto.CompactTextString(m) }
+func (*Metadata) ProtoMessage()    {}
+
+type ToolInfo struct {
+	Name      string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Version   string   `protobuf:"bytes,2,opt,name=version,proto3" json:"version,omitempty"`
+	Arguments []string `protobuf:"bytes,3,rep,name=arguments" json:"arguments,omitempty"`
+}
+
+func (m *ToolInfo) Reset()         { *m = ToolInfo{} }
+func (m *ToolInfo) String() string { return proto.CompactTextString(m) }
+func (*ToolInfo) ProtoMessage()    {}
+
+// A Document holds the occurrences of symbols in a file, and the
+// symbols defined in it.
+type Document struct {
+	RelativePath     string               `protobuf:"bytes,1,opt,name=relative_path,proto3" json:"relative_path,omitempty"`
+	Occurrences      []*Occurrence        `protobuf:"bytes,2,rep,name=occurrences" json:"occurrences,omitempty"`
+	Symbols          []*SymbolInformation `protobuf:"bytes,3,rep,name=symbols" json:"symbols,omitempty"`
+	Language         string               `protobuf:"bytes,4,opt,name=language,proto3" json:"language,omitempty"`
+	PositionEncoding PositionEncoding     `protobuf:"varint,6,opt,name=position_encoding,proto3,enum=scip.PositionEncoding" json:"position_encoding,omitempty"`
+}
+
+func (m *Document) Reset()         { *m = Document{} }
+func (m *Document) String() string { return proto.CompactTextString(m) }
+func (*Document) ProtoMessage()    {}
+
+type SymbolInformation struct {
+	Symbol          string   `protobuf:"bytes,1,opt,name=symbol,proto3" json:"symbol,omitempty"`
+	Documentation   []string `protobuf:"bytes,3,rep,name=documentation" json:"documentation,omitempty"`
+	DisplayName     string   `protobuf:"bytes,6,opt,name=display_name,proto3" json:"display_name,omitempty"`
+	EnclosingSymbol string   `protobuf:"bytes,8,opt,name=enclosing_symbol,proto3" json:"enclosing_symbol,omitempty"`
+}
+
+func (m *SymbolInformation) Reset()         { *m = SymbolInformation{} }
+func (m *SymbolInformation) String() string { return proto.CompactTextString(m) }
+func (*SymbolInformation) ProtoMessage()    {}
+
+// An Occurrence is a def or ref in a file. Its range is [startLine,
+// startCharacter, endLine, endCharacter], or [startLine,
+// startCharacter, endCharacter] if it starts and ends on the same
+// line.
+type Occurrence struct {
+	Range          []int32 `protobuf:"varint,1,rep,packed,name=range" json:"range,omitempty"`
+	Symbol         string  `protobuf:"bytes,2,opt,name=symbol,proto3" json:"symbol,omitempty"`
+	SymbolRoles    int32   `protobuf:"varint,3,opt,name=symbol_roles,proto3" json:"symbol_roles,omitempty"`
+	EnclosingRange []int32 `protobuf:"varint,7,rep,packed,name=enclosing_range" json:"enclosing_range,omitempty"`
+}
+
+func (m *Occurrence) Reset()         { *m = Occurrence{} }
+func (m *Occurrence) String() string { return proto.CompactTextString(m) }
+func (*Occurrence) ProtoMessage()    {}
+
+func init() {
+	proto.RegisterEnum("scip.ProtocolVersion", ProtocolVersion_name, ProtocolVersion_value)
+	proto.RegisterEnum("scip.TextEncoding", TextEncoding_name, TextEncoding_value)
+	proto.RegisterEnum("scip.PositionEncoding", PositionEncoding_name, PositionEncoding_value)
+	proto.RegisterEnum("scip.SymbolRole", SymbolRole_name, SymbolRole_value)
+}
@@ -0,0 +1,149 @@
+package scip
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+
+	"sourcegraph.com/sourcegraph/srclib/export"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// testData returns graph data for a version with 2 source units, a
+// cross-unit ref, a ref to a def in another repo, a local def, ref
+// kinds, docs, and a file with multi-byte characters (to test the
+// conversion of byte offsets to UTF-16 characters).
+func testData() (*export.Data, export.ReadFileFunc) {
+	files := map[string]string{
+		"a/a.go": "package a\n\n// Héllo 👋\nfunc Hello() { x := \"é👋\"; fmt.Println(x) }\n",
+		"b/b.go": "package b\n\nfunc B() { a.Hello() }\n",
+	}
+	d := &export.Data{
+		Repo:     "example.com/r",
+		CommitID: "c",
+		Units: []*unit.SourceUnit{
+			{Key: unit.Key{Type: "GoPackage", Name: "a"}, Info: unit.Info{Files: []string{"a/a.go"}}},
+			{Key: unit.Key{Type: "GoPackage", Name: "b"}, Info: unit.Info{Files: []string{"b/b.go", "b/missing.go"}}},
+		},
+		Defs: []*graph.Def{
+			{
+				DefKey: graph.DefKey{Repo: "example.com/r", CommitID: "c", UnitType: "GoPackage", Unit: "a", Path: "Hello"},
+				Name:   "Hello", File: "a/a.go", DefStart: 26, DefEnd: 72, Exported: true,
+				Docs: []*graph.DefDoc{{Format: "text/html", Data: "<p>Héllo 👋</p>"}, {Format: "text/plain", Data: "Héllo 👋"}},
+			},
+			{
+				DefKey: graph.DefKey{Repo: "example.com/r", CommitID: "c", UnitType: "GoPackage", Unit: "a", Path: "Hello/x"},
+				Name:   "x", File: "a/a.go", DefStart: 41, DefEnd: 42, Local: true,
+			},
+			{
+				DefKey: graph.DefKey{Repo: "example.com/r", CommitID: "c", UnitType: "GoPackage", Unit: "b", Path: "B"},
+				Name:   "B", File: "b/b.go", DefStart: 11, DefEnd: 33, Test: true,
+			},
+		},
+		Refs: []*graph.Ref{
+			{DefRepo: "example.com/r", DefUnitType: "GoPackage", DefUnit: "a", DefPath: "Hello", Repo: "example.com/r", CommitID: "c", UnitType: "GoPackage", Unit: "a", File: "a/a.go", Start: 31, End: 36, Def: true},
+			{DefRepo: "example.com/r", DefUnitType: "GoPackage", DefUnit: "a", DefPath: "Hello/x", Repo: "example.com/r", CommitID: "c", UnitType: "GoPackage", Unit: "a", File: "a/a.go", Start: 41, End: 42, Def: true, Kind: graph.RefKindWrite},
+			{DefRepo: "fmt", DefUnitType: "GoPackage", DefUnit: "fmt", DefPath: "Println", Repo: "example.com/r", CommitID: "c", UnitType: "GoPackage", Unit: "a", File: "a/a.go", Start: 60, End: 67, Kind: graph.RefKindCall},
+			{DefRepo: "example.com/r", DefUnitType: "GoPackage", DefUnit: "a", DefPath: "Hello/x", Repo: "example.com/r", CommitID: "c", UnitType: "GoPackage", Unit: "a", File: "a/a.go", Start: 68, End: 69, Kind: graph.RefKindRead},
+			{DefRepo: "example.com/r", DefUnitType: "GoPackage", DefUnit: "b", DefPath: "B", Repo: "example.com/r", CommitID: "c", UnitType: "GoPackage", Unit: "b", File: "b/b.go", Start: 16, End: 17, Def: true},
+			{DefRepo: "example.com/r", DefUnitType: "GoPackage", DefUnit: "a", DefPath: "Hello", Repo: "example.com/r", CommitID: "c", UnitType: "GoPackage", Unit: "b", File: "b/b.go", Start: 24, End: 29},
+		},
+	}
+	readFile := func(file string) ([]byte, error) {
+		if data, present := files[file]; present {
+			return []byte(data), nil
+		}
+		return nil, &os.PathError{Op: "open", Path: file, Err: os.ErrNotExist}
+	}
+	return d, readFile
+}
+
+func TestConvert(t *testing.T) {
+	d, readFile := testData()
+	index, skipped, err := Convert(d, Options{ProjectRoot: "file:///src/r", ReadFile: readFile, ToolVersion: "v"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"b/missing.go"}; !reflect.DeepEqual(skipped, want) {
+		t.Errorf("got skipped files %v, want %v", skipped, want)
+	}
+
+	const (
+		hello   = "srclib GoPackage example.com/r . a/Hello."
+		printLn = "srclib GoPackage fmt . fmt/Println."
+		b       = "srclib GoPackage example.com/r . b/B."
+	)
+	want := &Index{
+		Metadata: &Metadata{
+			ToolInfo:             &ToolInfo{Name: "srclib", Version: "v"},
+			ProjectRoot:          "file:///src/r",
+			TextDocumentEncoding: TextEncoding_UTF8,
+		},
+		Documents: []*Document{
+			{
+				RelativePath:     "a/a.go",
+				Language:         "Go",
+				PositionEncoding: PositionEncoding_UTF16CodeUnitOffsetFromLineStart,
+				Occurrences: []*Occurrence{
+					{Range: []int32{3, 5, 10}, Symbol: hello, SymbolRoles: int32(SymbolRole_Definition), EnclosingRange: []int32{3, 0, 43}},
+					{Range: []int32{3, 15, 16}, Symbol: "local 0", SymbolRoles: int32(SymbolRole_Definition), EnclosingRange: []int32{3, 15, 16}},
+					{Range: []int32{3, 31, 38}, Symbol: printLn},
+					{Range: []int32{3, 39, 40}, Symbol: "local 0", SymbolRoles: int32(SymbolRole_ReadAccess)},
+				},
+				Symbols: []*SymbolInformation{
+					{Symbol: hello, Documentation: []string{"Héllo 👋"}, DisplayName: "Hello"},
+					{Symbol: "local 0", DisplayName: "x", EnclosingSymbol: hello},
+				},
+			},
+			{
+				RelativePath:     "b/b.go",
+				Language:         "Go",
+				PositionEncoding: PositionEncoding_UTF16CodeUnitOffsetFromLineStart,
+				Occurrences: []*Occurrence{
+					{Range: []int32{2, 5, 6}, Symbol: b, SymbolRoles: int32(SymbolRole_Definition | SymbolRole_Test), EnclosingRange: []int32{2, 0, 22}},
+					{Range: []int32{2, 13, 18}, Symbol: hello},
+				},
+				Symbols: []*SymbolInformation{
+					{Symbol: b, DisplayName: "B"},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(index, want) {
+		t.Errorf("got index\n%s\nwant\n%s", proto.MarshalTextString(index), proto.MarshalTextString(want))
+	}
+}
+
+func TestWrite(t *testing.T) {
+	d, readFile := testData()
+	var buf bytes.Buffer
+	if _, err := Write(&buf, d, Options{ReadFile: readFile}); err != nil {
+		t.Fatal(err)
+	}
+
+	var index Index
+	if err := proto.Unmarshal(buf.Bytes(), &index); err != nil {
+		t.Fatal(err)
+	}
+	want, _, err := Convert(d, Options{ReadFile: readFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&index, want) {
+		t.Errorf("got decoded index\n%s\nwant\n%s", proto.MarshalTextString(&index), proto.MarshalTextString(want))
+	}
+}
+
+func TestConvert_offsetOutOfRange(t *testing.T) {
+	d, _ := testData()
+	_, _, err := Convert(d, Options{
+		ReadFile: func(file string) ([]byte, error) { return []byte("x"), nil },
+	})
+	if err == nil {
+		t.Error("got nil error, want an error for byte offsets past the end of the file")
+	}
+}
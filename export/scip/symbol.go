@@ -0,0 +1,88 @@
+package scip
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// Scheme is the scheme of the symbols of defs.
+const Scheme = "srclib"
+
+// Symbol returns the SCIP symbol of the def with the given key, which
+// is globally unique (unless the def is local to a file; see
+// LocalSymbol). Its package manager is the def's unit type, its
+// package name is the def's repo, and its version is empty (so that
+// refs from other repos resolve to the def in any version). Its
+// descriptors are the def's unit (as a namespace) and the components
+// of its path (as namespaces, except for the last one, which is a
+// term). For example, the def with key
+//
+//	{Repo: "github.com/a/b", UnitType: "GoPackage", Unit: "github.com/a/b/c", Path: "T/M"}
+//
+// has the symbol
+//
+//	srclib GoPackage github.com/a/b . `github.com/a/b/c`/T/M.
+func Symbol(k graph.DefKey) string {
+	var b bytes.Buffer
+	b.WriteString(Scheme)
+	for _, f := range []string{k.UnitType, k.Repo, ""} {
+		b.WriteByte(' ')
+		b.WriteString(packageField(f))
+	}
+	b.WriteByte(' ')
+	b.WriteString(descriptor(k.Unit))
+	b.WriteByte('/')
+
+	var parts []string
+	for _, p := range strings.Split(k.Path, "/") {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	if len(parts) == 0 {
+		parts = []string{k.Path}
+	}
+	for i, p := range parts {
+		b.WriteString(descriptor(p))
+		if i == len(parts)-1 {
+			b.WriteByte('.')
+		} else {
+			b.WriteByte('/')
+		}
+	}
+	return b.String()
+}
+
+// LocalSymbol returns the SCIP symbol of the nth def in a document
+// that is local to it (i.e., whose Local field is true). Local symbols
+// are only unique within their document.
+func LocalSymbol(n int) string {
+	return "local " + strconv.Itoa(n)
+}
+
+// packageField escapes a field of a symbol's package. Spaces are
+// escaped by doubling them, and empty fields are written as ".".
+func packageField(s string) string {
+	if s == "" {
+		return "."
+	}
+	return strings.Replace(s, " ", "  ", -1)
+}
+
+// descriptor escapes the name of a descriptor. Names that contain
+// characters other than letters, digits, and "_+-$" are enclosed in
+// backticks (and backticks in them are doubled).
+func descriptor(s string) string {
+	simple := s != ""
+	for i := 0; i < len(s) && simple; i++ {
+		c := s[i]
+		simple = c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '+' || c == '-' || c == '$'
+	}
+	if simple {
+		return s
+	}
+	return "`" + strings.Replace(s, "`", "``", -1) + "`"
+}
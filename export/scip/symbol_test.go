@@ -0,0 +1,49 @@
+package scip
+
+import (
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+func TestSymbol(t *testing.T) {
+	tests := []struct {
+		key  graph.DefKey
+		want string
+	}{
+		{
+			key:  graph.DefKey{Repo: "example.com/r", UnitType: "GoPackage", Unit: "a", Path: "Hello"},
+			want: "srclib GoPackage example.com/r . a/Hello.",
+		},
+		{
+			key:  graph.DefKey{Repo: "github.com/a/b", UnitType: "GoPackage", Unit: "github.com/a/b/c", Path: "T/M"},
+			want: "srclib GoPackage github.com/a/b . `github.com/a/b/c`/T/M.",
+		},
+		{
+			// The commit ID is not part of the symbol.
+			key:  graph.DefKey{Repo: "r", CommitID: "c", UnitType: "t", Unit: "u", Path: "p"},
+			want: "srclib t r . u/p.",
+		},
+		{
+			key:  graph.DefKey{UnitType: "Some Type", Unit: "u", Path: "p"},
+			want: "srclib Some  Type . . u/p.",
+		},
+		{
+			key:  graph.DefKey{Repo: "r", UnitType: "t", Unit: "u", Path: "/x//y/"},
+			want: "srclib t r . u/x/y.",
+		},
+		{
+			key:  graph.DefKey{Repo: "r", UnitType: "t", Unit: "u", Path: "a`b/$c.d"},
+			want: "srclib t r . u/`a``b`/`$c.d`.",
+		},
+		{
+			key:  graph.DefKey{Repo: "r", UnitType: "t", Unit: "", Path: ""},
+			want: "srclib t r . ``/``.",
+		},
+	}
+	for _, test := range tests {
+		if got := Symbol(test.key); got != test.want {
+			t.Errorf("%+v: got symbol %q, want %q", test.key, got, test.want)
+		}
+	}
+}
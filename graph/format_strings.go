@@ -0,0 +1,110 @@
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// FormatStringsDataKey is the key in a def's Data (a JSON object) whose
+// value is the def's DefFormatStrings. Toolchains that are not written
+// in Go (and so can't register a MakeDefFormatter) emit the formatted
+// strings there so that defs from them can still be formatted.
+const FormatStringsDataKey = "FormatStrings"
+
+// FormatStrings returns the DefFormatStrings in the def's Data, or nil
+// if there are none (or if Data is not a JSON object).
+func (s *Def) FormatStrings() *DefFormatStrings {
+	if len(s.Data) == 0 {
+		return nil
+	}
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal(s.Data, &data); err != nil || len(data[FormatStringsDataKey]) == 0 {
+		return nil
+	}
+	var fs *DefFormatStrings
+	if err := json.Unmarshal(data[FormatStringsDataKey], &fs); err != nil {
+		return nil
+	}
+	return fs
+}
+
+// SetFormatStrings sets the DefFormatStrings in the def's Data, keeping
+// the other fields of Data (which must be empty or a JSON object).
+func (s *Def) SetFormatStrings(fs *DefFormatStrings) error {
+	data := map[string]json.RawMessage{}
+	if len(s.Data) > 0 {
+		if err := json.Unmarshal(s.Data, &data); err != nil {
+			return fmt.Errorf("def %s: can't set format strings in Data that is not a JSON object: %s", s.Path, err)
+		}
+		if data == nil {
+			data = map[string]json.RawMessage{} // Data was null
+		}
+	}
+	b, err := json.Marshal(fs)
+	if err != nil {
+		return err
+	}
+	data[FormatStringsDataKey] = b
+	s.Data, err = json.Marshal(data)
+	return err
+}
+
+// Get returns the string with the given level of qualification. If
+// that string is empty, it returns the string with the highest lower
+// level of qualification that is nonempty (so that toolchains need
+// only emit the levels that differ).
+func (q *QualFormatStrings) Get(qual Qualification) string {
+	switch qual {
+	case LanguageWideQualified:
+		if q.LanguageWideQualified != "" {
+			return q.LanguageWideQualified
+		}
+		fallthrough
+	case RepositoryWideQualified:
+		if q.RepositoryWideQualified != "" {
+			return q.RepositoryWideQualified
+		}
+		fallthrough
+	case DepQualified:
+		if q.DepQualified != "" {
+			return q.DepQualified
+		}
+		fallthrough
+	case ScopeQualified:
+		if q.ScopeQualified != "" {
+			return q.ScopeQualified
+		}
+	}
+	return q.Unqualified
+}
+
+// NewFormatStringsFormatter returns a DefFormatter that formats a def
+// using its DefFormatStrings.
+func NewFormatStringsFormatter(fs *DefFormatStrings) DefFormatter {
+	return formatStringsFormatter{fs}
+}
+
+type formatStringsFormatter struct{ fs *DefFormatStrings }
+
+func (f formatStringsFormatter) Name(qual Qualification) string { return f.fs.Name.Get(qual) }
+func (f formatStringsFormatter) Type(qual Qualification) string { return f.fs.Type.Get(qual) }
+func (f formatStringsFormatter) NameAndTypeSeparator() string   { return f.fs.NameAndTypeSeparator }
+func (f formatStringsFormatter) Language() string               { return f.fs.Language }
+func (f formatStringsFormatter) DefKeyword() string             { return f.fs.DefKeyword }
+func (f formatStringsFormatter) Kind() string                   { return f.fs.Kind }
+
+// LookupDefFormatter returns a DefFormatter for the def. It uses the
+// MakeDefFormatter registered for the def's unit type, if any, and
+// otherwise the DefFormatStrings in the def's Data. If there are
+// neither, it returns nil.
+func LookupDefFormatter(s *Def) DefFormatter {
+	if mk, present := MakeDefFormatters[s.UnitType]; present {
+		if f := mk(s); f != nil {
+			return f
+		}
+	}
+	if fs := s.FormatStrings(); fs != nil {
+		return NewFormatStringsFormatter(fs)
+	}
+	return nil
+}
@@ -152,15 +152,13 @@ type DefFormatter interface {
 // The flags:
 //   ' '    (in `% t`) prepend the language-specific delimiter between a def's name and type
 //
-// See DefFormatter for more information.
+// See DefFormatter for more information. The def is formatted by the
+// DefFormatter that LookupDefFormatter returns; if there is none,
+// PrintFormatter panics.
 func PrintFormatter(s *Def) DefPrintFormatter {
-	mk, ok := MakeDefFormatters[s.UnitType]
-	if !ok {
-		panic("PrintFormatter: no formatter for unit type " + s.UnitType)
-	}
-	sf := mk(s)
+	sf := LookupDefFormatter(s)
 	if sf == nil {
-		panic("PrintFormatter: nil DefFormatter")
+		panic("PrintFormatter: no formatter for unit type " + s.UnitType + " and no format strings in def Data")
 	}
 	return &printFormatter{sf}
 }
@@ -2,6 +2,7 @@ package graph
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 )
 
@@ -75,3 +76,61 @@ func TestPrintFormatter(t *testing.T) {
 		}
 	}
 }
+
+func TestPrintFormatter_formatStrings(t *testing.T) {
+	def := &Def{
+		DefKey: DefKey{UnitType: "NoRegisteredFormatter"},
+		Data: []byte(`{"X":1,"FormatStrings":{
+			"Name":{"Unqualified":"name","ScopeQualified":"scope.name","LanguageWideQualified":"lib.scope.name"},
+			"Type":{"Unqualified":"typeName"},
+			"NameAndTypeSeparator":"_","Language":"lang","DefKeyword":"defkw","Kind":"kind"
+		}}`),
+	}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"%n", "name"},
+		{"%.1n", "scope.name"},
+		{"%.2n", "scope.name"},
+		{"%.3n", "scope.name"},
+		{"%.4n", "lib.scope.name"},
+		{"%.4t", "typeName"},
+		{"% t", "_typeName"},
+		{"%k", "kind"},
+	}
+	for _, test := range tests {
+		str := fmt.Sprintf(test.format, PrintFormatter(def))
+		if str != test.want {
+			t.Errorf("Sprintf(%q, def): got %q, want %q", test.format, str, test.want)
+		}
+	}
+}
+
+func TestLookupDefFormatter_none(t *testing.T) {
+	for _, data := range []string{"", `{"X":1}`, `[1]`, `"s"`} {
+		def := &Def{DefKey: DefKey{UnitType: "NoRegisteredFormatter"}, Data: []byte(data)}
+		if f := LookupDefFormatter(def); f != nil {
+			t.Errorf("Data %q: got formatter %v, want nil", data, f)
+		}
+	}
+}
+
+func TestDef_SetFormatStrings(t *testing.T) {
+	def := &Def{Data: []byte(`{"X":1}`)}
+	fs := &DefFormatStrings{Name: QualFormatStrings{Unqualified: "name"}, Kind: "kind"}
+	if err := def.SetFormatStrings(fs); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"FormatStrings":{"Name":{"Unqualified":"name"},"Type":{},"Kind":"kind"},"X":1}`; string(def.Data) != want {
+		t.Errorf("got Data %s, want %s", def.Data, want)
+	}
+	if got := def.FormatStrings(); !reflect.DeepEqual(got, fs) {
+		t.Errorf("got format strings %+v, want %+v", got, fs)
+	}
+
+	def = &Def{Data: []byte(`[1]`)}
+	if err := def.SetFormatStrings(fs); err == nil {
+		t.Error("got no error setting format strings in Data that is not a JSON object")
+	}
+}
@@ -19,16 +19,39 @@ var _ = fmt.Errorf
 var _ = math.Inf
 
 type Output struct {
-	Defs []*Def     `protobuf:"bytes,1,rep,name=Defs" json:"Defs,omitempty"`
-	Refs []*Ref     `protobuf:"bytes,2,rep,name=Refs" json:"Refs,omitempty"`
-	Docs []*Doc     `protobuf:"bytes,3,rep,name=Docs" json:"Docs,omitempty"`
-	Anns []*ann.Ann `protobuf:"bytes,4,rep,name=Anns" json:"Anns,omitempty"`
+	Defs      []*Def      `protobuf:"bytes,1,rep,name=Defs" json:"Defs,omitempty"`
+	Refs      []*Ref      `protobuf:"bytes,2,rep,name=Refs" json:"Refs,omitempty"`
+	Docs      []*Doc      `protobuf:"bytes,3,rep,name=Docs" json:"Docs,omitempty"`
+	Anns      []*ann.Ann  `protobuf:"bytes,4,rep,name=Anns" json:"Anns,omitempty"`
+	Relations []*Relation `protobuf:"bytes,5,rep,name=Relations" json:"Relations,omitempty"`
 }
 
 func (m *Output) Reset()         { *m = Output{} }
 func (m *Output) String() string { return proto.CompactTextString(m) }
 func (*Output) ProtoMessage()    {}
 
+// Relation represents a relationship between two defs, such as a type
+// that implements an interface or a method that overrides another
+// method.
+type Relation struct {
+	// From is the def that the relation originates from (e.g., the
+	// implementing type). Its Repo, CommitID, UnitType, and Unit
+	// fields are implied by the source unit whose output contains the
+	// relation, like a Def's.
+	From DefKey `protobuf:"bytes,1,opt,name=From" json:"From"`
+	// To is the def that the relation points to (e.g., the
+	// implemented interface). Like a Ref's def, empty fields refer to
+	// the same repository or source unit as From.
+	To RefDefKey `protobuf:"bytes,2,opt,name=To" json:"To"`
+	// Kind is the kind of relation (one of the Relation* constants,
+	// such as "implements").
+	Kind string `protobuf:"bytes,3,opt,name=Kind,proto3" json:"Kind"`
+}
+
+func (m *Relation) Reset()         { *m = Relation{} }
+func (m *Relation) String() string { return proto.CompactTextString(m) }
+func (*Relation) ProtoMessage()    {}
+
 func (m *Output) Marshal() (data []byte, err error) {
 	size := m.Size()
 	data = make([]byte, size)
@@ -92,6 +115,58 @@ func (m *Output) MarshalTo(data []byte) (int, error) {
 			i += n
 		}
 	}
+	if len(m.Relations) > 0 {
+		for _, msg := range m.Relations {
+			data[i] = 0x2a
+			i++
+			i = encodeVarintOutput(data, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(data[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	return i, nil
+}
+
+func (m *Relation) Marshal() (data []byte, err error) {
+	size := m.Size()
+	data = make([]byte, size)
+	n, err := m.MarshalTo(data)
+	if err != nil {
+		return nil, err
+	}
+	return data[:n], nil
+}
+
+func (m *Relation) MarshalTo(data []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	data[i] = 0xa
+	i++
+	i = encodeVarintOutput(data, i, uint64(m.From.Size()))
+	n1, err := m.From.MarshalTo(data[i:])
+	if err != nil {
+		return 0, err
+	}
+	i += n1
+	data[i] = 0x12
+	i++
+	i = encodeVarintOutput(data, i, uint64(m.To.Size()))
+	n2, err := m.To.MarshalTo(data[i:])
+	if err != nil {
+		return 0, err
+	}
+	i += n2
+	if len(m.Kind) > 0 {
+		data[i] = 0x1a
+		i++
+		i = encodeVarintOutput(data, i, uint64(len(m.Kind)))
+		i += copy(data[i:], m.Kind)
+	}
 	return i, nil
 }
 
@@ -149,6 +224,26 @@ func (m *Output) Size() (n int) {
 			n += 1 + l + sovOutput(uint64(l))
 		}
 	}
+	if len(m.Relations) > 0 {
+		for _, e := range m.Relations {
+			l = e.Size()
+			n += 1 + l + sovOutput(uint64(l))
+		}
+	}
+	return n
+}
+
+func (m *Relation) Size() (n int) {
+	var l int
+	_ = l
+	l = m.From.Size()
+	n += 1 + l + sovOutput(uint64(l))
+	l = m.To.Size()
+	n += 1 + l + sovOutput(uint64(l))
+	l = len(m.Kind)
+	if l > 0 {
+		n += 1 + l + sovOutput(uint64(l))
+	}
 	return n
 }
 
@@ -318,6 +413,176 @@ func (m *Output) Unmarshal(data []byte) error {
 				return err
 			}
 			iNdEx = postIndex
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Relations", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOutput
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthOutput
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Relations = append(m.Relations, &Relation{})
+			if err := m.Relations[len(m.Relations)-1].Unmarshal(data[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipOutput(data[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthOutput
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *Relation) Unmarshal(data []byte) error {
+	l := len(data)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowOutput
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := data[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: Relation: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: Relation: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field From", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOutput
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthOutput
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if err := m.From.Unmarshal(data[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field To", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOutput
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthOutput
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if err := m.To.Unmarshal(data[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Kind", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowOutput
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthOutput
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Kind = string(data[iNdEx:postIndex])
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipOutput(data[iNdEx:])
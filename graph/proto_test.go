@@ -13,9 +13,12 @@ import (
 func TestProtobufMarshal(t *testing.T) {
 	o := Output{
 		Defs: []*Def{{File: "f1"}},
-		Refs: []*Ref{{File: "f2"}},
+		Refs: []*Ref{{File: "f2", Kind: RefKindWrite}},
 		Docs: []*Doc{{File: "f3"}},
 		Anns: []*ann.Ann{{Unit: "foo"}},
+		Relations: []*Relation{
+			{From: DefKey{Path: "T"}, To: RefDefKey{DefRepo: "r", DefPath: "I"}, Kind: RelationImplements},
+		},
 	}
 
 	b, err := proto.Marshal(&o)
@@ -0,0 +1,132 @@
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"sourcegraph.com/sourcegraph/srclib/ann"
+)
+
+// A Record is a single item of graph data. Exactly one of its fields
+// is set.
+//
+// Records are used by the streaming graph output protocol, in which a
+// grapher writes its output as newline-delimited JSON records (such
+// as `{"Def":{...}}` and `{"Ref":{...}}`, one per line) instead of
+// as a single Output JSON object. This lets graphers and the
+// normalizer process the output of large source units without
+// holding all of it in memory.
+type Record struct {
+	Def      *Def      `json:",omitempty"`
+	Ref      *Ref      `json:",omitempty"`
+	Doc      *Doc      `json:",omitempty"`
+	Ann      *ann.Ann  `json:",omitempty"`
+	Relation *Relation `json:",omitempty"`
+}
+
+// DecodeRecords reads graph data from r and calls f with each def,
+// ref, doc, ann, and relation, in the order that they are read. The
+// graph data may be a sequence of Records (as written by graphers
+// that use the streaming output protocol), an Output JSON object, or
+// a sequence of both. An Output's items are decoded one at a time, so
+// the whole Output is never held in memory.
+//
+// If f returns an error, DecodeRecords stops and returns that error.
+func DecodeRecords(r io.Reader, f func(*Record) error) error {
+	dec := json.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if tok == nil {
+			continue // null
+		}
+		if tok != json.Delim('{') {
+			return fmt.Errorf("invalid graph data: got %v, want a JSON object", tok)
+		}
+
+		var rec Record
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key, _ := tok.(string)
+			switch key {
+			case "Def":
+				err = dec.Decode(&rec.Def)
+			case "Ref":
+				err = dec.Decode(&rec.Ref)
+			case "Doc":
+				err = dec.Decode(&rec.Doc)
+			case "Ann":
+				err = dec.Decode(&rec.Ann)
+			case "Relation":
+				err = dec.Decode(&rec.Relation)
+			case "Defs", "Refs", "Docs", "Anns", "Relations":
+				err = decodeOutputItems(dec, key, f)
+			default:
+				// Ignore unknown fields, as json.Unmarshal does.
+				var v json.RawMessage
+				err = dec.Decode(&v)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil { // '}'
+			return err
+		}
+
+		if rec != (Record{}) {
+			if err := f(&rec); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// decodeOutputItems decodes the items of the array field (such as
+// "Defs") of an Output and calls f with each item.
+func decodeOutputItems(dec *json.Decoder, field string, f func(*Record) error) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return nil // null
+	}
+	if tok != json.Delim('[') {
+		return fmt.Errorf("invalid graph data: got %v for Output.%s, want a JSON array", tok, field)
+	}
+	for dec.More() {
+		var rec Record
+		switch field {
+		case "Defs":
+			err = dec.Decode(&rec.Def)
+		case "Refs":
+			err = dec.Decode(&rec.Ref)
+		case "Docs":
+			err = dec.Decode(&rec.Doc)
+		case "Anns":
+			err = dec.Decode(&rec.Ann)
+		case "Relations":
+			err = dec.Decode(&rec.Relation)
+		}
+		if err != nil {
+			return err
+		}
+		if rec == (Record{}) {
+			continue // null item
+		}
+		if err := f(&rec); err != nil {
+			return err
+		}
+	}
+	_, err = dec.Token() // ']'
+	return err
+}
@@ -2,12 +2,58 @@ package graph
 
 import "strconv"
 
+// Ref kinds are the valid values of Ref.Kind. A ref's kind is
+// optional: toolchains that don't classify refs leave Kind empty.
+const (
+	// RefKindCall is a call of a function or method.
+	RefKindCall = "call"
+
+	// RefKindRead is a read of a variable or field.
+	RefKindRead = "read"
+
+	// RefKindWrite is a write (assignment) to a variable or field.
+	RefKindWrite = "write"
+
+	// RefKindTypeReference is a use of a type (e.g., in a declaration
+	// or conversion).
+	RefKindTypeReference = "type-reference"
+
+	// RefKindImport is an import of a package or module.
+	RefKindImport = "import"
+
+	// RefKindInherit is a reference from a type to a type that it
+	// inherits from (extends or implements).
+	RefKindInherit = "inherit"
+
+	// RefKindOverride is a reference from a method to the method that
+	// it overrides.
+	RefKindOverride = "override"
+)
+
+// RefKinds lists all valid (non-empty) ref kinds.
+var RefKinds = []string{RefKindCall, RefKindRead, RefKindWrite, RefKindTypeReference, RefKindImport, RefKindInherit, RefKindOverride}
+
+// IsValidRefKind returns whether kind is a valid value for
+// Ref.Kind. The empty kind (unknown) is valid.
+func IsValidRefKind(kind string) bool {
+	if kind == "" {
+		return true
+	}
+	for _, k := range RefKinds {
+		if kind == k {
+			return true
+		}
+	}
+	return false
+}
+
 type RefKey struct {
 	DefRepo     string `json:",omitempty"`
 	DefUnitType string `json:",omitempty"`
 	DefUnit     string `json:",omitempty"`
 	DefPath     string `json:",omitempty"`
 	Def         bool   `json:",omitempty"`
+	Kind        string `json:",omitempty"`
 	Repo        string `json:",omitempty"`
 	UnitType    string `json:",omitempty"`
 	Unit        string `json:",omitempty"`
@@ -33,6 +79,7 @@ func (r *Ref) RefKey() RefKey {
 		DefUnit:     r.DefUnit,
 		DefPath:     r.DefPath,
 		Def:         r.Def,
+		Kind:        r.Kind,
 		Repo:        r.Repo,
 		UnitType:    r.UnitType,
 		Unit:        r.Unit,
@@ -72,7 +119,7 @@ func (r *Ref) SetFromDefKey(k DefKey) {
 type Refs []*Ref
 
 func (r *Ref) sortKey() string {
-	return r.DefPath + r.DefRepo + r.DefUnitType + r.DefUnit + r.Repo + r.UnitType + r.Unit + r.File + strconv.Itoa(int(r.Start)) + strconv.Itoa(int(r.End))
+	return r.DefPath + r.DefRepo + r.DefUnitType + r.DefUnit + r.Repo + r.UnitType + r.Unit + r.File + strconv.Itoa(int(r.Start)) + strconv.Itoa(int(r.End)) + r.Kind
 }
 func (vs Refs) Len() int           { return len(vs) }
 func (vs Refs) Swap(i, j int)      { vs[i], vs[j] = vs[j], vs[i] }
@@ -41,6 +41,10 @@ type Ref struct {
 	Unit string `protobuf:"bytes,9,opt,name=Unit,proto3" json:"Unit,omitempty"`
 	// Def is true if this Ref spans the name of the Def it points to.
 	Def bool `protobuf:"varint,17,opt,name=Def,proto3" json:"Def,omitempty"`
+	// Kind is the kind of reference (e.g., "call" or "write"), if the
+	// toolchain classifies refs. See the RefKind* constants for the
+	// valid values. It is empty if the kind is unknown.
+	Kind string `protobuf:"bytes,18,opt,name=Kind,proto3" json:"Kind,omitempty"`
 	// File is the filename in which this Ref exists.
 	File string `protobuf:"bytes,10,opt,name=File,proto3" json:"File,omitempty"`
 	// Start is the byte offset of this ref's first byte in File.
@@ -155,6 +159,14 @@ func (m *Ref) MarshalTo(data []byte) (int, error) {
 		}
 		i++
 	}
+	if len(m.Kind) > 0 {
+		data[i] = 0x92
+		i++
+		data[i] = 0x1
+		i++
+		i = encodeVarintRef(data, i, uint64(len(m.Kind)))
+		i += copy(data[i:], m.Kind)
+	}
 	return i, nil
 }
 
@@ -275,6 +287,10 @@ func (m *Ref) Size() (n int) {
 	if m.Def {
 		n += 3
 	}
+	l = len(m.Kind)
+	if l > 0 {
+		n += 2 + l + sovRef(uint64(l))
+	}
 	return n
 }
 
@@ -661,6 +677,35 @@ func (m *Ref) Unmarshal(data []byte) error {
 				}
 			}
 			m.Def = bool(v != 0)
+		case 18:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Kind", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRef
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRef
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Kind = string(data[iNdEx:postIndex])
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipRef(data[iNdEx:])
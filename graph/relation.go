@@ -0,0 +1,68 @@
+package graph
+
+// Relation kinds are the valid values of Relation.Kind.
+const (
+	// RelationImplements is a relation from a type to an interface
+	// that it implements.
+	RelationImplements = "implements"
+
+	// RelationExtends is a relation from a type to a type that it
+	// extends (inherits from), or from an interface to an interface
+	// that it embeds.
+	RelationExtends = "extends"
+
+	// RelationOverrides is a relation from a method to the method that
+	// it overrides.
+	RelationOverrides = "overrides"
+)
+
+// RelationKinds lists all valid relation kinds.
+var RelationKinds = []string{RelationImplements, RelationExtends, RelationOverrides}
+
+// IsValidRelationKind returns whether kind is a valid value for
+// Relation.Kind. Unlike a ref's kind, a relation's kind is required.
+func IsValidRelationKind(kind string) bool {
+	for _, k := range RelationKinds {
+		if kind == k {
+			return true
+		}
+	}
+	return false
+}
+
+// ToDefKey returns the DefKey of the def that the relation points to
+// (with the repo and source unit implied by From filled in).
+func (r *Relation) ToDefKey() DefKey {
+	k := DefKey{
+		Repo:     r.To.DefRepo,
+		CommitID: r.From.CommitID,
+		UnitType: r.To.DefUnitType,
+		Unit:     r.To.DefUnit,
+		Path:     r.To.DefPath,
+	}
+	if k.Repo == "" {
+		k.Repo = r.From.Repo
+	}
+	if k.Repo != r.From.Repo {
+		// A def in another repo may be at any commit.
+		k.CommitID = ""
+	}
+	if k.UnitType == "" {
+		k.UnitType = r.From.UnitType
+	}
+	if k.Unit == "" {
+		k.Unit = r.From.Unit
+	}
+	return k
+}
+
+// Sorting
+
+type Relations []*Relation
+
+func (r *Relation) sortKey() string {
+	return r.From.String() + r.To.DefPath + r.To.DefRepo + r.To.DefUnitType + r.To.DefUnit + r.Kind
+}
+func (vs Relations) Len() int           { return len(vs) }
+func (vs Relations) Swap(i, j int)      { vs[i], vs[j] = vs[j], vs[i] }
+func (vs Relations) Less(i, j int) bool { return vs[i].sortKey() < vs[j].sortKey() }
@@ -7,11 +7,10 @@ import (
 	"path/filepath"
 	"sort"
 
-	"github.com/sqs/fileset"
-
 	"sourcegraph.com/sourcegraph/srclib/ann"
 	"sourcegraph.com/sourcegraph/srclib/config"
 	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/srcpos"
 	"sourcegraph.com/sourcegraph/srclib/unit"
 )
 
@@ -21,56 +20,87 @@ type Grapher interface {
 
 // TODO(sqs): add grapher validation of output
 
-func ensureOffsetsAreByteOffsets(dir string, output *graph.Output) {
-	fset := fileset.NewFileSet()
-	files := make(map[string]*fileset.File)
+// An offsetFixer converts the offsets that a grapher emits, measured
+// in an encoding other than bytes (such as runes), to byte offsets.
+type offsetFixer struct {
+	dir    string
+	enc    srcpos.Encoding
+	tables *srcpos.Cache
+}
 
-	addOrGetFile := func(filename string) *fileset.File {
-		if f, ok := files[filename]; ok {
-			return f
-		}
-		data, err := ioutil.ReadFile(filename)
-		if err != nil {
-			panic("ReadFile " + filename + ": " + err.Error())
-		}
+func newOffsetFixer(dir string, enc srcpos.Encoding, tables *srcpos.Cache) *offsetFixer {
+	return &offsetFixer{dir: dir, enc: enc, tables: tables}
+}
+
+// DefaultLineTablesBytes is the default maximum size of the line
+// tables (and file contents) that are cached to convert offsets to
+// byte offsets.
+const DefaultLineTablesBytes = 64 << 20
 
-		f := fset.AddFile(filename, fset.Base(), len(data))
-		f.SetByteOffsetsForContent(data)
-		files[filename] = f
-		return f
+// NewLineTables returns a cache of the line tables of source files,
+// for use as the NormalizeOptions.LineTables of Normalizers. It holds
+// at most about maxBytes bytes (or DefaultLineTablesBytes if maxBytes
+// is not positive).
+func NewLineTables(maxBytes int64) *srcpos.Cache {
+	if maxBytes <= 0 {
+		maxBytes = DefaultLineTablesBytes
 	}
+	return srcpos.NewCache(ioutil.ReadFile, maxBytes)
+}
 
-	fix := func(filename string, offsets ...*uint32) {
-		defer func() {
-			if e := recover(); e != nil {
-				log.Printf("failed to convert unicode offset to byte offset in file %s (did grapher output a nonexistent byte offset?) continuing anyway...", filename)
-			}
-		}()
-		if filename == "" {
-			return
+// fix converts the offsets in filename (relative to the source unit
+// dir) to byte offsets.
+func (x *offsetFixer) fix(filename string, offsets ...*uint32) {
+	if filename == "" {
+		return
+	}
+	filename = filepath.Join(x.dir, filename)
+	if fi, err := os.Stat(filename); err != nil || !fi.Mode().IsRegular() {
+		return
+	}
+	t, err := x.tables.Table(filename)
+	if err != nil {
+		log.Printf("failed to convert %s offsets to byte offsets in file %s (%s) continuing anyway...", x.enc, filename, err)
+		return
+	}
+	for _, offset := range offsets {
+		if *offset == 0 {
+			continue
 		}
-		filename = filepath.Join(dir, filename)
-		if fi, err := os.Stat(filename); err != nil || !fi.Mode().IsRegular() {
+		byteOffset, err := t.ByteOffset(int(*offset), x.enc)
+		if err != nil {
+			log.Printf("failed to convert %s offset to byte offset in file %s (did grapher output a nonexistent offset?) continuing anyway...", x.enc, filename)
 			return
 		}
-		f := addOrGetFile(filename)
-		for _, offset := range offsets {
-			if *offset == 0 {
-				continue
-			}
-			*offset = uint32(f.ByteOffsetOfRune(int(*offset)))
-		}
+		*offset = byteOffset
 	}
+}
 
+func ensureOffsetsAreByteOffsets(dir string, enc srcpos.Encoding, output *graph.Output) {
+	x := newOffsetFixer(dir, enc, NewLineTables(0))
 	for _, s := range output.Defs {
-		fix(s.File, &s.DefStart, &s.DefEnd)
+		x.fix(s.File, &s.DefStart, &s.DefEnd)
 	}
 	for _, r := range output.Refs {
-		fix(r.File, &r.Start, &r.End)
+		x.fix(r.File, &r.Start, &r.End)
 	}
 	for _, d := range output.Docs {
-		fix(d.File, &d.Start, &d.End)
+		x.fix(d.File, &d.Start, &d.End)
+	}
+}
+
+// defaultOffsetEncoding returns the encoding of the offsets emitted
+// by graphers for unitType that don't declare their offset encoding
+// (see toolchain.ToolInfo's OffsetEncoding field). These graphers are
+// assumed to emit rune offsets, except for the graphers of a few unit
+// types that emitted byte offsets before tools could declare their
+// offset encoding.
+func defaultOffsetEncoding(unitType string) srcpos.Encoding {
+	switch unitType {
+	case "GoPackage", "Dockerfile", "BashDirectory", "ManPages":
+		return srcpos.Bytes
 	}
+	return srcpos.Runes
 }
 
 func sortedOutput(o *graph.Output) *graph.Output {
@@ -78,30 +108,62 @@ func sortedOutput(o *graph.Output) *graph.Output {
 	sort.Sort(graph.Refs(o.Refs))
 	sort.Sort(graph.Docs(o.Docs))
 	sort.Sort(ann.Anns(o.Anns))
+	sort.Sort(graph.Relations(o.Relations))
 	return o
 }
 
-// NormalizeData sorts data and performs other postprocessing.
+// normalizeRef canonicalizes the repo URIs of ref.
+func normalizeRef(ref *graph.Ref) error {
+	if ref.DefRepo != "" && ref.DefRepo != unit.UnitRepoUnresolved {
+		uri, err := graph.TryMakeURI(string(ref.DefRepo))
+		if err != nil {
+			return err
+		}
+		ref.DefRepo = uri
+	}
+	if ref.Repo != "" && ref.DefRepo != unit.UnitRepoUnresolved {
+		uri, err := graph.TryMakeURI(string(ref.Repo))
+		if err != nil {
+			return err
+		}
+		ref.Repo = uri
+	}
+	return nil
+}
+
+// normalizeRelation canonicalizes the repo URI of the def that rel
+// points to.
+func normalizeRelation(rel *graph.Relation) error {
+	if rel.To.DefRepo != "" && rel.To.DefRepo != unit.UnitRepoUnresolved {
+		uri, err := graph.TryMakeURI(string(rel.To.DefRepo))
+		if err != nil {
+			return err
+		}
+		rel.To.DefRepo = uri
+	}
+	return nil
+}
+
+// NormalizeData sorts data and performs other postprocessing. The
+// data's offsets are assumed to be in the default encoding for
+// unitType (as when NormalizeOptions.OffsetEncoding is empty).
+//
+// NormalizeData holds all of the data in memory. To normalize the
+// output of large source units, use a Normalizer.
 func NormalizeData(unitType, dir string, o *graph.Output) error {
 	for _, ref := range o.Refs {
-		if ref.DefRepo != "" && ref.DefRepo != unit.UnitRepoUnresolved {
-			uri, err := graph.TryMakeURI(string(ref.DefRepo))
-			if err != nil {
-				return err
-			}
-			ref.DefRepo = uri
+		if err := normalizeRef(ref); err != nil {
+			return err
 		}
-		if ref.Repo != "" && ref.DefRepo != unit.UnitRepoUnresolved {
-			uri, err := graph.TryMakeURI(string(ref.Repo))
-			if err != nil {
-				return err
-			}
-			ref.Repo = uri
+	}
+	for _, rel := range o.Relations {
+		if err := normalizeRelation(rel); err != nil {
+			return err
 		}
 	}
 
-	if unitType != "GoPackage" && unitType != "Dockerfile" && unitType != "BashDirectory" && unitType != "ManPages" {
-		ensureOffsetsAreByteOffsets(dir, o)
+	if enc := defaultOffsetEncoding(unitType); enc != srcpos.Bytes {
+		ensureOffsetsAreByteOffsets(dir, enc, o)
 	}
 
 	if err := ValidateRefs(o.Refs); err != nil {
@@ -113,6 +175,9 @@ func NormalizeData(unitType, dir string, o *graph.Output) error {
 	if err := ValidateDocs(o.Docs); err != nil {
 		return err
 	}
+	if err := ValidateRelations(o.Relations); err != nil {
+		return err
+	}
 
 	sortedOutput(o)
 	return nil
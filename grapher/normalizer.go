@@ -0,0 +1,703 @@
+package grapher
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"sourcegraph.com/sourcegraph/srclib/ann"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/srcpos"
+)
+
+// DefaultMaxBuffered is the default maximum number of records that a
+// Normalizer holds in memory.
+const DefaultMaxBuffered = 100000
+
+// maxMergeRuns is the maximum number of sorted runs (temporary files)
+// that are merged at once.
+const maxMergeRuns = 64
+
+// NormalizeOptions configures a Normalizer.
+type NormalizeOptions struct {
+	// MaxBuffered is the maximum number of records that are held in
+	// memory. When more records are added, the buffered records are
+	// sorted and written to a temporary file. If zero,
+	// DefaultMaxBuffered is used. It is ignored if Budget is set.
+	MaxBuffered int
+
+	// Budget, if set, limits the total number of records that are
+	// held in memory by all of the Normalizers that share it (such as
+	// the Normalizers of the source units in a multi-unit grapher's
+	// output). When the budget is exceeded, all of them write their
+	// buffered records to temporary files.
+	Budget *Budget
+
+	// TempDir is the directory in which temporary files are created.
+	// If empty, the default directory for temporary files (see
+	// os.TempDir) is used.
+	TempDir string
+
+	// OffsetEncoding is the encoding of the offsets in the graph
+	// data (as declared by the grapher's tool in its toolchain's
+	// Srclibtoolchain file). Offsets are converted to byte offsets.
+	// If empty, the graph data is assumed to have rune offsets,
+	// except for a few unit types whose graphers emitted byte offsets
+	// before tools could declare their offset encoding.
+	OffsetEncoding srcpos.Encoding
+
+	// LineTables caches the line tables of the source files whose
+	// offsets are converted to byte offsets (see NewLineTables).
+	// Normalizers of source units that share source files should
+	// share it. If nil, each Normalizer has its own cache.
+	LineTables *srcpos.Cache
+
+	// Repair enables repair mode, in which invalid records are
+	// dropped or fixed instead of making WriteOutput fail: duplicate
+	// defs, refs, docs, and relations are dropped (keeping the first
+	// of the records with each key); refs with out-of-range offsets
+	// and invalid relations are dropped; invalid ref kinds are
+	// cleared; invalid def TreePaths are cleaned; and the out-of-range
+	// offsets of defs and docs are cleared. Refs and relations whose
+	// repo URIs are invalid are also dropped (instead of making Add
+	// fail).
+	Repair bool
+
+	// OnRepair, if set, is called with each repair made in repair
+	// mode.
+	OnRepair func(*Repair)
+}
+
+// A Normalizer normalizes graph data in the same way as NormalizeData,
+// but with bounded memory use. Records are added one at a time, and
+// they are sorted using an external merge sort: when too many records
+// are buffered, they are sorted and written to a temporary file, and
+// the temporary files are merged when the output is written.
+//
+// Unlike NormalizeData, a Normalizer removes records that are exact
+// duplicates of other records. Different records with the same key
+// (such as two defs with the same DefKey) are still invalid.
+type Normalizer struct {
+	dir     string
+	opt     NormalizeOptions
+	offsets *offsetFixer // nil if the offsets are byte offsets
+	budget  *Budget
+
+	buf  [numRecordKinds][]*item  // buffered records of each kind
+	nbuf int                      // total number of buffered records
+	runs [numRecordKinds][]string // sorted runs (temporary files) of each kind
+
+	tmpDir string // created when needed
+	errs   MultiError
+
+	fileSizes map[string]int64 // sizes of files (-1 if not a regular file), for repair mode
+}
+
+// NewNormalizer creates a Normalizer for the graph data of a source
+// unit of type unitType in dir. Callers must call Close when they are
+// done with it to remove its temporary files.
+func NewNormalizer(unitType, dir string, opt NormalizeOptions) *Normalizer {
+	if opt.OffsetEncoding == "" {
+		opt.OffsetEncoding = defaultOffsetEncoding(unitType)
+	}
+	n := &Normalizer{dir: dir, opt: opt, budget: opt.Budget}
+	if n.budget == nil {
+		n.budget = NewBudget(opt.MaxBuffered)
+	}
+	n.budget.normalizers = append(n.budget.normalizers, n)
+	if opt.OffsetEncoding != srcpos.Bytes {
+		tables := opt.LineTables
+		if tables == nil {
+			tables = NewLineTables(0)
+		}
+		n.offsets = newOffsetFixer(dir, opt.OffsetEncoding, tables)
+	}
+	return n
+}
+
+// Add normalizes the def, ref, doc, ann, or relation in rec and adds
+// it to the output. Records are not validated until the output is
+// written (except in repair mode, in which invalid records are
+// repaired as they are added).
+func (n *Normalizer) Add(rec *graph.Record) error {
+	if def := rec.Def; def != nil {
+		if n.offsets != nil {
+			n.offsets.fix(def.File, &def.DefStart, &def.DefEnd)
+		}
+		if n.opt.Repair {
+			n.repairDef(def)
+		}
+		if err := n.add(defRecords, def); err != nil {
+			return err
+		}
+	}
+	if ref := rec.Ref; ref != nil {
+		orig := *ref
+		if err := normalizeRef(ref); err != nil {
+			if !n.opt.Repair {
+				return err
+			}
+			n.repaired(&graph.Record{Ref: &orig}, err.Error(), RepairDropped)
+		} else {
+			if n.offsets != nil {
+				n.offsets.fix(ref.File, &ref.Start, &ref.End)
+			}
+			if !n.opt.Repair || n.repairRef(ref) {
+				if err := n.add(refRecords, ref); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if doc := rec.Doc; doc != nil {
+		if n.offsets != nil {
+			n.offsets.fix(doc.File, &doc.Start, &doc.End)
+		}
+		if n.opt.Repair {
+			n.repairDoc(doc)
+		}
+		if err := n.add(docRecords, doc); err != nil {
+			return err
+		}
+	}
+	if rec.Ann != nil {
+		if err := n.add(annRecords, rec.Ann); err != nil {
+			return err
+		}
+	}
+	if rel := rec.Relation; rel != nil {
+		orig := *rel
+		if err := normalizeRelation(rel); err != nil {
+			if !n.opt.Repair {
+				return err
+			}
+			n.repaired(&graph.Record{Relation: &orig}, err.Error(), RepairDropped)
+		} else if !n.opt.Repair || n.repairRelation(rel) {
+			if err := n.add(relationRecords, rel); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (n *Normalizer) add(k int, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	n.buf[k] = append(n.buf[k], &item{v: v, data: data})
+	n.nbuf++
+	return n.budget.add(1)
+}
+
+// spill sorts the buffered records and writes them to temporary
+// files (one per kind).
+func (n *Normalizer) spill() error {
+	for k, items := range n.buf {
+		if len(items) == 0 {
+			continue
+		}
+		recordKinds[k].sort(items)
+		f, err := n.tempFile(recordKinds[k].field)
+		if err != nil {
+			return err
+		}
+		w := bufio.NewWriter(f)
+		for _, it := range items {
+			w.Write(it.data)
+			w.WriteByte('\n')
+		}
+		if err := w.Flush(); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		n.runs[k] = append(n.runs[k], f.Name())
+		n.buf[k] = nil
+	}
+	n.budget.buffered -= n.nbuf
+	n.nbuf = 0
+	return nil
+}
+
+func (n *Normalizer) tempFile(prefix string) (*os.File, error) {
+	if n.tmpDir == "" {
+		dir, err := ioutil.TempDir(n.opt.TempDir, "srclib-normalize")
+		if err != nil {
+			return nil, err
+		}
+		n.tmpDir = dir
+	}
+	return ioutil.TempFile(n.tmpDir, prefix)
+}
+
+// WriteOutput validates the records and writes them to w, sorted, as
+// a JSON graph.Output (in the same format as json.MarshalIndent(o, "",
+// "  ")). If the records are invalid, it returns a MultiError and
+// writes nothing.
+//
+// WriteOutput may only be called once, after all records are added.
+func (n *Normalizer) WriteOutput(w io.Writer) error {
+	// Write the output to a temporary file (or, if all of the records
+	// fit in memory, to memory) first, so that nothing is written if
+	// the records are invalid.
+	var out io.ReadWriter
+	var buf bytes.Buffer
+	if n.tmpDir == "" {
+		out = &buf
+	} else {
+		f, err := n.tempFile("output")
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	bw := bufio.NewWriter(out)
+	err := n.writeOutput(bw)
+	n.budget.remove(n) // the buffered records were released by merge
+	if err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if len(n.errs) > 0 {
+		return n.errs
+	}
+
+	if f, ok := out.(*os.File); ok {
+		if _, err := f.Seek(0, 0); err != nil {
+			return err
+		}
+	}
+	_, err = io.Copy(w, out)
+	return err
+}
+
+func (n *Normalizer) writeOutput(w *bufio.Writer) error {
+	var indented bytes.Buffer
+	empty := true
+	for k := range recordKinds {
+		kind := &recordKinds[k]
+		first := true
+		err := n.merge(k, func(it *item) error {
+			if first {
+				if empty {
+					w.WriteString("{\n")
+				} else {
+					w.WriteString(",\n")
+				}
+				w.WriteString(`  "` + kind.field + `": [` + "\n    ")
+				first, empty = false, false
+			} else {
+				w.WriteString(",\n    ")
+			}
+			indented.Reset()
+			if err := json.Indent(&indented, it.data, "    ", "  "); err != nil {
+				return err
+			}
+			_, err := w.Write(indented.Bytes())
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if !first {
+			w.WriteString("\n  ]")
+		}
+	}
+	if empty {
+		w.WriteString("{}")
+	} else {
+		w.WriteString("\n}")
+	}
+	return nil
+}
+
+// merge calls emit with each of the records of kind k, in order,
+// skipping exact duplicates. It validates the records and adds the
+// validation errors to n.errs (or, in repair mode, drops the records
+// whose keys are duplicates).
+func (n *Normalizer) merge(k int, emit func(*item) error) error {
+	kind := &recordKinds[k]
+
+	// Merge the runs until few enough are left to merge at once.
+	for len(n.runs[k]) > maxMergeRuns {
+		f, err := n.tempFile(kind.field)
+		if err != nil {
+			return err
+		}
+		w := bufio.NewWriter(f)
+		err = mergeRuns(kind, n.runs[k][:maxMergeRuns], nil, func(it *item) error {
+			w.Write(it.data)
+			return w.WriteByte('\n')
+		})
+		if err == nil {
+			err = w.Flush()
+		}
+		if err2 := f.Close(); err == nil {
+			err = err2
+		}
+		if err != nil {
+			return err
+		}
+		for _, name := range n.runs[k][:maxMergeRuns] {
+			os.Remove(name)
+		}
+		n.runs[k] = append(n.runs[k][maxMergeRuns:], f.Name())
+	}
+
+	kind.sort(n.buf[k])
+
+	// Records with the same key (and exact duplicates) have the same
+	// sort key, so only the records in each group of records with the
+	// same sort key need to be validated together.
+	var prev *item
+	var group []interface{}
+	keys := make(map[interface{}]struct{}) // keys in the group (in repair mode)
+	validateGroup := func() {
+		if kind.validate != nil && len(group) > 0 && !n.opt.Repair {
+			n.errs = append(n.errs, kind.validate(group)...)
+		}
+		group = group[:0]
+		for key := range keys {
+			delete(keys, key)
+		}
+	}
+	err := mergeRuns(kind, n.runs[k], n.buf[k], func(it *item) error {
+		if prev != nil {
+			if bytes.Equal(prev.data, it.data) {
+				return nil
+			}
+			if kind.less(prev.v, it.v) {
+				validateGroup()
+			}
+		}
+		prev = it
+		if n.opt.Repair && kind.key != nil {
+			key := kind.key(it.v)
+			if _, dup := keys[key]; dup {
+				n.repaired(kind.record(it.v), "duplicate "+kind.name+" key", RepairDropped)
+				return nil
+			}
+			keys[key] = struct{}{}
+		}
+		group = append(group, it.v)
+		return emit(it)
+	})
+	if err != nil {
+		return err
+	}
+	validateGroup()
+	n.buf[k] = nil
+	return nil
+}
+
+// Close removes the Normalizer's temporary files.
+func (n *Normalizer) Close() error {
+	n.budget.remove(n)
+	if n.tmpDir == "" {
+		return nil
+	}
+	return os.RemoveAll(n.tmpDir)
+}
+
+// A Budget limits the total number of records that a group of
+// Normalizers hold in memory (see NormalizeOptions.Budget). It is not
+// safe for concurrent use.
+type Budget struct {
+	max         int
+	buffered    int
+	normalizers []*Normalizer
+}
+
+// NewBudget returns a Budget of max records (or DefaultMaxBuffered if
+// max is not positive).
+func NewBudget(max int) *Budget {
+	if max <= 0 {
+		max = DefaultMaxBuffered
+	}
+	return &Budget{max: max}
+}
+
+// add records that n more records are buffered, and spills the
+// buffered records of all of the Normalizers if the budget is
+// exceeded.
+func (b *Budget) add(n int) error {
+	b.buffered += n
+	if b.buffered < b.max {
+		return nil
+	}
+	for _, x := range b.normalizers {
+		if err := x.spill(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove removes n (whose buffered records have been written or
+// discarded) from the budget.
+func (b *Budget) remove(n *Normalizer) {
+	for i, n2 := range b.normalizers {
+		if n2 == n {
+			b.normalizers = append(b.normalizers[:i], b.normalizers[i+1:]...)
+			b.buffered -= n.nbuf
+			n.nbuf = 0
+			return
+		}
+	}
+}
+
+// An item is a def, ref, doc, ann, or relation and its JSON encoding.
+type item struct {
+	v    interface{}
+	data []byte
+}
+
+// The kinds of records, in the order of the graph.Output fields.
+const (
+	defRecords = iota
+	refRecords
+	docRecords
+	annRecords
+	relationRecords
+	numRecordKinds
+)
+
+type recordKind struct {
+	name     string // e.g., "def"
+	field    string // graph.Output field name
+	new      func() interface{}
+	record   func(interface{}) *graph.Record
+	less     func(a, b interface{}) bool // compares sort keys
+	key      func(interface{}) interface{}
+	validate func([]interface{}) MultiError
+}
+
+var recordKinds = [numRecordKinds]recordKind{
+	defRecords: {
+		name:   "def",
+		field:  "Defs",
+		new:    func() interface{} { return new(graph.Def) },
+		record: func(v interface{}) *graph.Record { return &graph.Record{Def: v.(*graph.Def)} },
+		key:    func(v interface{}) interface{} { return v.(*graph.Def).DefKey },
+		less: func(a, b interface{}) bool {
+			return graph.Defs{a.(*graph.Def), b.(*graph.Def)}.Less(0, 1)
+		},
+		validate: func(vs []interface{}) MultiError {
+			defs := make([]*graph.Def, len(vs))
+			for i, v := range vs {
+				defs[i] = v.(*graph.Def)
+			}
+			return ValidateDefs(defs)
+		},
+	},
+	refRecords: {
+		name:   "ref",
+		field:  "Refs",
+		new:    func() interface{} { return new(graph.Ref) },
+		record: func(v interface{}) *graph.Record { return &graph.Record{Ref: v.(*graph.Ref)} },
+		key:    func(v interface{}) interface{} { return v.(*graph.Ref).RefKey() },
+		less: func(a, b interface{}) bool {
+			return graph.Refs{a.(*graph.Ref), b.(*graph.Ref)}.Less(0, 1)
+		},
+		validate: func(vs []interface{}) MultiError {
+			refs := make([]*graph.Ref, len(vs))
+			for i, v := range vs {
+				refs[i] = v.(*graph.Ref)
+			}
+			return ValidateRefs(refs)
+		},
+	},
+	docRecords: {
+		name:   "doc",
+		field:  "Docs",
+		new:    func() interface{} { return new(graph.Doc) },
+		record: func(v interface{}) *graph.Record { return &graph.Record{Doc: v.(*graph.Doc)} },
+		key:    func(v interface{}) interface{} { return v.(*graph.Doc).Key() },
+		less: func(a, b interface{}) bool {
+			return graph.Docs{a.(*graph.Doc), b.(*graph.Doc)}.Less(0, 1)
+		},
+		validate: func(vs []interface{}) MultiError {
+			docs := make([]*graph.Doc, len(vs))
+			for i, v := range vs {
+				docs[i] = v.(*graph.Doc)
+			}
+			return ValidateDocs(docs)
+		},
+	},
+	annRecords: {
+		name:   "ann",
+		field:  "Anns",
+		new:    func() interface{} { return new(ann.Ann) },
+		record: func(v interface{}) *graph.Record { return &graph.Record{Ann: v.(*ann.Ann)} },
+		less: func(a, b interface{}) bool {
+			return ann.Anns{a.(*ann.Ann), b.(*ann.Ann)}.Less(0, 1)
+		},
+	},
+	relationRecords: {
+		name:   "relation",
+		field:  "Relations",
+		new:    func() interface{} { return new(graph.Relation) },
+		record: func(v interface{}) *graph.Record { return &graph.Record{Relation: v.(*graph.Relation)} },
+		key:    func(v interface{}) interface{} { return *v.(*graph.Relation) },
+		less: func(a, b interface{}) bool {
+			return graph.Relations{a.(*graph.Relation), b.(*graph.Relation)}.Less(0, 1)
+		},
+		validate: func(vs []interface{}) MultiError {
+			rels := make([]*graph.Relation, len(vs))
+			for i, v := range vs {
+				rels[i] = v.(*graph.Relation)
+			}
+			return ValidateRelations(rels)
+		},
+	},
+}
+
+// itemLess orders items by their sort keys, and items with the same
+// sort key by their JSON encodings (so that exact duplicates are
+// adjacent).
+func (k *recordKind) itemLess(a, b *item) bool {
+	if k.less(a.v, b.v) {
+		return true
+	}
+	if k.less(b.v, a.v) {
+		return false
+	}
+	return bytes.Compare(a.data, b.data) < 0
+}
+
+func (k *recordKind) sort(items []*item) {
+	sort.Sort(sortedItems{kind: k, items: items})
+}
+
+type sortedItems struct {
+	kind  *recordKind
+	items []*item
+}
+
+func (s sortedItems) Len() int           { return len(s.items) }
+func (s sortedItems) Swap(i, j int)      { s.items[i], s.items[j] = s.items[j], s.items[i] }
+func (s sortedItems) Less(i, j int) bool { return s.kind.itemLess(s.items[i], s.items[j]) }
+
+// mergeRuns calls emit with the items in the sorted runs (temporary
+// files) and in the sorted items, in order.
+func mergeRuns(kind *recordKind, runs []string, items []*item, emit func(*item) error) error {
+	h := &itemHeap{kind: kind}
+	add := func(src itemSource) error {
+		it, err := src.next()
+		if err != nil {
+			return err
+		}
+		if it != nil {
+			h.heads = append(h.heads, head{item: it, src: src})
+		}
+		return nil
+	}
+
+	if err := add(&sliceSource{items: items}); err != nil {
+		return err
+	}
+	for _, name := range runs {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := add(&runSource{kind: kind, r: bufio.NewReader(f)}); err != nil {
+			return err
+		}
+	}
+
+	heap.Init(h)
+	for h.Len() > 0 {
+		top := &h.heads[0]
+		it := top.item
+		next, err := top.src.next()
+		if err != nil {
+			return err
+		}
+		if next != nil {
+			top.item = next
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+		if err := emit(it); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// An itemSource is a sorted sequence of items. Its next method returns
+// nil after the last item.
+type itemSource interface {
+	next() (*item, error)
+}
+
+type sliceSource struct{ items []*item }
+
+func (s *sliceSource) next() (*item, error) {
+	if len(s.items) == 0 {
+		return nil, nil
+	}
+	it := s.items[0]
+	s.items = s.items[1:]
+	return it, nil
+}
+
+// A runSource reads the items of a sorted run (a temporary file with
+// one JSON record per line).
+type runSource struct {
+	kind *recordKind
+	r    *bufio.Reader
+}
+
+func (s *runSource) next() (*item, error) {
+	line, err := s.r.ReadBytes('\n')
+	if err == io.EOF && len(line) == 0 {
+		return nil, nil
+	} else if err != nil && err != io.EOF {
+		return nil, err
+	}
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	v := s.kind.new()
+	if err := json.Unmarshal(line, v); err != nil {
+		return nil, err
+	}
+	return &item{v: v, data: line}, nil
+}
+
+type head struct {
+	item *item
+	src  itemSource
+}
+
+// An itemHeap is a min-heap of the next items of the sources being
+// merged.
+type itemHeap struct {
+	kind  *recordKind
+	heads []head
+}
+
+func (h *itemHeap) Len() int           { return len(h.heads) }
+func (h *itemHeap) Less(i, j int) bool { return h.kind.itemLess(h.heads[i].item, h.heads[j].item) }
+func (h *itemHeap) Swap(i, j int)      { h.heads[i], h.heads[j] = h.heads[j], h.heads[i] }
+func (h *itemHeap) Push(x interface{}) { h.heads = append(h.heads, x.(head)) }
+func (h *itemHeap) Pop() interface{} {
+	x := h.heads[len(h.heads)-1]
+	h.heads = h.heads[:len(h.heads)-1]
+	return x
+}
@@ -0,0 +1,315 @@
+package grapher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/ann"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/srcpos"
+)
+
+// testOutput returns unsorted graph data with enough defs to require
+// merging the sorted runs more than once when MaxBuffered is small.
+func testOutput() *graph.Output {
+	o := &graph.Output{
+		Refs: []*graph.Ref{
+			{DefPath: "d2", File: "f", Start: 5, End: 6},
+			{DefPath: "d1", File: "f", Start: 3, End: 4, Kind: graph.RefKindWrite},
+			{DefPath: "d1", File: "f", Start: 1, End: 2, Def: true},
+		},
+		Docs: []*graph.Doc{
+			{DefKey: graph.DefKey{Path: "d1"}, Format: "text/plain", Data: "b"},
+			{DefKey: graph.DefKey{Path: "d0"}, Format: "text/plain", Data: "a"},
+		},
+		Anns: []*ann.Ann{
+			{File: "f", StartLine: 2, EndLine: 3, Type: "t"},
+			{File: "f", StartLine: 1, EndLine: 2, Type: "t"},
+		},
+		Relations: []*graph.Relation{
+			{From: graph.DefKey{Path: "d2"}, To: graph.RefDefKey{DefPath: "d1"}, Kind: graph.RelationImplements},
+			{From: graph.DefKey{Path: "d1"}, To: graph.RefDefKey{DefPath: "d0"}, Kind: graph.RelationExtends},
+		},
+	}
+	for i := 0; i < 150; i++ {
+		o.Defs = append(o.Defs, &graph.Def{DefKey: graph.DefKey{Path: fmt.Sprintf("d%d", (i*37)%150)}, Name: "n", File: "f"})
+	}
+	return o
+}
+
+func TestNormalizer(t *testing.T) {
+	o := testOutput()
+	if err := NormalizeData("GoPackage", ".", o); err != nil {
+		t.Fatal(err)
+	}
+	want, err := json.MarshalIndent(o, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The streaming output protocol, with some exact duplicates.
+	var ndjson bytes.Buffer
+	enc := json.NewEncoder(&ndjson)
+	o = testOutput()
+	for _, def := range o.Defs {
+		enc.Encode(graph.Record{Def: def})
+	}
+	for _, ref := range o.Refs {
+		enc.Encode(graph.Record{Ref: ref})
+		enc.Encode(graph.Record{Ref: ref})
+	}
+	for _, doc := range o.Docs {
+		enc.Encode(graph.Record{Doc: doc})
+	}
+	for _, a := range o.Anns {
+		enc.Encode(graph.Record{Ann: a})
+	}
+	for _, rel := range o.Relations {
+		enc.Encode(graph.Record{Relation: rel})
+	}
+
+	// A single graph.Output.
+	output, err := json.Marshal(testOutput())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inputs := map[string][]byte{"records": ndjson.Bytes(), "Output": output}
+	for name, input := range inputs {
+		for _, maxBuffered := range []int{0, 1, 7} {
+			label := fmt.Sprintf("%s (MaxBuffered %d)", name, maxBuffered)
+			n := NewNormalizer("GoPackage", ".", NormalizeOptions{MaxBuffered: maxBuffered})
+			if err := graph.DecodeRecords(bytes.NewReader(input), n.Add); err != nil {
+				t.Fatalf("%s: %s", label, err)
+			}
+			var got bytes.Buffer
+			if err := n.WriteOutput(&got); err != nil {
+				t.Fatalf("%s: %s", label, err)
+			}
+			if err := n.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != string(want) {
+				t.Errorf("%s: got\n%s\nwant\n%s", label, got.String(), want)
+			}
+		}
+	}
+}
+
+func TestNormalizer_empty(t *testing.T) {
+	n := NewNormalizer("GoPackage", ".", NormalizeOptions{})
+	defer n.Close()
+	if err := graph.DecodeRecords(bytes.NewReader(nil), n.Add); err != nil {
+		t.Fatal(err)
+	}
+	var got bytes.Buffer
+	if err := n.WriteOutput(&got); err != nil {
+		t.Fatal(err)
+	}
+	if want := "{}"; got.String() != want {
+		t.Errorf("got %q, want %q", got.String(), want)
+	}
+}
+
+func TestNormalizer_invalid(t *testing.T) {
+	tests := map[string][]*graph.Record{
+		"duplicate def key": {
+			{Def: &graph.Def{DefKey: graph.DefKey{Path: "p"}, Name: "a"}},
+			{Def: &graph.Def{DefKey: graph.DefKey{Path: "q"}}},
+			{Def: &graph.Def{DefKey: graph.DefKey{Path: "p"}, Name: "b"}},
+		},
+		"invalid ref kind": {
+			{Ref: &graph.Ref{DefPath: "p", File: "f", Kind: "x"}},
+		},
+		"relation to itself": {
+			{Relation: &graph.Relation{From: graph.DefKey{Path: "p"}, To: graph.RefDefKey{DefPath: "p"}, Kind: graph.RelationExtends}},
+		},
+	}
+	for label, recs := range tests {
+		n := NewNormalizer("GoPackage", ".", NormalizeOptions{MaxBuffered: 1})
+		for _, rec := range recs {
+			if err := n.Add(rec); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var got bytes.Buffer
+		if err := n.WriteOutput(&got); err == nil {
+			t.Errorf("%s: got nil err, want validation error", label)
+		}
+		if got.Len() != 0 {
+			t.Errorf("%s: got output %q, want no output", label, got.String())
+		}
+		n.Close()
+	}
+}
+
+func TestNormalizer_offsetEncoding(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srclib-normalizer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// "é" is 2 bytes and "👋" is 4 bytes (2 UTF-16 code units), so "x"
+	// is at byte offset 6.
+	if err := ioutil.WriteFile(filepath.Join(dir, "f"), []byte("é👋x\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for enc, start := range map[srcpos.Encoding]uint32{srcpos.Bytes: 6, srcpos.Runes: 2, srcpos.UTF16: 3} {
+		n := NewNormalizer("t", dir, NormalizeOptions{OffsetEncoding: enc})
+		defer n.Close()
+		if err := n.Add(&graph.Record{Def: &graph.Def{DefKey: graph.DefKey{Path: "x"}, File: "f", DefStart: start, DefEnd: start + 1}}); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := n.WriteOutput(&buf); err != nil {
+			t.Fatal(err)
+		}
+		var o graph.Output
+		if err := json.Unmarshal(buf.Bytes(), &o); err != nil {
+			t.Fatal(err)
+		}
+		if def := o.Defs[0]; def.DefStart != 6 || def.DefEnd != 7 {
+			t.Errorf("%s: got byte offsets %d-%d, want 6-7", enc, def.DefStart, def.DefEnd)
+		}
+	}
+}
+
+func TestNormalizer_sharedBudget(t *testing.T) {
+	b := NewBudget(5)
+	ns := []*Normalizer{
+		NewNormalizer("GoPackage", ".", NormalizeOptions{Budget: b}),
+		NewNormalizer("GoPackage", ".", NormalizeOptions{Budget: b}),
+	}
+	defs := testOutput().Defs
+	for i, def := range defs {
+		if err := ns[i%2].Add(&graph.Record{Def: def}); err != nil {
+			t.Fatal(err)
+		}
+		if nbuf := ns[0].nbuf + ns[1].nbuf; nbuf >= 5 || nbuf != b.buffered {
+			t.Fatalf("after adding %d defs: got %d buffered records (budget has %d), want fewer than 5", i+1, nbuf, b.buffered)
+		}
+	}
+
+	for i, n := range ns {
+		var buf bytes.Buffer
+		if err := n.WriteOutput(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if err := n.Close(); err != nil {
+			t.Fatal(err)
+		}
+		var o graph.Output
+		if err := json.Unmarshal(buf.Bytes(), &o); err != nil {
+			t.Fatal(err)
+		}
+		if len(o.Defs) != len(defs)/2 {
+			t.Errorf("normalizer %d: got %d defs, want %d", i, len(o.Defs), len(defs)/2)
+		}
+	}
+	if b.buffered != 0 || len(b.normalizers) != 0 {
+		t.Errorf("got %d buffered records and %d normalizers in the budget, want none", b.buffered, len(b.normalizers))
+	}
+}
+
+func TestNormalizer_sharedLineTables(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srclib-normalizer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "f"), []byte("é👋x\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var reads int
+	tables := srcpos.NewCache(func(file string) ([]byte, error) {
+		reads++
+		return ioutil.ReadFile(file)
+	}, DefaultLineTablesBytes)
+	for _, path := range []string{"x", "y"} {
+		n := NewNormalizer("t", dir, NormalizeOptions{OffsetEncoding: srcpos.Runes, LineTables: tables})
+		defer n.Close()
+		if err := n.Add(&graph.Record{Def: &graph.Def{DefKey: graph.DefKey{Path: path}, File: "f", DefStart: 2, DefEnd: 3}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reads != 1 {
+		t.Errorf("got %d reads, want 1 (the line table should be shared)", reads)
+	}
+}
+
+func TestNormalizer_repair(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srclib-normalizer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "f"), []byte("0123456789"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	recs := []*graph.Record{
+		{Def: &graph.Def{DefKey: graph.DefKey{Path: "p"}, Name: "a", File: "f", DefStart: 1, DefEnd: 2}},
+		{Def: &graph.Def{DefKey: graph.DefKey{Path: "p"}, Name: "b", File: "f", DefStart: 1, DefEnd: 2}}, // duplicate
+		{Def: &graph.Def{DefKey: graph.DefKey{Path: "q"}, TreePath: "/q//r/", File: "f", DefStart: 3, DefEnd: 20}},
+		{Ref: &graph.Ref{DefPath: "p", File: "f", Start: 1, End: 2, Kind: "x"}},
+		{Ref: &graph.Ref{DefPath: "p", File: "f", Start: 3, End: 4}},
+		{Ref: &graph.Ref{DefPath: "p", File: "f", Start: 3, End: 4, CommitID: "c"}}, // duplicate
+		{Ref: &graph.Ref{DefPath: "p", File: "f", Start: 9, End: 11}},
+		{Ref: &graph.Ref{DefPath: "p", File: "f", Start: 4, End: 3}},
+		{Ref: &graph.Ref{DefRepo: "bad", DefPath: "p", File: "f", Start: 5, End: 6}}, // invalid repo URI
+		{Doc: &graph.Doc{DefKey: graph.DefKey{Path: "p"}, Format: "text/plain", Data: "a"}},
+		{Doc: &graph.Doc{DefKey: graph.DefKey{Path: "p"}, Format: "text/plain", Data: "b"}}, // duplicate
+		{Relation: &graph.Relation{From: graph.DefKey{Path: "p"}, To: graph.RefDefKey{DefPath: "p"}, Kind: graph.RelationExtends}},
+		{Relation: &graph.Relation{From: graph.DefKey{Path: "q"}, To: graph.RefDefKey{DefRepo: "bad", DefPath: "p"}, Kind: graph.RelationExtends}}, // invalid repo URI
+	}
+	var repairs []*Repair
+	n := NewNormalizer("GoPackage", dir, NormalizeOptions{
+		MaxBuffered: 2,
+		Repair:      true,
+		OnRepair:    func(r *Repair) { repairs = append(repairs, r) },
+	})
+	defer n.Close()
+	for _, rec := range recs {
+		if err := n.Add(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := n.WriteOutput(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var o graph.Output
+	if err := json.Unmarshal(buf.Bytes(), &o); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(o.Defs) != 2 || o.Defs[0].Name != "a" {
+		t.Errorf("got defs %+v, want the first def p and def q", o.Defs)
+	} else if q := o.Defs[1]; q.TreePath != "q/r" || q.DefStart != 0 || q.DefEnd != 0 {
+		t.Errorf("got def q with TreePath %q and offsets %d-%d, want TreePath \"q/r\" and no offsets", q.TreePath, q.DefStart, q.DefEnd)
+	}
+	if len(o.Refs) != 2 || o.Refs[0].Kind != "" || o.Refs[0].Start != 1 {
+		t.Errorf("got refs %+v, want 2 (with the invalid kind cleared)", o.Refs)
+	}
+	if len(o.Docs) != 1 || o.Docs[0].Data != "a" {
+		t.Errorf("got docs %+v, want the first doc", o.Docs)
+	}
+	if len(o.Relations) != 0 {
+		t.Errorf("got relations %+v, want none", o.Relations)
+	}
+
+	actions := map[string]int{}
+	for _, r := range repairs {
+		actions[r.Action]++
+	}
+	if want := (map[string]int{RepairDropped: 8, RepairFixed: 2}); actions[RepairDropped] != want[RepairDropped] || actions[RepairFixed] != want[RepairFixed] {
+		t.Errorf("got repair actions %v, want %v", actions, want)
+	}
+}
@@ -0,0 +1,137 @@
+package grapher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// RepairsDataType is the build data type name of the files that
+// record the repairs made to the graph data of source units (see
+// plan.SourceUnitDataFilename).
+//
+// The repairs files are written by the graph rules in repair mode (see
+// GraphUnitRule.Repair) alongside their targets, but they are not
+// targets themselves. Enabling or disabling repair mode therefore does
+// not rebuild graph data that is already up to date; remove the build
+// data to regraph it in the other mode.
+const RepairsDataType = "graph-repairs"
+
+// Repair actions.
+const (
+	RepairDropped = "dropped" // the record was removed
+	RepairFixed   = "fixed"   // the record's invalid fields were corrected or cleared
+)
+
+// A Repair describes an invalid record in graph data that a
+// Normalizer repaired in repair mode.
+type Repair struct {
+	Record  *graph.Record // the record before it was repaired
+	Problem string        // what was invalid about the record
+	Action  string        // RepairDropped or RepairFixed
+}
+
+func (n *Normalizer) repaired(rec *graph.Record, problem, action string) {
+	if n.opt.OnRepair != nil {
+		n.opt.OnRepair(&Repair{Record: rec, Problem: problem, Action: action})
+	}
+}
+
+// repairDef fixes def's invalid TreePath and clears its invalid
+// offsets.
+func (n *Normalizer) repairDef(def *graph.Def) {
+	orig := *def
+	var problems []string
+	if def.TreePath != "" && !graph.IsValidTreePath(def.TreePath) {
+		problems = append(problems, fmt.Sprintf("invalid TreePath %q", def.TreePath))
+		def.TreePath = cleanTreePath(def.TreePath)
+	}
+	if problem := n.checkOffsets(def.File, def.DefStart, def.DefEnd); problem != "" {
+		problems = append(problems, problem)
+		def.DefStart, def.DefEnd = 0, 0
+	}
+	if len(problems) > 0 {
+		n.repaired(&graph.Record{Def: &orig}, strings.Join(problems, "; "), RepairFixed)
+	}
+}
+
+// repairRef fixes ref's invalid kind. It returns false if ref must be
+// dropped because its offsets are invalid.
+func (n *Normalizer) repairRef(ref *graph.Ref) bool {
+	if problem := n.checkOffsets(ref.File, ref.Start, ref.End); problem != "" {
+		n.repaired(&graph.Record{Ref: ref}, problem, RepairDropped)
+		return false
+	}
+	if !graph.IsValidRefKind(ref.Kind) {
+		orig := *ref
+		ref.Kind = ""
+		n.repaired(&graph.Record{Ref: &orig}, fmt.Sprintf("invalid ref kind %q", orig.Kind), RepairFixed)
+	}
+	return true
+}
+
+// repairDoc clears doc's location if its offsets are invalid.
+func (n *Normalizer) repairDoc(doc *graph.Doc) {
+	if problem := n.checkOffsets(doc.File, doc.Start, doc.End); problem != "" {
+		orig := *doc
+		doc.File, doc.Start, doc.End = "", 0, 0
+		n.repaired(&graph.Record{Doc: &orig}, problem, RepairFixed)
+	}
+}
+
+// repairRelation returns false if rel is invalid and must be dropped.
+func (n *Normalizer) repairRelation(rel *graph.Relation) bool {
+	if errs := ValidateRelations([]*graph.Relation{rel}); len(errs) > 0 {
+		n.repaired(&graph.Record{Relation: rel}, errs.Error(), RepairDropped)
+		return false
+	}
+	return true
+}
+
+// checkOffsets describes the problem with the byte offsets of a def,
+// ref, or doc in file, or returns "" if they are valid.
+func (n *Normalizer) checkOffsets(file string, start, end uint32) string {
+	if start > end {
+		return fmt.Sprintf("start offset %d is after end offset %d", start, end)
+	}
+	if file == "" {
+		return ""
+	}
+	size, ok := n.fileSize(file)
+	if ok && int64(end) > size {
+		return fmt.Sprintf("end offset %d is past the end of file %s (%d bytes)", end, file, size)
+	}
+	return ""
+}
+
+// fileSize returns the size of file (relative to the source unit
+// dir), or false if it isn't a regular file.
+func (n *Normalizer) fileSize(file string) (int64, bool) {
+	if size, ok := n.fileSizes[file]; ok {
+		return size, size >= 0
+	}
+	size := int64(-1)
+	if fi, err := os.Stat(filepath.Join(n.dir, file)); err == nil && fi.Mode().IsRegular() {
+		size = fi.Size()
+	}
+	if n.fileSizes == nil {
+		n.fileSizes = make(map[string]int64)
+	}
+	n.fileSizes[file] = size
+	return size, size >= 0
+}
+
+// cleanTreePath removes the empty components of treePath (which make
+// it invalid). It returns "" if treePath has no non-empty components.
+func cleanTreePath(treePath string) string {
+	var parts []string
+	for _, part := range strings.Split(treePath, "/") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "/")
+}
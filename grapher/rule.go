@@ -12,6 +12,7 @@ import (
 	"sourcegraph.com/sourcegraph/srclib/config"
 	"sourcegraph.com/sourcegraph/srclib/graph"
 	"sourcegraph.com/sourcegraph/srclib/plan"
+	"sourcegraph.com/sourcegraph/srclib/srcpos"
 	"sourcegraph.com/sourcegraph/srclib/toolchain"
 	"sourcegraph.com/sourcegraph/srclib/unit"
 	"sourcegraph.com/sourcegraph/srclib/util"
@@ -28,6 +29,7 @@ func init() {
 
 func makeGraphRules(c *config.Tree, dataDir string, existing []makex.Rule) ([]makex.Rule, error) {
 	var rules []makex.Rule
+	encs := toolOffsetEncodings{}
 	for _, u := range c.SourceUnits {
 		// HACK: ensure backward compatibility with old behavior where
 		// we assume we should `graph` if no `graph` op explicitly specified
@@ -38,7 +40,11 @@ func makeGraphRules(c *config.Tree, dataDir string, existing []makex.Rule) ([]ma
 		if err != nil {
 			return nil, err
 		}
-		rules = append(rules, &GraphUnitRule{dataDir, u, toolRef})
+		enc, err := encs.get(toolRef)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, &GraphUnitRule{dataDir: dataDir, Unit: u, Tool: toolRef, OffsetEncoding: enc})
 	}
 	return rules, nil
 }
@@ -56,26 +62,95 @@ func makeGraphAllRules(c *config.Tree, dataDir string, existing []makex.Rule) ([
 
 	// Make a GraphMultiUnitsRule for each group of source units
 	var rules []makex.Rule
+	encs := toolOffsetEncodings{}
 	for unitType, units := range groupedUnits {
 		toolRef, err := toolchain.ChooseTool(graphOp, unitType)
 		if err != nil {
 			return nil, err
 		}
-		rules = append(rules, &GraphMultiUnitsRule{dataDir, units, unitType, toolRef})
+		enc, err := encs.get(toolRef)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, &GraphMultiUnitsRule{dataDir: dataDir, Units: units, UnitsType: unitType, Tool: toolRef, OffsetEncoding: enc})
 	}
 	return rules, nil
 }
 
+// toolOffsetEncodings caches the offset encodings that tools declare,
+// so that each tool's toolchain config is read only once when
+// planning.
+type toolOffsetEncodings map[srclib.ToolRef]srcpos.Encoding
+
+// get returns the offset encoding that the tool declares, or "" if it
+// doesn't declare one.
+//
+// If the tool can't be looked up (e.g., because its toolchain is not
+// installed), it is treated as declaring no encoding. That is not a
+// planning error, because running the tool would fail anyway.
+func (c toolOffsetEncodings) get(toolRef *srclib.ToolRef) (srcpos.Encoding, error) {
+	if toolRef == nil {
+		return "", nil
+	}
+	if enc, present := c[*toolRef]; present {
+		return enc, nil
+	}
+	tool, err := toolchain.LookupTool(toolRef)
+	if err != nil {
+		c[*toolRef] = ""
+		return "", nil
+	}
+	if enc := tool.OffsetEncoding; enc != "" && !srcpos.IsValidEncoding(enc) {
+		return "", fmt.Errorf("invalid OffsetEncoding %q for tool %s %s (valid encodings are %v)", enc, toolRef.Toolchain, toolRef.Subcmd, srcpos.Encodings)
+	}
+	c[*toolRef] = tool.OffsetEncoding
+	return tool.OffsetEncoding, nil
+}
+
+// offsetEncodingFlag returns the normalize-graph-data flag that sets
+// the offset encoding, if any.
+func offsetEncodingFlag(enc srcpos.Encoding) string {
+	if enc == "" {
+		return ""
+	}
+	return fmt.Sprintf(" --offset-encoding %q", enc)
+}
+
+// repairFlags returns the normalize-graph-data flags that enable
+// repair mode (if repair is set) and write the repairs to repairsFile
+// (if set).
+func repairFlags(repair bool, repairsFile string) string {
+	if !repair {
+		return ""
+	}
+	if repairsFile == "" {
+		return " --repair"
+	}
+	return fmt.Sprintf(" --repair --diagnostics %q", repairsFile)
+}
+
 type GraphUnitRule struct {
-	dataDir string
-	Unit    *unit.SourceUnit
-	Tool    *srclib.ToolRef
+	dataDir        string
+	Unit           *unit.SourceUnit
+	Tool           *srclib.ToolRef
+	OffsetEncoding srcpos.Encoding // declared by Tool (empty if undeclared)
+
+	// Repair is whether the graph data is normalized in repair mode
+	// (see NormalizeOptions.Repair), recording the repairs in the
+	// unit's RepairsDataType file.
+	Repair bool
 }
 
 func (r *GraphUnitRule) Target() string {
 	return filepath.ToSlash(filepath.Join(r.dataDir, plan.SourceUnitDataFilename(&graph.Output{}, r.Unit)))
 }
 
+// repairsFile returns the file that the repairs made to the unit's
+// graph data are written to in repair mode.
+func (r *GraphUnitRule) repairsFile() string {
+	return filepath.ToSlash(filepath.Join(r.dataDir, plan.SourceUnitDataFilename(RepairsDataType, r.Unit)))
+}
+
 func (r *GraphUnitRule) Prereqs() []string {
 	ps := []string{filepath.ToSlash(filepath.Join(r.dataDir, plan.SourceUnitDataFilename(unit.SourceUnit{}, r.Unit)))}
 	for _, file := range r.Unit.Files {
@@ -94,15 +169,21 @@ func (r *GraphUnitRule) Recipes() []string {
 	}
 	safeCommand := util.SafeCommandName(srclib.CommandName)
 	return []string{
-		fmt.Sprintf("%s tool %q %q < $< | %s internal normalize-graph-data --unit-type %q --dir . 1> $@", safeCommand, r.Tool.Toolchain, r.Tool.Subcmd, safeCommand, r.Unit.Type),
+		fmt.Sprintf("%s tool %q %q < $< | %s internal normalize-graph-data --unit-type %q%s%s --dir . 1> $@", safeCommand, r.Tool.Toolchain, r.Tool.Subcmd, safeCommand, r.Unit.Type, offsetEncodingFlag(r.OffsetEncoding), repairFlags(r.Repair, r.repairsFile())),
 	}
 }
 
 type GraphMultiUnitsRule struct {
-	dataDir   string
-	Units     unit.SourceUnits
-	UnitsType string
-	Tool      *srclib.ToolRef
+	dataDir        string
+	Units          unit.SourceUnits
+	UnitsType      string
+	Tool           *srclib.ToolRef
+	OffsetEncoding srcpos.Encoding // declared by Tool (empty if undeclared)
+
+	// Repair is whether the graph data is normalized in repair mode
+	// (see NormalizeOptions.Repair), recording the repairs in each
+	// unit's RepairsDataType file in the data dir.
+	Repair bool
 }
 
 func (r *GraphMultiUnitsRule) Target() string {
@@ -152,6 +233,6 @@ func (r *GraphMultiUnitsRule) Recipes() []string {
 		findCmd = "/usr/bin/find"
 	}
 	return []string{
-		fmt.Sprintf(`%s %s -name "*%s.unit.json" | xargs %s internal emit-unit-data  | %s tool %q %q | %s internal normalize-graph-data --unit-type %q --dir . --multi --data-dir %s`, findCmd, filepath.ToSlash(r.dataDir), r.UnitsType, safeCommand, safeCommand, r.Tool.Toolchain, r.Tool.Subcmd, safeCommand, r.UnitsType, filepath.ToSlash(r.dataDir)),
+		fmt.Sprintf(`%s %s -name "*%s.unit.json" | xargs %s internal emit-unit-data  | %s tool %q %q | %s internal normalize-graph-data --unit-type %q%s%s --dir . --multi --data-dir %s`, findCmd, filepath.ToSlash(r.dataDir), r.UnitsType, safeCommand, safeCommand, r.Tool.Toolchain, r.Tool.Subcmd, safeCommand, r.UnitsType, offsetEncodingFlag(r.OffsetEncoding), repairFlags(r.Repair, ""), filepath.ToSlash(r.dataDir)),
 	}
 }
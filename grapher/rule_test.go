@@ -0,0 +1,94 @@
+package grapher
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib"
+	"sourcegraph.com/sourcegraph/srclib/config"
+	"sourcegraph.com/sourcegraph/srclib/srcpos"
+	"sourcegraph.com/sourcegraph/srclib/toolchain"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestMakeGraphRules_normalizeFlags(t *testing.T) {
+	oldChooseTool, oldLookupTool := toolchain.ChooseTool, toolchain.LookupTool
+	defer func() { toolchain.ChooseTool, toolchain.LookupTool = oldChooseTool, oldLookupTool }()
+
+	toolchain.ChooseTool = func(op, unitType string) (*srclib.ToolRef, error) {
+		return &srclib.ToolRef{Toolchain: "tc", Subcmd: "t"}, nil
+	}
+	c := &config.Tree{SourceUnits: []*unit.SourceUnit{{Key: unit.Key{Name: "n", Type: "t"}}}}
+
+	tests := map[srcpos.Encoding]string{
+		"":           `--unit-type "t" --dir .`,
+		srcpos.UTF16: `--unit-type "t" --offset-encoding "utf-16" --dir .`,
+	}
+	for enc, want := range tests {
+		toolchain.LookupTool = func(ref *srclib.ToolRef) (*toolchain.ToolInfo, error) {
+			return &toolchain.ToolInfo{Subcmd: ref.Subcmd, Op: "graph", OffsetEncoding: enc}, nil
+		}
+		rules, err := makeGraphRules(c, "testdata", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if recipe := rules[0].Recipes()[0]; !strings.Contains(recipe, want) {
+			t.Errorf("OffsetEncoding %q: got recipe %q, want it to contain %q", enc, recipe, want)
+		}
+	}
+
+	rules, err := makeGraphRules(c, "testdata", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules[0].(*GraphUnitRule).Repair = true
+	if recipe, want := rules[0].Recipes()[0], `--repair --diagnostics "testdata/n/t.graph-repairs.json"`; !strings.Contains(recipe, want) {
+		t.Errorf("Repair: got recipe %q, want it to contain %q", recipe, want)
+	}
+
+	toolchain.LookupTool = func(ref *srclib.ToolRef) (*toolchain.ToolInfo, error) {
+		return &toolchain.ToolInfo{Subcmd: ref.Subcmd, Op: "graph", OffsetEncoding: "x"}, nil
+	}
+	if _, err := makeGraphRules(c, "testdata", nil); err == nil {
+		t.Error("got nil error for invalid OffsetEncoding")
+	}
+
+	// A tool that can't be looked up declares no encoding.
+	toolchain.LookupTool = func(ref *srclib.ToolRef) (*toolchain.ToolInfo, error) {
+		return nil, os.ErrNotExist
+	}
+	rules, err = makeGraphRules(c, "testdata", nil)
+	if err != nil {
+		t.Fatalf("got error %v for missing toolchain, want nil", err)
+	}
+	if recipe := rules[0].Recipes()[0]; strings.Contains(recipe, "--offset-encoding") {
+		t.Errorf("missing toolchain: got recipe %q, want no --offset-encoding flag", recipe)
+	}
+}
+
+func TestMakeGraphRules_lookupToolOnce(t *testing.T) {
+	oldChooseTool, oldLookupTool := toolchain.ChooseTool, toolchain.LookupTool
+	defer func() { toolchain.ChooseTool, toolchain.LookupTool = oldChooseTool, oldLookupTool }()
+
+	toolchain.ChooseTool = func(op, unitType string) (*srclib.ToolRef, error) {
+		return &srclib.ToolRef{Toolchain: "tc", Subcmd: "t"}, nil
+	}
+	var lookups int
+	toolchain.LookupTool = func(ref *srclib.ToolRef) (*toolchain.ToolInfo, error) {
+		lookups++
+		return &toolchain.ToolInfo{Subcmd: ref.Subcmd, Op: "graph"}, nil
+	}
+
+	c := &config.Tree{SourceUnits: []*unit.SourceUnit{
+		{Key: unit.Key{Name: "a", Type: "t"}},
+		{Key: unit.Key{Name: "b", Type: "t"}},
+		{Key: unit.Key{Name: "c", Type: "t"}},
+	}}
+	if _, err := makeGraphRules(c, "testdata", nil); err != nil {
+		t.Fatal(err)
+	}
+	if lookups != 1 {
+		t.Errorf("got %d tool lookups for 3 units graphed by the same tool, want 1", lookups)
+	}
+}
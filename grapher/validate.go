@@ -11,6 +11,9 @@ import (
 func ValidateRefs(refs []*graph.Ref) (errs MultiError) {
 	refKeys := make(map[graph.RefKey]struct{})
 	for _, ref := range refs {
+		if !graph.IsValidRefKind(ref.Kind) {
+			errs = append(errs, fmt.Errorf("invalid ref kind %q (valid kinds are %s): %+v", ref.Kind, strings.Join(graph.RefKinds, ", "), ref.RefKey()))
+		}
 		key := ref.RefKey()
 		if _, in := refKeys[key]; in {
 			errs = append(errs, fmt.Errorf("duplicate ref key: %+v", key))
@@ -49,6 +52,29 @@ func ValidateDocs(docs []*graph.Doc) (errs MultiError) {
 	return
 }
 
+// ValidateRelations checks that each relation has a valid kind,
+// refers to defs on both ends (and not to the same def on both ends),
+// and is not a duplicate.
+func ValidateRelations(rels []*graph.Relation) (errs MultiError) {
+	relKeys := make(map[graph.Relation]struct{})
+	for _, rel := range rels {
+		if !graph.IsValidRelationKind(rel.Kind) {
+			errs = append(errs, fmt.Errorf("invalid relation kind %q (valid kinds are %s): %+v", rel.Kind, strings.Join(graph.RelationKinds, ", "), *rel))
+		}
+		if rel.From.Path == "" || rel.To.DefPath == "" {
+			errs = append(errs, fmt.Errorf("relation has an empty def path: %+v", *rel))
+		} else if rel.ToDefKey() == rel.From {
+			errs = append(errs, fmt.Errorf("relation from a def to itself: %+v", *rel))
+		}
+		if _, in := relKeys[*rel]; in {
+			errs = append(errs, fmt.Errorf("duplicate relation: %+v", *rel))
+		} else {
+			relKeys[*rel] = struct{}{}
+		}
+	}
+	return
+}
+
 type MultiError []error
 
 func (e MultiError) Error() string {
@@ -127,6 +153,25 @@ func PopulateImpliedFields(repo, commitID, unitType, unit string, o *graph.Outpu
 			ref.DefUnitType = unitType
 		}
 	}
+	for _, rel := range o.Relations {
+		rel.From.Repo = repo
+		rel.From.CommitID = commitID
+		rel.From.UnitType = unitType
+		rel.From.Unit = unit
+
+		// As with refs, an empty repository URI refers to the current
+		// repository.
+		if rel.To.DefRepo == "" {
+			rel.To.DefRepo = repo
+			if rel.To.DefUnit == "" {
+				rel.To.DefUnitType = unitType
+				rel.To.DefUnit = unit
+			}
+		}
+		if rel.To.DefUnitType == "" {
+			rel.To.DefUnitType = unitType
+		}
+	}
 	for _, doc := range o.Docs {
 		doc.UnitType = unitType
 		doc.Unit = unit
@@ -6,6 +6,22 @@ import (
 	"sourcegraph.com/sourcegraph/srclib/graph"
 )
 
+func TestValidateRefs_kind(t *testing.T) {
+	refs := []*graph.Ref{
+		{DefPath: "p", File: "f", Start: 0, End: 1},
+		{DefPath: "p", File: "f", Start: 0, End: 1, Kind: graph.RefKindRead},
+		{DefPath: "p", File: "f", Start: 0, End: 1, Kind: graph.RefKindWrite},
+	}
+	if err := ValidateRefs(refs); err != nil {
+		t.Fatal(err)
+	}
+
+	refs = append(refs, &graph.Ref{DefPath: "p", File: "f", Start: 2, End: 3, Kind: "x"})
+	if err := ValidateRefs(refs); err == nil {
+		t.Fatalf("got nil err, want validation error")
+	}
+}
+
 func TestValidateDocs_ok(t *testing.T) {
 	docs := []*graph.Doc{
 		{
@@ -46,3 +62,27 @@ func TestValidateDocs_dup(t *testing.T) {
 		t.Fatalf("got nil err, want validation error")
 	}
 }
+
+func TestValidateRelations(t *testing.T) {
+	rels := []*graph.Relation{
+		{From: graph.DefKey{Path: "T"}, To: graph.RefDefKey{DefPath: "I"}, Kind: graph.RelationImplements},
+		{From: graph.DefKey{Path: "T"}, To: graph.RefDefKey{DefRepo: "r2", DefPath: "I"}, Kind: graph.RelationImplements},
+		{From: graph.DefKey{Path: "T/m"}, To: graph.RefDefKey{DefPath: "I/m"}, Kind: graph.RelationOverrides},
+	}
+	if err := ValidateRelations(rels); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := []*graph.Relation{
+		{From: graph.DefKey{Path: "T"}, To: graph.RefDefKey{DefPath: "I"}, Kind: "x"},
+		{From: graph.DefKey{Path: "T"}, To: graph.RefDefKey{DefPath: "I"}},
+		{From: graph.DefKey{Path: "T"}, To: graph.RefDefKey{DefPath: "T"}, Kind: graph.RelationExtends},
+		{From: graph.DefKey{Path: "T"}, Kind: graph.RelationExtends},
+		rels[0],
+	}
+	for _, rel := range invalid {
+		if err := ValidateRelations(append(rels, rel)); err == nil {
+			t.Errorf("%+v: got nil err, want validation error", *rel)
+		}
+	}
+}
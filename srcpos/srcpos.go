@@ -0,0 +1,310 @@
+// Package srcpos converts between the byte offsets in files that
+// srclib's defs, refs, and docs use, and lines and columns (as used by
+// editors, annotations, and exchange formats such as LSIF and SCIP).
+//
+// Lines and columns are zero-based. (Annotations and most editors
+// number lines from 1, so callers must convert.) Columns are measured
+// in one of several units, given by an Encoding: bytes, Unicode code
+// points (runes), or UTF-16 code units (as in LSP).
+package srcpos
+
+import (
+	"container/list"
+	"fmt"
+	"sort"
+	"sync"
+	"unicode/utf8"
+)
+
+// An Encoding is the unit in which columns are measured.
+type Encoding string
+
+const (
+	// Bytes measures columns in bytes (UTF-8 code units).
+	Bytes Encoding = "bytes"
+
+	// Runes measures columns in Unicode code points.
+	Runes Encoding = "runes"
+
+	// UTF16 measures columns in UTF-16 code units, so that code points
+	// outside the Basic Multilingual Plane (e.g., most emoji) count as
+	// 2.
+	UTF16 Encoding = "utf-16"
+)
+
+// Encodings are the valid encodings.
+var Encodings = []Encoding{Bytes, Runes, UTF16}
+
+// IsValidEncoding reports whether enc is a valid encoding.
+func IsValidEncoding(enc Encoding) bool {
+	for _, e := range Encodings {
+		if enc == e {
+			return true
+		}
+	}
+	return false
+}
+
+// A Position is a zero-based line and column in a file.
+type Position struct {
+	Line   int
+	Column int
+}
+
+func (p Position) String() string { return fmt.Sprintf("%d:%d", p.Line, p.Column) }
+
+// A Table is the line table of a file, which converts between byte
+// offsets and positions in it.
+type Table struct {
+	src    []byte
+	starts []int // byte offset of the start of each line
+
+	mu        sync.Mutex
+	encStarts map[Encoding][]int // offset (measured in an encoding) of the start of each line
+}
+
+// NewTable returns the line table of the file whose contents are
+// src. Lines end at "\n" (a preceding "\r" is part of the line).
+func NewTable(src []byte) *Table {
+	starts := []int{0}
+	for i, c := range src {
+		if c == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+	return &Table{src: src, starts: starts}
+}
+
+// Len returns the size of the file in bytes.
+func (t *Table) Len() int { return len(t.src) }
+
+// NumLines returns the number of lines in the file. A file that ends
+// in a newline has an empty last line.
+func (t *Table) NumLines() int { return len(t.starts) }
+
+// Line returns the line that contains the given byte offset. It
+// returns an error if the offset is past the end of the file.
+func (t *Table) Line(offset uint32) (int, error) {
+	if int(offset) > len(t.src) {
+		return 0, t.offsetError(offset)
+	}
+
+	// Find the last line that starts at or before offset.
+	lo, hi := 0, len(t.starts)
+	for hi-lo > 1 {
+		if mid := (lo + hi) / 2; t.starts[mid] <= int(offset) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+// LineOffsets returns the byte offsets of the start and end of a line
+// (excluding its newline).
+func (t *Table) LineOffsets(line int) (start, end uint32, err error) {
+	if line < 0 || line >= len(t.starts) {
+		return 0, 0, fmt.Errorf("line %d is out of range (the file has %d lines)", line, len(t.starts))
+	}
+	s, e := t.starts[line], len(t.src)
+	if line+1 < len(t.starts) {
+		e = t.starts[line+1] - 1
+	}
+	return uint32(s), uint32(e), nil
+}
+
+// Position returns the position of the given byte offset, with its
+// column measured in enc. It returns an error if the offset is past
+// the end of the file.
+func (t *Table) Position(offset uint32, enc Encoding) (Position, error) {
+	line, err := t.Line(offset)
+	if err != nil {
+		return Position{}, err
+	}
+	return Position{Line: line, Column: columnWidth(t.src[t.starts[line]:offset], enc)}, nil
+}
+
+// Offset returns the byte offset of the given position, whose column
+// is measured in enc. It returns an error if the position is not in
+// the file (including if its column is past the end of its line). A
+// column in the middle of a UTF-16 surrogate pair is rounded up to
+// the end of the pair.
+func (t *Table) Offset(p Position, enc Encoding) (uint32, error) {
+	start, end, err := t.LineOffsets(p.Line)
+	if err != nil {
+		return 0, err
+	}
+	if p.Column < 0 {
+		return 0, fmt.Errorf("column %d is negative", p.Column)
+	}
+	line := t.src[start:end]
+	if enc == Bytes {
+		if p.Column > len(line) {
+			return 0, t.columnError(p, enc, len(line))
+		}
+		return start + uint32(p.Column), nil
+	}
+
+	var col, i int
+	for col < p.Column && i < len(line) {
+		r, size := utf8.DecodeRune(line[i:])
+		col += runeWidth(r, enc)
+		i += size
+	}
+	if col < p.Column {
+		return 0, t.columnError(p, enc, col)
+	}
+	return start + uint32(i), nil
+}
+
+// ByteOffset returns the byte offset of the given offset from the
+// start of the file, measured in enc. (Some graphers emit offsets
+// that are measured in runes or UTF-16 code units.) It returns an
+// error if the offset is past the end of the file. An offset in the
+// middle of a UTF-16 surrogate pair is rounded up to the end of the
+// pair.
+func (t *Table) ByteOffset(offset int, enc Encoding) (uint32, error) {
+	if offset < 0 {
+		return 0, fmt.Errorf("offset %d is negative", offset)
+	}
+	if enc == Bytes {
+		if offset > len(t.src) {
+			return 0, t.offsetError(uint32(offset))
+		}
+		return uint32(offset), nil
+	}
+
+	// Find the last line that starts at or before offset.
+	starts := t.lineStarts(enc)
+	line := sort.Search(len(starts), func(i int) bool { return starts[i] > offset }) - 1
+	off, err := t.Offset(Position{Line: line, Column: offset - starts[line]}, enc)
+	if err != nil {
+		last := len(starts) - 1
+		return 0, fmt.Errorf("offset %d is past the end of the file (%d %s)", offset, starts[last]+columnWidth(t.src[t.starts[last]:], enc), enc)
+	}
+	return off, nil
+}
+
+// lineStarts returns the offset (measured in enc) of the start of
+// each line.
+func (t *Table) lineStarts(enc Encoding) []int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if starts, ok := t.encStarts[enc]; ok {
+		return starts
+	}
+	starts := make([]int, len(t.starts))
+	for i := 1; i < len(t.starts); i++ {
+		starts[i] = starts[i-1] + columnWidth(t.src[t.starts[i-1]:t.starts[i]], enc)
+	}
+	if t.encStarts == nil {
+		t.encStarts = make(map[Encoding][]int)
+	}
+	t.encStarts[enc] = starts
+	return starts
+}
+
+func (t *Table) offsetError(offset uint32) error {
+	return fmt.Errorf("byte offset %d is past the end of the file (%d bytes)", offset, len(t.src))
+}
+
+func (t *Table) columnError(p Position, enc Encoding, lineLen int) error {
+	return fmt.Errorf("column %d is past the end of line %d (%d %s)", p.Column, p.Line, lineLen, enc)
+}
+
+// columnWidth returns the width of b, measured in enc.
+func columnWidth(b []byte, enc Encoding) int {
+	if enc == Bytes {
+		return len(b)
+	}
+	var n int
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		n += runeWidth(r, enc)
+		b = b[size:]
+	}
+	return n
+}
+
+// runeWidth returns the width of r (which is utf8.RuneError, counted
+// as 1, for invalid UTF-8), measured in enc (Runes or UTF16).
+func runeWidth(r rune, enc Encoding) int {
+	if enc == UTF16 && r >= 0x10000 {
+		return 2 // surrogate pair
+	}
+	return 1
+}
+
+// A Cache caches the line tables of files. It holds at most about
+// maxBytes bytes of tables (and file contents); when it is full, the
+// least recently used tables are evicted.
+type Cache struct {
+	readFile func(file string) ([]byte, error)
+	maxBytes int64
+
+	mu     sync.Mutex
+	tables map[string]*list.Element // values are *cacheEntry
+	lru    *list.List               // most recently used first
+	bytes  int64
+}
+
+type cacheEntry struct {
+	file  string
+	table *Table
+	size  int64
+}
+
+// NewCache returns a cache of the line tables of the files that
+// readFile reads, which holds at most about maxBytes bytes of tables.
+// A Cache is safe for concurrent use.
+func NewCache(readFile func(file string) ([]byte, error), maxBytes int64) *Cache {
+	return &Cache{
+		readFile: readFile,
+		maxBytes: maxBytes,
+		tables:   map[string]*list.Element{},
+		lru:      list.New(),
+	}
+}
+
+// Table returns the line table of a file, reading the file if its
+// table is not cached. Errors are not cached.
+func (c *Cache) Table(file string) (*Table, error) {
+	c.mu.Lock()
+	if el, present := c.tables[file]; present {
+		c.lru.MoveToFront(el)
+		c.mu.Unlock()
+		return el.Value.(*cacheEntry).table, nil
+	}
+	c.mu.Unlock()
+
+	src, err := c.readFile(file)
+	if err != nil {
+		return nil, err
+	}
+	t := NewTable(src)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, present := c.tables[file]; present {
+		// Another goroutine read the file concurrently.
+		c.lru.MoveToFront(el)
+		return el.Value.(*cacheEntry).table, nil
+	}
+	e := &cacheEntry{file: file, table: t, size: t.size()}
+	c.tables[file] = c.lru.PushFront(e)
+	c.bytes += e.size
+	for c.bytes > c.maxBytes && c.lru.Len() > 0 {
+		dead := c.lru.Remove(c.lru.Back()).(*cacheEntry)
+		delete(c.tables, dead.file)
+		c.bytes -= dead.size
+	}
+	return t, nil
+}
+
+// size returns the approximate number of bytes of memory that t uses
+// (not counting the line starts of other encodings, which are
+// computed as needed).
+func (t *Table) size() int64 {
+	return int64(len(t.src)) + int64(len(t.starts))*8
+}
@@ -0,0 +1,179 @@
+package srcpos
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// testSrc has a 2-byte rune ("é", 1 UTF-16 code unit) and a 4-byte rune
+// ("👋", 2 UTF-16 code units) on line 1, and an empty line 2.
+const testSrc = "ab\ncé👋d\n\nx"
+
+func TestTable_Position(t *testing.T) {
+	table := NewTable([]byte(testSrc))
+	tests := []struct {
+		offset                    uint32
+		bytes, runes, utf16, line int
+	}{
+		{offset: 0, line: 0, bytes: 0, runes: 0, utf16: 0},
+		{offset: 2, line: 0, bytes: 2, runes: 2, utf16: 2},
+		{offset: 3, line: 1, bytes: 0, runes: 0, utf16: 0},
+		{offset: 4, line: 1, bytes: 1, runes: 1, utf16: 1},
+		{offset: 6, line: 1, bytes: 3, runes: 2, utf16: 2},  // after "é"
+		{offset: 10, line: 1, bytes: 7, runes: 3, utf16: 4}, // after "👋"
+		{offset: 11, line: 1, bytes: 8, runes: 4, utf16: 5}, // at the newline
+		{offset: 12, line: 2, bytes: 0, runes: 0, utf16: 0}, // empty line
+		{offset: 13, line: 3, bytes: 0, runes: 0, utf16: 0}, // last line
+		{offset: 14, line: 3, bytes: 1, runes: 1, utf16: 1}, // end of file
+	}
+	for _, test := range tests {
+		for enc, col := range map[Encoding]int{Bytes: test.bytes, Runes: test.runes, UTF16: test.utf16} {
+			pos, err := table.Position(test.offset, enc)
+			if err != nil {
+				t.Errorf("offset %d (%s): %s", test.offset, enc, err)
+				continue
+			}
+			if want := (Position{test.line, col}); pos != want {
+				t.Errorf("offset %d (%s): got %v, want %v", test.offset, enc, pos, want)
+			}
+
+			// The conversion must round-trip.
+			offset, err := table.Offset(pos, enc)
+			if err != nil {
+				t.Errorf("position %v (%s): %s", pos, enc, err)
+				continue
+			}
+			if offset != test.offset {
+				t.Errorf("position %v (%s): got offset %d, want %d", pos, enc, offset, test.offset)
+			}
+		}
+	}
+
+	if _, err := table.Position(15, Bytes); err == nil {
+		t.Error("offset past the end of the file: got nil error")
+	}
+	if n := table.NumLines(); n != 4 {
+		t.Errorf("got %d lines, want 4", n)
+	}
+}
+
+func TestTable_Offset(t *testing.T) {
+	table := NewTable([]byte(testSrc))
+
+	// A column in the middle of a surrogate pair is rounded up.
+	if offset, err := table.Offset(Position{1, 3}, UTF16); err != nil {
+		t.Error(err)
+	} else if offset != 10 {
+		t.Errorf("got offset %d, want 10", offset)
+	}
+
+	for _, p := range []Position{{-1, 0}, {4, 0}, {0, 3}, {1, -1}, {2, 1}} {
+		for _, enc := range Encodings {
+			if _, err := table.Offset(p, enc); err == nil {
+				t.Errorf("position %v (%s): got nil error", p, enc)
+			}
+		}
+	}
+}
+
+func TestTable_ByteOffset(t *testing.T) {
+	table := NewTable([]byte(testSrc))
+	tests := []struct {
+		enc    Encoding
+		offset int
+		want   uint32
+	}{
+		{Bytes, 14, 14},
+		{Runes, 3, 3},
+		{Runes, 6, 10}, // after "👋"
+		{Runes, 8, 12}, // empty line
+		{Runes, 10, 14},
+		{UTF16, 6, 10}, // in the middle of "👋" (rounded up)
+		{UTF16, 7, 10},
+		{UTF16, 11, 14},
+	}
+	for _, test := range tests {
+		offset, err := table.ByteOffset(test.offset, test.enc)
+		if err != nil {
+			t.Errorf("offset %d (%s): %s", test.offset, test.enc, err)
+			continue
+		}
+		if offset != test.want {
+			t.Errorf("offset %d (%s): got byte offset %d, want %d", test.offset, test.enc, offset, test.want)
+		}
+	}
+
+	for enc, offset := range map[Encoding]int{Bytes: 15, Runes: 11, UTF16: 12} {
+		if _, err := table.ByteOffset(offset, enc); err == nil {
+			t.Errorf("offset %d (%s): got nil error", offset, enc)
+		}
+		if _, err := table.ByteOffset(-1, enc); err == nil {
+			t.Errorf("offset -1 (%s): got nil error", enc)
+		}
+	}
+}
+
+func TestTable_LineOffsets(t *testing.T) {
+	table := NewTable([]byte(testSrc))
+	want := [][2]uint32{{0, 2}, {3, 11}, {12, 12}, {13, 14}}
+	for line, w := range want {
+		start, end, err := table.LineOffsets(line)
+		if err != nil {
+			t.Errorf("line %d: %s", line, err)
+			continue
+		}
+		if start != w[0] || end != w[1] {
+			t.Errorf("line %d: got offsets %d-%d, want %d-%d", line, start, end, w[0], w[1])
+		}
+	}
+}
+
+func TestCache(t *testing.T) {
+	var reads int
+	c := NewCache(func(file string) ([]byte, error) {
+		reads++
+		if file == "missing" {
+			return nil, errors.New("not found")
+		}
+		return []byte(testSrc), nil
+	}, 1<<20)
+	for i := 0; i < 2; i++ {
+		table, err := c.Table("f")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if table.Len() != len(testSrc) {
+			t.Errorf("got table of %d bytes, want %d", table.Len(), len(testSrc))
+		}
+	}
+	if reads != 1 {
+		t.Errorf("got %d reads, want 1 (the table should be cached)", reads)
+	}
+	if _, err := c.Table("missing"); err == nil {
+		t.Error("missing file: got nil error")
+	}
+}
+
+func TestCache_maxBytes(t *testing.T) {
+	reads := map[string]int{}
+	size := NewTable([]byte(testSrc)).size()
+	c := NewCache(func(file string) ([]byte, error) {
+		reads[file]++
+		return []byte(testSrc), nil
+	}, 2*size) // room for 2 tables
+
+	for _, file := range []string{"a", "b", "a", "c", "a", "b"} {
+		if _, err := c.Table(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// "b" is evicted when "c" is added, because "a" was used more
+	// recently.
+	if want := map[string]int{"a": 1, "b": 2, "c": 1}; !reflect.DeepEqual(reads, want) {
+		t.Errorf("got reads %v, want %v", reads, want)
+	}
+	if c.bytes != 2*size || c.lru.Len() != 2 {
+		t.Errorf("got %d tables (%d bytes), want 2 (%d bytes)", c.lru.Len(), c.bytes, 2*size)
+	}
+}
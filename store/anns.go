@@ -0,0 +1,259 @@
+package store
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/neelance/parallel"
+	"sourcegraph.com/sourcegraph/srclib/ann"
+)
+
+// An AnnStore stores and accesses annotations (see ann.Ann).
+//
+// Like RelationStore, it is not part of UnitStore, so that existing
+// UnitStore implementations need not implement it. All of the stores
+// in this package implement it; composite stores skip child stores
+// that don't.
+type AnnStore interface {
+	// Anns returns all annotations that match the filters. The
+	// {Repo,CommitID,UnitType,Unit} fields are filled in by the
+	// stores at the levels above the unit store that holds the
+	// annotation.
+	Anns(...AnnFilter) ([]*ann.Ann, error)
+}
+
+var (
+	_ AnnStore = (*unitStores)(nil)
+	_ AnnStore = (*treeStores)(nil)
+	_ AnnStore = (*repoStores)(nil)
+	_ AnnStore = (*fsUnitStore)(nil)
+	_ AnnStore = (*indexedUnitStore)(nil)
+	_ AnnStore = (*memoryUnitStore)(nil)
+	_ AnnStore = (*unionMultiRepoStore)(nil)
+)
+
+func (s repoStores) Anns(f ...AnnFilter) ([]*ann.Ann, error) {
+	rss, err := openRepoStores(s.opener, f)
+	if err != nil {
+		return nil, err
+	}
+
+	var allAnns []*ann.Ann
+	for _, repo := range sortedRepos(rss) {
+		rs, ok := rss[repo].(AnnStore)
+		if !ok {
+			continue
+		}
+		anns, err := rs.Anns(filtersForRepo(repo, f).([]AnnFilter)...)
+		if err != nil && !isStoreNotExist(err) {
+			return nil, err
+		}
+		for _, a := range anns {
+			a.Repo = repo
+		}
+		allAnns = append(allAnns, anns...)
+	}
+	return allAnns, nil
+}
+
+func (s treeStores) Anns(f ...AnnFilter) ([]*ann.Ann, error) {
+	tss, err := openTreeStores(s.opener, f)
+	if err != nil {
+		return nil, err
+	}
+
+	var allAnns []*ann.Ann
+	for _, commitID := range sortedCommitIDs(tss) {
+		ts, ok := tss[commitID].(AnnStore)
+		if !ok {
+			continue
+		}
+		anns, err := ts.Anns(filtersForTree(commitID, f).([]AnnFilter)...)
+		if err != nil && !isStoreNotExist(err) {
+			return nil, err
+		}
+		for _, a := range anns {
+			a.CommitID = commitID
+		}
+		allAnns = append(allAnns, anns...)
+	}
+	return allAnns, nil
+}
+
+func (s unitStores) Anns(f ...AnnFilter) ([]*ann.Ann, error) {
+	uss, err := openUnitStores(s.opener, f)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		units    = sortedUnits(uss)
+		unitAnns = make([][]*ann.Ann, len(units))
+	)
+	par := parallel.NewRun(storeFetchPar)
+	for i_, u_ := range units {
+		i, u := i_, u_
+		us, ok := uss[u].(AnnStore)
+		if !ok {
+			continue
+		}
+
+		par.Acquire()
+		go func() {
+			defer par.Release()
+			anns, err := us.Anns(filtersForUnit(u, f).([]AnnFilter)...)
+			if err != nil && !isStoreNotExist(err) {
+				par.Error(err)
+				return
+			}
+			for _, a := range anns {
+				a.UnitType = u.Type
+				a.Unit = u.Name
+			}
+			sort.Sort(ann.Anns(anns))
+			unitAnns[i] = anns
+		}()
+	}
+	err = par.Wait()
+
+	var allAnns []*ann.Ann
+	for _, anns := range unitAnns {
+		allAnns = append(allAnns, anns...)
+	}
+	return allAnns, err
+}
+
+func (s *memoryUnitStore) Anns(f ...AnnFilter) ([]*ann.Ann, error) {
+	if s.data == nil {
+		return nil, errUnitNoInit
+	}
+
+	var anns []*ann.Ann
+	for _, a := range s.data.Anns {
+		if annFilters(f).SelectAnn(a) {
+			anns = append(anns, a)
+		}
+	}
+	return anns, nil
+}
+
+const unitAnnsFilename = "ann.dat"
+
+// Anns implements AnnStore. Source units that were imported before
+// annotations were stored have no annotation data file, and therefore
+// no annotations.
+func (s *fsUnitStore) Anns(fs ...AnnFilter) ([]*ann.Ann, error) {
+	anns, err := s.readAnns()
+	if err != nil {
+		return nil, err
+	}
+	var sel []*ann.Ann
+	for _, a := range anns {
+		if annFilters(fs).SelectAnn(a) {
+			sel = append(sel, a)
+		}
+	}
+	return sel, nil
+}
+
+// readAnns reads all annotations from the annotation data file.
+func (s *fsUnitStore) readAnns() (anns []*ann.Ann, err error) {
+	f, err := s.fs.Open(unitAnnsFilename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err2 := f.Close()
+		if err == nil {
+			err = err2
+		}
+	}()
+
+	dec := Codec.NewDecoder(f)
+	for {
+		var a ann.Ann
+		if _, err := dec.Decode(&a); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		anns = append(anns, &a)
+	}
+	return anns, nil
+}
+
+// writeAnns writes the annotation data file.
+func (s *fsUnitStore) writeAnns(anns []*ann.Ann) (err error) {
+	vlog.Printf("%s: writing %d anns...", s, len(anns))
+	f, err := s.fs.Create(unitAnnsFilename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err2 := f.Close()
+		if err == nil {
+			err = err2
+		}
+	}()
+
+	bw := bufio.NewWriter(f)
+	enc := Codec.NewEncoder(bw)
+	for _, a := range anns {
+		if _, err := enc.Encode(a); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func (s *unionMultiRepoStore) Anns(f ...AnnFilter) ([]*ann.Ann, error) {
+	owned, err := s.owners(f)
+	if err != nil {
+		return nil, err
+	}
+
+	storeAnns := make([][]*ann.Ann, len(s.stores))
+	par := parallel.NewRun(storeFetchPar)
+	for i_, ss_ := range s.stores {
+		i := i_
+		ss, ok := ss_.(AnnStore)
+		if !ok || len(owned[i]) == 0 {
+			continue
+		}
+		par.Acquire()
+		go func() {
+			defer par.Release()
+			anns, err := ss.Anns(filtersForUnionMember(f, owned[i]).([]AnnFilter)...)
+			if err != nil && !isStoreNotExist(err) {
+				par.Error(err)
+				return
+			}
+			storeAnns[i] = anns
+		}()
+	}
+	if err := par.Wait(); err != nil {
+		return nil, err
+	}
+
+	var allAnns []*ann.Ann
+	for _, anns := range storeAnns {
+		allAnns = append(allAnns, anns...)
+	}
+	sort.Stable(annsInStoreOrder(allAnns))
+	return allAnns, nil
+}
+
+// annsInStoreOrder sorts annotations returned by a MultiRepoStore by
+// the repo and commit ID that contain them, preserving the order of
+// annotations within each version.
+type annsInStoreOrder []*ann.Ann
+
+func (v annsInStoreOrder) Len() int      { return len(v) }
+func (v annsInStoreOrder) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v annsInStoreOrder) Less(i, j int) bool {
+	return compareStrings(v[i].Repo, v[j].Repo, v[i].CommitID, v[j].CommitID) < 0
+}
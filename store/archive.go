@@ -0,0 +1,450 @@
+package store
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"time"
+
+	"sourcegraph.com/sourcegraph/srclib/ann"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// ArchiveFormatVersion is the version of the archive format written
+// by ExportVersion. It is incremented when the layout of archives
+// changes incompatibly.
+const ArchiveFormatVersion = 1
+
+// An ArchiveManifest describes the contents of an archive written by
+// ExportVersion. It is the first entry (named "manifest.json") in the
+// archive.
+//
+// An archive is a gzipped tar file. After the manifest, it contains
+// the entries "units/N/unit", "units/N/defs", "units/N/refs",
+// "units/N/relations" and "units/N/anns" for each source unit N (in
+// the order listed in the manifest), which hold the unit and its
+// defs, refs, relations and annotations encoded with the codec named
+// in the manifest. Docs are stored on the defs (in their Docs field).
+type ArchiveManifest struct {
+	// FormatVersion is the ArchiveFormatVersion of the archive.
+	FormatVersion int
+
+	// Codec is the name of the codec used to encode the units and
+	// their data ("protobuf" or "json").
+	Codec string
+
+	// Repo and CommitID identify the exported version. Repo is empty
+	// if it was exported from a RepoStore and no repo was specified.
+	Repo     string `json:",omitempty"`
+	CommitID string
+
+	// Meta is the metadata of the exported version, if any.
+	Meta *VersionMeta `json:",omitempty"`
+
+	// Units lists the source units in the archive.
+	Units []ArchiveUnit
+
+	// ExportedAt is when the archive was created.
+	ExportedAt time.Time
+}
+
+// An ArchiveUnit describes a source unit in an archive.
+type ArchiveUnit struct {
+	unit.ID2
+}
+
+// archiveUnitKinds are the kinds of entries for each source unit in
+// an archive, in the order they are written.
+var archiveUnitKinds = []string{"unit", "defs", "refs", "relations", "anns"}
+
+const archiveManifestName = "manifest.json"
+
+func archiveUnitEntry(i int, kind string) string {
+	return path.Join("units", fmt.Sprint(i), kind)
+}
+
+// codecName returns the name of c that is recorded in archive
+// manifests.
+func codecName(c codec) (string, error) {
+	switch c.(type) {
+	case ProtobufCodec:
+		return "protobuf", nil
+	case JSONCodec:
+		return "json", nil
+	}
+	return "", fmt.Errorf("codec %T has no name", c)
+}
+
+// codecByName returns the codec whose codecName is name.
+func codecByName(name string) (codec, error) {
+	switch name {
+	case "protobuf":
+		return ProtobufCodec{}, nil
+	case "json":
+		return JSONCodec{}, nil
+	}
+	return nil, fmt.Errorf("unknown codec %q", name)
+}
+
+// ExportVersion writes an archive (described in the ArchiveManifest
+// docs) of the data for a commit in rs to w. If rs is a
+// MultiRepoStore, only the data for repo is exported.
+func ExportVersion(w io.Writer, rs RepoStore, repo, commitID string) (*ArchiveManifest, error) {
+	cname, err := codecName(Codec)
+	if err != nil {
+		return nil, err
+	}
+	if commitID == "" {
+		return nil, fmt.Errorf("export from %s: commit ID is required", rs)
+	}
+
+	// scope restricts all queries to the exported version.
+	var scope interface {
+		DefFilter
+		RefFilter
+		RelationFilter
+		AnnFilter
+		UnitFilter
+		VersionFilter
+	} = ByCommitIDs(commitID)
+	if _, ok := rs.(MultiRepoStore); ok {
+		if repo == "" {
+			return nil, fmt.Errorf("export from %s: repo is required", rs)
+		}
+		scope = ByRepoCommitIDs(Version{Repo: repo, CommitID: commitID})
+	}
+
+	versions, err := rs.Versions(scope)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("export from %s: no version with repo %q and commit %q", rs, repo, commitID)
+	}
+	units, err := rs.Units(scope)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(unitsInStoreOrder(units))
+
+	m := &ArchiveManifest{
+		FormatVersion: ArchiveFormatVersion,
+		Codec:         cname,
+		Repo:          repo,
+		CommitID:      commitID,
+		Meta:          versions[0].Meta,
+		ExportedAt:    time.Now().UTC(),
+	}
+	for _, u := range units {
+		m.Units = append(m.Units, ArchiveUnit{ID2: unit.ID2{Type: u.Type, Name: u.Name}})
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	mb, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: archiveManifestName, Mode: 0644, Size: int64(len(mb)), ModTime: m.ExportedAt}); err != nil {
+		return nil, err
+	}
+	if _, err := tw.Write(mb); err != nil {
+		return nil, err
+	}
+
+	// writeEntry writes an entry that holds the encoded items. Tar
+	// headers must contain the size of the entry, so the items are
+	// encoded once to compute it and again to write them (instead of
+	// buffering the encoded items).
+	writeEntry := func(name string, items []interface{}) error {
+		var size uint64
+		enc := Codec.NewEncoder(ioutil.Discard)
+		for _, item := range items {
+			n, err := enc.Encode(item)
+			if err != nil {
+				return err
+			}
+			size += n
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(size), ModTime: m.ExportedAt}); err != nil {
+			return err
+		}
+		enc = Codec.NewEncoder(tw)
+		for _, item := range items {
+			if _, err := enc.Encode(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for i, u := range units {
+		unitFilter := ByUnits(unit.ID2{Type: u.Type, Name: u.Name})
+		for _, kind := range archiveUnitKinds {
+			items, err := archiveUnitItems(rs, kind, u, repo, scope, unitFilter)
+			if err != nil {
+				return nil, err
+			}
+			if err := writeEntry(archiveUnitEntry(i, kind), items); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// archiveUnitItems returns the items of source unit u to write in the
+// given kind of archive entry. Only one unit's items of one kind are
+// held in memory at a time.
+func archiveUnitItems(rs RepoStore, kind string, u *unit.SourceUnit, repo string, scope, unitFilter interface {
+	DefFilter
+	RefFilter
+	RelationFilter
+	AnnFilter
+}) ([]interface{}, error) {
+	var items []interface{}
+	switch kind {
+	case "unit":
+		items = append(items, u)
+	case "defs":
+		defs, err := rs.Defs(scope, unitFilter)
+		if err != nil {
+			return nil, err
+		}
+		for _, def := range defs {
+			items = append(items, def)
+		}
+	case "refs":
+		refs, err := rs.Refs(scope, unitFilter)
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range refs {
+			if repo != "" && ref.DefRepo == repo {
+				// Store refs to defs in the same repo the way
+				// stores do, so that they still point to the same
+				// repo if the archive is loaded under another name.
+				refCopy := *ref
+				refCopy.DefRepo = ""
+				ref = &refCopy
+			}
+			items = append(items, ref)
+		}
+	case "relations":
+		if relStore, ok := rs.(RelationStore); ok {
+			rels, err := relStore.Relations(scope, unitFilter)
+			if err != nil {
+				return nil, err
+			}
+			for _, rel := range rels {
+				if repo != "" && rel.To.DefRepo == repo {
+					// See the refs above.
+					relCopy := *rel
+					relCopy.To.DefRepo = ""
+					rel = &relCopy
+				}
+				items = append(items, rel)
+			}
+		}
+	case "anns":
+		if annStore, ok := rs.(AnnStore); ok {
+			anns, err := annStore.Anns(scope, unitFilter)
+			if err != nil {
+				return nil, err
+			}
+			for _, a := range anns {
+				items = append(items, a)
+			}
+		}
+	}
+	return items, nil
+}
+
+// LoadArchive imports the data in an archive written by ExportVersion
+// into s, which must be a RepoImporter or MultiRepoImporter. If repo
+// is non-empty, it overrides the repo recorded in the archive (for
+// MultiRepoImporters). Indexes are built (if s is an indexer) before
+// the version is created. If s supports locking, the version's write
+// lock is held while loading.
+func LoadArchive(r io.Reader, s interface{}, repo string) (*ArchiveManifest, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+
+	// nextEntry returns a reader of the next entry, which must be
+	// named name.
+	nextEntry := func(name string) (io.Reader, error) {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("archive: missing entry %q", name)
+		} else if err != nil {
+			return nil, err
+		}
+		if hdr.Name != name {
+			return nil, fmt.Errorf("archive: got entry %q, want %q", hdr.Name, name)
+		}
+		return tr, nil
+	}
+
+	mr, err := nextEntry(archiveManifestName)
+	if err != nil {
+		return nil, err
+	}
+	mb, err := ioutil.ReadAll(mr)
+	if err != nil {
+		return nil, err
+	}
+	var m ArchiveManifest
+	if err := json.Unmarshal(mb, &m); err != nil {
+		return nil, fmt.Errorf("archive: bad manifest: %s", err)
+	}
+	if m.FormatVersion != ArchiveFormatVersion {
+		return nil, fmt.Errorf("archive: unsupported format version %d (want %d)", m.FormatVersion, ArchiveFormatVersion)
+	}
+	c, err := codecByName(m.Codec)
+	if err != nil {
+		return nil, fmt.Errorf("archive: %s", err)
+	}
+	if repo == "" {
+		repo = m.Repo
+	}
+
+	var importUnit func(*unit.SourceUnit, graph.Output) error
+	var createVersion func() error
+	switch imp := s.(type) {
+	case RepoImporter:
+		importUnit = func(u *unit.SourceUnit, data graph.Output) error { return imp.Import(m.CommitID, u, data) }
+		createVersion = func() error { return imp.CreateVersion(m.CommitID, m.Meta) }
+	case MultiRepoImporter:
+		if repo == "" {
+			return nil, fmt.Errorf("load archive into %s: repo is required (the archive has none)", s)
+		}
+		importUnit = func(u *unit.SourceUnit, data graph.Output) error { return imp.Import(repo, m.CommitID, u, data) }
+		createVersion = func() error { return imp.CreateVersion(repo, m.CommitID, m.Meta) }
+	default:
+		return nil, fmt.Errorf("store (type %T) does not implement importing", s)
+	}
+
+	var unlock func() error
+	switch l := s.(type) {
+	case RepoLocker:
+		unlock, err = l.LockVersion(m.CommitID, 0)
+	case MultiRepoLocker:
+		unlock, err = l.LockVersion(repo, m.CommitID, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if unlock != nil {
+		defer unlock()
+	}
+
+	for i, au := range m.Units {
+		entry := func(kind string) (decoder, error) {
+			r, err := nextEntry(archiveUnitEntry(i, kind))
+			if err != nil {
+				return nil, err
+			}
+			return c.NewDecoder(r), nil
+		}
+		decodeErr := func(kind string, j int, err error) error {
+			return fmt.Errorf("archive: unit %d: %s %d: %s", i, kind, j, err)
+		}
+
+		dec, err := entry("unit")
+		if err != nil {
+			return nil, err
+		}
+		var u unit.SourceUnit
+		if _, err := dec.Decode(&u); err != nil {
+			return nil, fmt.Errorf("archive: unit %d: %s", i, err)
+		}
+		if u.Type != au.Type || u.Name != au.Name {
+			return nil, fmt.Errorf("archive: unit %d is %s %s, but the manifest lists %s %s", i, u.Type, u.Name, au.Type, au.Name)
+		}
+
+		var data graph.Output
+		if dec, err = entry("defs"); err != nil {
+			return nil, err
+		}
+		for j := 0; ; j++ {
+			var def graph.Def
+			if _, err := dec.Decode(&def); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, decodeErr("def", j, err)
+			}
+			data.Defs = append(data.Defs, &def)
+		}
+		if dec, err = entry("refs"); err != nil {
+			return nil, err
+		}
+		for j := 0; ; j++ {
+			var ref graph.Ref
+			if _, err := dec.Decode(&ref); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, decodeErr("ref", j, err)
+			}
+			data.Refs = append(data.Refs, &ref)
+		}
+		if dec, err = entry("relations"); err != nil {
+			return nil, err
+		}
+		for j := 0; ; j++ {
+			var rel graph.Relation
+			if _, err := dec.Decode(&rel); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, decodeErr("relation", j, err)
+			}
+			data.Relations = append(data.Relations, &rel)
+		}
+		if dec, err = entry("anns"); err != nil {
+			return nil, err
+		}
+		for j := 0; ; j++ {
+			var a ann.Ann
+			if _, err := dec.Decode(&a); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, decodeErr("ann", j, err)
+			}
+			data.Anns = append(data.Anns, &a)
+		}
+
+		if err := importUnit(&u, data); err != nil {
+			return nil, err
+		}
+	}
+
+	switch s := s.(type) {
+	case RepoIndexer:
+		if err := s.Index(m.CommitID); err != nil {
+			return nil, err
+		}
+	case MultiRepoIndexer:
+		if err := s.Index(repo, m.CommitID); err != nil {
+			return nil, err
+		}
+	}
+	if err := createVersion(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestArchive_roundTrip(t *testing.T) {
+	src := newMemoryMultiRepoStore()
+	importPagingTestData(t, src)
+
+	var buf bytes.Buffer
+	m, err := ExportVersion(&buf, src, "r1", "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Units) != 2 {
+		t.Errorf("got %d units in manifest, want 2", len(m.Units))
+	}
+
+	for _, dst := range []MultiRepoStoreImporter{newMemoryMultiRepoStore(), NewFSMultiRepoStore(newTestFS(), nil)} {
+		m2, err := LoadArchive(bytes.NewReader(buf.Bytes()), dst, "r3")
+		if err != nil {
+			t.Fatalf("%s: LoadArchive: %s", dst, err)
+		}
+		if m2.CommitID != "c1" || m2.Repo != "r1" {
+			t.Errorf("%s: got manifest version %s@%s, want r1@c1", dst, m2.Repo, m2.CommitID)
+		}
+
+		versions, err := dst.Versions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []*Version{{Repo: "r3", CommitID: "c1"}}; !deepEqual(versions, want) {
+			t.Errorf("%s: Versions(): got %v, want %v", dst, versions, want)
+		}
+
+		wantDefs, err := src.Defs(ByRepoCommitIDs(Version{Repo: "r1", CommitID: "c1"}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, def := range wantDefs {
+			def.Repo = "r3"
+		}
+		defs, err := dst.Defs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !deepEqual(defs, wantDefs) {
+			t.Errorf("%s: Defs(): got defs %v, want %v", dst, defs, wantDefs)
+		}
+
+		wantRefs, err := src.Refs(ByRepoCommitIDs(Version{Repo: "r1", CommitID: "c1"}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, ref := range wantRefs {
+			ref.Repo, ref.DefRepo = "r3", "r3"
+		}
+		refs, err := dst.Refs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !deepEqual(refs, wantRefs) {
+			t.Errorf("%s: Refs(): got refs %v, want %v", dst, refs, wantRefs)
+		}
+
+		wantAnns, err := src.Anns(ByRepoCommitIDs(Version{Repo: "r1", CommitID: "c1"}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(wantAnns) != 2 {
+			t.Fatalf("got %d anns in the source store, want 2", len(wantAnns))
+		}
+		for _, a := range wantAnns {
+			a.Repo = "r3"
+		}
+		anns, err := dst.(AnnStore).Anns()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !deepEqual(anns, wantAnns) {
+			t.Errorf("%s: Anns(): got anns %v, want %v", dst, anns, wantAnns)
+		}
+	}
+}
+
+func TestLoadArchive_invalid(t *testing.T) {
+	if _, err := LoadArchive(bytes.NewReader([]byte("x")), newMemoryMultiRepoStore(), "r"); err == nil {
+		t.Error("got nil error, want non-nil")
+	}
+}
@@ -0,0 +1,371 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/kr/fs"
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// blobsDir is the dir (at the root of an FS-backed multi-repo store)
+// that holds the store's blobs. Its name begins with "." so that
+// DefaultRepoPaths does not treat it as a repo.
+const blobsDir = ".srclib-blobs"
+
+// A blobStore is a content-addressed store of def data (the Data
+// field of graph.Def), shared by all repos in an FS-backed multi-repo
+// store. Consecutive versions of a repo usually have mostly identical
+// defs, so when deduplication is enabled (with the DedupBlobs field of
+// FSMultiRepoStoreConf), each distinct def data payload is stored only
+// once, and each def that has that data refers to it by its hash.
+//
+// Only def data is deduplicated. The def and ref records themselves
+// are still written to each version's data files, because indexes
+// refer to them by their byte offsets in those files; they are small
+// compared to the def data.
+//
+// Blobs are stored in files named "XX/HASH", where HASH is the hex
+// SHA-256 hash of the blob and XX is its first 2 characters.
+type blobStore struct {
+	fs rwvfs.WalkableFileSystem
+
+	// dedup is whether def data written to the store is moved into
+	// the blob store. Existing blob refs are always resolved when
+	// reading, even if dedup is false.
+	dedup bool
+}
+
+func newBlobStore(root rwvfs.FileSystem, dedup bool) *blobStore {
+	return &blobStore{fs: subFS(root, blobsDir), dedup: dedup}
+}
+
+func (s *blobStore) String() string { return fmt.Sprintf("blobStore(%s)", s.fs) }
+
+func blobHash(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func blobPath(hash string) string { return path.Join(hash[:2], hash) }
+
+// put adds data to the store (if it isn't already present) and
+// returns its hash.
+func (s *blobStore) put(data []byte) (hash string, err error) {
+	hash = blobHash(data)
+	name := blobPath(hash)
+
+	// A blob whose size differs from the data's size was only
+	// partially written (e.g., by an import that was interrupted), so
+	// overwrite it.
+	if fi, err := s.fs.Stat(name); err == nil && fi.Size() == int64(len(data)) {
+		return hash, nil
+	} else if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	if err := rwvfs.MkdirAll(s.fs, path.Dir(name)); err != nil {
+		return "", err
+	}
+	// Write to a temporary file and rename it into place, so that
+	// concurrent readers never see a partially written blob.
+	err = createAtomic(s.fs, name, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// get returns the blob with the given hash.
+func (s *blobStore) get(hash string) ([]byte, error) {
+	f, err := s.fs.Open(blobPath(hash))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// A blob ref is stored in place of def data that was moved into the
+// blob store. It is valid JSON, so that the def data remains valid
+// JSON (as required by graph.Def) even if it is not resolved.
+var (
+	blobRefPrefix = []byte(`{"srclib.blob":"`)
+	blobRefSuffix = []byte(`"}`)
+	blobRefLen    = len(blobRefPrefix) + sha256.Size*2 + len(blobRefSuffix)
+)
+
+func blobRef(hash string) []byte {
+	ref := make([]byte, 0, blobRefLen)
+	ref = append(ref, blobRefPrefix...)
+	ref = append(ref, hash...)
+	return append(ref, blobRefSuffix...)
+}
+
+// parseBlobRef returns the hash in data if data is a blob ref.
+func parseBlobRef(data []byte) (hash string, ok bool) {
+	if len(data) != blobRefLen || !bytes.HasPrefix(data, blobRefPrefix) || !bytes.HasSuffix(data, blobRefSuffix) {
+		return "", false
+	}
+	hash = string(data[len(blobRefPrefix) : len(data)-len(blobRefSuffix)])
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", false
+	}
+	return hash, true
+}
+
+// storeDefData moves def's data into the blob store (if dedup is
+// enabled and the data is larger than a blob ref). It returns a copy
+// of def whose data is the blob ref, so that callers' defs are not
+// modified. It is safe to call on a nil *blobStore.
+func (s *blobStore) storeDefData(def *graph.Def) (*graph.Def, error) {
+	if s == nil || !s.dedup || len(def.Data) <= blobRefLen {
+		return def, nil
+	}
+	hash, err := s.put(def.Data)
+	if err != nil {
+		return nil, err
+	}
+	defCopy := *def
+	defCopy.Data = blobRef(hash)
+	return &defCopy, nil
+}
+
+// loadDefData replaces def's data with the blob it refers to, if its
+// data is a blob ref. It is safe to call on a nil *blobStore (in which
+// case it returns an error if def's data is a blob ref).
+func (s *blobStore) loadDefData(def *graph.Def) error {
+	hash, ok := parseBlobRef(def.Data)
+	if !ok {
+		return nil
+	}
+	if s == nil {
+		return fmt.Errorf("def %s data is stored in a blob store, which is only available in multi-repo stores", def.Path)
+	}
+	data, err := s.get(hash)
+	if err != nil {
+		return fmt.Errorf("def %s data blob %s: %s", def.Path, hash, err)
+	}
+	def.Data = data
+	return nil
+}
+
+// BlobStats describes the contents of a store's blob store (see
+// FSMultiRepoStoreConf.DedupBlobs).
+type BlobStats struct {
+	// NumBlobs and Bytes are the number and total size of the blobs
+	// in the store.
+	NumBlobs int
+	Bytes    int64
+
+	// NumRefs is the number of defs whose data is stored in a blob,
+	// and RefBytes is the total size of their data (i.e., how many
+	// bytes their data would occupy without deduplication).
+	NumRefs  int
+	RefBytes int64
+
+	// DedupRatio is RefBytes divided by Bytes (or 0 if there are no
+	// blobs).
+	DedupRatio float64
+}
+
+// A BlobGCResult describes the blobs removed by GCBlobs.
+type BlobGCResult struct {
+	// Removed and RemovedBytes are the number and total size of the
+	// unreferenced blobs that were removed (or, in a dry run, that
+	// would have been removed).
+	Removed      int
+	RemovedBytes int64
+
+	// After describes the blob store after the garbage collection.
+	After BlobStats
+}
+
+// GetBlobStats returns statistics about the blob store of s, which
+// must be an FS-backed multi-repo store. If s has no blobs, the stats
+// are all zero.
+func GetBlobStats(s interface{}) (*BlobStats, error) {
+	mrs, ok := s.(*fsMultiRepoStore)
+	if !ok {
+		return nil, fmt.Errorf("store (type %T) has no blob store", s)
+	}
+	refs, err := mrs.blobRefs()
+	if err != nil {
+		return nil, err
+	}
+	sizes, err := mrs.blobs.list()
+	if err != nil {
+		return nil, err
+	}
+	st := blobStats(refs, sizes)
+	return &st, nil
+}
+
+// GCBlobs removes the blobs in the blob store of s (which must be an
+// FS-backed multi-repo store) that are no longer referenced by any
+// def. If dryRun is true, the blobs that would be removed are only
+// counted.
+//
+// GCBlobs must not run concurrently with imports into the store,
+// because a blob that is written by an import is unreferenced until
+// the import writes the defs that refer to it. GCBlobs holds a
+// store-wide lock while it runs, and LockVersion fails while it is
+// held. If any version in the store is locked (see MultiRepoLocker)
+// or another GCBlobs call holds the store-wide lock, GCBlobs returns a
+// *LockedError without removing any blobs.
+func GCBlobs(s interface{}, dryRun bool) (res *BlobGCResult, err error) {
+	mrs, ok := s.(*fsMultiRepoStore)
+	if !ok {
+		return nil, fmt.Errorf("store (type %T) has no blob store", s)
+	}
+	unlock, holder, err := mrs.gcLock().acquire(0)
+	if err != nil {
+		return nil, err
+	}
+	if holder != nil {
+		return nil, &LockedError{Owner: *holder}
+	}
+	defer func() {
+		if err2 := unlock(); err == nil {
+			err = err2
+		}
+	}()
+	if err := mrs.checkNoLocks(); err != nil {
+		return nil, err
+	}
+
+	refs, err := mrs.blobRefs()
+	if err != nil {
+		return nil, err
+	}
+	sizes, err := mrs.blobs.list()
+	if err != nil {
+		return nil, err
+	}
+
+	res = &BlobGCResult{}
+	for hash, size := range sizes {
+		if _, present := refs[hash]; present {
+			continue
+		}
+		if !dryRun {
+			if err := mrs.blobs.fs.Remove(blobPath(hash)); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+		delete(sizes, hash)
+		res.Removed++
+		res.RemovedBytes += size
+	}
+	res.After = blobStats(refs, sizes)
+	return res, nil
+}
+
+// blobStats computes the stats of a blob store given the number of
+// refs to each blob and the size of each blob.
+func blobStats(refs map[string]int, sizes map[string]int64) BlobStats {
+	var st BlobStats
+	for _, size := range sizes {
+		st.NumBlobs++
+		st.Bytes += size
+	}
+	for hash, n := range refs {
+		st.NumRefs += n
+		st.RefBytes += int64(n) * sizes[hash]
+	}
+	if st.Bytes > 0 {
+		st.DedupRatio = float64(st.RefBytes) / float64(st.Bytes)
+	}
+	return st
+}
+
+// list returns the size of each blob in the store (keyed on its
+// hash).
+func (s *blobStore) list() (map[string]int64, error) {
+	sizes := map[string]int64{}
+	if _, err := s.fs.Stat("."); os.IsNotExist(err) {
+		return sizes, nil
+	}
+	w := fs.WalkFS(".", s.fs)
+	for w.Step() {
+		if err := w.Err(); err != nil {
+			return nil, err
+		}
+		fi := w.Stat()
+		if fi.Mode().IsRegular() {
+			sizes[fi.Name()] = fi.Size()
+		}
+	}
+	return sizes, nil
+}
+
+// blobRefs returns the number of defs (in all versions of all repos)
+// that refer to each blob (keyed on its hash).
+func (s *fsMultiRepoStore) blobRefs() (map[string]int, error) {
+	refs := map[string]int{}
+	err := walkTreeStores(s, "", func(repo, commitID string, ts TreeStore) error {
+		uo, ok := ts.(unitStoreOpener)
+		if !ok {
+			return nil
+		}
+		uss, err := uo.openAllUnitStores()
+		if err != nil && !isStoreNotExist(err) {
+			return err
+		}
+		for _, us := range uss {
+			var fus *fsUnitStore
+			switch us := us.(type) {
+			case *fsUnitStore:
+				fus = us
+			case *indexedUnitStore:
+				fus = us.fsUnitStore
+			default:
+				continue
+			}
+			if err := fus.blobRefs(refs); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return nil
+	})
+	return refs, err
+}
+
+// blobRefs increments the count in refs of each blob that a def in
+// the unit refers to.
+func (s *fsUnitStore) blobRefs(refs map[string]int) (err error) {
+	f, err := s.fs.Open(unitDefsFilename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err2 := f.Close()
+		if err == nil {
+			err = err2
+		}
+	}()
+
+	dec := Codec.NewDecoder(f)
+	for {
+		var def graph.Def
+		if _, err := dec.Decode(&def); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if hash, ok := parseBlobRef(def.Data); ok {
+			refs[hash]++
+		}
+	}
+	return nil
+}
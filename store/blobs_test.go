@@ -0,0 +1,166 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestBlobRef(t *testing.T) {
+	hash := blobHash([]byte("x"))
+	if got, ok := parseBlobRef(blobRef(hash)); !ok || got != hash {
+		t.Errorf("parseBlobRef(blobRef(%q)): got %q, %v", hash, got, ok)
+	}
+	for _, data := range []string{"", `{"a":1}`, `{"srclib.blob":"` + strings.Repeat("z", 64) + `"}`} {
+		if _, ok := parseBlobRef([]byte(data)); ok {
+			t.Errorf("parseBlobRef(%q): got ok, want not a blob ref", data)
+		}
+	}
+}
+
+func TestBlobStore_put(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "srclib-blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := os.Mkdir(filepath.Join(tmpDir, blobsDir), 0700); err != nil {
+		t.Fatal(err)
+	}
+	s := newBlobStore(OSFS(tmpDir), true)
+
+	data := []byte("data")
+	hash, err := s.put(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.get(hash); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(got, data) {
+		t.Errorf("get: got %q, want %q", got, data)
+	}
+
+	// Blobs are written to temporary files that are renamed into
+	// place; none should be left behind.
+	sizes, err := s.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int64{hash: int64(len(data))}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("list: got %v, want %v", sizes, want)
+	}
+}
+
+func TestFSMultiRepoStore_dedupBlobs(t *testing.T) {
+	for _, indexed := range []bool{false, true} {
+		useIndexedStore = indexed
+		mrs := NewFSMultiRepoStore(newTestFS(), &FSMultiRepoStoreConf{DedupBlobs: true})
+
+		u := &unit.SourceUnit{Key: unit.Key{Type: "t", Name: "u"}, Info: unit.Info{Files: []string{"f"}}}
+		bigData := func(s string) []byte { return []byte(`"` + strings.Repeat(s, 200) + `"`) }
+		importVersion := func(commitID string, data []byte) {
+			defs := []*graph.Def{
+				{DefKey: graph.DefKey{Path: "p"}, Data: data},
+				{DefKey: graph.DefKey{Path: "q"}, Data: []byte(`"small"`)},
+			}
+			if err := mrs.Import("r", commitID, u, graph.Output{Defs: defs}); err != nil {
+				t.Fatal(err)
+			}
+			if err := mrs.Index("r", commitID); err != nil {
+				t.Fatal(err)
+			}
+			if err := mrs.CreateVersion("r", commitID, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		checkBlobStats := func(label string, want BlobStats) {
+			st, err := GetBlobStats(mrs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *st != want {
+				t.Errorf("indexed=%v: %s: got blob stats %+v, want %+v", indexed, label, st, want)
+			}
+		}
+
+		importVersion("c1", bigData("a"))
+		importVersion("c2", bigData("a"))
+		size := int64(len(bigData("a")))
+		checkBlobStats("after import", BlobStats{NumBlobs: 1, Bytes: size, NumRefs: 2, RefBytes: 2 * size, DedupRatio: 2})
+
+		for _, f := range [][]DefFilter{nil, {ByDefPath("p")}} {
+			defs, err := mrs.Defs(f...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, def := range defs {
+				if def.Path == "p" && string(def.Data) != string(bigData("a")) {
+					t.Errorf("indexed=%v: Defs(%v): got def %s data %q, want the original data", indexed, f, def.DefKey, def.Data)
+				}
+			}
+		}
+
+		// Reimport both versions with different data, so that the
+		// original blob is no longer referenced.
+		importVersion("c1", bigData("b"))
+		importVersion("c2", bigData("b"))
+
+		res, err := GCBlobs(mrs, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Removed != 1 || res.RemovedBytes != size {
+			t.Errorf("indexed=%v: GCBlobs dry run: got %+v, want 1 blob (%d bytes) removed", indexed, res, size)
+		}
+		checkBlobStats("after GC dry run", BlobStats{NumBlobs: 2, Bytes: 2 * size, NumRefs: 2, RefBytes: 2 * size, DedupRatio: 1})
+
+		res, err = GCBlobs(mrs, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Removed != 1 {
+			t.Errorf("indexed=%v: GCBlobs: got %+v, want 1 blob removed", indexed, res)
+		}
+		checkBlobStats("after GC", BlobStats{NumBlobs: 1, Bytes: size, NumRefs: 2, RefBytes: 2 * size, DedupRatio: 2})
+
+		// GC must not run while a version is locked.
+		unlock, err := mrs.(MultiRepoLocker).LockVersion("r", "c3", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := GCBlobs(mrs, false); !IsLocked(err) {
+			t.Errorf("indexed=%v: GCBlobs with a locked version: got error %v, want locked", indexed, err)
+		}
+		if err := unlock(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Versions can't be locked while GC holds the store-wide lock.
+		unlockGC, holder, err := mrs.(*fsMultiRepoStore).gcLock().acquire(0)
+		if err != nil || holder != nil {
+			t.Fatalf("acquiring GC lock: got holder %v, error %v", holder, err)
+		}
+		if _, err := mrs.(MultiRepoLocker).LockVersion("r", "c3", 0); !IsLocked(err) {
+			t.Errorf("indexed=%v: LockVersion during GC: got error %v, want locked", indexed, err)
+		}
+		if _, err := GCBlobs(mrs, false); !IsLocked(err) {
+			t.Errorf("indexed=%v: GCBlobs during GC: got error %v, want locked", indexed, err)
+		}
+		if err := unlockGC(); err != nil {
+			t.Fatal(err)
+		}
+		unlock, err = mrs.(MultiRepoLocker).LockVersion("r", "c3", 0)
+		if err != nil {
+			t.Fatalf("indexed=%v: LockVersion after GC: %s", indexed, err)
+		}
+		if err := unlock(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -25,7 +25,7 @@ type defRefUnitsIndex struct {
 
 var _ interface {
 	Index
-	persistedIndex
+	mmapIndex
 	unitRefIndexBuilder
 	unitIndex
 } = (*defRefUnitsIndex)(nil)
@@ -155,6 +155,16 @@ func (x *defRefUnitsIndex) Write(w io.Writer) error {
 	return x.phtable.Write(w)
 }
 
+// WriteMappable implements mmapIndex.
+func (x *defRefUnitsIndex) WriteMappable(w io.Writer) error {
+	x.RLock()
+	defer x.RUnlock()
+	if x.phtable == nil {
+		panic("no phtable to write")
+	}
+	return x.phtable.WriteMappable(w)
+}
+
 // Read implements persistedIndex.
 func (x *defRefUnitsIndex) Read(r io.Reader) error {
 	phtable, err := phtable.Read(r)
@@ -165,6 +175,16 @@ func (x *defRefUnitsIndex) Read(r io.Reader) error {
 	return err
 }
 
+// Mmap implements mmapIndex.
+func (x *defRefUnitsIndex) Mmap(b []byte, release func()) error {
+	phtable, err := phtable.Mapped(b, false, release)
+	x.Lock()
+	defer x.Unlock()
+	x.phtable = phtable
+	x.ready = (err == nil)
+	return err
+}
+
 // Ready implements persistedIndex.
 func (x *defRefUnitsIndex) Ready() bool {
 	x.RLock()
@@ -21,7 +21,7 @@ type defRefsIndex struct {
 
 var _ interface {
 	Index
-	persistedIndex
+	mmapIndex
 	refIndexByteOffsets
 	refIndexBuilder
 } = (*defRefsIndex)(nil)
@@ -129,6 +129,16 @@ func (x *defRefsIndex) Write(w io.Writer) error {
 	return x.phtable.Write(w)
 }
 
+// WriteMappable implements mmapIndex.
+func (x *defRefsIndex) WriteMappable(w io.Writer) error {
+	x.RLock()
+	defer x.RUnlock()
+	if x.phtable == nil {
+		panic("no phtable to write")
+	}
+	return x.phtable.WriteMappable(w)
+}
+
 // Read implements persistedIndex.
 func (x *defRefsIndex) Read(r io.Reader) error {
 	phtable, err := phtable.Read(r)
@@ -139,6 +149,16 @@ func (x *defRefsIndex) Read(r io.Reader) error {
 	return err
 }
 
+// Mmap implements mmapIndex.
+func (x *defRefsIndex) Mmap(b []byte, release func()) error {
+	phtable, err := phtable.Mapped(b, false, release)
+	x.Lock()
+	defer x.Unlock()
+	x.phtable = phtable
+	x.ready = (err == nil)
+	return err
+}
+
 // Ready implements persistedIndex.
 func (x *defRefsIndex) Ready() bool {
 	x.RLock()
@@ -10,6 +10,7 @@ import (
 
 	"sort"
 
+	"sourcegraph.com/sourcegraph/srclib/ann"
 	"sourcegraph.com/sourcegraph/srclib/graph"
 	"sourcegraph.com/sourcegraph/srclib/unit"
 )
@@ -77,6 +78,40 @@ type RefFilterFunc func(*graph.Ref) bool
 func (f RefFilterFunc) SelectRef(ref *graph.Ref) bool { return f(ref) }
 func (f RefFilterFunc) String() string                { return "RefFilterFunc" }
 
+// A RelationFilter filters a set of relations to only those for which
+// SelectRelation returns true.
+type RelationFilter interface {
+	SelectRelation(*graph.Relation) bool
+}
+
+type relationFilters []RelationFilter
+
+func (fs relationFilters) SelectRelation(rel *graph.Relation) bool {
+	for _, f := range fs {
+		if !f.SelectRelation(rel) {
+			return false
+		}
+	}
+	return true
+}
+
+// An AnnFilter filters a set of annotations to only those for which
+// SelectAnn returns true.
+type AnnFilter interface {
+	SelectAnn(*ann.Ann) bool
+}
+
+type annFilters []AnnFilter
+
+func (fs annFilters) SelectAnn(a *ann.Ann) bool {
+	for _, f := range fs {
+		if !f.SelectAnn(a) {
+			return false
+		}
+	}
+	return true
+}
+
 // A UnitFilter filters a set of units to only those for which Select
 // returns true.
 type UnitFilter interface {
@@ -127,6 +162,72 @@ type VersionFilterFunc func(*Version) bool
 func (f VersionFilterFunc) SelectVersion(version *Version) bool { return f(version) }
 func (f VersionFilterFunc) String() string                      { return "VersionFilterFunc" }
 
+// A VersionsSelector is a VersionFilter that also selects among all
+// of the versions that the other filters matched (e.g., to return
+// only the newest versions). Stores call SelectVersions after
+// applying SelectVersion to each version.
+type VersionsSelector interface {
+	VersionFilter
+	SelectVersions([]*Version) []*Version
+}
+
+// selectVersions applies the VersionsSelectors in fs to versions.
+func selectVersions(versions []*Version, fs []VersionFilter) []*Version {
+	for _, f := range fs {
+		if s, ok := f.(VersionsSelector); ok {
+			versions = s.SelectVersions(versions)
+		}
+	}
+	return versions
+}
+
+// ByBranch returns a filter that selects versions whose metadata
+// records that they were imported from the given branch.
+func ByBranch(branch string) VersionFilter {
+	return byBranchFilter(branch)
+}
+
+type byBranchFilter string
+
+func (f byBranchFilter) String() string { return fmt.Sprintf("ByBranch(%s)", string(f)) }
+func (f byBranchFilter) SelectVersion(version *Version) bool {
+	return version.Meta != nil && version.Meta.Branch == string(f)
+}
+
+// NewestVersions returns a filter that selects the n most recently
+// imported versions (by their metadata's ImportedAt), newest first.
+// Versions with no metadata are considered the oldest. If n is
+// negative, no versions are selected.
+func NewestVersions(n int) VersionsSelector {
+	if n < 0 {
+		n = 0
+	}
+	return newestVersionsFilter(n)
+}
+
+type newestVersionsFilter int
+
+func (f newestVersionsFilter) String() string                      { return fmt.Sprintf("NewestVersions(%d)", int(f)) }
+func (f newestVersionsFilter) SelectVersion(version *Version) bool { return true }
+func (f newestVersionsFilter) SelectVersions(versions []*Version) []*Version {
+	sort.Stable(versionsByNewest(versions))
+	if len(versions) > int(f) {
+		versions = versions[:int(f)]
+	}
+	return versions
+}
+
+type versionsByNewest []*Version
+
+func (v versionsByNewest) Len() int      { return len(v) }
+func (v versionsByNewest) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v versionsByNewest) Less(i, j int) bool {
+	if v[j].Meta == nil {
+		return v[i].Meta != nil
+	}
+	return v[i].Meta != nil && v[i].Meta.ImportedAt.After(v[j].Meta.ImportedAt)
+}
+
 // A RepoFilter filters a set of repos to only those for which SelectRepo
 // returns true.
 type RepoFilter interface {
@@ -166,6 +267,8 @@ type ByUnitsFilter interface {
 func ByUnits(units ...unit.ID2) interface {
 	DefFilter
 	RefFilter
+	RelationFilter
+	AnnFilter
 	UnitFilter
 	ByUnitsFilter
 } {
@@ -199,6 +302,12 @@ func (f byUnitsFilter) SelectDef(def *graph.Def) bool {
 func (f byUnitsFilter) SelectRef(ref *graph.Ref) bool {
 	return (ref.Unit == "" && ref.UnitType == "") || f.contains(unit.ID2{Type: ref.UnitType, Name: ref.Unit})
 }
+func (f byUnitsFilter) SelectRelation(rel *graph.Relation) bool {
+	return (rel.From.Unit == "" && rel.From.UnitType == "") || f.contains(unit.ID2{Type: rel.From.UnitType, Name: rel.From.Unit})
+}
+func (f byUnitsFilter) SelectAnn(a *ann.Ann) bool {
+	return (a.Unit == "" && a.UnitType == "") || f.contains(unit.ID2{Type: a.UnitType, Name: a.Unit})
+}
 func (f byUnitsFilter) SelectUnit(unit *unit.SourceUnit) bool {
 	return (unit.Type == "" && unit.Name == "") || f.contains(unit.ID2())
 }
@@ -216,6 +325,8 @@ type ByCommitIDsFilter interface {
 func ByCommitIDs(commitIDs ...string) interface {
 	DefFilter
 	RefFilter
+	RelationFilter
+	AnnFilter
 	UnitFilter
 	VersionFilter
 	ByCommitIDsFilter
@@ -246,6 +357,12 @@ func (f byCommitIDsFilter) SelectDef(def *graph.Def) bool {
 func (f byCommitIDsFilter) SelectRef(ref *graph.Ref) bool {
 	return ref.CommitID == "" || f.contains(ref.CommitID)
 }
+func (f byCommitIDsFilter) SelectRelation(rel *graph.Relation) bool {
+	return rel.From.CommitID == "" || f.contains(rel.From.CommitID)
+}
+func (f byCommitIDsFilter) SelectAnn(a *ann.Ann) bool {
+	return a.CommitID == "" || f.contains(a.CommitID)
+}
 func (f byCommitIDsFilter) SelectUnit(unit *unit.SourceUnit) bool {
 	return unit.CommitID == "" || f.contains(unit.CommitID)
 }
@@ -266,6 +383,8 @@ type ByReposFilter interface {
 func ByRepos(repos ...string) interface {
 	DefFilter
 	RefFilter
+	RelationFilter
+	AnnFilter
 	UnitFilter
 	VersionFilter
 	RepoFilter
@@ -297,6 +416,12 @@ func (f byReposFilter) SelectDef(def *graph.Def) bool {
 func (f byReposFilter) SelectRef(ref *graph.Ref) bool {
 	return ref.Repo == "" || f.contains(ref.Repo)
 }
+func (f byReposFilter) SelectRelation(rel *graph.Relation) bool {
+	return rel.From.Repo == "" || f.contains(rel.From.Repo)
+}
+func (f byReposFilter) SelectAnn(a *ann.Ann) bool {
+	return a.Repo == "" || f.contains(a.Repo)
+}
 func (f byReposFilter) SelectUnit(unit *unit.SourceUnit) bool {
 	return unit.Repo == "" || f.contains(unit.Repo)
 }
@@ -309,7 +434,7 @@ func (f byReposFilter) SelectRepo(repo string) bool {
 
 // ByRepoCommitIDsFilter is implemented by filters that restrict their
 // selections to items in a set of repositories (and in each
-// repository, to a specific version). It allows the store to optimize
+// repository, to a specific set of versions). It allows the store to optimize
 // calls by skipping data that it knows is not in any of the specified
 // repository versions.
 type ByRepoCommitIDsFilter interface {
@@ -321,6 +446,8 @@ type ByRepoCommitIDsFilter interface {
 func ByRepoCommitIDs(versions ...Version) interface {
 	DefFilter
 	RefFilter
+	RelationFilter
+	AnnFilter
 	UnitFilter
 	VersionFilter
 	RepoFilter
@@ -365,6 +492,12 @@ func (f byRepoCommitIDsFilter) SelectDef(def *graph.Def) bool {
 func (f byRepoCommitIDsFilter) SelectRef(ref *graph.Ref) bool {
 	return (ref.Repo == "" && ref.CommitID == "") || f.contains(ref.Repo, ref.CommitID)
 }
+func (f byRepoCommitIDsFilter) SelectRelation(rel *graph.Relation) bool {
+	return (rel.From.Repo == "" && rel.From.CommitID == "") || f.contains(rel.From.Repo, rel.From.CommitID)
+}
+func (f byRepoCommitIDsFilter) SelectAnn(a *ann.Ann) bool {
+	return (a.Repo == "" && a.CommitID == "") || f.contains(a.Repo, a.CommitID)
+}
 func (f byRepoCommitIDsFilter) SelectUnit(unit *unit.SourceUnit) bool {
 	return (unit.Repo == "" && unit.CommitID == "") || f.contains(unit.Repo, unit.CommitID)
 }
@@ -386,6 +519,8 @@ func (f byRepoCommitIDsFilter) SelectRepo(repo string) bool {
 func ByUnitKey(key unit.Key) interface {
 	DefFilter
 	RefFilter
+	RelationFilter
+	AnnFilter
 	UnitFilter
 	ByReposFilter
 	ByCommitIDsFilter
@@ -420,6 +555,14 @@ func (f byUnitKeyFilter) SelectRef(ref *graph.Ref) bool {
 	return (ref.Repo == "" || ref.Repo == f.key.Repo) && (ref.CommitID == "" || ref.CommitID == f.key.CommitID) &&
 		(ref.UnitType == "" || ref.UnitType == f.key.Type) && (ref.Unit == "" || ref.Unit == f.key.Name)
 }
+func (f byUnitKeyFilter) SelectRelation(rel *graph.Relation) bool {
+	return (rel.From.Repo == "" || rel.From.Repo == f.key.Repo) && (rel.From.CommitID == "" || rel.From.CommitID == f.key.CommitID) &&
+		(rel.From.UnitType == "" || rel.From.UnitType == f.key.Type) && (rel.From.Unit == "" || rel.From.Unit == f.key.Name)
+}
+func (f byUnitKeyFilter) SelectAnn(a *ann.Ann) bool {
+	return (a.Repo == "" || a.Repo == f.key.Repo) && (a.CommitID == "" || a.CommitID == f.key.CommitID) &&
+		(a.UnitType == "" || a.UnitType == f.key.Type) && (a.Unit == "" || a.Unit == f.key.Name)
+}
 func (f byUnitKeyFilter) SelectUnit(unit *unit.SourceUnit) bool {
 	return (unit.Repo == "" || unit.Repo == f.key.Repo) && (unit.CommitID == "" || unit.CommitID == f.key.CommitID) &&
 		(unit.Type == "" || unit.Type == f.key.Type) && (unit.Name == "" || unit.Name == f.key.Name)
@@ -544,6 +687,182 @@ func (f *byRefDefFilter) withEmptyImpliedValues() graph.RefDefKey {
 var _ impliedRepoSetter = (*byRefDefFilter)(nil)
 var _ impliedUnitSetter = (*byRefDefFilter)(nil)
 
+// ByRefKindFilter is implemented by filters that restrict their
+// selection to refs of specific kinds.
+type ByRefKindFilter interface {
+	ByRefKinds() []string
+}
+
+// ByRefKind returns a filter that selects refs whose Kind (see the
+// graph.RefKind* constants) is one of kinds. The empty kind selects
+// refs whose kind is unknown. It panics if kinds is empty or contains
+// an invalid kind.
+func ByRefKind(kinds ...string) interface {
+	RefFilter
+	ByRefKindFilter
+} {
+	if len(kinds) == 0 {
+		panic("ByRefKind: no kinds")
+	}
+	for _, kind := range kinds {
+		if !graph.IsValidRefKind(kind) {
+			panic(fmt.Sprintf("ByRefKind: invalid kind %q", kind))
+		}
+	}
+	return byRefKindFilter(kinds)
+}
+
+type byRefKindFilter []string
+
+func (f byRefKindFilter) String() string       { return fmt.Sprintf("ByRefKind(%v)", []string(f)) }
+func (f byRefKindFilter) ByRefKinds() []string { return f }
+func (f byRefKindFilter) SelectRef(ref *graph.Ref) bool {
+	for _, kind := range f {
+		if ref.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// ByRelationFrom returns a filter that selects relations from the
+// given def (e.g., to list the def's supertypes). It panics if the def
+// path is not set. Like ByDefKey, it restricts the query to the
+// key's repo, commit ID, and source unit, which must all be set for
+// the filter to match any relations in a multi-repo store.
+func ByRelationFrom(key graph.DefKey) interface {
+	RelationFilter
+	ByReposFilter
+	ByCommitIDsFilter
+	ByUnitsFilter
+} {
+	if key.Path == "" {
+		panic("key.Path: empty")
+	}
+	return byRelationFromFilter{key}
+}
+
+type byRelationFromFilter struct{ key graph.DefKey }
+
+func (f byRelationFromFilter) String() string        { return fmt.Sprintf("ByRelationFrom(%+v)", f.key) }
+func (f byRelationFromFilter) ByRepos() []string     { return []string{f.key.Repo} }
+func (f byRelationFromFilter) ByCommitIDs() []string { return []string{f.key.CommitID} }
+func (f byRelationFromFilter) ByUnits() []unit.ID2 {
+	return []unit.ID2{{Type: f.key.UnitType, Name: f.key.Unit}}
+}
+func (f byRelationFromFilter) SelectRelation(rel *graph.Relation) bool {
+	from := rel.From
+	return (from.Repo == "" || from.Repo == f.key.Repo) && (from.CommitID == "" || from.CommitID == f.key.CommitID) &&
+		(from.UnitType == "" || from.UnitType == f.key.UnitType) && (from.Unit == "" || from.Unit == f.key.Unit) &&
+		from.Path == f.key.Path
+}
+
+// ByRelationToFilter is implemented by filters that restrict their
+// selection to relations to a specific def.
+type ByRelationToFilter interface {
+	ByRelationTo() graph.RefDefKey
+
+	withEmptyImpliedValues() graph.RefDefKey // see byRefDefFilter.withEmptyImpliedValues
+}
+
+// ByRelationTo returns a filter that selects relations to the given
+// def (e.g., to list the def's subtypes or implementers). It panics if
+// def.DefPath is empty. If other fields are empty, they are assumed to
+// match any value.
+//
+// Like a ByRefDef filter, it can't be used to narrow the scope of a
+// query, since relations to a def may be stored in any repo, commit,
+// or source unit.
+func ByRelationTo(def graph.RefDefKey) interface {
+	RelationFilter
+	ByRelationToFilter
+} {
+	if def.DefPath == "" {
+		panic("def.DefPath: empty")
+	}
+	return &byRelationToFilter{def: def}
+}
+
+type byRelationToFilter struct {
+	def graph.RefDefKey
+
+	// These fields hold the repo and source unit that the filter is
+	// being applied to, because relations' To.DefRepo and
+	// To.DefUnit{,Type} fields are empty if they are the same as the
+	// relation's own repo and source unit (see byRefDefFilter).
+	impliedRepo string
+	impliedUnit unit.ID2
+}
+
+func (f *byRelationToFilter) String() string {
+	return fmt.Sprintf("ByRelationTo(%+v, impliedRepo=%q, impliedUnit=%+v)", f.def, f.impliedRepo, f.impliedUnit)
+}
+func (f *byRelationToFilter) ByRelationTo() graph.RefDefKey { return f.def }
+func (f *byRelationToFilter) withImpliedRelationRepo(repo string) RelationFilter {
+	newF := *f
+	newF.impliedRepo = repo
+	return &newF
+}
+func (f *byRelationToFilter) withImpliedRelationUnit(u unit.ID2) RelationFilter {
+	newF := *f
+	newF.impliedUnit = u
+	return &newF
+}
+func (f *byRelationToFilter) SelectRelation(rel *graph.Relation) bool {
+	to := rel.To
+	return (f.def.DefRepo == "" || (to.DefRepo == "" && f.impliedRepo == f.def.DefRepo) || to.DefRepo == f.def.DefRepo) &&
+		(f.def.DefUnitType == "" || (to.DefUnitType == "" && f.impliedUnit.Type == f.def.DefUnitType) || to.DefUnitType == f.def.DefUnitType) &&
+		(f.def.DefUnit == "" || (to.DefUnit == "" && f.impliedUnit.Name == f.def.DefUnit) || to.DefUnit == f.def.DefUnit) &&
+		to.DefPath == f.def.DefPath
+}
+
+// withEmptyImpliedValues returns the RefDefKey with empty field
+// values for fields whose value in f.def matches the implied value
+// (which is how they are stored in the source unit's relation data).
+func (f *byRelationToFilter) withEmptyImpliedValues() graph.RefDefKey {
+	def := graph.RefDefKey{DefPath: f.def.DefPath}
+	if f.def.DefRepo != f.impliedRepo {
+		def.DefRepo = f.def.DefRepo
+	}
+	if f.def.DefUnitType != f.impliedUnit.Type {
+		def.DefUnitType = f.def.DefUnitType
+	}
+	if f.def.DefUnit != f.impliedUnit.Name {
+		def.DefUnit = f.def.DefUnit
+	}
+	return def
+}
+
+// ByRelationKinds returns a filter that selects relations whose Kind
+// (see the graph.Relation* constants) is one of kinds. It panics if
+// kinds is empty or contains an invalid kind.
+func ByRelationKinds(kinds ...string) RelationFilter {
+	if len(kinds) == 0 {
+		panic("ByRelationKinds: no kinds")
+	}
+	for _, kind := range kinds {
+		if !graph.IsValidRelationKind(kind) {
+			panic(fmt.Sprintf("ByRelationKinds: invalid kind %q", kind))
+		}
+	}
+	return byRelationKindsFilter(kinds)
+}
+
+type byRelationKindsFilter []string
+
+func (f byRelationKindsFilter) String() string {
+	return fmt.Sprintf("ByRelationKinds(%v)", []string(f))
+}
+
+func (f byRelationKindsFilter) SelectRelation(rel *graph.Relation) bool {
+	for _, kind := range f {
+		if rel.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
 // An AbsRefFilterFunc creates a RefFilter that selects only those
 // refs for which the func returns true. Unlike RefFilterFunc, the
 // ref's Def{Repo,UnitType,Unit,Path}, Repo, and CommitID fields are
@@ -764,6 +1083,11 @@ func (f byFilesFilter) SelectUnit(unit *unit.SourceUnit) bool {
 // and then those would count toward the limit for this filter but
 // would never get returned. We could guarantee that Limit always runs
 // last (after all other filters have accepted something).
+//
+// When combined with an After filter, Limit is exact: the store
+// returns the first limit results (after skipping offset results) in
+// sort order. Use After instead of a large offset to page through
+// results.
 func Limit(limit, offset int) interface {
 	DefFilter
 	RefFilter
@@ -913,3 +1237,14 @@ func (ds DefsSortByKey) SelectDef(def *graph.Def) bool {
 type DefsSorter interface {
 	DefsSort(defs []*graph.Def)
 }
+
+// hasDefsSorter returns whether any of the filters is a DefsSorter
+// (in which case defs should be returned in the order it specifies).
+func hasDefsSorter(fs []DefFilter) bool {
+	for _, f := range fs {
+		if _, ok := f.(DefsSorter); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -2,8 +2,10 @@ package store
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
@@ -37,6 +39,8 @@ type fsMultiRepoStore struct {
 	fs rwvfs.WalkableFileSystem
 	FSMultiRepoStoreConf
 	repoStores
+
+	blobs *blobStore
 }
 
 var _ MultiRepoStoreImporterIndexer = (*fsMultiRepoStore)(nil)
@@ -54,6 +58,7 @@ func NewFSMultiRepoStore(fs rwvfs.WalkableFileSystem, conf *FSMultiRepoStoreConf
 	setCreateParentDirs(fs)
 	mrs := &fsMultiRepoStore{fs: fs, FSMultiRepoStoreConf: *conf}
 	mrs.repoStores = repoStores{mrs}
+	mrs.blobs = newBlobStore(fs, conf.DedupBlobs)
 	return mrs
 }
 
@@ -63,8 +68,20 @@ func NewFSMultiRepoStore(fs rwvfs.WalkableFileSystem, conf *FSMultiRepoStoreConf
 type FSMultiRepoStoreConf struct {
 	// RepoPathConfig specifies where the multi-repo store stores
 	// repository data. If nil, DefaultRepoPaths is used, which stores
-	// repos at "${REPO}/.srclib-store".
+	// repos at "${REPO}/.srclib-store". Stores with many repos should
+	// use HashedRepoPaths.
+	//
+	// If it is a RepoPathsRecorder, the store adds each new repo to
+	// its listing.
 	RepoPaths
+
+	// DedupBlobs is whether def data (the Data field of graph.Def)
+	// is stored once in a content-addressed blob store shared by all
+	// repos and versions, instead of in each version's def data file.
+	// It only affects imports; defs whose data was stored in the blob
+	// store are always readable. Use GCBlobs to remove blobs that are
+	// no longer referenced.
+	DedupBlobs bool
 }
 
 // getRepo gets a single repo.
@@ -149,7 +166,9 @@ func (s *fsMultiRepoStore) Repos(f ...RepoFilter) ([]string, error) {
 
 func (s *fsMultiRepoStore) openRepoStore(repo string) RepoStore {
 	subpath := s.fs.Join(s.RepoToPath(repo)...)
-	return NewFSRepoStore(rwvfs.Walkable(rwvfs.Sub(s.fs, subpath)))
+	rs := NewFSRepoStore(subFS(s.fs, subpath)).(*fsRepoStore)
+	rs.blobs = s.blobs
+	return rs
 }
 
 func (s *fsMultiRepoStore) openAllRepoStores() (map[string]RepoStore, error) {
@@ -171,15 +190,28 @@ func (s *fsMultiRepoStore) Import(repo, commitID string, unit *unit.SourceUnit,
 	if unit != nil {
 		cleanForImport(&data, repo, unit.Type, unit.Name)
 	}
-	subpath := s.fs.Join(s.RepoToPath(repo)...)
-	if err := rwvfs.MkdirAll(s.fs, subpath); err != nil {
+	if err := s.createRepoDir(repo); err != nil {
 		return err
 	}
 	return s.openRepoStore(repo).(RepoImporter).Import(commitID, unit, data)
 }
 
-func (s *fsMultiRepoStore) CreateVersion(repo, commitID string) error {
-	return s.openRepoStore(repo).(RepoImporter).CreateVersion(commitID)
+// createRepoDir creates the dir that holds the repo's data (if it
+// doesn't already exist).
+func (s *fsMultiRepoStore) createRepoDir(repo string) error {
+	subpath := s.fs.Join(s.RepoToPath(repo)...)
+	if rec, ok := s.RepoPaths.(RepoPathsRecorder); ok {
+		if _, err := s.fs.Stat(subpath); os.IsNotExist(err) {
+			if err := rec.AddRepo(s.fs, repo); err != nil {
+				return err
+			}
+		}
+	}
+	return rwvfs.MkdirAll(s.fs, subpath)
+}
+
+func (s *fsMultiRepoStore) CreateVersion(repo, commitID string, meta *VersionMeta) error {
+	return s.openRepoStore(repo).(RepoImporter).CreateVersion(commitID, meta)
 }
 
 func (s *fsMultiRepoStore) Index(repo, commitID string) error {
@@ -196,6 +228,8 @@ func (s *fsMultiRepoStore) String() string { return "fsMultiRepoStore" }
 type fsRepoStore struct {
 	fs rwvfs.WalkableFileSystem
 	treeStores
+
+	blobs *blobStore // blob store of the multi-repo store (if any)
 }
 
 // SrclibStoreDir is the name of the directory under which a RepoStore's data is stored.
@@ -219,11 +253,39 @@ func (s *fsRepoStore) Versions(f ...VersionFilter) ([]*Version, error) {
 	var versions []*Version
 	for _, v := range allVersions {
 		version := &Version{CommitID: path.Base(v)}
+		version.Meta, err = s.readVersionMeta(version.CommitID)
+		if err != nil {
+			return nil, err
+		}
 		if versionFilters(f).SelectVersion(version) {
 			versions = append(versions, version)
 		}
 	}
-	return versions, nil
+	return selectVersions(versions, f), nil
+}
+
+// readVersionMeta reads the metadata stored in the version file for
+// the given commit. Version files that were created without metadata
+// (or before metadata was supported) are empty, and a nil
+// *VersionMeta is returned for them.
+func (s *fsRepoStore) readVersionMeta(commitID string) (*VersionMeta, error) {
+	f, err := s.fs.Open(s.fs.Join(versionsDir, commitID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if err != nil || len(b) == 0 {
+		return nil, err
+	}
+	var meta VersionMeta
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return nil, fmt.Errorf("version %s: invalid metadata: %s", commitID, err)
+	}
+	return &meta, nil
 }
 
 const (
@@ -294,7 +356,7 @@ func (s *fsRepoStore) listAllVersions_old() ([]string, error) {
 	}
 	dirs := make([]string, 0, len(entries))
 	for _, e := range entries {
-		if e.Name() == versionsDir {
+		if e.Name() == versionsDir || e.Name() == locksDir {
 			continue
 		}
 		dirs = append(dirs, e.Name())
@@ -303,6 +365,9 @@ func (s *fsRepoStore) listAllVersions_old() ([]string, error) {
 }
 
 func (s *fsRepoStore) Import(commitID string, unit *unit.SourceUnit, data graph.Output) error {
+	if err := s.checkLock(commitID); err != nil {
+		return err
+	}
 	if unit != nil {
 		cleanForImport(&data, "", unit.Type, unit.Name)
 	}
@@ -313,19 +378,36 @@ func (s *fsRepoStore) Import(commitID string, unit *unit.SourceUnit, data graph.
 	return nil
 }
 
-func (s *fsRepoStore) CreateVersion(commitID string) error {
+func (s *fsRepoStore) CreateVersion(commitID string, meta *VersionMeta) error {
+	if err := s.checkLock(commitID); err != nil {
+		return err
+	}
 	if err := s.fs.Mkdir(versionsDir); err != nil && !os.IsExist(err) {
 		return err
 	}
+	var data []byte
+	if meta != nil {
+		var err error
+		data, err = json.Marshal(meta)
+		if err != nil {
+			return err
+		}
+	}
 	f, err := s.fs.Create(s.fs.Join(versionsDir, commitID))
 	if err != nil {
 		return err
 	}
-	f.Write(nil)
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
 	return f.Close()
 }
 
 func (s *fsRepoStore) Index(commitID string) error {
+	if err := s.checkLock(commitID); err != nil {
+		return err
+	}
 	if xs, ok := s.newTreeStore(commitID).(*indexedTreeStore); ok {
 		return xs.Index()
 	}
@@ -333,16 +415,23 @@ func (s *fsRepoStore) Index(commitID string) error {
 }
 
 func (s *fsRepoStore) treeStoreFS(commitID string) rwvfs.FileSystem {
-	return rwvfs.Sub(s.fs, commitID)
+	return subFS(s.fs, commitID)
 }
 
 func (s *fsRepoStore) newTreeStore(commitID string) TreeStoreImporter {
 	fs := s.treeStoreFS(commitID)
 	if useIndexedStore {
+		// The cache key identifies the filesystem and the path of the
+		// tree store, so all stores over the same files share cached
+		// indexes. Import and Index invalidate them.
 		cacheKey := fs.String()
-		return newIndexedTreeStore(fs, cacheKey)
+		ts := newIndexedTreeStore(fs, cacheKey)
+		ts.(*indexedTreeStore).blobs = s.blobs
+		return ts
 	}
-	return newFSTreeStore(fs)
+	ts := newFSTreeStore(fs)
+	ts.blobs = s.blobs
+	return ts
 }
 
 func (s *fsRepoStore) openTreeStore(commitID string) TreeStore {
@@ -371,6 +460,8 @@ func (s *fsRepoStore) String() string { return "fsRepoStore" }
 type fsTreeStore struct {
 	fs rwvfs.FileSystem
 	unitStores
+
+	blobs *blobStore // blob store of the multi-repo store (if any)
 }
 
 func newFSTreeStore(fs rwvfs.FileSystem) *fsTreeStore {
@@ -491,9 +582,11 @@ func (s *fsTreeStore) openUnitStore(u unit.ID2) UnitStore {
 	filename := s.unitFilename(u.Type, u.Name)
 	dir := strings.TrimSuffix(filename, unitFileSuffix)
 	if useIndexedStore {
-		return newIndexedUnitStore(rwvfs.Sub(s.fs, dir), u.String())
+		us := newIndexedUnitStore(subFS(s.fs, dir), u.String())
+		us.(*indexedUnitStore).blobs = s.blobs
+		return us
 	}
-	return &fsUnitStore{fs: rwvfs.Sub(s.fs, dir), label: u.String()}
+	return &fsUnitStore{fs: subFS(s.fs, dir), label: u.String(), blobs: s.blobs}
 }
 
 func (s *fsTreeStore) openAllUnitStores() (map[unit.ID2]UnitStore, error) {
@@ -530,6 +623,10 @@ type fsUnitStore struct {
 	fs rwvfs.FileSystem
 
 	label string // a human-readable label (included in String() output)
+
+	// blobs is the store that holds def data (if the unit store is in
+	// a multi-repo store). See blobStore.
+	blobs *blobStore
 }
 
 const (
@@ -542,6 +639,10 @@ func (s *fsUnitStore) Defs(fs ...DefFilter) (defs []*graph.Def, err error) {
 		return s.defsAtOffsets(byteOffsets(f), fs)
 	}
 
+	sp := traceOf(fs).start(s, "Defs")
+	sp.readsFile(unitDefsFilename)
+	defer func() { sp.end(len(defs), err) }()
+
 	vlog.Printf("%s: reading defs with filters %v...", s, fs)
 	f, err := s.fs.Open(unitDefsFilename)
 	if err != nil {
@@ -557,11 +658,16 @@ func (s *fsUnitStore) Defs(fs ...DefFilter) (defs []*graph.Def, err error) {
 	dec := Codec.NewDecoder(f)
 	for {
 		def := &graph.Def{}
-		if _, err := dec.Decode(def); err == io.EOF {
+		n, err := dec.Decode(def)
+		if err == io.EOF {
 			break
 		} else if err != nil {
 			return nil, err
 		}
+		sp.read(n)
+		if err := s.blobs.loadDefData(def); err != nil {
+			return nil, err
+		}
 		if DefFilters(fs).SelectDef(def) {
 			defs = append(defs, def)
 		}
@@ -580,6 +686,10 @@ func (s *fsUnitStore) Defs(fs ...DefFilter) (defs []*graph.Def, err error) {
 // from the def data file and returns them in arbitrary order.
 func (s *fsUnitStore) defsAtOffsets(ofs byteOffsets, fs []DefFilter) (defs []*graph.Def, err error) {
 	vlog.Printf("%s: reading defs at %d offsets with filters %v...", s, len(ofs), fs)
+	sp := traceOf(fs).start(s, "Defs")
+	sp.readsFile(unitDefsFilename)
+	defer func() { sp.end(len(defs), err) }()
+
 	f, err := openFetcherOrOpen(s.fs, unitDefsFilename)
 	if err != nil {
 		return nil, err
@@ -620,7 +730,13 @@ func (s *fsUnitStore) defsAtOffsets(ofs byteOffsets, fs []DefFilter) (defs []*gr
 			}
 			dec := Codec.NewDecoder(r)
 			var def graph.Def
-			if _, err := dec.Decode(&def); err != nil {
+			n, err := dec.Decode(&def)
+			if err != nil {
+				par.Error(err)
+				return
+			}
+			sp.read(n)
+			if err := s.blobs.loadDefData(&def); err != nil {
 				par.Error(err)
 				return
 			}
@@ -676,6 +792,10 @@ func (s *fsUnitStore) readDefs() (defs []*graph.Def, ofs byteOffsets, err error)
 
 func (s *fsUnitStore) Refs(fs ...RefFilter) (refs []*graph.Ref, err error) {
 	vlog.Printf("%s: reading refs with filters %v...", s, fs)
+	sp := traceOf(fs).start(s, "Refs")
+	sp.readsFile(unitRefsFilename)
+	defer func() { sp.end(len(refs), err) }()
+
 	f, err := s.fs.Open(unitRefsFilename)
 	if err != nil {
 		return nil, err
@@ -690,11 +810,13 @@ func (s *fsUnitStore) Refs(fs ...RefFilter) (refs []*graph.Ref, err error) {
 	dec := Codec.NewDecoder(f)
 	for {
 		var ref graph.Ref
-		if _, err := dec.Decode(&ref); err == io.EOF {
+		n, err := dec.Decode(&ref)
+		if err == io.EOF {
 			break
 		} else if err != nil {
 			return nil, err
 		}
+		sp.read(n)
 		if refFilters(fs).SelectRef(&ref) {
 			refs = append(refs, &ref)
 		}
@@ -707,6 +829,10 @@ func (s *fsUnitStore) Refs(fs ...RefFilter) (refs []*graph.Ref, err error) {
 // from the ref data file and returns them in arbitrary order.
 func (s *fsUnitStore) refsAtByteRanges(brs []byteRanges, fs []RefFilter) (refs []*graph.Ref, err error) {
 	vlog.Printf("%s: reading refs at %d byte ranges with filters %v...", s, len(brs), fs)
+	sp := traceOf(fs).start(s, "Refs")
+	sp.readsFile(unitRefsFilename)
+	defer func() { sp.end(len(refs), err) }()
+
 	f, err := openFetcherOrOpen(s.fs, unitRefsFilename)
 	if err != nil {
 		return nil, err
@@ -758,10 +884,12 @@ func (s *fsUnitStore) refsAtByteRanges(brs []byteRanges, fs []RefFilter) (refs [
 			dec := Codec.NewDecoder(r)
 			for range br[1:] {
 				var ref graph.Ref
-				if _, err := dec.Decode(&ref); err != nil {
+				n, err := dec.Decode(&ref)
+				if err != nil {
 					par.Error(err)
 					return
 				}
+				sp.read(n)
 				if ffs.SelectRef(&ref) {
 					refsLock.Lock()
 					refs = append(refs, &ref)
@@ -782,6 +910,10 @@ func (s *fsUnitStore) refsAtByteRanges(brs []byteRanges, fs []RefFilter) (refs [
 // from the ref data file and returns them in arbitrary order.
 func (s *fsUnitStore) refsAtOffsets(ofs byteOffsets, fs []RefFilter) (refs []*graph.Ref, err error) {
 	vlog.Printf("%s: reading refs at %d offsets with filters %v...", s, len(ofs), fs)
+	sp := traceOf(fs).start(s, "Refs")
+	sp.readsFile(unitRefsFilename)
+	defer func() { sp.end(len(refs), err) }()
+
 	f, err := openFetcherOrOpen(s.fs, unitRefsFilename)
 	if err != nil {
 		return nil, err
@@ -822,10 +954,12 @@ func (s *fsUnitStore) refsAtOffsets(ofs byteOffsets, fs []RefFilter) (refs []*gr
 			}
 			dec := Codec.NewDecoder(r)
 			var ref graph.Ref
-			if _, err := dec.Decode(&ref); err != nil {
+			n, err := dec.Decode(&ref)
+			if err != nil {
 				par.Error(err)
 				return
 			}
+			sp.read(n)
 			if ffs.SelectRef(&ref) {
 				refsLock.Lock()
 				refs = append(refs, &ref)
@@ -954,6 +1088,12 @@ func (s *fsUnitStore) Import(data graph.Output) error {
 	if _, _, err := s.writeRefs(data.Refs); err != nil {
 		return err
 	}
+	if _, err := s.writeRelations(data.Relations); err != nil {
+		return err
+	}
+	if err := s.writeAnns(data.Anns); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -979,6 +1119,10 @@ func (s *fsUnitStore) writeDefs(defs []*graph.Def) (ofs byteOffsets, err error)
 	var o uint64 // number of bytes read
 	for i, def := range defs {
 		ofs[i] = int64(o)
+		def, err := s.blobs.storeDefData(def)
+		if err != nil {
+			return nil, err
+		}
 		n, err := enc.Encode(def)
 		if err != nil {
 			return nil, err
@@ -9,15 +9,15 @@ import (
 type MockRepoStoreImporter struct {
 	MockRepoStore
 	Import_        func(commitID string, unit *unit.SourceUnit, data graph.Output) error
-	CreateVersion_ func(commitID string) error
+	CreateVersion_ func(commitID string, meta *VersionMeta) error
 }
 
 func (m MockRepoStoreImporter) Import(commitID string, unit *unit.SourceUnit, data graph.Output) error {
 	return m.Import_(commitID, unit, data)
 }
 
-func (m MockRepoStoreImporter) CreateVersion(commitID string) error {
-	return m.CreateVersion_(commitID)
+func (m MockRepoStoreImporter) CreateVersion(commitID string, meta *VersionMeta) error {
+	return m.CreateVersion_(commitID, meta)
 }
 
 var _ RepoStoreImporter = (*MockRepoStoreImporter)(nil)
@@ -36,3 +36,10 @@ func TestFSMultiRepoStore_customRepoPaths(t *testing.T) {
 		return NewFSMultiRepoStore(newTestFS(), &FSMultiRepoStoreConf{RepoPaths: &customRepoPaths{}})
 	})
 }
+
+func TestFSMultiRepoStore_hashedRepoPaths(t *testing.T) {
+	useIndexedStore = false
+	testMultiRepoStore(t, func() MultiRepoStoreImporter {
+		return NewFSMultiRepoStore(newTestFS(), &FSMultiRepoStoreConf{RepoPaths: HashedRepoPaths})
+	})
+}
@@ -47,6 +47,25 @@ type persistedIndex interface {
 	Read(io.Reader) error
 }
 
+// An mmapIndex is a persistedIndex whose serialized form can be used
+// in place, without copying or decoding all of it (typically by
+// memory-mapping its backing file).
+type mmapIndex interface {
+	persistedIndex
+
+	// WriteMappable serializes an index in a form that Mmap can use
+	// in place. (It is larger than the form written by Write.)
+	WriteMappable(io.Writer) error
+
+	// Mmap populates an index from the data that the index
+	// previously wrote (using WriteMappable). The index refers to b
+	// instead of copying it, so b must not be modified while the
+	// index is in use. If release is non-nil, it is called when the
+	// index no longer refers to b (after the index is garbage
+	// collected), so that b can be unmapped.
+	Mmap(b []byte, release func()) error
+}
+
 // The rest of this file contains helpers used by many index
 // implementations.
 
@@ -119,6 +138,7 @@ func bestCoverageIndex(indexes map[string]Index, filters interface{}, test func(
 func isUnitIndex(x interface{}) bool    { _, ok := x.(unitIndex); return ok }
 func isDefIndex(x interface{}) bool     { _, ok := x.(defIndex); return ok }
 func isDefTreeIndex(x interface{}) bool { _, ok := x.(defTreeIndex); return ok }
+func isRelationIndex(x interface{}) bool { _, ok := x.(relationIndex); return ok }
 func isRefIndex(x interface{}) bool {
 	switch x.(type) {
 	case refIndexByteRanges, refIndexByteOffsets:
@@ -206,6 +226,17 @@ type refIndexBuilder interface {
 	Build([]*graph.Ref, fileByteRanges, byteOffsets) error
 }
 
+type relationIndex interface {
+	// Relations returns the byte offsets (in the relation data file)
+	// of matching relations.
+	Relations(...RelationFilter) (byteOffsets, error)
+}
+
+type relationIndexBuilder interface {
+	// Build constructs the index in memory.
+	Build([]*graph.Relation, byteOffsets) error
+}
+
 type unitIndex interface {
 	// Units returns the unit IDs units that match the unit filters.
 	Units(...UnitFilter) ([]unit.ID2, error)
@@ -3,11 +3,14 @@ package store
 import (
 	"container/list"
 	"sync"
+	"time"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
 )
 
 // cacheableIndexStore is an index store which can allow the indexes to be
 // shared across instances of the store. The store itself needs to be
-// instrumented with calls to `cacheGet` and `cachePut`
+// instrumented with calls to `loadIndex` (or `cacheGet` and `cachePut`).
 type cacheableIndexStore interface {
 	StoreKey() interface{}
 }
@@ -23,21 +26,90 @@ type indexCacheKey struct {
 type indexCacheElement struct {
 	key   indexCacheKey
 	index Index
+	size  int64 // approximate size in bytes
 }
 
+// indexLoad is an in-progress read of an index into the cache. Other
+// goroutines that need the same index wait for it instead of reading
+// the index themselves.
+type indexLoad struct {
+	done  chan struct{}
+	index Index
+	err   error
+}
+
+// IndexCacheStats describes the state and usage of the index cache.
+type IndexCacheStats struct {
+	Hits      int64 // lookups that found the index in the cache
+	Misses    int64 // lookups that did not find the index in the cache
+	Evictions int64 // indexes evicted to stay within MaxBytes
+
+	Loads    int64         // indexes read from the underlying store
+	LoadTime time.Duration // total time spent reading indexes
+
+	Entries  int   // number of indexes currently in the cache
+	Bytes    int64 // approximate size of the indexes in the cache
+	MaxBytes int64 // maximum approximate size of the cache
+}
+
+// defaultIndexCacheMaxBytes is the default maximum approximate size
+// of the index cache (256 MB).
+const defaultIndexCacheMaxBytes = 256 << 20
+
 // indexCache stores indexes for use across stores. This is to prevent the
 // cost of deserializing the index from the underlying VFS store
+//
+// The cache is bounded by the approximate size of its indexes, which
+// is the number of (uncompressed) bytes that were read to load them.
 type indexCache struct {
-	indexes map[indexCacheKey]*list.Element
-	lru     *list.List
-	maxLen  int
-	sync.RWMutex
+	indexes  map[indexCacheKey]*list.Element
+	lru      *list.List
+	loading  map[indexCacheKey]*indexLoad
+	bytes    int64
+	maxBytes int64
+	stats    IndexCacheStats
+
+	// gen is incremented when indexes are invalidated. Indexes that
+	// were being read when the cache was invalidated aren't cached.
+	gen int64
+
+	sync.Mutex
+}
+
+func newIndexCache(maxBytes int64) *indexCache {
+	return &indexCache{
+		indexes:  map[indexCacheKey]*list.Element{},
+		lru:      list.New(),
+		loading:  map[indexCacheKey]*indexLoad{},
+		maxBytes: maxBytes,
+	}
+}
+
+var defaultIndexCache = newIndexCache(defaultIndexCacheMaxBytes)
+
+// SetIndexCacheMaxBytes sets the maximum approximate size, in bytes,
+// of the process-wide cache of loaded indexes. Indexes are evicted
+// (least recently used first) until the cache fits. If maxBytes is 0,
+// indexes are not cached.
+func SetIndexCacheMaxBytes(maxBytes int64) {
+	defaultIndexCache.setMaxBytes(maxBytes)
 }
 
-var defaultIndexCache *indexCache = &indexCache{
-	indexes: map[indexCacheKey]*list.Element{},
-	lru:     list.New(),
-	maxLen:  15,
+// GetIndexCacheStats returns the usage statistics of the process-wide
+// cache of loaded indexes.
+func GetIndexCacheStats() IndexCacheStats {
+	return defaultIndexCache.getStats()
+}
+
+// loadIndex returns the loaded index named name for the store. If
+// the index is in the cache, the cached index is returned. Otherwise
+// x is read from fs (unless it is already Ready) and added to the
+// cache.
+//
+// The returned index may be shared with other goroutines and store
+// instances, so it must only be used for reads.
+func loadIndex(store cacheableIndexStore, fs rwvfs.FileSystem, name string, x Index) (Index, error) {
+	return defaultIndexCache.load(store, fs, name, x)
 }
 
 // cacheGet attempts to fetch an instance of a loaded Index from an in-memory
@@ -50,57 +122,176 @@ func cacheGet(store cacheableIndexStore, name string, fallback Index) Index {
 	return defaultIndexCache.cacheGet(store, name, fallback)
 }
 
-// cachePut will store an index in the cache
-func cachePut(store cacheableIndexStore, name string, index Index) {
-	defaultIndexCache.cachePut(store, name, index)
+// cacheInvalidate removes the store's indexes from the cache. It
+// must be called after the store's indexes or data are written, so
+// that out-of-date indexes aren't used.
+func cacheInvalidate(store cacheableIndexStore) {
+	defaultIndexCache.invalidate(store.StoreKey())
 }
 
-func (c *indexCache) cacheGet(store cacheableIndexStore, name string, fallback Index) Index {
+// cachePut will store an index, whose approximate size is size
+// bytes, in the cache
+func cachePut(store cacheableIndexStore, name string, index Index, size int64) {
+	defaultIndexCache.cachePut(store, name, index, size)
+}
+
+func (c *indexCache) setMaxBytes(maxBytes int64) {
 	c.Lock()
 	defer c.Unlock()
+	c.maxBytes = maxBytes
+	c.evict()
+}
+
+func (c *indexCache) getStats() IndexCacheStats {
+	c.Lock()
+	defer c.Unlock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	stats.Bytes = c.bytes
+	stats.MaxBytes = c.maxBytes
+	return stats
+}
+
+func (c *indexCache) load(store cacheableIndexStore, fs rwvfs.FileSystem, name string, x Index) (Index, error) {
+	if x.Ready() {
+		return x, nil
+	}
+
+	key := indexCacheKey{
+		storeKey:  store.StoreKey(),
+		indexName: name,
+	}
+
+	c.Lock()
+	if index, ok := c.get(key); ok {
+		c.Unlock()
+		return index, nil
+	}
+	if l, ok := c.loading[key]; ok {
+		// Another goroutine is already reading this index.
+		c.Unlock()
+		<-l.done
+		return l.index, l.err
+	}
+	l := &indexLoad{done: make(chan struct{})}
+	c.loading[key] = l
+	gen := c.gen
+	c.Unlock()
+
+	start := time.Now()
+	size, err := prepareIndexSize(fs, name, x)
+	elapsed := time.Since(start)
+
+	c.Lock()
+	if c.loading[key] == l {
+		delete(c.loading, key)
+	}
+	c.stats.Loads++
+	c.stats.LoadTime += elapsed
+	if err == nil && c.gen == gen {
+		c.put(key, x, size)
+	}
+	c.Unlock()
+
+	l.index, l.err = x, err
+	close(l.done)
+	return x, err
+}
+
+func (c *indexCache) cacheGet(store cacheableIndexStore, name string, fallback Index) Index {
 	key := indexCacheKey{
 		storeKey:  store.StoreKey(),
 		indexName: name,
 	}
+	c.Lock()
+	defer c.Unlock()
+	if index, ok := c.get(key); ok {
+		return index
+	}
+	return fallback
+}
+
+// get looks up the index in the cache. The caller must hold c's lock.
+func (c *indexCache) get(key indexCacheKey) (Index, bool) {
 	if el, ok := c.indexes[key]; ok {
-		vlog.Printf("%s: loaded from cache key=%v", name, key)
+		vlog.Printf("%s: loaded from cache key=%v", key.indexName, key)
+		c.stats.Hits++
 		c.lru.MoveToFront(el)
-		return el.Value.(indexCacheElement).index
-	} else {
-		vlog.Printf("%s: not in cache key=%v", name, key)
-		return fallback
+		return el.Value.(indexCacheElement).index, true
 	}
+	vlog.Printf("%s: not in cache key=%v", key.indexName, key)
+	c.stats.Misses++
+	return nil, false
 }
 
-func (c *indexCache) cachePut(store cacheableIndexStore, name string, index Index) {
+func (c *indexCache) cachePut(store cacheableIndexStore, name string, index Index, size int64) {
 	key := indexCacheKey{
 		storeKey:  store.StoreKey(),
 		indexName: name,
 	}
+	c.Lock()
+	defer c.Unlock()
+	c.put(key, index, size)
+}
 
+// put adds the index to the cache and evicts the least recently used
+// indexes to keep the cache within its max size. The caller must hold
+// c's lock.
+func (c *indexCache) put(key indexCacheKey, index Index, size int64) {
 	// We don't need to store something in the cache that is already
 	// stored
-	c.RLock()
 	if _, ok := c.indexes[key]; ok {
 		// NOP we already have it cached
-		c.RUnlock()
 		return
 	}
-	c.RUnlock()
+	if c.maxBytes <= 0 || size > c.maxBytes {
+		vlog.Printf("%s: too large to cache (%d bytes) key=%v", key.indexName, size, key)
+		return
+	}
 
 	// Update cache
+	vlog.Printf("%s: updating cache key=%v", key.indexName, key)
+	el := indexCacheElement{key: key, index: index, size: size}
+	c.indexes[key] = c.lru.PushFront(el)
+	c.bytes += size
+	c.evict()
+}
+
+// invalidate removes the indexes of the store identified by storeKey
+// from the cache.
+func (c *indexCache) invalidate(storeKey interface{}) {
 	c.Lock()
 	defer c.Unlock()
-	vlog.Printf("%s: updating cache key=%v", name, key)
-	el := indexCacheElement{key: key, index: index}
-	c.indexes[key] = c.lru.PushFront(el)
+	c.gen++
+	for key := range c.loading {
+		if key.storeKey == storeKey {
+			delete(c.loading, key)
+		}
+	}
+	for key, el := range c.indexes {
+		if key.storeKey == storeKey {
+			vlog.Printf("Invalidating %v", key)
+			c.remove(el)
+		}
+	}
+}
+
+// remove removes the element from the cache. The caller must hold c's
+// lock.
+func (c *indexCache) remove(el *list.Element) {
+	e := el.Value.(indexCacheElement)
+	c.lru.Remove(el)
+	delete(c.indexes, e.key)
+	c.bytes -= e.size
+}
 
-	// Evict least recently used
-	if c.lru.Len() > c.maxLen {
+// evict evicts the least recently used indexes until the cache is
+// within its max size. The caller must hold c's lock.
+func (c *indexCache) evict() {
+	for c.bytes > c.maxBytes && c.lru.Len() > 0 {
 		dead := c.lru.Back()
-		deadKey := dead.Value.(indexCacheElement).key
-		vlog.Printf("Evicting %v", deadKey)
-		c.lru.Remove(dead)
-		delete(c.indexes, deadKey)
+		vlog.Printf("Evicting %v", dead.Value.(indexCacheElement).key)
+		c.remove(dead)
+		c.stats.Evictions++
 	}
 }
@@ -1,7 +1,6 @@
 package store
 
 import (
-	"container/list"
 	"fmt"
 	"testing"
 )
@@ -28,7 +27,7 @@ func TestCache(t *testing.T) {
 	}
 
 	// We put in 2, and get with fallback 1. We should get back 2
-	cachePut(store, "test_index", index2)
+	cachePut(store, "test_index", index2, 1)
 	if index2 != cacheGet(store, "test_index", index1) {
 		t.Errorf("cachePut followed by cacheGet returns different results")
 	}
@@ -37,7 +36,7 @@ func TestCache(t *testing.T) {
 	if index2 != cacheGet(store, "test_index_2", index2) {
 		t.Errorf("cacheGet expected to use fallback value")
 	}
-	cachePut(store, "test_index_2", index1)
+	cachePut(store, "test_index_2", index1, 1)
 	if index1 != cacheGet(store, "test_index_2", index2) {
 		t.Errorf("cachePut followed by cacheGet returns different results")
 	}
@@ -47,14 +46,10 @@ func TestCache(t *testing.T) {
 func TestLRU(t *testing.T) {
 	store := &mockCacheableIndexStore{}
 	cacheSize := 50
-	c := &indexCache{
-		indexes: map[indexCacheKey]*list.Element{},
-		lru:     list.New(),
-		maxLen:  cacheSize,
-	}
+	c := newIndexCache(int64(cacheSize)) // each index has size 1
 	for i := 0; i < cacheSize+5; i++ {
 		index := &mockIndex{i}
-		c.cachePut(store, fmt.Sprintf("index_%d", i), index)
+		c.cachePut(store, fmt.Sprintf("index_%d", i), index, 1)
 	}
 
 	// Now indexes < 5 should have been evicted, everything else should still be there
@@ -71,7 +66,7 @@ func TestLRU(t *testing.T) {
 	// Do some NOOP puts. In our implementation a NOOP put does not affect
 	// LRU
 	for i := cacheSize / 3; i < cacheSize/2; i++ {
-		c.cachePut(store, fmt.Sprintf("index_%d", i), fallback)
+		c.cachePut(store, fmt.Sprintf("index_%d", i), fallback, 1)
 	}
 	for i := 5; i < cacheSize+5; i++ {
 		if fallback == c.cacheGet(store, fmt.Sprintf("index_%d", i), fallback) {
@@ -81,7 +76,7 @@ func TestLRU(t *testing.T) {
 
 	// The LRU index we did a get on was index_5. Do a put and ensure it
 	// is gone
-	c.cachePut(store, fmt.Sprintf("index_%d", cacheSize+5), &mockIndex{cacheSize + 5})
+	c.cachePut(store, fmt.Sprintf("index_%d", cacheSize+5), &mockIndex{cacheSize + 5}, 1)
 	for i := 0; i <= cacheSize+5; i++ {
 		index := c.cacheGet(store, fmt.Sprintf("index_%d", i), fallback)
 		if i <= 5 && index != fallback {
@@ -91,3 +86,58 @@ func TestLRU(t *testing.T) {
 		}
 	}
 }
+
+func TestCache_maxBytes(t *testing.T) {
+	store := &mockCacheableIndexStore{}
+	c := newIndexCache(100)
+	fallback := &mockIndex{-1}
+
+	c.cachePut(store, "a", &mockIndex{1}, 60)
+	c.cachePut(store, "b", &mockIndex{2}, 30)
+	c.cachePut(store, "c", &mockIndex{3}, 30)  // evicts a
+	c.cachePut(store, "d", &mockIndex{4}, 101) // too large to cache
+
+	for name, wantCached := range map[string]bool{"a": false, "b": true, "c": true, "d": false} {
+		cached := c.cacheGet(store, name, fallback) != fallback
+		if cached != wantCached {
+			t.Errorf("%s: got cached %v, want %v", name, cached, wantCached)
+		}
+	}
+
+	stats := c.getStats()
+	want := IndexCacheStats{Hits: 2, Misses: 2, Evictions: 1, Entries: 2, Bytes: 60, MaxBytes: 100}
+	if stats != want {
+		t.Errorf("got stats %+v, want %+v", stats, want)
+	}
+
+	// Shrinking the cache evicts the least recently used indexes.
+	c.setMaxBytes(30)
+	if stats := c.getStats(); stats.Entries != 1 || stats.Bytes != 30 || stats.Evictions != 2 {
+		t.Errorf("after shrinking: got stats %+v, want 1 entry (30 bytes) and 2 evictions", stats)
+	}
+}
+
+type mockCacheableIndexStoreKey string
+
+func (k mockCacheableIndexStoreKey) StoreKey() interface{} { return string(k) }
+
+func TestCache_invalidate(t *testing.T) {
+	store1, store2 := mockCacheableIndexStoreKey("s1"), mockCacheableIndexStoreKey("s2")
+	c := newIndexCache(100)
+	fallback := &mockIndex{-1}
+
+	c.cachePut(store1, "a", &mockIndex{1}, 10)
+	c.cachePut(store1, "b", &mockIndex{2}, 10)
+	c.cachePut(store2, "a", &mockIndex{3}, 10)
+	c.invalidate(store1.StoreKey())
+
+	if c.cacheGet(store1, "a", fallback) != fallback || c.cacheGet(store1, "b", fallback) != fallback {
+		t.Error("got cached index of invalidated store, want fallback")
+	}
+	if c.cacheGet(store2, "a", fallback) == fallback {
+		t.Error("got fallback for other store, want cached index")
+	}
+	if stats := c.getStats(); stats.Entries != 1 || stats.Bytes != 10 {
+		t.Errorf("got stats %+v, want 1 entry (10 bytes)", stats)
+	}
+}
@@ -1,8 +1,12 @@
 package store
 
 import (
+	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"runtime"
@@ -109,13 +113,10 @@ func (s *indexedTreeStore) unitIDs(indexOnly bool, fs ...UnitFilter) ([]unit.ID2
 
 	// Try to find an index that covers this query.
 	if xname, bx := bestCoverageIndex(s.indexes, fs, isUnitIndex); bx != nil {
-		if !bx.Ready() {
-			bx = cacheGet(s, xname, bx)
-		}
-		if err := prepareIndex(s.fs, xname, bx); err != nil {
+		bx, err := loadIndex(s, s.fs, xname, bx)
+		if err != nil {
 			return nil, err
 		}
-		cachePut(s, xname, bx)
 		vlog.Printf("indexedTreeStore.unitIDs(%v): Found covering index %q (%v).", fs, xname, bx)
 		return bx.(unitIndex).Units(fs...)
 	}
@@ -158,23 +159,27 @@ func (s *indexedTreeStore) Units(fs ...UnitFilter) ([]*unit.SourceUnit, error) {
 }
 
 func (s *indexedTreeStore) unitsUsingFullIndex(fs ...UnitFilter) ([]*unit.SourceUnit, error) {
-	x := s.indexes[unitsIndexName]
-	if err := prepareIndex(s.fs, unitsIndexName, x); err != nil {
+	x, err := loadIndex(s, s.fs, unitsIndexName, s.indexes[unitsIndexName])
+	if err != nil {
 		return nil, err
 	}
 	return x.(unitFullIndex).Units(fs...)
 }
 
-func (s *indexedTreeStore) Defs(fs ...DefFilter) ([]*graph.Def, error) {
+func (s *indexedTreeStore) Defs(fs ...DefFilter) (defs []*graph.Def, err error) {
 	vlog.Printf("indexedTreeStore.Defs(%v)", fs)
+	sp := traceOf(fs).start(s, "Defs")
+	defer func() { sp.end(len(defs), err) }()
 
 	// First, check if any defs indexes at the tree level cover this
 	// query.
 	if xname, bx := bestCoverageIndex(s.indexes, fs, isDefTreeIndex); bx != nil {
-		if err := prepareIndex(s.fs, xname, bx); err != nil {
+		bx, err := loadIndex(s, s.fs, xname, bx)
+		if err != nil {
 			return nil, err
 		}
 		vlog.Printf("indexedTreeStore.Defs(%v): Found covering index %q (%v).", fs, xname, bx)
+		sp.usedIndex(xname, bx, fs)
 		uoffs, err := bx.(defTreeIndex).Defs(fs...)
 		if err != nil {
 			return nil, err
@@ -271,6 +276,7 @@ func (s *indexedTreeStore) Import(u *unit.SourceUnit, data graph.Output) error {
 	if err := s.fsTreeStore.Import(u, data); err != nil {
 		return err
 	}
+	cacheInvalidate(s)
 	return nil
 }
 
@@ -502,7 +508,11 @@ func (s *indexedTreeStore) buildIndexes(xs map[string]Index, units []*unit.Sourc
 			}
 		}()
 	}
-	return par.Wait()
+	err := par.Wait()
+	// Other stores over the same files (which share cached indexes)
+	// must read the new indexes.
+	cacheInvalidate(s)
+	return err
 }
 
 func (s *indexedTreeStore) statIndex(name string) (os.FileInfo, error) {
@@ -539,6 +549,7 @@ func newIndexedUnitStore(fs rwvfs.FileSystem, label string) UnitStoreImporter {
 			"file_to_refs":     &refFileIndex{},
 			defToRefsIndexName: &defRefsIndex{},
 			defQueryIndexName:  &defQueryIndex{f: defQueryFilter},
+			"relations_to":     &relationsToIndex{},
 		},
 		fsUnitStore: &fsUnitStore{fs: fs, label: label},
 	}
@@ -550,7 +561,10 @@ const (
 	indexFilename      = "%s.idx"
 )
 
-func (s *indexedUnitStore) Defs(fs ...DefFilter) ([]*graph.Def, error) {
+func (s *indexedUnitStore) Defs(fs ...DefFilter) (defs []*graph.Def, err error) {
+	sp := traceOf(fs).start(s.traceName(), "Defs")
+	defer func() { sp.end(len(defs), err) }()
+
 	// If there's a defOffsetsFilter, that'll be faster than
 	// consulting an index (since it already gives us the byte
 	// offsets).
@@ -561,6 +575,7 @@ func (s *indexedUnitStore) Defs(fs ...DefFilter) ([]*graph.Def, error) {
 				return nil, err
 			}
 			vlog.Printf("indexedUnitStore.Defs(%v): Found covering index %q (%v).", fs, xname, bx)
+			sp.usedIndex(xname, bx, fs)
 			ofs, err := bx.(defIndex).Defs(fs...)
 			if err != nil {
 				return nil, err
@@ -569,18 +584,26 @@ func (s *indexedUnitStore) Defs(fs ...DefFilter) ([]*graph.Def, error) {
 		}
 	}
 
-	// Fall back to full scan.
+	// Fall back to full scan (unless the tree store already found
+	// the defs' byte offsets using a tree-level index).
+	if getDefOffsetsFilter(fs) == nil {
+		sp.fullScan()
+	}
 	return s.fsUnitStore.Defs(fs...)
 }
 
 // Refs implements UnitStore.
-func (s *indexedUnitStore) Refs(fs ...RefFilter) ([]*graph.Ref, error) {
+func (s *indexedUnitStore) Refs(fs ...RefFilter) (refs []*graph.Ref, err error) {
+	sp := traceOf(fs).start(s.traceName(), "Refs")
+	defer func() { sp.end(len(refs), err) }()
+
 	// Try to find an index that covers this query.
 	if xname, bx := bestCoverageIndex(s.indexes, fs, isRefIndex); bx != nil {
 		if err := prepareIndex(s.fs, xname, bx); err != nil {
 			return nil, err
 		}
 		vlog.Printf("indexedUnitStore.Refs(%v): Found covering index %q (%v).", fs, xname, bx)
+		sp.usedIndex(xname, bx, fs)
 		switch bx := bx.(type) {
 		case refIndexByteRanges:
 			brs, err := bx.Refs(fs...)
@@ -598,6 +621,7 @@ func (s *indexedUnitStore) Refs(fs ...RefFilter) ([]*graph.Ref, error) {
 	}
 
 	// Fall back to full scan.
+	sp.fullScan()
 	return s.fsUnitStore.Refs(fs...)
 }
 
@@ -606,7 +630,7 @@ func (s *indexedUnitStore) Refs(fs ...RefFilter) ([]*graph.Ref, error) {
 func (s *indexedUnitStore) Import(data graph.Output) error {
 	cleanForImport(&data, "", "", "")
 
-	var defOfs, refOfs byteOffsets
+	var defOfs, refOfs, relOfs byteOffsets
 	var refFBRs fileByteRanges
 
 	var err error
@@ -621,7 +645,14 @@ func (s *indexedUnitStore) Import(data graph.Output) error {
 	if err != nil {
 		return err
 	}
-	if err := s.buildIndexes(s.Indexes(), &data, defOfs, refFBRs, refOfs); err != nil {
+	relOfs, err = s.fsUnitStore.writeRelations(data.Relations)
+	if err != nil {
+		return err
+	}
+	if err := s.fsUnitStore.writeAnns(data.Anns); err != nil {
+		return err
+	}
+	if err := s.buildIndexes(s.Indexes(), &data, defOfs, refFBRs, refOfs, relOfs); err != nil {
 		return err
 	}
 
@@ -631,16 +662,17 @@ func (s *indexedUnitStore) Import(data graph.Output) error {
 func (s *indexedUnitStore) Indexes() map[string]Index { return s.indexes }
 
 func (s *indexedUnitStore) BuildIndex(name string, x Index) error {
-	return s.buildIndexes(map[string]Index{name: x}, nil, nil, nil, nil)
+	return s.buildIndexes(map[string]Index{name: x}, nil, nil, nil, nil, nil)
 }
 
 func (s *indexedUnitStore) readIndex(name string, x persistedIndex) error {
 	return readIndex(s.fs, name, x)
 }
 
-func (s *indexedUnitStore) buildIndexes(xs map[string]Index, data *graph.Output, defOfs byteOffsets, refFBRs fileByteRanges, refOfs byteOffsets) error {
+func (s *indexedUnitStore) buildIndexes(xs map[string]Index, data *graph.Output, defOfs byteOffsets, refFBRs fileByteRanges, refOfs, relOfs byteOffsets) error {
 	var defs []*graph.Def
 	var refs []*graph.Ref
+	var rels []*graph.Relation
 	if data != nil {
 		// Allow us to distinguish between empty (empty slice) and not-yet-fetched (nil).
 		defs = data.Defs
@@ -651,6 +683,10 @@ func (s *indexedUnitStore) buildIndexes(xs map[string]Index, data *graph.Output,
 		if refs == nil {
 			refs = []*graph.Ref{}
 		}
+		rels = data.Relations
+		if rels == nil {
+			rels = []*graph.Relation{}
+		}
 	}
 
 	var getDefsErr error
@@ -685,6 +721,21 @@ func (s *indexedUnitStore) buildIndexes(xs map[string]Index, data *graph.Output,
 		return refs, refFBRs, refOfs, getRefsErr
 	}
 
+	var getRelsErr error
+	var getRelsOnce sync.Once
+	getRels := func() ([]*graph.Relation, byteOffsets, error) {
+		getRelsOnce.Do(func() {
+			// Don't refetch if passed in as arg.
+			if rels == nil {
+				rels, relOfs, getRelsErr = s.fsUnitStore.readRelations()
+			}
+			if rels == nil {
+				rels = []*graph.Relation{}
+			}
+		})
+		return rels, relOfs, getRelsErr
+	}
+
 	par := parallel.NewRun(runtime.GOMAXPROCS(0))
 	for name_, x_ := range xs {
 		name, x := name_, x_
@@ -712,6 +763,16 @@ func (s *indexedUnitStore) buildIndexes(xs map[string]Index, data *graph.Output,
 					par.Error(err)
 					return
 				}
+			case relationIndexBuilder:
+				rels, relOfs, err := getRels()
+				if err != nil {
+					par.Error(err)
+					return
+				}
+				if err := x.Build(rels, relOfs); err != nil {
+					par.Error(err)
+					return
+				}
 			default:
 				par.Error(fmt.Errorf("don't know how to build index %q of type %T", name, x))
 				return
@@ -733,9 +794,22 @@ func (s *indexedUnitStore) statIndex(name string) (os.FileInfo, error) {
 
 func (s *indexedUnitStore) String() string { return "indexedUnitStore" }
 
+// traceName is the name of the store in trace spans, which (unlike
+// String) identifies the source unit.
+func (s *indexedUnitStore) traceName() string {
+	return fmt.Sprintf("indexedUnitStore(%v)", s.label)
+}
+
 // writeIndex calls x.Write with the index's backing file.
 func writeIndex(fs rwvfs.FileSystem, name string, x persistedIndex) (err error) {
 	vlog.Printf("%s: writing index...", name)
+	if _, ok := x.(mmapIndex); ok {
+		// Remove (instead of truncating) the existing index file,
+		// which might be memory-mapped by a reader.
+		if err := fs.Remove(fmt.Sprintf(indexFilename, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
 	f, err := fs.Create(fmt.Sprintf(indexFilename, name))
 	if err != nil {
 		return err
@@ -747,6 +821,25 @@ func writeIndex(fs rwvfs.FileSystem, name string, x persistedIndex) (err error)
 		}
 	}()
 
+	if x, ok := x.(mmapIndex); ok {
+		if _, isLocal := f.(*os.File); isLocal {
+			// Write the index uncompressed so that readIndex can
+			// mmap it.
+			w := bufio.NewWriter(f)
+			if _, err := w.Write(rawIndexHeader); err != nil {
+				return err
+			}
+			if err := x.WriteMappable(w); err != nil {
+				return err
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			vlog.Printf("%s: done writing uncompressed index.", name)
+			return nil
+		}
+	}
+
 	w := gzip.NewWriter(f)
 
 	if err := x.Write(w); err != nil {
@@ -762,18 +855,34 @@ func writeIndex(fs rwvfs.FileSystem, name string, x persistedIndex) (err error)
 	return nil
 }
 
+// rawIndexHeader begins index files that are stored uncompressed (so
+// that they can be memory-mapped; see mmapIndex). All other index
+// files are gzipped. Its length is a multiple of 8 bytes, so that the
+// index data that follows it is aligned.
+var rawIndexHeader = []byte("srclib raw index v2\n\x00\x00\x00\x00")
+
+var errMmapUnsupported = errors.New("mmap is not supported for this file")
+
 // prepareIndex prepares an index to be used. If it is already Ready,
 // nothing happens. If it's not Ready and it's a persistedIndex,
 // prepareIndex calls readIndex(fs, name, x). Otherwise an
 // *errIndexNotReady is returned.
 func prepareIndex(fs rwvfs.FileSystem, name string, x Index) error {
+	_, err := prepareIndexSize(fs, name, x)
+	return err
+}
+
+// prepareIndexSize is like prepareIndex, but it also returns the
+// number of (uncompressed) bytes that were read, which approximates
+// the index's size in memory.
+func prepareIndexSize(fs rwvfs.FileSystem, name string, x Index) (int64, error) {
 	if x.Ready() {
-		return nil
+		return 0, nil
 	}
 	if x, ok := x.(persistedIndex); ok {
-		return readIndex(fs, name, x)
+		return readIndexSize(fs, name, x)
 	}
-	return &errIndexNotReady{name: name}
+	return 0, &errIndexNotReady{name: name}
 }
 
 type errIndexNotReady struct {
@@ -792,16 +901,23 @@ func (e *errIndexNotExist) Error() string {
 }
 
 // readIndex calls x.Read with the index's backing file.
-func readIndex(fs rwvfs.FileSystem, name string, x persistedIndex) (err error) {
+func readIndex(fs rwvfs.FileSystem, name string, x persistedIndex) error {
+	_, err := readIndexSize(fs, name, x)
+	return err
+}
+
+// readIndexSize is like readIndex, but it also returns the number of
+// (uncompressed) bytes that were read.
+func readIndexSize(fs rwvfs.FileSystem, name string, x persistedIndex) (n int64, err error) {
 	vlog.Printf("%s: reading index...", name)
 	var f vfs.ReadSeekCloser
 	f, err = fs.Open(fmt.Sprintf(indexFilename, name))
 	if err != nil {
 		vlog.Printf("%s: failed to read index: %s.", name, err)
 		if os.IsNotExist(err) {
-			return &errIndexNotExist{name: name, err: err}
+			return 0, &errIndexNotExist{name: name, err: err}
 		}
-		return err
+		return 0, err
 	}
 	defer func() {
 		err2 := f.Close()
@@ -810,19 +926,93 @@ func readIndex(fs rwvfs.FileSystem, name string, x persistedIndex) (err error) {
 		}
 	}()
 
-	r, err := gzip.NewReader(f)
+	if x, ok := x.(mmapIndex); ok {
+		if f, isLocal := f.(*os.File); isLocal {
+			if n, ok, err := mmapIndexFile(f, x); ok {
+				vlog.Printf("%s: done mapping index (%d bytes).", name, n)
+				return n, err
+			}
+		}
+	}
+
+	br := bufio.NewReader(f)
+	if hdr, err := br.Peek(len(rawIndexHeader)); err == nil && bytes.Equal(hdr, rawIndexHeader) {
+		// The index is uncompressed, but it can't be mmapped (e.g.,
+		// because it's not on the local filesystem), so read it into
+		// memory and use it in place.
+		x, ok := x.(mmapIndex)
+		if !ok {
+			return 0, fmt.Errorf("index %q is uncompressed but does not support mmap", name)
+		}
+		if _, err := io.CopyN(ioutil.Discard, br, int64(len(rawIndexHeader))); err != nil {
+			return 0, err
+		}
+		b, err := ioutil.ReadAll(br)
+		if err != nil {
+			return int64(len(b)), err
+		}
+		if err := x.Mmap(b, nil); err != nil {
+			return int64(len(b)), err
+		}
+		vlog.Printf("%s: done reading uncompressed index (%d bytes).", name, len(b))
+		return int64(len(b)), nil
+	}
+
+	r, err := gzip.NewReader(br)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	if err := x.Read(r); err != nil {
-		return err
+	cr := &countingReader{r: r}
+	if err := x.Read(cr); err != nil {
+		return cr.n, err
 	}
 	if err := r.Close(); err != nil {
-		return err
+		return cr.n, err
 	}
-	vlog.Printf("%s: done reading index.", name)
-	return nil
+	vlog.Printf("%s: done reading index (%d bytes).", name, cr.n)
+	return cr.n, nil
+}
+
+// mmapIndexFile populates x by memory-mapping the index file f. If f
+// is not an uncompressed index file or can't be mapped, ok is false
+// and the caller should read the file instead.
+//
+// The mapping is unmapped when x no longer refers to it (see
+// mmapIndex). Because x may be shared via the index cache, that is
+// after x is evicted from the cache and no queries are using it, not
+// immediately upon eviction.
+func mmapIndexFile(f *os.File, x mmapIndex) (n int64, ok bool, err error) {
+	b, err := mmapFile(f)
+	if err != nil {
+		return 0, false, nil
+	}
+	if !bytes.HasPrefix(b, rawIndexHeader) {
+		munmap(b)
+		return 0, false, nil
+	}
+	release := func() {
+		if err := munmap(b); err != nil {
+			log.Printf("Warning: failed to unmap index %s: %s.", f.Name(), err)
+		}
+	}
+	if err := x.Mmap(b[len(rawIndexHeader):], release); err != nil {
+		munmap(b)
+		return 0, true, err
+	}
+	return int64(len(b)), true, nil
+}
+
+// countingReader counts the number of bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
 }
 
 // statIndex calls fs.Stat on the index's backing file or dir.
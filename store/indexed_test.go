@@ -1,6 +1,11 @@
 package store
 
-import "testing"
+import (
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
 
 func TestIndexedUnitStore(t *testing.T) {
 	useIndexedStore = true
@@ -43,3 +48,43 @@ func TestIndexedFSMultiRepoStore_customRepoPaths(t *testing.T) {
 		return NewFSMultiRepoStore(newTestFS(), &FSMultiRepoStoreConf{RepoPaths: &customRepoPaths{}})
 	})
 }
+
+// TestIndexedFSRepoStore_sharedIndexes tests that stores over the
+// same files share cached indexes and see each other's imports.
+func TestIndexedFSRepoStore_sharedIndexes(t *testing.T) {
+	useIndexedStore = true
+	fs := newTestFS()
+	rs1, rs2 := NewFSRepoStore(fs), NewFSRepoStore(fs)
+
+	k1 := rs1.(*fsRepoStore).newTreeStore("c").(*indexedTreeStore).StoreKey()
+	k2 := rs2.(*fsRepoStore).newTreeStore("c").(*indexedTreeStore).StoreKey()
+	if k1 != k2 {
+		t.Errorf("got cache keys %v and %v, want equal", k1, k2)
+	}
+
+	var want []*unit.SourceUnit
+	for _, name := range []string{"u1", "u2"} {
+		if err := rs1.Import("c", &unit.SourceUnit{Key: unit.Key{Type: "t", Name: name}}, graph.Output{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := rs1.(RepoIndexer).Index("c"); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, &unit.SourceUnit{Key: unit.Key{CommitID: "c", Type: "t", Name: name}})
+
+		units, err := rs2.Units(ByCommitIDs("c"), ByUnits(unit.ID2{Type: "t", Name: name}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !deepEqual(units, want[len(want)-1:]) {
+			t.Errorf("after importing %s: got units %v, want %v", name, units, want[len(want)-1:])
+		}
+		units, err = rs2.Units(ByCommitIDs("c"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !deepEqual(units, want) {
+			t.Errorf("after importing %s: got units %v, want %v", name, units, want)
+		}
+	}
+}
@@ -0,0 +1,437 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// A RepoLocker is a RepoStore that supports advisory locking of
+// versions, so that concurrent writers (e.g., imports in separate
+// processes) of the same version do not interleave their writes.
+//
+// Readers never need to acquire locks.
+type RepoLocker interface {
+	// LockVersion acquires the write lock for the version. If another
+	// writer holds the lock, a *LockedError is returned. Otherwise
+	// the returned func must be called to release the lock.
+	//
+	// A lock that was acquired more than timeout ago (or
+	// DefaultLockTimeout ago, if timeout is 0) is considered stale
+	// and is broken.
+	LockVersion(commitID string, timeout time.Duration) (unlock func() error, err error)
+}
+
+// A MultiRepoLocker is a MultiRepoStore that supports advisory
+// locking of versions. See RepoLocker.
+type MultiRepoLocker interface {
+	// LockVersion acquires the write lock for the version. See
+	// (RepoLocker).LockVersion.
+	LockVersion(repo, commitID string, timeout time.Duration) (unlock func() error, err error)
+}
+
+// DefaultLockTimeout is the default age after which a lock is
+// considered stale (and may be broken by another writer). Locks held
+// by processes on the same host are also considered stale as soon as
+// the process that holds them exits.
+const DefaultLockTimeout = time.Hour
+
+// A LockOwner identifies the holder of a lock.
+type LockOwner struct {
+	PID      int
+	Host     string
+	Acquired time.Time
+}
+
+func (o LockOwner) String() string {
+	return fmt.Sprintf("pid %d on %s since %s", o.PID, o.Host, o.Acquired.Format(time.RFC3339))
+}
+
+// stale reports whether the lock should be considered abandoned. If
+// timeout is 0, DefaultLockTimeout is used.
+func (o LockOwner) stale(now time.Time, timeout time.Duration) bool {
+	if timeout == 0 {
+		timeout = DefaultLockTimeout
+	}
+	if now.Sub(o.Acquired) > timeout {
+		return true
+	}
+	if host, _ := os.Hostname(); o.Host == host && o.PID != 0 && !processExists(o.PID) {
+		return true
+	}
+	return false
+}
+
+func (o LockOwner) equal(o2 LockOwner) bool {
+	return o.PID == o2.PID && o.Host == o2.Host && o.Acquired.Equal(o2.Acquired)
+}
+
+// A LockedError is returned when a write lock is held by another
+// writer.
+type LockedError struct {
+	Repo string `json:",omitempty"`
+
+	// CommitID is the version that is locked. It is empty if the
+	// whole store is locked (e.g., while its blobs are being
+	// garbage-collected).
+	CommitID string `json:",omitempty"`
+
+	Owner LockOwner
+}
+
+func (e *LockedError) Error() string {
+	what := "store"
+	if e.CommitID != "" {
+		what = "version " + e.CommitID
+		if e.Repo != "" {
+			what = "version " + e.Repo + "@" + e.CommitID
+		}
+	}
+	return fmt.Sprintf("%s is locked by another writer (%s); wait for it to finish or, if it has died, for the lock to become stale", what, e.Owner)
+}
+
+// IsLocked returns true if err is a *LockedError.
+func IsLocked(err error) bool {
+	_, ok := err.(*LockedError)
+	return ok
+}
+
+const (
+	// locksDir is the dir (in a repo store) that holds the lock dirs
+	// of versions that are being written.
+	locksDir = "__locks"
+
+	// storeLocksDir is the dir (at the root of a multi-repo store)
+	// that holds the lock dirs of store-wide locks.
+	storeLocksDir = ".srclib-locks"
+
+	lockOwnerFile = "owner"
+)
+
+// heldLocks are the lock dirs (keyed on the filesystem and path)
+// held by this process.
+var (
+	heldLocksMu sync.Mutex
+	heldLocks   = map[string]struct{}{}
+)
+
+// An fsLock is an advisory lock in a filesystem. The lock is a
+// directory (which is created atomically) that contains a file
+// describing its owner.
+type fsLock struct {
+	fs  rwvfs.FileSystem
+	dir string
+}
+
+func (l fsLock) key() string { return l.fs.String() + "\x00" + l.dir }
+
+func (l fsLock) heldByUs() bool {
+	heldLocksMu.Lock()
+	defer heldLocksMu.Unlock()
+	_, held := heldLocks[l.key()]
+	return held
+}
+
+// acquire acquires the lock, breaking it if it is stale. If another
+// writer holds the lock, the lock is not acquired and its owner is
+// returned.
+func (l fsLock) acquire(timeout time.Duration) (unlock func() error, holder *LockOwner, err error) {
+	if err := rwvfs.MkdirAll(l.fs, path.Dir(l.dir)); err != nil {
+		return nil, nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := l.fs.Mkdir(l.dir)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return nil, nil, err
+		}
+		owner, err := l.owner(l.dir)
+		if os.IsNotExist(err) && attempt < 3 {
+			continue // released concurrently
+		} else if err != nil {
+			return nil, nil, err
+		}
+		if l.heldByUs() || attempt > 0 || !owner.stale(time.Now(), timeout) {
+			return nil, owner, nil
+		}
+		vlog.Printf("Breaking stale lock %s (held by %s)", l.dir, owner)
+		if holder, err := l.breakStale(*owner); err != nil || holder != nil {
+			return nil, holder, err
+		}
+	}
+
+	host, _ := os.Hostname()
+	owner := LockOwner{PID: os.Getpid(), Host: host, Acquired: time.Now().UTC()}
+	if err := l.writeOwner(owner); err != nil {
+		removeLockDir(l.fs, l.dir)
+		return nil, nil, err
+	}
+	key := l.key()
+	heldLocksMu.Lock()
+	heldLocks[key] = struct{}{}
+	heldLocksMu.Unlock()
+
+	return func() error {
+		heldLocksMu.Lock()
+		delete(heldLocks, key)
+		heldLocksMu.Unlock()
+		return removeLockDir(l.fs, l.dir)
+	}, nil, nil
+}
+
+// wait acquires the lock, polling until the writer that holds it
+// releases it (or until it becomes stale). It should only be used for
+// locks that are held briefly.
+func (l fsLock) wait(timeout time.Duration) (unlock func() error, err error) {
+	for {
+		unlock, holder, err := l.acquire(timeout)
+		if err != nil {
+			return nil, err
+		}
+		if holder == nil {
+			return unlock, nil
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+const lockPollInterval = 50 * time.Millisecond
+
+// staleLockSeq makes the names that stale locks are renamed to
+// unique within this process.
+var staleLockSeq int64
+
+// breakStale removes the lock, which is held by the given (stale)
+// owner.
+//
+// If the filesystem is a Renamer, the lock dir is first renamed to a
+// unique name, so that writers that break the same stale lock
+// concurrently do not remove the lock that one of them acquired after
+// breaking it. If the renamed lock turns out to have been acquired by
+// another writer after the stale owner was read, it is moved back and
+// its owner is returned.
+func (l fsLock) breakStale(stale LockOwner) (holder *LockOwner, err error) {
+	r, ok := l.fs.(Renamer)
+	if !ok {
+		return nil, removeLockDir(l.fs, l.dir)
+	}
+
+	dir := fmt.Sprintf("%s.stale-%d-%d", l.dir, os.Getpid(), atomic.AddInt64(&staleLockSeq, 1))
+	if err := r.Rename(l.dir, dir); os.IsNotExist(err) {
+		return nil, nil // broken concurrently
+	} else if err != nil {
+		return nil, err
+	}
+	owner, err := l.owner(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !owner.equal(stale) {
+		if err := r.Rename(dir, l.dir); err != nil {
+			return nil, err
+		}
+		return owner, nil
+	}
+	return nil, removeLockDir(l.fs, dir)
+}
+
+// owner returns the owner of the lock whose dir is dir. If the owner
+// file has not been written yet, the owner is unknown and the time
+// the lock was acquired is taken from the lock dir.
+func (l fsLock) owner(dir string) (*LockOwner, error) {
+	f, err := l.fs.Open(path.Join(dir, lockOwnerFile))
+	if os.IsNotExist(err) {
+		fi, err := l.fs.Stat(dir)
+		if err != nil {
+			return nil, err
+		}
+		return &LockOwner{Acquired: fi.ModTime()}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	var owner LockOwner
+	if err := json.Unmarshal(b, &owner); err != nil {
+		return nil, fmt.Errorf("lock %s: invalid owner file: %s", dir, err)
+	}
+	return &owner, nil
+}
+
+func (l fsLock) writeOwner(owner LockOwner) error {
+	b, err := json.Marshal(owner)
+	if err != nil {
+		return err
+	}
+	f, err := l.fs.Create(path.Join(l.dir, lockOwnerFile))
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// holder returns the owner of the lock if another writer holds it (and
+// it is not stale), or nil otherwise.
+func (l fsLock) holder() (*LockOwner, error) {
+	if _, err := l.fs.Stat(l.dir); os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if l.heldByUs() {
+		return nil, nil
+	}
+	owner, err := l.owner(l.dir)
+	if os.IsNotExist(err) {
+		return nil, nil // unlocked concurrently
+	} else if err != nil {
+		return nil, err
+	}
+	if owner.stale(time.Now(), 0) {
+		return nil, nil
+	}
+	return owner, nil
+}
+
+func removeLockDir(fs rwvfs.FileSystem, dir string) error {
+	if err := fs.Remove(path.Join(dir, lockOwnerFile)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := fs.Remove(dir); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *fsRepoStore) versionLock(commitID string) fsLock {
+	return fsLock{fs: s.fs, dir: s.fs.Join(locksDir, commitID)}
+}
+
+// LockVersion implements RepoLocker.
+func (s *fsRepoStore) LockVersion(commitID string, timeout time.Duration) (func() error, error) {
+	unlock, holder, err := s.versionLock(commitID).acquire(timeout)
+	if err != nil {
+		return nil, err
+	}
+	if holder != nil {
+		return nil, &LockedError{CommitID: commitID, Owner: *holder}
+	}
+	return unlock, nil
+}
+
+// checkLock returns a *LockedError if another writer holds the lock
+// on the version. It is called before writing to a version, so that
+// writers that did not acquire the lock do not interleave their
+// writes with the lock holder's.
+func (s *fsRepoStore) checkLock(commitID string) error {
+	holder, err := s.versionLock(commitID).holder()
+	if err != nil {
+		return err
+	}
+	if holder != nil {
+		return &LockedError{CommitID: commitID, Owner: *holder}
+	}
+	return nil
+}
+
+// checkNoLocks returns a *LockedError if any version in the repo is
+// locked (by this or another process).
+func (s *fsRepoStore) checkNoLocks() error {
+	fis, err := s.fs.ReadDir(locksDir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		commitID := fi.Name()
+		l := s.versionLock(commitID)
+		owner, err := l.owner(l.dir)
+		if os.IsNotExist(err) {
+			continue // unlocked concurrently
+		} else if err != nil {
+			return err
+		}
+		if !owner.stale(time.Now(), 0) {
+			return &LockedError{CommitID: commitID, Owner: *owner}
+		}
+	}
+	return nil
+}
+
+// checkNoLocks returns a *LockedError if any version of any repo in
+// the store is locked.
+func (s *fsMultiRepoStore) checkNoLocks() error {
+	repos, err := s.Repos()
+	if err != nil {
+		return err
+	}
+	for _, repo := range repos {
+		if err := s.openRepoStore(repo).(*fsRepoStore).checkNoLocks(); err != nil {
+			if e, ok := err.(*LockedError); ok {
+				e.Repo = repo
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// gcLock returns the store-wide lock that GCBlobs holds while it
+// runs.
+func (s *fsMultiRepoStore) gcLock() fsLock {
+	return fsLock{fs: s.fs, dir: s.fs.Join(storeLocksDir, "gc")}
+}
+
+// LockVersion implements MultiRepoLocker. It fails while GCBlobs
+// holds the store-wide lock.
+func (s *fsMultiRepoStore) LockVersion(repo, commitID string, timeout time.Duration) (func() error, error) {
+	if err := s.createRepoDir(repo); err != nil {
+		return nil, err
+	}
+	unlock, err := s.openRepoStore(repo).(RepoLocker).LockVersion(commitID, timeout)
+	if e, ok := err.(*LockedError); ok {
+		e.Repo = repo
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// GCBlobs acquires its lock before checking that no versions are
+	// locked, so checking for its lock after acquiring the version's
+	// lock ensures that they never run concurrently.
+	l := s.gcLock()
+	owner, err := l.owner(l.dir)
+	if os.IsNotExist(err) {
+		err = nil
+	} else if err == nil && !owner.stale(time.Now(), 0) {
+		err = &LockedError{Owner: *owner}
+	}
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	return unlock, nil
+}
+
+var (
+	_ RepoLocker      = (*fsRepoStore)(nil)
+	_ MultiRepoLocker = (*fsMultiRepoStore)(nil)
+)
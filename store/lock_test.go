@@ -0,0 +1,112 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestFSRepoStore_LockVersion(t *testing.T) {
+	rs := NewFSRepoStore(newTestFS()).(*fsRepoStore)
+	u := &unit.SourceUnit{Key: unit.Key{Type: "t", Name: "u"}}
+
+	unlock, err := rs.LockVersion("c", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rs.LockVersion("c", 0); !IsLocked(err) {
+		t.Errorf("LockVersion while locked: got error %v, want locked error", err)
+	}
+	if err := rs.Import("c", u, graph.Output{}); err != nil {
+		t.Errorf("Import while holding lock: %s", err)
+	}
+	if err := unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a lock held by a writer on another host.
+	otherOwner := LockOwner{PID: 1, Host: "other-host", Acquired: time.Now()}
+	l := rs.versionLock("c")
+	if err := rs.fs.Mkdir(locksDir); err != nil && !os.IsExist(err) {
+		t.Fatal(err)
+	}
+	if err := rs.fs.Mkdir(l.dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.writeOwner(otherOwner); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.Import("c", u, graph.Output{}); !IsLocked(err) {
+		t.Errorf("Import while another writer holds lock: got error %v, want locked error", err)
+	}
+	if err := rs.CreateVersion("c", nil); !IsLocked(err) {
+		t.Errorf("CreateVersion while another writer holds lock: got error %v, want locked error", err)
+	}
+	if _, err := rs.LockVersion("c", 0); !IsLocked(err) {
+		t.Errorf("LockVersion while another writer holds lock: got error %v, want locked error", err)
+	}
+
+	// Once the other writer's lock is stale, it is broken.
+	otherOwner.Acquired = time.Now().Add(-2 * DefaultLockTimeout)
+	if err := l.writeOwner(otherOwner); err != nil {
+		t.Fatal(err)
+	}
+	unlock, err = rs.LockVersion("c", 0)
+	if err != nil {
+		t.Fatalf("LockVersion with stale lock: %s", err)
+	}
+	if err := unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.CreateVersion("c", nil); err != nil {
+		t.Errorf("CreateVersion after unlock: %s", err)
+	}
+}
+
+func TestFSRepoStore_LockVersion_timeout(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "srclib-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	rs := NewFSRepoStore(OSFS(tmpDir)).(*fsRepoStore)
+
+	// Simulate a lock held by a writer on another host for a minute.
+	l := rs.versionLock("c")
+	if err := rwvfs.MkdirAll(rs.fs, l.dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.writeOwner(LockOwner{PID: 1, Host: "other-host", Acquired: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := rs.LockVersion("c", 0); !IsLocked(err) {
+		t.Errorf("LockVersion with default timeout: got error %v, want locked error", err)
+	}
+	unlock, err := rs.LockVersion("c", time.Second)
+	if err != nil {
+		t.Fatalf("LockVersion with timeout shorter than lock's age: %s", err)
+	}
+	if err := unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The stale lock dir was renamed before it was removed; nothing
+	// should be left behind.
+	fis, err := rs.fs.ReadDir(locksDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 0 {
+		var names []string
+		for _, fi := range fis {
+			names = append(names, fi.Name())
+		}
+		t.Errorf("got leftover lock dirs %v, want none", names)
+	}
+}
@@ -71,8 +71,8 @@ func (s *memoryMultiRepoStore) Import(repo, commitID string, unit *unit.SourceUn
 	return s.repos[repo].Import(commitID, unit, data)
 }
 
-func (s *memoryMultiRepoStore) CreateVersion(repo, commitID string) error {
-	return s.repos[repo].CreateVersion(commitID)
+func (s *memoryMultiRepoStore) CreateVersion(repo, commitID string, meta *VersionMeta) error {
+	return s.repos[repo].CreateVersion(commitID, meta)
 }
 
 func (s *memoryMultiRepoStore) String() string { return "memoryMultiRepoStore" }
@@ -104,7 +104,7 @@ func (s *memoryRepoStore) Versions(f ...VersionFilter) ([]*Version, error) {
 		}
 
 	}
-	return versions, nil
+	return selectVersions(versions, f), nil
 }
 
 func (s *memoryRepoStore) Import(commitID string, unit *unit.SourceUnit, data graph.Output) error {
@@ -120,8 +120,8 @@ func (s *memoryRepoStore) Import(commitID string, unit *unit.SourceUnit, data gr
 	return s.trees[commitID].Import(unit, data)
 }
 
-func (s *memoryRepoStore) CreateVersion(commitID string) error {
-	s.versions = append(s.versions, &Version{CommitID: commitID})
+func (s *memoryRepoStore) CreateVersion(commitID string, meta *VersionMeta) error {
+	s.versions = append(s.versions, &Version{CommitID: commitID, Meta: meta})
 	return nil
 }
 
@@ -0,0 +1,10 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package store
+
+import "os"
+
+func mmapFile(f *os.File) ([]byte, error) { return nil, errMmapUnsupported }
+
+func munmap(b []byte) error { return errMmapUnsupported }
@@ -17,7 +17,8 @@ type MultiRepoStore interface {
 
 	// RepoStore's methods call the corresponding methods on the
 	// RepoStore of each repository contained within this multi-repo
-	// store. The combined results are returned (in undefined order).
+	// store. The combined results are returned in the order described
+	// in paging.go.
 	RepoStore
 }
 
@@ -30,7 +31,9 @@ type MultiRepoImporter interface {
 
 	// CreateVersion creates the version entry for the given commit. All other data (including
 	// indexes) needs to exist before this gets called.
-	CreateVersion(repo, commitID string) error
+	//
+	// The metadata (if non-nil) is stored with the version entry.
+	CreateVersion(repo, commitID string, meta *VersionMeta) error
 }
 
 type MultiRepoIndexer interface {
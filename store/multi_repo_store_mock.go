@@ -14,7 +14,7 @@ type MockMultiRepoStore struct {
 
 	Import_        func(repo, commitID string, unit *unit.SourceUnit, data graph.Output) error
 	Index_         func(repo, commitID string) error
-	CreateVersion_ func(repo, commit string) error
+	CreateVersion_ func(repo, commit string, meta *VersionMeta) error
 }
 
 func (m MockMultiRepoStore) Repos(f ...RepoFilter) ([]string, error) {
@@ -45,8 +45,8 @@ func (m MockMultiRepoStore) Index(repo, commitID string) error {
 	return m.Index_(repo, commitID)
 }
 
-func (m MockMultiRepoStore) CreateVersion(repo, commitID string) error {
-	return m.CreateVersion_(repo, commitID)
+func (m MockMultiRepoStore) CreateVersion(repo, commitID string, meta *VersionMeta) error {
+	return m.CreateVersion_(repo, commitID, meta)
 }
 
 var _ MultiRepoStoreImporterIndexer = MockMultiRepoStore{}
@@ -2,9 +2,11 @@ package store
 
 import (
 	"testing"
+	"time"
 
 	"sort"
 
+	"sourcegraph.com/sourcegraph/srclib/ann"
 	"sourcegraph.com/sourcegraph/srclib/graph"
 	"sourcegraph.com/sourcegraph/srclib/unit"
 )
@@ -16,6 +18,7 @@ func testMultiRepoStore(t *testing.T, newFn func() MultiRepoStoreImporter) {
 	testMultiRepoStore_Repos(t, newFn())
 	testMultiRepoStore_Repos_ByRepos(t, newFn())
 	testMultiRepoStore_Versions(t, newFn())
+	testMultiRepoStore_Versions_meta(t, newFn())
 	testMultiRepoStore_Units(t, newFn())
 	testMultiRepoStore_Def(t, newFn())
 	testMultiRepoStore_Defs(t, newFn())
@@ -27,6 +30,10 @@ func testMultiRepoStore(t *testing.T, newFn func() MultiRepoStoreImporter) {
 	testMultiRepoStore_Refs(t, newFn())
 	testMultiRepoStore_Refs_filterByRepoCommitAndFile(t, newFn())
 	testMultiRepoStore_Refs_filterByDef(t, newFn())
+	testMultiRepoStore_Relations(t, newFn())
+	testMultiRepoStore_Anns(t, newFn())
+	testMultiRepoStore_Defs_After(t, newFn())
+	testMultiRepoStore_Refs_After(t, newFn())
 }
 
 func testMultiRepoStore_uninitialized(t *testing.T, mrs MultiRepoStore) {
@@ -42,7 +49,7 @@ func testMultiRepoStore_Import_empty(t *testing.T, mrs MultiRepoStoreImporter) {
 	if err := mrs.Import("r", "c", nil, graph.Output{}); err != nil {
 		t.Errorf("%s: Import(c, nil, empty): %s", mrs, err)
 	}
-	if err := mrs.CreateVersion("r", "c"); err != nil {
+	if err := mrs.CreateVersion("r", "c", nil); err != nil {
 		t.Errorf("%s: CreateVersion(c): %s", mrs, err)
 	}
 	testTreeStore_empty(t, mrs)
@@ -69,7 +76,7 @@ func testMultiRepoStore_Import(t *testing.T, mrs MultiRepoStoreImporter) {
 	if err := mrs.Import("r", "c", unit, data); err != nil {
 		t.Errorf("%s: Import(c, %v, data): %s", mrs, unit, err)
 	}
-	if err := mrs.CreateVersion("r", "c"); err != nil {
+	if err := mrs.CreateVersion("r", "c", nil); err != nil {
 		t.Errorf("%s: CreateVersion(c): %s", mrs, err)
 	}
 }
@@ -80,7 +87,7 @@ func testMultiRepoStore_Repos(t *testing.T, mrs MultiRepoStoreImporter) {
 		if err := mrs.Import(repo, "c", unit, graph.Output{}); err != nil {
 			t.Errorf("%s: Import(%s, c, %v, empty data): %s", mrs, repo, unit, err)
 		}
-		if err := mrs.CreateVersion(repo, "c"); err != nil {
+		if err := mrs.CreateVersion(repo, "c", nil); err != nil {
 			t.Errorf("%s: CreateVersion(%s, c): %s", mrs, repo, err)
 		}
 	}
@@ -112,7 +119,7 @@ func testMultiRepoStore_Repos_ByRepos(t *testing.T, mrs MultiRepoStoreImporter)
 		if err := mrs.Import(repo, "c", unit, graph.Output{}); err != nil {
 			t.Errorf("%s: Import(%s, c, %v, empty data): %s", mrs, repo, unit, err)
 		}
-		if err := mrs.CreateVersion(repo, "c"); err != nil {
+		if err := mrs.CreateVersion(repo, "c", nil); err != nil {
 			t.Errorf("%s: CreateVersion(%s, c): %s", mrs, repo, err)
 		}
 	}
@@ -166,7 +173,7 @@ func TestFSMultiRepoStore_Repos_customPathFuncs(t *testing.T) {
 				t.Errorf("%s: Import(%s, c, %v, empty data): %s", label, repo, unit, err)
 				continue
 			}
-			if err := mrs.CreateVersion(repo, "c"); err != nil {
+			if err := mrs.CreateVersion(repo, "c", nil); err != nil {
 				t.Errorf("%s: CreateVersion(%s, c): %s", mrs, repo, err)
 			}
 		}
@@ -199,7 +206,7 @@ func testMultiRepoStore_Versions(t *testing.T, mrs MultiRepoStoreImporter) {
 		if err := mrs.Import("r", version, unit, graph.Output{}); err != nil {
 			t.Errorf("%s: Import(%s, %v, empty data): %s", mrs, version, unit, err)
 		}
-		if err := mrs.CreateVersion("r", version); err != nil {
+		if err := mrs.CreateVersion("r", version, nil); err != nil {
 			t.Errorf("%s: CreateVersion(%s): %s", mrs, version, err)
 		}
 	}
@@ -223,6 +230,58 @@ func testMultiRepoStore_Versions(t *testing.T, mrs MultiRepoStoreImporter) {
 	}
 }
 
+func testMultiRepoStore_Versions_meta(t *testing.T, mrs MultiRepoStoreImporter) {
+	t0 := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	metas := map[string]*VersionMeta{
+		"c1": {ImportedAt: t0, Branch: "master", NumUnits: 1},
+		"c2": {ImportedAt: t0.Add(time.Hour), Branch: "dev", NumUnits: 1},
+		"c3": {ImportedAt: t0.Add(2 * time.Hour), Branch: "master", NumUnits: 1},
+	}
+	for _, version := range []string{"c1", "c2", "c3"} {
+		unit := &unit.SourceUnit{Key: unit.Key{Type: "t1", Name: "u1"}}
+		if err := mrs.Import("r", version, unit, graph.Output{}); err != nil {
+			t.Errorf("%s: Import(%s, %v, empty data): %s", mrs, version, unit, err)
+		}
+		if err := mrs.CreateVersion("r", version, metas[version]); err != nil {
+			t.Errorf("%s: CreateVersion(%s): %s", mrs, version, err)
+		}
+	}
+
+	versions, err := mrs.Versions(ByCommitIDs("c2"))
+	if err != nil {
+		t.Errorf("%s: Versions(ByCommitIDs c2): %s", mrs, err)
+	}
+	if want := []*Version{{Repo: "r", CommitID: "c2", Meta: metas["c2"]}}; !deepEqual(versions, want) {
+		t.Errorf("%s: Versions(ByCommitIDs c2): got %v, want %v", mrs, versions, want)
+	}
+
+	versions, err = mrs.Versions(ByBranch("master"))
+	if err != nil {
+		t.Errorf("%s: Versions(ByBranch master): %s", mrs, err)
+	}
+	want := []*Version{{Repo: "r", CommitID: "c1", Meta: metas["c1"]}, {Repo: "r", CommitID: "c3", Meta: metas["c3"]}}
+	if !deepEqual(versions, want) {
+		t.Errorf("%s: Versions(ByBranch master): got %v, want %v", mrs, versions, want)
+	}
+
+	versions, err = mrs.Versions(NewestVersions(2))
+	if err != nil {
+		t.Errorf("%s: Versions(NewestVersions 2): %s", mrs, err)
+	}
+	want = []*Version{{Repo: "r", CommitID: "c3", Meta: metas["c3"]}, {Repo: "r", CommitID: "c2", Meta: metas["c2"]}}
+	if !deepEqual(versions, want) {
+		t.Errorf("%s: Versions(NewestVersions 2): got %v, want %v", mrs, versions, want)
+	}
+
+	versions, err = mrs.Versions(NewestVersions(-1))
+	if err != nil {
+		t.Errorf("%s: Versions(NewestVersions -1): %s", mrs, err)
+	}
+	if len(versions) != 0 {
+		t.Errorf("%s: Versions(NewestVersions -1): got %v, want none", mrs, versions)
+	}
+}
+
 func testMultiRepoStore_Units(t *testing.T, mrs MultiRepoStoreImporter) {
 	units := []*unit.SourceUnit{
 		{Key: unit.Key{Type: "t1", Name: "u1"}},
@@ -238,7 +297,7 @@ func testMultiRepoStore_Units(t *testing.T, mrs MultiRepoStoreImporter) {
 			t.Fatalf("%s: Index: %s", mrs, err)
 		}
 	}
-	if err := mrs.CreateVersion("r", "c"); err != nil {
+	if err := mrs.CreateVersion("r", "c", nil); err != nil {
 		t.Errorf("%s: CreateVersion(c): %s", mrs, err)
 	}
 
@@ -279,7 +338,7 @@ func testMultiRepoStore_Def(t *testing.T, mrs MultiRepoStoreImporter) {
 	if err := mrs.Import("r", "c", unit, data); err != nil {
 		t.Errorf("%s: Import(c, %v, data): %s", mrs, unit, err)
 	}
-	if err := mrs.CreateVersion("r", "c"); err != nil {
+	if err := mrs.CreateVersion("r", "c", nil); err != nil {
 		t.Errorf("%s: CreateVersion(c): %s", mrs, err)
 	}
 
@@ -323,7 +382,7 @@ func testMultiRepoStore_Defs(t *testing.T, mrs MultiRepoStoreImporter) {
 	if err := mrs.Import("r", "c", unit, data); err != nil {
 		t.Errorf("%s: Import(c, %v, data): %s", mrs, unit, err)
 	}
-	if err := mrs.CreateVersion("r", "c"); err != nil {
+	if err := mrs.CreateVersion("r", "c", nil); err != nil {
 		t.Errorf("%s: CreateVersion(c): %s", mrs, err)
 	}
 
@@ -371,13 +430,13 @@ func testMultiRepoStore_Defs_filter(t *testing.T, mrs MultiRepoStoreImporter) {
 			t.Fatalf("%s: Index: %s", mrs, err)
 		}
 	}
-	if err := mrs.CreateVersion("r", "c"); err != nil {
+	if err := mrs.CreateVersion("r", "c", nil); err != nil {
 		t.Errorf("%s: CreateVersion: %s", mrs, err)
 	}
-	if err := mrs.CreateVersion("r", "c2"); err != nil {
+	if err := mrs.CreateVersion("r", "c2", nil); err != nil {
 		t.Errorf("%s: CreateVersion: %s", mrs, err)
 	}
-	if err := mrs.CreateVersion("r2", "c2"); err != nil {
+	if err := mrs.CreateVersion("r2", "c2", nil); err != nil {
 		t.Errorf("%s: CreateVersion: %s", mrs, err)
 	}
 
@@ -409,7 +468,7 @@ func testMultiRepoStore_Defs_ByRepos(t *testing.T, mrs MultiRepoStoreImporter) {
 				t.Fatalf("%s: Index: %s", mrs, err)
 			}
 		}
-		if err := mrs.CreateVersion(repo, "c"); err != nil {
+		if err := mrs.CreateVersion(repo, "c", nil); err != nil {
 			t.Errorf("%s: CreateVersion: %s", mrs, err)
 		}
 	}
@@ -447,7 +506,7 @@ func testMultiRepoStore_Defs_ByRepos_ByDefQuery(t *testing.T, mrs MultiRepoStore
 				t.Fatalf("%s: Index: %s", mrs, err)
 			}
 		}
-		if err := mrs.CreateVersion(repo, "c"); err != nil {
+		if err := mrs.CreateVersion(repo, "c", nil); err != nil {
 			t.Errorf("%s: CreateVersion: %s", mrs, err)
 		}
 	}
@@ -490,7 +549,7 @@ func testMultiRepoStore_Defs_ByRepoCommitIDs(t *testing.T, mrs MultiRepoStoreImp
 					t.Fatalf("%s: Index: %s", mrs, err)
 				}
 			}
-			if err := mrs.CreateVersion(repo, commitID); err != nil {
+			if err := mrs.CreateVersion(repo, commitID, nil); err != nil {
 				t.Errorf("%s: CreateVersion: %s", mrs, err)
 			}
 		}
@@ -531,7 +590,7 @@ func testMultiRepoStore_Defs_ByRepoCommitIDs_ByDefQuery(t *testing.T, mrs MultiR
 					t.Fatalf("%s: Index: %s", mrs, err)
 				}
 			}
-			if err := mrs.CreateVersion(repo, commitID); err != nil {
+			if err := mrs.CreateVersion(repo, commitID, nil); err != nil {
 				t.Errorf("%s: CreateVersion: %s", mrs, err)
 			}
 		}
@@ -581,7 +640,7 @@ func testMultiRepoStore_Refs(t *testing.T, mrs MultiRepoStoreImporter) {
 	if err := mrs.Import("r", "c", unit, data); err != nil {
 		t.Errorf("%s: Import(c, %v, data): %s", mrs, unit, err)
 	}
-	if err := mrs.CreateVersion("r", "c"); err != nil {
+	if err := mrs.CreateVersion("r", "c", nil); err != nil {
 		t.Errorf("%s: CreateVersion(c): %s", mrs, err)
 	}
 
@@ -665,13 +724,13 @@ func testMultiRepoStore_Refs_filterByRepoCommitAndFile(t *testing.T, mrs MultiRe
 			t.Fatalf("%s: Index: %s", mrs, err)
 		}
 	}
-	if err := mrs.CreateVersion("r", "c"); err != nil {
+	if err := mrs.CreateVersion("r", "c", nil); err != nil {
 		t.Errorf("%s: CreateVersion: %s", mrs, err)
 	}
-	if err := mrs.CreateVersion("r", "c2"); err != nil {
+	if err := mrs.CreateVersion("r", "c2", nil); err != nil {
 		t.Errorf("%s: CreateVersion: %s", mrs, err)
 	}
-	if err := mrs.CreateVersion("r2", "c"); err != nil {
+	if err := mrs.CreateVersion("r2", "c", nil); err != nil {
 		t.Errorf("%s: CreateVersion: %s", mrs, err)
 	}
 
@@ -728,7 +787,7 @@ func testMultiRepoStore_Refs_filterByDef(t *testing.T, mrs MultiRepoStoreImporte
 			t.Fatalf("%s: Index: %s", mrs, err)
 		}
 	}
-	if err := mrs.CreateVersion("r", "c"); err != nil {
+	if err := mrs.CreateVersion("r", "c", nil); err != nil {
 		t.Errorf("%s: CreateVersion: %s", mrs, err)
 	}
 
@@ -760,3 +819,248 @@ func testMultiRepoStore_Refs_filterByDef(t *testing.T, mrs MultiRepoStoreImporte
 		t.Errorf("%s: Refs(): got refs %v, want %v", mrs, refs, want)
 	}
 }
+
+func testMultiRepoStore_Relations(t *testing.T, mrs MultiRepoStoreImporter) {
+	imports := []struct {
+		repo, unit string
+		rel        *graph.Relation
+	}{
+		{"r1", "u1", &graph.Relation{From: graph.DefKey{Path: "U"}, To: graph.RefDefKey{DefPath: "I"}, Kind: graph.RelationImplements}},
+		{"r1", "u2", &graph.Relation{From: graph.DefKey{Path: "T"}, To: graph.RefDefKey{DefUnitType: "t", DefUnit: "u1", DefPath: "I"}, Kind: graph.RelationImplements}},
+		{"r2", "u1", &graph.Relation{From: graph.DefKey{Path: "V"}, To: graph.RefDefKey{DefRepo: "r1", DefUnitType: "t", DefUnit: "u1", DefPath: "I"}, Kind: graph.RelationImplements}},
+		{"r2", "u2", &graph.Relation{From: graph.DefKey{Path: "W"}, To: graph.RefDefKey{DefUnitType: "t", DefUnit: "u1", DefPath: "I"}, Kind: graph.RelationImplements}},
+	}
+	for _, imp := range imports {
+		u := &unit.SourceUnit{Key: unit.Key{Type: "t", Name: imp.unit}, Info: unit.Info{Files: []string{"f"}}}
+		if err := mrs.Import(imp.repo, "c", u, graph.Output{Relations: []*graph.Relation{imp.rel}}); err != nil {
+			t.Errorf("%s: Import: %s", mrs, err)
+		}
+	}
+	for _, repo := range []string{"r1", "r2"} {
+		if mrs, ok := mrs.(MultiRepoIndexer); ok {
+			if err := mrs.Index(repo, "c"); err != nil {
+				t.Fatalf("%s: Index: %s", mrs, err)
+			}
+		}
+		if err := mrs.CreateVersion(repo, "c", nil); err != nil {
+			t.Errorf("%s: CreateVersion: %s", mrs, err)
+		}
+	}
+
+	rs, ok := mrs.(RelationStore)
+	if !ok {
+		t.Errorf("%s: does not implement RelationStore", mrs)
+		return
+	}
+	rel := func(repo, unit, path string, to graph.RefDefKey) *graph.Relation {
+		return &graph.Relation{
+			From: graph.DefKey{Repo: repo, CommitID: "c", UnitType: "t", Unit: unit, Path: path},
+			To:   to,
+			Kind: graph.RelationImplements,
+		}
+	}
+	toI := graph.RefDefKey{DefRepo: "r1", DefUnitType: "t", DefUnit: "u1", DefPath: "I"}
+	toR2I := graph.RefDefKey{DefRepo: "r2", DefUnitType: "t", DefUnit: "u1", DefPath: "I"}
+
+	tests := []struct {
+		filters  []RelationFilter
+		wantRels []*graph.Relation
+	}{
+		{
+			[]RelationFilter{ByRelationTo(toI)},
+			[]*graph.Relation{rel("r1", "u1", "U", toI), rel("r1", "u2", "T", toI), rel("r2", "u1", "V", toI)},
+		},
+		{
+			[]RelationFilter{ByRelationTo(toR2I)},
+			[]*graph.Relation{rel("r2", "u2", "W", toR2I)},
+		},
+		{
+			[]RelationFilter{ByRelationFrom(graph.DefKey{Repo: "r1", CommitID: "c", UnitType: "t", Unit: "u2", Path: "T"})},
+			[]*graph.Relation{rel("r1", "u2", "T", toI)},
+		},
+		{
+			[]RelationFilter{ByRepos("r2"), ByRelationKinds(graph.RelationImplements)},
+			[]*graph.Relation{rel("r2", "u1", "V", toI), rel("r2", "u2", "W", toR2I)},
+		},
+	}
+	for _, test := range tests {
+		rels, err := rs.Relations(test.filters...)
+		if err != nil {
+			t.Errorf("%s: Relations(%v): %s", mrs, test.filters, err)
+			continue
+		}
+		if !deepEqual(rels, test.wantRels) {
+			t.Errorf("%s: Relations(%v): got relations %v, want %v", mrs, test.filters, rels, test.wantRels)
+		}
+	}
+}
+
+func testMultiRepoStore_Anns(t *testing.T, mrs MultiRepoStoreImporter) {
+	imports := []struct{ repo, unit string }{{"r2", "u1"}, {"r1", "u2"}, {"r1", "u1"}}
+	for _, imp := range imports {
+		u := &unit.SourceUnit{Key: unit.Key{Type: "t", Name: imp.unit}, Info: unit.Info{Files: []string{"f"}}}
+		data := graph.Output{Anns: []*ann.Ann{{File: "f", StartLine: 1, EndLine: 2, Type: "t"}}}
+		if err := mrs.Import(imp.repo, "c", u, data); err != nil {
+			t.Errorf("%s: Import: %s", mrs, err)
+		}
+	}
+	for _, repo := range []string{"r1", "r2"} {
+		if mrs, ok := mrs.(MultiRepoIndexer); ok {
+			if err := mrs.Index(repo, "c"); err != nil {
+				t.Fatalf("%s: Index: %s", mrs, err)
+			}
+		}
+		if err := mrs.CreateVersion(repo, "c", nil); err != nil {
+			t.Errorf("%s: CreateVersion: %s", mrs, err)
+		}
+	}
+
+	as, ok := mrs.(AnnStore)
+	if !ok {
+		t.Errorf("%s: does not implement AnnStore", mrs)
+		return
+	}
+	a := func(repo, unit string) *ann.Ann {
+		return &ann.Ann{Repo: repo, CommitID: "c", UnitType: "t", Unit: unit, File: "f", StartLine: 1, EndLine: 2, Type: "t"}
+	}
+
+	tests := []struct {
+		filters  []AnnFilter
+		wantAnns []*ann.Ann
+	}{
+		{nil, []*ann.Ann{a("r1", "u1"), a("r1", "u2"), a("r2", "u1")}},
+		{[]AnnFilter{ByRepos("r1")}, []*ann.Ann{a("r1", "u1"), a("r1", "u2")}},
+		{[]AnnFilter{ByUnits(unit.ID2{Type: "t", Name: "u1"})}, []*ann.Ann{a("r1", "u1"), a("r2", "u1")}},
+		{[]AnnFilter{ByUnitKey(unit.Key{Repo: "r1", CommitID: "c", Type: "t", Name: "u2"})}, []*ann.Ann{a("r1", "u2")}},
+	}
+	for _, test := range tests {
+		anns, err := as.Anns(test.filters...)
+		if err != nil {
+			t.Errorf("%s: Anns(%v): %s", mrs, test.filters, err)
+			continue
+		}
+		if !deepEqual(anns, test.wantAnns) {
+			t.Errorf("%s: Anns(%v): got anns %v, want %v", mrs, test.filters, anns, test.wantAnns)
+		}
+	}
+}
+
+// importPagingTestData imports 2 repos, each with 2 commits and 2
+// source units, with 3 defs, 3 refs and an annotation in each source
+// unit.
+func importPagingTestData(t *testing.T, mrs MultiRepoStoreImporter) {
+	for _, repo := range []string{"r2", "r1"} {
+		for _, commitID := range []string{"c2", "c1"} {
+			for _, unitName := range []string{"u2", "u1"} {
+				u := &unit.SourceUnit{Key: unit.Key{Type: "t", Name: unitName}, Info: unit.Info{Files: []string{"f"}}}
+				data := graph.Output{
+					Defs: []*graph.Def{
+						{DefKey: graph.DefKey{Path: "p3"}},
+						{DefKey: graph.DefKey{Path: "p1"}},
+						{DefKey: graph.DefKey{Path: "p2"}},
+					},
+					Refs: []*graph.Ref{
+						{DefPath: "p3", File: "f", Start: 3, End: 4},
+						{DefPath: "p1", File: "f", Start: 1, End: 2},
+						{DefPath: "p2", File: "f", Start: 2, End: 3},
+					},
+					Anns: []*ann.Ann{{File: "f", StartLine: 1, EndLine: 1, Type: "t"}},
+				}
+				if err := mrs.Import(repo, commitID, u, data); err != nil {
+					t.Errorf("%s: Import(%s, %s, %v, data): %s", mrs, repo, commitID, u, err)
+				}
+			}
+			if mrs, ok := mrs.(MultiRepoIndexer); ok {
+				if err := mrs.Index(repo, commitID); err != nil {
+					t.Fatalf("%s: Index: %s", mrs, err)
+				}
+			}
+			if err := mrs.CreateVersion(repo, commitID, nil); err != nil {
+				t.Errorf("%s: CreateVersion(%s, %s): %s", mrs, repo, commitID, err)
+			}
+		}
+	}
+}
+
+func testMultiRepoStore_Defs_After(t *testing.T, mrs MultiRepoStoreImporter) {
+	importPagingTestData(t, mrs)
+
+	allDefs, err := mrs.Defs()
+	if err != nil {
+		t.Fatalf("%s: Defs(): %s", mrs, err)
+	}
+	if len(allDefs) != 24 {
+		t.Fatalf("%s: Defs(): got %d defs, want 24", mrs, len(allDefs))
+	}
+	if !sort.IsSorted(defsInStoreOrder(allDefs)) {
+		t.Errorf("%s: Defs(): got defs %v, want them in store order", mrs, allDefs)
+	}
+
+	var (
+		pagedDefs []*graph.Def
+		cursor    *Cursor
+	)
+	for i := 0; ; i++ {
+		if i > len(allDefs) {
+			t.Fatalf("%s: Defs(After, Limit): too many pages", mrs)
+		}
+		defs, err := mrs.Defs(After(cursor), Limit(5, 0))
+		if err != nil {
+			t.Fatalf("%s: Defs(After(%v), Limit): %s", mrs, cursor, err)
+		}
+		if len(defs) > 5 {
+			t.Fatalf("%s: Defs(After(%v), Limit): got %d defs, want at most 5", mrs, cursor, len(defs))
+		}
+		if len(defs) == 0 {
+			break
+		}
+		pagedDefs = append(pagedDefs, defs...)
+		cursor = DefCursor(defs[len(defs)-1])
+	}
+	if !deepEqual(pagedDefs, allDefs) {
+		t.Errorf("%s: Defs(After, Limit): got paged defs %v, want %v", mrs, pagedDefs, allDefs)
+	}
+}
+
+func testMultiRepoStore_Refs_After(t *testing.T, mrs MultiRepoStoreImporter) {
+	importPagingTestData(t, mrs)
+
+	allRefs, err := mrs.Refs()
+	if err != nil {
+		t.Fatalf("%s: Refs(): %s", mrs, err)
+	}
+	if len(allRefs) != 24 {
+		t.Fatalf("%s: Refs(): got %d refs, want 24", mrs, len(allRefs))
+	}
+
+	var (
+		pagedRefs []*graph.Ref
+		cursor    *Cursor
+	)
+	for i := 0; ; i++ {
+		if i > len(allRefs) {
+			t.Fatalf("%s: Refs(After, Limit): too many pages", mrs)
+		}
+		refs, err := mrs.Refs(After(cursor), Limit(5, 0))
+		if err != nil {
+			t.Fatalf("%s: Refs(After(%v), Limit): %s", mrs, cursor, err)
+		}
+		if len(refs) > 5 {
+			t.Fatalf("%s: Refs(After(%v), Limit): got %d refs, want at most 5", mrs, cursor, len(refs))
+		}
+		if len(refs) == 0 {
+			break
+		}
+		pagedRefs = append(pagedRefs, refs...)
+
+		// Round-trip the cursor through its serialized form, as a
+		// client would.
+		cursor, err = ParseCursor(RefCursor(refs[len(refs)-1]).String())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !deepEqual(pagedRefs, allRefs) {
+		t.Errorf("%s: Refs(After, Limit): got paged refs %v, want %v", mrs, pagedRefs, allRefs)
+	}
+}
@@ -0,0 +1,554 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// ORDERING
+//
+// The combined stores (repoStores, treeStores, and unitStores) return
+// results in a deterministic order: by repo, commit ID, source unit
+// name, and source unit type, and then (within a source unit) by def
+// path for defs, or by file, start, end, target def, and kind for refs.
+//
+// This ordering is what makes cursor-based paging (see After)
+// possible. Because every item in a child store sorts before every
+// item in the next child store, the combined stores can visit their
+// child stores in order and stop once a page is full.
+
+// A Cursor identifies a position in the ordered results of a Defs or
+// Refs call. It is created from the last item of a page (with
+// DefCursor or RefCursor) and passed to After to fetch the next page.
+//
+// Cursors are opaque to clients; use String and ParseCursor to
+// serialize them.
+type Cursor struct {
+	// Kind is "def" or "ref".
+	Kind string `json:"k"`
+
+	Repo     string `json:"r,omitempty"`
+	CommitID string `json:"c,omitempty"`
+	UnitType string `json:"ut,omitempty"`
+	Unit     string `json:"u,omitempty"`
+
+	// Path is the def path (for def cursors).
+	Path string `json:"p,omitempty"`
+
+	// File, Start, End, Def, RefKind, and the DefXyz fields identify
+	// the ref (for ref cursors).
+	File        string `json:"f,omitempty"`
+	Start       uint32 `json:"s,omitempty"`
+	End         uint32 `json:"e,omitempty"`
+	Def         bool   `json:"d,omitempty"`
+	DefRepo     string `json:"dr,omitempty"`
+	DefUnitType string `json:"dut,omitempty"`
+	DefUnit     string `json:"du,omitempty"`
+	DefPath     string `json:"dp,omitempty"`
+	RefKind     string `json:"rk,omitempty"`
+}
+
+const (
+	defCursorKind = "def"
+	refCursorKind = "ref"
+)
+
+// DefCursor returns a cursor positioned at def.
+func DefCursor(def *graph.Def) *Cursor {
+	return &Cursor{
+		Kind:     defCursorKind,
+		Repo:     def.Repo,
+		CommitID: def.CommitID,
+		UnitType: def.UnitType,
+		Unit:     def.Unit,
+		Path:     def.Path,
+	}
+}
+
+// RefCursor returns a cursor positioned at ref.
+func RefCursor(ref *graph.Ref) *Cursor {
+	return &Cursor{
+		Kind:        refCursorKind,
+		Repo:        ref.Repo,
+		CommitID:    ref.CommitID,
+		UnitType:    ref.UnitType,
+		Unit:        ref.Unit,
+		File:        ref.File,
+		Start:       ref.Start,
+		End:         ref.End,
+		Def:         ref.Def,
+		DefRepo:     ref.DefRepo,
+		DefUnitType: ref.DefUnitType,
+		DefUnit:     ref.DefUnit,
+		DefPath:     ref.DefPath,
+		RefKind:     ref.Kind,
+	}
+}
+
+// String returns the opaque, URL-safe serialization of the cursor.
+func (c *Cursor) String() string {
+	b, err := json.Marshal(c)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParseCursor parses a cursor previously serialized with String.
+func ParseCursor(s string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor %q: %s", s, err)
+	}
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("invalid cursor %q: %s", s, err)
+	}
+	if c.Kind != defCursorKind && c.Kind != refCursorKind {
+		return nil, fmt.Errorf("invalid cursor %q: unknown kind %q", s, c.Kind)
+	}
+	return &c, nil
+}
+
+// AfterFilter is implemented by filters that restrict their selection
+// to items that sort after a cursor.
+type AfterFilter interface {
+	After() *Cursor
+}
+
+// After returns a filter that selects only the defs or refs that sort
+// strictly after the cursor (in the order described at the top of
+// this file). If c is nil, all items are selected, but the query is
+// still executed as a paged query.
+//
+// When After is combined with Limit, the store returns the first
+// results in sort order (instead of whichever results it happens to
+// read first), skipping child stores that sort entirely before the
+// cursor and stopping once the page is full. The cursor for the next
+// page is DefCursor or RefCursor of the last result.
+//
+// A def cursor must only be used with Defs, and a ref cursor only
+// with Refs.
+func After(c *Cursor) interface {
+	DefFilter
+	RefFilter
+	AfterFilter
+} {
+	return &afterFilter{c: c}
+}
+
+type afterFilter struct {
+	c *Cursor // nil means "from the beginning"
+
+	// inRepo, inTree, and inUnit are whether the filter is being
+	// applied to a single repo, tree, or source unit (see forRepo,
+	// forTree, and forUnit). The cursor's fields for that scope are
+	// cleared, so the same fields of the defs and refs are ignored
+	// when comparing them with the cursor. (Stores may or may not
+	// fill in those fields, depending on how the items were read.)
+	inRepo, inTree, inUnit bool
+
+	// impliedRepo and impliedUnit are the repo and source unit that
+	// the filter is being applied to. They are needed to compare ref
+	// DefRepo, DefUnitType, and DefUnit fields, which are empty when
+	// the def is in the same repo or source unit as the ref (see
+	// compareRefs).
+	impliedRepo string
+	impliedUnit unit.ID2
+}
+
+func (f *afterFilter) String() string {
+	if f.c == nil {
+		return "After(nil)"
+	}
+	return fmt.Sprintf("After(%+v)", *f.c)
+}
+func (f *afterFilter) After() *Cursor { return f.c }
+func (f *afterFilter) SelectDef(def *graph.Def) bool {
+	if f.c == nil {
+		return true
+	}
+	key := def.DefKey
+	if f.inRepo {
+		key.Repo = ""
+	}
+	if f.inTree {
+		key.CommitID = ""
+	}
+	if f.inUnit {
+		key.UnitType, key.Unit = "", ""
+	}
+	return compareDefCursor(&graph.Def{DefKey: key}, f.c) > 0
+}
+func (f *afterFilter) SelectRef(ref *graph.Ref) bool {
+	if f.c == nil {
+		return true
+	}
+	r := *ref
+	if f.inRepo {
+		r.Repo = ""
+		if r.DefRepo == f.impliedRepo {
+			r.DefRepo = ""
+		}
+	}
+	if f.inTree {
+		r.CommitID = ""
+	}
+	if f.inUnit {
+		r.UnitType, r.Unit = "", ""
+		if r.DefUnitType == "" {
+			r.DefUnitType = f.impliedUnit.Type
+		}
+		if r.DefUnit == "" {
+			r.DefUnit = f.impliedUnit.Name
+		}
+	}
+	return compareRefs(&r, cursorRef(f.c)) > 0
+}
+
+// compareScope compares a child store's scope (its repo, commit ID,
+// or source unit) with the cursor's. It returns -1 if the child store
+// sorts entirely before the cursor (and need not be queried), +1 if
+// it sorts entirely after the cursor (and all of its items are
+// selected), and 0 if the cursor is inside the child store.
+func compareScope(scope, cursorScope []string) int {
+	for i := range scope {
+		if scope[i] != cursorScope[i] {
+			if scope[i] < cursorScope[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func (f *afterFilter) repoScope(repo string) int {
+	if f.c == nil {
+		return 1
+	}
+	return compareScope([]string{repo}, []string{f.c.Repo})
+}
+
+func (f *afterFilter) treeScope(commitID string) int {
+	if f.c == nil {
+		return 1
+	}
+	return compareScope([]string{commitID}, []string{f.c.CommitID})
+}
+
+func (f *afterFilter) unitScope(u unit.ID2) int {
+	if f.c == nil {
+		return 1
+	}
+	return compareScope([]string{u.Name, u.Type}, []string{f.c.Unit, f.c.UnitType})
+}
+
+// forRepo returns the filter to pass to the RepoStore for repo, or
+// nil if all of the repo's items are after the cursor.
+func (f *afterFilter) forRepo(repo string) *afterFilter {
+	if f.repoScope(repo) != 0 {
+		return nil
+	}
+	c := *f.c
+	c.Repo = ""
+	if c.DefRepo == repo {
+		// Refs to defs in the same repo are stored with an empty
+		// DefRepo.
+		c.DefRepo = ""
+	}
+	f2 := *f
+	f2.c, f2.inRepo, f2.impliedRepo = &c, true, repo
+	return &f2
+}
+
+// forTree returns the filter to pass to the TreeStore for commitID,
+// or nil if all of the tree's items are after the cursor.
+func (f *afterFilter) forTree(commitID string) *afterFilter {
+	if f.treeScope(commitID) != 0 {
+		return nil
+	}
+	c := *f.c
+	c.CommitID = ""
+	f2 := *f
+	f2.c, f2.inTree = &c, true
+	return &f2
+}
+
+// forUnit returns the filter to pass to the UnitStore for u, or nil
+// if all of the unit's items are after the cursor.
+func (f *afterFilter) forUnit(u unit.ID2) *afterFilter {
+	if f.unitScope(u) != 0 {
+		return nil
+	}
+	c := *f.c
+	c.UnitType, c.Unit = "", ""
+	f2 := *f
+	f2.c, f2.inUnit, f2.impliedUnit = &c, true, u
+	return &f2
+}
+
+func getAfterFilter(filters interface{}) *afterFilter {
+	for _, f := range storeFilters(filters) {
+		if f, ok := f.(*afterFilter); ok {
+			return f
+		}
+	}
+	return nil
+}
+
+// pageLimitFilter is passed to child stores in a paged query (one
+// with an After filter). It tells the child store that only the
+// first n results (in sort order) are needed.
+type pageLimitFilter int
+
+func (f pageLimitFilter) String() string                { return fmt.Sprintf("pageLimit(%d)", int(f)) }
+func (f pageLimitFilter) SelectDef(def *graph.Def) bool { return true }
+func (f pageLimitFilter) SelectRef(ref *graph.Ref) bool { return true }
+
+// pageBounds returns the number of results to skip and the maximum
+// number of results to return for a paged query. If the query is not
+// paged or has no limit, ok is false.
+func pageBounds(filters interface{}) (skip, n int, ok bool) {
+	var paged bool
+	for _, f := range storeFilters(filters) {
+		switch f := f.(type) {
+		case *afterFilter:
+			paged = true
+		case *limiter:
+			skip, n = f.ofs, f.n
+		case pageLimitFilter:
+			paged = true
+			n = int(f)
+		}
+	}
+	return skip, n, paged && n > 0
+}
+
+// forChildStore is called by filtersForRepo, filtersForTree, and
+// filtersForUnit to rewrite the filters that pertain to paging for
+// a child store. The after func returns the narrowed After filter
+// (see afterFilter.forRepo, etc.). If keep is false, the filter
+// should be removed.
+func forChildStore(f interface{}, paged bool, after func(*afterFilter) *afterFilter) (newF interface{}, keep bool) {
+	switch f := f.(type) {
+	case *afterFilter:
+		if f2 := after(f); f2 != nil {
+			return f2, true
+		}
+		return nil, false
+	case *limiter:
+		if paged {
+			// The offset is applied by the outermost store, so the
+			// child store needs to return the first ofs+n results.
+			return pageLimitFilter(f.ofs + f.n), true
+		}
+	}
+	return f, true
+}
+
+// fetchPar returns the number of child stores that should be
+// queried in parallel. Paged queries visit child stores serially (in
+// order) so that they can stop once the page is full.
+func fetchPar(filters interface{}) int {
+	if _, _, paged := pageBounds(filters); paged {
+		return 1
+	}
+	return storeFetchPar
+}
+
+// pageFull returns whether a paged query has collected enough
+// results (n) that the remaining child stores need not be queried.
+func pageFull(filters interface{}, n int) bool {
+	skip, limit, paged := pageBounds(filters)
+	return paged && n >= skip+limit
+}
+
+// pageDefs returns the page of defs (which must be sorted) selected
+// by the filters.
+func pageDefs(defs []*graph.Def, filters interface{}) []*graph.Def {
+	if lo, hi, paged := pageRange(len(defs), filters); paged {
+		return defs[lo:hi]
+	}
+	return defs
+}
+
+// pageRefs returns the page of refs (which must be sorted) selected
+// by the filters.
+func pageRefs(refs []*graph.Ref, filters interface{}) []*graph.Ref {
+	if lo, hi, paged := pageRange(len(refs), filters); paged {
+		return refs[lo:hi]
+	}
+	return refs
+}
+
+// pageRange returns the range [lo, hi) of results, out of n sorted
+// results, that should be returned.
+//
+// Only the outermost store (the one that was passed the Limit filter
+// by the client) applies the offset; child stores are passed a
+// pageLimitFilter that has no offset.
+func pageRange(n int, filters interface{}) (lo, hi int, paged bool) {
+	skip, limit, paged := pageBounds(filters)
+	if !paged {
+		return 0, n, false
+	}
+	lo, hi = min(skip, n), min(skip+limit, n)
+	return lo, hi, true
+}
+
+// compareDefs compares 2 defs by the store's def ordering.
+func compareDefs(a, b *graph.Def) int {
+	return compareStrings(
+		a.Repo, b.Repo,
+		a.CommitID, b.CommitID,
+		a.Unit, b.Unit,
+		a.UnitType, b.UnitType,
+		a.Path, b.Path,
+	)
+}
+
+func compareDefCursor(def *graph.Def, c *Cursor) int {
+	return compareDefs(def, &graph.Def{
+		DefKey: graph.DefKey{Repo: c.Repo, CommitID: c.CommitID, UnitType: c.UnitType, Unit: c.Unit, Path: c.Path},
+	})
+}
+
+// compareRefs compares 2 refs by the store's ref ordering.
+func compareRefs(a, b *graph.Ref) int {
+	if c := compareStrings(
+		a.Repo, b.Repo,
+		a.CommitID, b.CommitID,
+		a.Unit, b.Unit,
+		a.UnitType, b.UnitType,
+		a.File, b.File,
+	); c != 0 {
+		return c
+	}
+	if c := compareUint32s(a.Start, b.Start, a.End, b.End); c != 0 {
+		return c
+	}
+	if c := compareStrings(
+		a.DefRepo, b.DefRepo,
+		a.DefUnit, b.DefUnit,
+		a.DefUnitType, b.DefUnitType,
+		a.DefPath, b.DefPath,
+	); c != 0 {
+		return c
+	}
+	switch {
+	case a.Def && !b.Def:
+		return 1
+	case !a.Def && b.Def:
+		return -1
+	}
+	return compareStrings(a.Kind, b.Kind)
+}
+
+func cursorRef(c *Cursor) *graph.Ref {
+	return &graph.Ref{
+		Repo:        c.Repo,
+		CommitID:    c.CommitID,
+		UnitType:    c.UnitType,
+		Unit:        c.Unit,
+		File:        c.File,
+		Start:       c.Start,
+		End:         c.End,
+		Def:         c.Def,
+		DefRepo:     c.DefRepo,
+		DefUnitType: c.DefUnitType,
+		DefUnit:     c.DefUnit,
+		DefPath:     c.DefPath,
+		Kind:        c.RefKind,
+	}
+}
+
+// compareStrings compares pairs of strings (a0, b0, a1, b1, ...) in
+// order and returns the result of the first unequal comparison.
+func compareStrings(pairs ...string) int {
+	for i := 0; i < len(pairs); i += 2 {
+		if a, b := pairs[i], pairs[i+1]; a != b {
+			if a < b {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func compareUint32s(pairs ...uint32) int {
+	for i := 0; i < len(pairs); i += 2 {
+		if a, b := pairs[i], pairs[i+1]; a != b {
+			if a < b {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+type defsInStoreOrder []*graph.Def
+
+func (v defsInStoreOrder) Len() int           { return len(v) }
+func (v defsInStoreOrder) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v defsInStoreOrder) Less(i, j int) bool { return compareDefs(v[i], v[j]) < 0 }
+
+type refsInStoreOrder []*graph.Ref
+
+func (v refsInStoreOrder) Len() int           { return len(v) }
+func (v refsInStoreOrder) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v refsInStoreOrder) Less(i, j int) bool { return compareRefs(v[i], v[j]) < 0 }
+
+type unitsInStoreOrder []*unit.SourceUnit
+
+func (v unitsInStoreOrder) Len() int      { return len(v) }
+func (v unitsInStoreOrder) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v unitsInStoreOrder) Less(i, j int) bool {
+	return compareStrings(v[i].Repo, v[j].Repo, v[i].CommitID, v[j].CommitID, v[i].Name, v[j].Name, v[i].Type, v[j].Type) < 0
+}
+
+// sortedRepos returns the repos in rss in sorted order, omitting
+// those whose RepoStore is nil.
+func sortedRepos(rss map[string]RepoStore) []string {
+	repos := make([]string, 0, len(rss))
+	for repo, rs := range rss {
+		if rs != nil {
+			repos = append(repos, repo)
+		}
+	}
+	sort.Strings(repos)
+	return repos
+}
+
+// sortedCommitIDs returns the commit IDs in tss in sorted order,
+// omitting those whose TreeStore is nil.
+func sortedCommitIDs(tss map[string]TreeStore) []string {
+	commitIDs := make([]string, 0, len(tss))
+	for commitID, ts := range tss {
+		if ts != nil {
+			commitIDs = append(commitIDs, commitID)
+		}
+	}
+	sort.Strings(commitIDs)
+	return commitIDs
+}
+
+// sortedUnits returns the source units in uss in sorted order,
+// omitting those whose UnitStore is nil.
+func sortedUnits(uss map[unit.ID2]UnitStore) []unit.ID2 {
+	units := make([]unit.ID2, 0, len(uss))
+	for u, us := range uss {
+		if us != nil {
+			units = append(units, u)
+		}
+	}
+	sort.Sort(unitID2s(units))
+	return units
+}
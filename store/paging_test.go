@@ -0,0 +1,70 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestCursor_roundTrip(t *testing.T) {
+	tests := []*Cursor{
+		DefCursor(&graph.Def{DefKey: graph.DefKey{Repo: "r", CommitID: "c", UnitType: "t", Unit: "u", Path: "p"}}),
+		RefCursor(&graph.Ref{Repo: "r", CommitID: "c", UnitType: "t", Unit: "u", File: "f", Start: 1, End: 2, DefPath: "p"}),
+	}
+	for _, c := range tests {
+		c2, err := ParseCursor(c.String())
+		if err != nil {
+			t.Errorf("%+v: ParseCursor: %s", c, err)
+			continue
+		}
+		if !reflect.DeepEqual(c2, c) {
+			t.Errorf("got cursor %+v, want %+v", c2, c)
+		}
+	}
+}
+
+func TestParseCursor_invalid(t *testing.T) {
+	for _, s := range []string{"", "!", (&Cursor{Kind: "x"}).String()} {
+		if _, err := ParseCursor(s); err == nil {
+			t.Errorf("%q: got nil error, want non-nil", s)
+		}
+	}
+}
+
+func TestAfterFilter_forUnit(t *testing.T) {
+	u := unit.ID2{Type: "t", Name: "u"}
+	af := After(DefCursor(&graph.Def{DefKey: graph.DefKey{Repo: "r", CommitID: "c", UnitType: "t", Unit: "u", Path: "p2"}})).(*afterFilter)
+	af = af.forRepo("r").forTree("c").forUnit(u)
+
+	// Stores may or may not fill in the scope fields of the defs and
+	// refs that they read, so both forms must compare the same.
+	for _, key := range []graph.DefKey{
+		{Path: "p1"},
+		{Repo: "r", CommitID: "c", UnitType: "t", Unit: "u", Path: "p1"},
+	} {
+		if af.SelectDef(&graph.Def{DefKey: key}) {
+			t.Errorf("%+v: got selected, want not selected", key)
+		}
+		key.Path = "p3"
+		if !af.SelectDef(&graph.Def{DefKey: key}) {
+			t.Errorf("%+v: got not selected, want selected", key)
+		}
+	}
+
+	af = After(RefCursor(&graph.Ref{Repo: "r", CommitID: "c", UnitType: "t", Unit: "u", File: "f", Start: 2, DefRepo: "r", DefUnitType: "t", DefUnit: "u", DefPath: "p"})).(*afterFilter)
+	af = af.forRepo("r").forTree("c").forUnit(u)
+	for _, ref := range []graph.Ref{
+		{File: "f", Start: 2, DefPath: "p"},
+		{Repo: "r", CommitID: "c", UnitType: "t", Unit: "u", File: "f", Start: 2, DefRepo: "r", DefUnitType: "t", DefUnit: "u", DefPath: "p"},
+	} {
+		if af.SelectRef(&ref) {
+			t.Errorf("%+v: got selected, want not selected (the cursor's own ref)", ref)
+		}
+		ref.Start = 3
+		if !af.SelectRef(&ref) {
+			t.Errorf("%+v: got not selected, want selected", ref)
+		}
+	}
+}
@@ -37,10 +37,11 @@ func (c *client) Import(repo, commitID string, u *unit.SourceUnit, data graph.Ou
 	return err
 }
 
-func (c *client) CreateVersion(repo, commitID string) error {
+func (c *client) CreateVersion(repo, commitID string, meta *store.VersionMeta) error {
 	_, err := c.u.CreateVersion(c.ctx, &CreateVersionOp{
 		Repo:     repo,
 		CommitID: commitID,
+		Meta:     NewVersionMeta(meta),
 	})
 	return err
 }
@@ -67,7 +68,7 @@ func (s *server) Import(ctx context.Context, op *ImportOp) (*pbtypes.Void, error
 }
 
 func (s *server) CreateVersion(ctx context.Context, op *CreateVersionOp) (*pbtypes.Void, error) {
-	if err := s.u.CreateVersion(op.Repo, op.CommitID); err != nil {
+	if err := s.u.CreateVersion(op.Repo, op.CommitID, op.Meta.VersionMeta()); err != nil {
 		return nil, err
 	}
 	return &pbtypes.Void{}, nil
@@ -79,3 +80,37 @@ func (s *server) Index(ctx context.Context, op *IndexOp) (*pbtypes.Void, error)
 	}
 	return &pbtypes.Void{}, nil
 }
+
+// NewVersionMeta converts a store.VersionMeta to its protobuf
+// representation. It returns nil if meta is nil.
+func NewVersionMeta(meta *store.VersionMeta) *VersionMeta {
+	if meta == nil {
+		return nil
+	}
+	return &VersionMeta{
+		ImportedAt:    pbtypes.NewTimestamp(meta.ImportedAt),
+		Branch:        meta.Branch,
+		SrclibVersion: meta.SrclibVersion,
+		Toolchains:    meta.Toolchains,
+		NumUnits:      int64(meta.NumUnits),
+		NumDefs:       int64(meta.NumDefs),
+		NumRefs:       int64(meta.NumRefs),
+	}
+}
+
+// VersionMeta converts m to a store.VersionMeta. It returns nil if m
+// is nil.
+func (m *VersionMeta) VersionMeta() *store.VersionMeta {
+	if m == nil {
+		return nil
+	}
+	return &store.VersionMeta{
+		ImportedAt:    m.ImportedAt.Time().UTC(),
+		Branch:        m.Branch,
+		SrclibVersion: m.SrclibVersion,
+		Toolchains:    m.Toolchains,
+		NumUnits:      int(m.NumUnits),
+		NumDefs:       int(m.NumDefs),
+		NumRefs:       int(m.NumRefs),
+	}
+}
@@ -11,6 +11,7 @@
 	It has these top-level messages:
 		ImportOp
 		CreateVersionOp
+		VersionMeta
 		IndexOp
 */
 package pb
@@ -50,12 +51,30 @@ func (*ImportOp) ProtoMessage()    {}
 type CreateVersionOp struct {
 	Repo     string `protobuf:"bytes,1,opt,name=Repo,proto3" json:"Repo,omitempty"`
 	CommitID string `protobuf:"bytes,2,opt,name=CommitID,proto3" json:"CommitID,omitempty"`
+	// Meta describes how and when the version's data was imported
+	// (if known).
+	Meta *VersionMeta `protobuf:"bytes,3,opt,name=Meta" json:"Meta,omitempty"`
 }
 
 func (m *CreateVersionOp) Reset()         { *m = CreateVersionOp{} }
 func (m *CreateVersionOp) String() string { return proto.CompactTextString(m) }
 func (*CreateVersionOp) ProtoMessage()    {}
 
+// VersionMeta is the protobuf representation of store.VersionMeta.
+type VersionMeta struct {
+	ImportedAt    pbtypes.Timestamp `protobuf:"bytes,1,opt,name=ImportedAt" json:"ImportedAt"`
+	Branch        string            `protobuf:"bytes,2,opt,name=Branch,proto3" json:"Branch,omitempty"`
+	SrclibVersion string            `protobuf:"bytes,3,opt,name=SrclibVersion,proto3" json:"SrclibVersion,omitempty"`
+	Toolchains    []string          `protobuf:"bytes,4,rep,name=Toolchains" json:"Toolchains,omitempty"`
+	NumUnits      int64             `protobuf:"varint,5,opt,name=NumUnits,proto3" json:"NumUnits,omitempty"`
+	NumDefs       int64             `protobuf:"varint,6,opt,name=NumDefs,proto3" json:"NumDefs,omitempty"`
+	NumRefs       int64             `protobuf:"varint,7,opt,name=NumRefs,proto3" json:"NumRefs,omitempty"`
+}
+
+func (m *VersionMeta) Reset()         { *m = VersionMeta{} }
+func (m *VersionMeta) String() string { return proto.CompactTextString(m) }
+func (*VersionMeta) ProtoMessage()    {}
+
 type IndexOp struct {
 	Repo     string `protobuf:"bytes,1,opt,name=Repo,proto3" json:"Repo,omitempty"`
 	CommitID string `protobuf:"bytes,2,opt,name=CommitID,proto3" json:"CommitID,omitempty"`
@@ -267,6 +286,84 @@ func (m *CreateVersionOp) MarshalTo(data []byte) (int, error) {
 		i = encodeVarintSrcstore(data, i, uint64(len(m.CommitID)))
 		i += copy(data[i:], m.CommitID)
 	}
+	if m.Meta != nil {
+		data[i] = 0x1a
+		i++
+		i = encodeVarintSrcstore(data, i, uint64(m.Meta.Size()))
+		n3, err := m.Meta.MarshalTo(data[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n3
+	}
+	return i, nil
+}
+
+func (m *VersionMeta) Marshal() (data []byte, err error) {
+	size := m.Size()
+	data = make([]byte, size)
+	n, err := m.MarshalTo(data)
+	if err != nil {
+		return nil, err
+	}
+	return data[:n], nil
+}
+
+func (m *VersionMeta) MarshalTo(data []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	data[i] = 0xa
+	i++
+	i = encodeVarintSrcstore(data, i, uint64(m.ImportedAt.Size()))
+	n4, err := m.ImportedAt.MarshalTo(data[i:])
+	if err != nil {
+		return 0, err
+	}
+	i += n4
+	if len(m.Branch) > 0 {
+		data[i] = 0x12
+		i++
+		i = encodeVarintSrcstore(data, i, uint64(len(m.Branch)))
+		i += copy(data[i:], m.Branch)
+	}
+	if len(m.SrclibVersion) > 0 {
+		data[i] = 0x1a
+		i++
+		i = encodeVarintSrcstore(data, i, uint64(len(m.SrclibVersion)))
+		i += copy(data[i:], m.SrclibVersion)
+	}
+	if len(m.Toolchains) > 0 {
+		for _, s := range m.Toolchains {
+			data[i] = 0x22
+			i++
+			l = len(s)
+			for l >= 1<<7 {
+				data[i] = uint8(uint64(l)&0x7f | 0x80)
+				l >>= 7
+				i++
+			}
+			data[i] = uint8(l)
+			i++
+			i += copy(data[i:], s)
+		}
+	}
+	if m.NumUnits != 0 {
+		data[i] = 0x28
+		i++
+		i = encodeVarintSrcstore(data, i, uint64(m.NumUnits))
+	}
+	if m.NumDefs != 0 {
+		data[i] = 0x30
+		i++
+		i = encodeVarintSrcstore(data, i, uint64(m.NumDefs))
+	}
+	if m.NumRefs != 0 {
+		data[i] = 0x38
+		i++
+		i = encodeVarintSrcstore(data, i, uint64(m.NumRefs))
+	}
 	return i, nil
 }
 
@@ -360,6 +457,41 @@ func (m *CreateVersionOp) Size() (n int) {
 	if l > 0 {
 		n += 1 + l + sovSrcstore(uint64(l))
 	}
+	if m.Meta != nil {
+		l = m.Meta.Size()
+		n += 1 + l + sovSrcstore(uint64(l))
+	}
+	return n
+}
+
+func (m *VersionMeta) Size() (n int) {
+	var l int
+	_ = l
+	l = m.ImportedAt.Size()
+	n += 1 + l + sovSrcstore(uint64(l))
+	l = len(m.Branch)
+	if l > 0 {
+		n += 1 + l + sovSrcstore(uint64(l))
+	}
+	l = len(m.SrclibVersion)
+	if l > 0 {
+		n += 1 + l + sovSrcstore(uint64(l))
+	}
+	if len(m.Toolchains) > 0 {
+		for _, s := range m.Toolchains {
+			l = len(s)
+			n += 1 + l + sovSrcstore(uint64(l))
+		}
+	}
+	if m.NumUnits != 0 {
+		n += 1 + sovSrcstore(uint64(m.NumUnits))
+	}
+	if m.NumDefs != 0 {
+		n += 1 + sovSrcstore(uint64(m.NumDefs))
+	}
+	if m.NumRefs != 0 {
+		n += 1 + sovSrcstore(uint64(m.NumRefs))
+	}
 	return n
 }
 
@@ -651,6 +783,263 @@ func (m *CreateVersionOp) Unmarshal(data []byte) error {
 			}
 			m.CommitID = string(data[iNdEx:postIndex])
 			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Meta", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSrcstore
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthSrcstore
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Meta == nil {
+				m.Meta = &VersionMeta{}
+			}
+			if err := m.Meta.Unmarshal(data[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipSrcstore(data[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthSrcstore
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *VersionMeta) Unmarshal(data []byte) error {
+	l := len(data)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowSrcstore
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := data[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: VersionMeta: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: VersionMeta: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ImportedAt", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSrcstore
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthSrcstore
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if err := m.ImportedAt.Unmarshal(data[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Branch", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSrcstore
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthSrcstore
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Branch = string(data[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SrclibVersion", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSrcstore
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthSrcstore
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.SrclibVersion = string(data[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Toolchains", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSrcstore
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthSrcstore
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Toolchains = append(m.Toolchains, string(data[iNdEx:postIndex]))
+			iNdEx = postIndex
+		case 5:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field NumUnits", wireType)
+			}
+			m.NumUnits = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSrcstore
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				m.NumUnits |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 6:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field NumDefs", wireType)
+			}
+			m.NumDefs = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSrcstore
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				m.NumDefs |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 7:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field NumRefs", wireType)
+			}
+			m.NumRefs = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSrcstore
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				m.NumRefs |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipSrcstore(data[iNdEx:])
@@ -3,8 +3,11 @@ package phtable
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"runtime"
 )
 
 // CHD hash table lookup.
@@ -23,6 +26,12 @@ type CHD struct {
 	StoreKeys        bool
 	ValuesAreVarints bool
 	valueVarints     []uint64
+
+	// entries and offsets are set instead of keys, values, and
+	// valueVarints in a CHD created by Mapped. Entry i (encoded as in
+	// Write) is entries[offsets[i]:offsets[i+1]].
+	entries []byte
+	offsets []uint64
 }
 
 func hasher(data []byte) uint64 {
@@ -105,6 +114,7 @@ func (c *CHD) getIndex(key []byte) (uint64, bool) {
 	r := c.r[ri]
 	ti := (h ^ r) % uint64(c.el)
 	// fmt.Printf("r[0]=%d, h=%d, i=%d, ri=%d, r=%d, ti=%d\n", c.r[0], h, i, ri, r, ti)
+	runtime.KeepAlive(c) // see Mapped
 	return ti, true
 }
 
@@ -117,13 +127,10 @@ func (c *CHD) Get(key []byte) []byte {
 	if !found {
 		return nil
 	}
-	if c.StoreKeys {
-		k := c.keys[ti]
-		if bytes.Compare(k, key) != 0 {
-			return nil
-		}
+	if c.StoreKeys && !c.keyEquals(ti, key) {
+		return nil
 	}
-	return c.values[ti]
+	return c.value(ti)
 }
 
 func (c *CHD) GetUint64(key []byte) (uint64, bool) {
@@ -134,22 +141,25 @@ func (c *CHD) GetUint64(key []byte) (uint64, bool) {
 	if !found {
 		return 0, false
 	}
-	if c.StoreKeys {
-		k := c.keys[ti]
-		if bytes.Compare(k, key) != 0 {
-			return 0, false
-		}
+	if c.StoreKeys && !c.keyEquals(ti, key) {
+		return 0, false
 	}
-	return c.valueVarints[ti], true
+	return c.valueVarint(ti), true
 }
 
 func (c *CHD) Len() int {
+	if c.entries != nil {
+		if !c.StoreKeys {
+			return 0
+		}
+		return int(c.el)
+	}
 	return len(c.keys)
 }
 
 // Iterate over entries in the hash table.
 func (c *CHD) Iterate() *Iterator {
-	if len(c.keys) == 0 {
+	if c.Len() == 0 {
 		return nil
 	}
 	return &Iterator{c: c}
@@ -184,50 +194,267 @@ func (c *CHD) Write(w io.Writer) error {
 	}
 
 	vb := make([]byte, binary.MaxVarintLen64)
-	for i := 0; i < int(c.el); i++ {
-		if c.StoreKeys {
-			k := c.keys[i]
-			n := binary.PutUvarint(vb, uint64(len(k)))
-			if _, err := w.Write(vb[:n]); err != nil {
-				return err
-			}
-			if _, err := w.Write(k); err != nil {
-				return err
-			}
-		}
-		if c.ValuesAreVarints {
-			v := c.valueVarints[i]
-			n := binary.PutUvarint(vb, v)
-			if _, err := w.Write(vb[:n]); err != nil {
-				return err
-			}
-		} else {
-			v := c.values[i]
-			n := binary.PutUvarint(vb, uint64(len(v)))
-			if _, err := w.Write(vb[:n]); err != nil {
-				return err
-			}
-			if _, err := w.Write(v); err != nil {
-				return err
-			}
+	for i := uint64(0); i < uint64(c.el); i++ {
+		if err := c.writeEntry(w, vb, i); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// writeEntry writes entry i (its key, if keys are stored, and its
+// value) to w. The vb buffer must be at least binary.MaxVarintLen64
+// bytes long.
+func (c *CHD) writeEntry(w io.Writer, vb []byte, i uint64) error {
+	if c.StoreKeys {
+		k := c.key(i)
+		n := binary.PutUvarint(vb, uint64(len(k)))
+		if _, err := w.Write(vb[:n]); err != nil {
+			return err
+		}
+		if _, err := w.Write(k); err != nil {
+			return err
+		}
+	}
+	if c.ValuesAreVarints {
+		n := binary.PutUvarint(vb, c.valueVarint(i))
+		_, err := w.Write(vb[:n])
+		return err
+	}
+	v := c.value(i)
+	n := binary.PutUvarint(vb, uint64(len(v)))
+	if _, err := w.Write(vb[:n]); err != nil {
+		return err
+	}
+	_, err := w.Write(v)
+	return err
+}
+
+// entrySize returns the number of bytes that writeEntry writes for
+// entry i.
+func (c *CHD) entrySize(i uint64) uint64 {
+	var size uint64
+	if c.StoreKeys {
+		k := c.key(i)
+		size += uvarintLen(uint64(len(k))) + uint64(len(k))
+	}
+	if c.ValuesAreVarints {
+		return size + uvarintLen(c.valueVarint(i))
+	}
+	v := c.value(i)
+	return size + uvarintLen(uint64(len(v))) + uint64(len(v))
+}
+
+func uvarintLen(v uint64) uint64 {
+	var vb [binary.MaxVarintLen64]byte
+	return uint64(binary.PutUvarint(vb[:], v))
+}
+
 type Iterator struct {
 	i int
 	c *CHD
 }
 
 func (c *Iterator) Get() (key []byte, value []byte) {
+	if c.c.entries != nil {
+		key = c.c.key(uint64(c.i))
+		if !c.c.ValuesAreVarints {
+			value = c.c.value(uint64(c.i))
+		}
+		return key, value
+	}
 	return c.c.keys[c.i], c.c.values[c.i]
 }
 
 func (c *Iterator) Next() *Iterator {
 	c.i++
-	if c.i >= len(c.c.keys) {
+	if c.i >= c.c.Len() {
 		return nil
 	}
 	return c
 }
+
+// mappedHeaderLen is the length of the header of the serialized form
+// written by WriteMappable.
+const mappedHeaderLen = 32
+
+// Flags in the header of the serialized form written by
+// WriteMappable.
+const (
+	mappedStoreKeys = 1 << iota
+	mappedValuesAreVarints
+)
+
+// WriteMappable serializes the CHD in a form that Mapped can use in
+// place, without decoding (or even reading) all of its entries. It is
+// larger than the form written by Write because it includes the
+// offset of each entry. All integers are little-endian, and each
+// section is 8-byte aligned:
+//
+//	uint64    len(r)
+//	uint64    len(indices)
+//	uint64    number of entries (n)
+//	uint64    flags (mappedStoreKeys | mappedValuesAreVarints)
+//	[]uint64  r
+//	[]uint16  indices (padded with zeros to a multiple of 8 bytes)
+//	[]uint64  n+1 entry offsets, relative to the first entry
+//	entries   (each encoded as in Write)
+func (c *CHD) WriteMappable(w io.Writer) error {
+	var flags uint64
+	if c.StoreKeys {
+		flags |= mappedStoreKeys
+	}
+	if c.ValuesAreVarints {
+		flags |= mappedValuesAreVarints
+	}
+	offsets := make([]uint64, c.el+1)
+	for i := uint64(0); i < uint64(c.el); i++ {
+		offsets[i+1] = offsets[i] + c.entrySize(i)
+	}
+
+	data := []interface{}{
+		uint64(len(c.r)), uint64(len(c.indices)), uint64(c.el), flags,
+		c.r,
+		c.indices, make([]byte, pad8(uint64(len(c.indices))*2)),
+		offsets,
+	}
+	for _, d := range data {
+		if err := binary.Write(w, binary.LittleEndian, d); err != nil {
+			return err
+		}
+	}
+
+	vb := make([]byte, binary.MaxVarintLen64)
+	for i := uint64(0); i < uint64(c.el); i++ {
+		if err := c.writeEntry(w, vb, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pad8 returns the number of bytes needed to pad n bytes to a multiple
+// of 8 bytes.
+func pad8(n uint64) uint64 { return (8 - n%8) % 8 }
+
+var errMappedTooShort = errors.New("phtable: mapped CHD data is truncated")
+
+// Mapped creates a new CHD that uses b, which contains a CHD
+// serialized by WriteMappable, in place. Its entries are decoded only
+// when they are accessed, so creating it takes constant time (on
+// little-endian architectures). To avoid unaligned access, b must
+// begin at an 8-byte aligned address (as memory-mapped files and
+// allocated byte slices do).
+//
+// The CHD refers to b instead of copying it, so b must not be
+// modified while the CHD is in use. Its Get method and Iterator
+// return copies of keys and values, which remain valid after b is
+// released. If release is non-nil, it is called (once) when the CHD
+// is garbage collected, to release b (for example, to unmap it).
+func Mapped(b []byte, isVarints bool, release func()) (*CHD, error) {
+	if len(b) < mappedHeaderLen {
+		return nil, errMappedTooShort
+	}
+	rl := binary.LittleEndian.Uint64(b[0:])
+	il := binary.LittleEndian.Uint64(b[8:])
+	el := binary.LittleEndian.Uint64(b[16:])
+	flags := binary.LittleEndian.Uint64(b[24:])
+	if isVarints != (flags&mappedValuesAreVarints != 0) {
+		return nil, fmt.Errorf("phtable: mapped CHD has ValuesAreVarints == %v, want %v", !isVarints, isVarints)
+	}
+	if el >= 1<<32 {
+		return nil, fmt.Errorf("phtable: mapped CHD has too many entries (%d)", el)
+	}
+	// Check the section lengths before computing their sum, so that
+	// corrupt lengths can't overflow it.
+	if rl > uint64(len(b))/8 || il > uint64(len(b))/2 || el >= uint64(len(b))/8 {
+		return nil, errMappedTooShort
+	}
+	entriesStart := mappedHeaderLen + rl*8 + il*2 + pad8(il*2) + (el+1)*8
+	if entriesStart > uint64(len(b)) {
+		return nil, errMappedTooShort
+	}
+
+	c := &CHD{
+		el:               uint32(el),
+		StoreKeys:        flags&mappedStoreKeys != 0,
+		ValuesAreVarints: isVarints,
+	}
+	bi := &sliceReader{b: b, end: mappedHeaderLen}
+	c.r = bi.ReadUint64Array(rl)
+	c.indices = bi.ReadUint16Array(il)
+	bi.Read(pad8(il * 2))
+	c.offsets = bi.ReadUint64Array(el + 1)
+	c.entries = b[entriesStart:]
+	if c.offsets[el] > uint64(len(c.entries)) {
+		return nil, errMappedTooShort
+	}
+	if el == 0 {
+		c.entries = []byte{} // non-nil, so that c is known to be mapped
+	}
+
+	if release != nil {
+		runtime.SetFinalizer(c, func(*CHD) { release() })
+	}
+	return c, nil
+}
+
+// entry returns the encoded entry i of a mapped CHD.
+func (c *CHD) entry(i uint64) []byte {
+	return c.entries[c.offsets[i]:c.offsets[i+1]]
+}
+
+// key returns the key of entry i. For a mapped CHD, it returns a copy.
+func (c *CHD) key(i uint64) []byte {
+	if c.entries == nil {
+		return c.keys[i]
+	}
+	bi := &sliceReader{b: c.entry(i)}
+	k := append([]byte(nil), bi.Read(bi.ReadUvarint())...)
+	runtime.KeepAlive(c) // don't release the entries while they're being read
+	return k
+}
+
+// keyEquals returns whether the key of entry i is key.
+func (c *CHD) keyEquals(i uint64, key []byte) bool {
+	if c.entries == nil {
+		return bytes.Equal(c.keys[i], key)
+	}
+	bi := &sliceReader{b: c.entry(i)}
+	eq := bytes.Equal(bi.Read(bi.ReadUvarint()), key)
+	runtime.KeepAlive(c)
+	return eq
+}
+
+// value returns the value of entry i (if the values are not
+// varints). For a mapped CHD, it returns a copy.
+func (c *CHD) value(i uint64) []byte {
+	if c.entries == nil {
+		return c.values[i]
+	}
+	bi := &sliceReader{b: c.entry(i)}
+	if c.StoreKeys {
+		bi.Read(bi.ReadUvarint())
+	}
+	v := bi.Read(bi.ReadUvarint())
+	if v != nil {
+		v = append([]byte(nil), v...)
+	}
+	runtime.KeepAlive(c)
+	return v
+}
+
+// valueVarint returns the value of entry i (if the values are
+// varints).
+func (c *CHD) valueVarint(i uint64) uint64 {
+	if c.entries == nil {
+		return c.valueVarints[i]
+	}
+	bi := &sliceReader{b: c.entry(i)}
+	if c.StoreKeys {
+		bi.Read(bi.ReadUvarint())
+	}
+	v := bi.ReadUvarint()
+	runtime.KeepAlive(c)
+	return v
+}
@@ -258,3 +258,71 @@ func BenchmarkCHD(b *testing.B) {
 		h.Get(words[i%len(words)])
 	}
 }
+
+func TestCHDMapped(t *testing.T) {
+	for _, varints := range []bool{false, true} {
+		var b *CHDBuilder
+		if varints {
+			b = Uvarint64Builder(0)
+		} else {
+			b = Builder(0)
+		}
+		for k, v := range sampleData {
+			if varints {
+				b.AddUvarint64([]byte(k), uint64(len(k)))
+			} else {
+				b.Add([]byte(k), []byte(v))
+			}
+		}
+		m, err := b.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.StoreKeys = true
+		m.ValuesAreVarints = varints
+		w := &bytes.Buffer{}
+		if err := m.WriteMappable(w); err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := Mapped(w.Bytes(), varints, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range sampleData {
+			if varints {
+				if vv, ok := n.GetUint64([]byte(k)); !ok || vv != uint64(len(k)) {
+					t.Errorf("varints: got value == %d (found %v), want %d", vv, ok, len(k))
+				}
+			} else if vv := n.Get([]byte(k)); string(vv) != v {
+				t.Errorf("got value == %q, want %q", vv, v)
+			}
+		}
+		if varints {
+			if _, ok := n.GetUint64([]byte("monkey")); ok {
+				t.Error("varints: for key 'monkey', got found, want not found")
+			}
+		} else if v := n.Get([]byte("monkey")); v != nil {
+			t.Errorf("for key 'monkey', got value %q, want nil", v)
+		}
+		if n.Len() != m.Len() {
+			t.Errorf("got Len() == %d, want %d", n.Len(), m.Len())
+		}
+
+		// Mapping a CHD and writing it again yields the same data.
+		w2 := &bytes.Buffer{}
+		if err := n.WriteMappable(w2); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(w2.Bytes(), w.Bytes()) {
+			t.Error("got different data after writing mapped CHD")
+		}
+
+		if _, err := Mapped(w.Bytes()[:w.Len()-1], varints, nil); err == nil {
+			t.Error("truncated: got nil err, want non-nil")
+		}
+		if _, err := Mapped(w.Bytes(), !varints, nil); err == nil {
+			t.Error("wrong isVarints: got nil err, want non-nil")
+		}
+	}
+}
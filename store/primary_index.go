@@ -16,7 +16,7 @@ type defPathIndex struct {
 
 var _ interface {
 	Index
-	persistedIndex
+	mmapIndex
 	defIndexBuilder
 	defIndex
 } = (*defPathIndex)(nil)
@@ -87,6 +87,14 @@ func (x *defPathIndex) Write(w io.Writer) error {
 	return x.phtable.Write(w)
 }
 
+// WriteMappable implements mmapIndex.
+func (x *defPathIndex) WriteMappable(w io.Writer) error {
+	if x.phtable == nil {
+		panic("no phtable to write")
+	}
+	return x.phtable.WriteMappable(w)
+}
+
 // Read implements persistedIndex.
 func (x *defPathIndex) Read(r io.Reader) error {
 	var err error
@@ -95,5 +103,13 @@ func (x *defPathIndex) Read(r io.Reader) error {
 	return err
 }
 
+// Mmap implements mmapIndex.
+func (x *defPathIndex) Mmap(b []byte, release func()) error {
+	var err error
+	x.phtable, err = phtable.Mapped(b, true, release)
+	x.ready = (err == nil)
+	return err
+}
+
 // Ready implements persistedIndex.
 func (x *defPathIndex) Ready() bool { return x.ready }
@@ -1,6 +1,16 @@
 package store
 
-import "testing"
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
 
 func TestDefPathIndex_Covers(t *testing.T) {
 	x := &defPathIndex{}
@@ -19,3 +29,58 @@ func TestDefPathIndex_Covers(t *testing.T) {
 		t.Errorf("got coverage %d, want %d", c, want)
 	}
 }
+
+func TestDefPathIndex_persisted(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "srclib-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	defs := []*graph.Def{{DefKey: graph.DefKey{Path: "p1"}}, {DefKey: graph.DefKey{Path: "p2"}}}
+	x := &defPathIndex{}
+	if err := x.Build(defs, byteOffsets{10, 20}); err != nil {
+		t.Fatal(err)
+	}
+
+	fss := map[string]rwvfs.FileSystem{
+		"os":  rwvfs.OS(tmpDir), // written uncompressed and mmapped
+		"map": rwvfs.Map(map[string]string{}),
+	}
+	for label, fs := range fss {
+		if err := writeIndex(fs, "x", x); err != nil {
+			t.Errorf("%s: writeIndex: %s", label, err)
+			continue
+		}
+		x2 := &defPathIndex{}
+		if err := readIndex(fs, "x", x2); err != nil {
+			t.Errorf("%s: readIndex: %s", label, err)
+			continue
+		}
+		for i, def := range defs {
+			if ofs, found := x2.getByPath(def.Path); !found || ofs != int64((i+1)*10) {
+				t.Errorf("%s: %s: got offset %d (found %v), want %d", label, def.Path, ofs, found, (i+1)*10)
+			}
+		}
+	}
+
+	// The index on the local filesystem should not be gzipped.
+	b, err := ioutil.ReadFile(filepath.Join(tmpDir, fmt.Sprintf(indexFilename, "x")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b, rawIndexHeader) {
+		t.Errorf("got index file %q, want it to begin with %q", b, rawIndexHeader)
+	}
+
+	// An uncompressed index can also be read from a filesystem that
+	// doesn't support mmap.
+	fs := rwvfs.Map(map[string]string{fmt.Sprintf(indexFilename, "x"): string(b)})
+	x2 := &defPathIndex{}
+	if err := readIndex(fs, "x", x2); err != nil {
+		t.Fatalf("uncompressed in map: readIndex: %s", err)
+	}
+	if ofs, found := x2.getByPath("p2"); !found || ofs != 20 {
+		t.Errorf("uncompressed in map: p2: got offset %d (found %v), want 20", ofs, found)
+	}
+}
@@ -18,7 +18,7 @@ type refFileIndex struct {
 
 var _ interface {
 	Index
-	persistedIndex
+	mmapIndex
 	refIndexByteRanges
 	refIndexBuilder
 } = (*refFileIndex)(nil)
@@ -108,6 +108,14 @@ func (x *refFileIndex) Write(w io.Writer) error {
 	return x.phtable.Write(w)
 }
 
+// WriteMappable implements mmapIndex.
+func (x *refFileIndex) WriteMappable(w io.Writer) error {
+	if x.phtable == nil {
+		panic("no phtable to write")
+	}
+	return x.phtable.WriteMappable(w)
+}
+
 // Read implements persistedIndex.
 func (x *refFileIndex) Read(r io.Reader) error {
 	var err error
@@ -116,5 +124,13 @@ func (x *refFileIndex) Read(r io.Reader) error {
 	return err
 }
 
+// Mmap implements mmapIndex.
+func (x *refFileIndex) Mmap(b []byte, release func()) error {
+	var err error
+	x.phtable, err = phtable.Mapped(b, false, release)
+	x.ready = (err == nil)
+	return err
+}
+
 // Ready implements persistedIndex.
 func (x *refFileIndex) Ready() bool { return x.ready }
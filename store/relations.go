@@ -0,0 +1,375 @@
+package store
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/neelance/parallel"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// A RelationStore stores and accesses relations between defs (see
+// graph.Relation), such as a type that implements an interface.
+//
+// It is not part of UnitStore, so that existing UnitStore
+// implementations need not implement it. All of the stores in this
+// package implement it (at every level, from unit stores to
+// multi-repo stores); composite stores skip child stores that don't.
+type RelationStore interface {
+	// Relations returns all relations that match the filters. As
+	// with refs, the From.{Repo,CommitID,UnitType,Unit} and
+	// To.Def{Repo,UnitType,Unit} fields are filled in by the stores
+	// at the levels above the unit store that holds the relation.
+	Relations(...RelationFilter) ([]*graph.Relation, error)
+}
+
+var (
+	_ RelationStore = (*unitStores)(nil)
+	_ RelationStore = (*treeStores)(nil)
+	_ RelationStore = (*repoStores)(nil)
+	_ RelationStore = (*fsUnitStore)(nil)
+	_ RelationStore = (*indexedUnitStore)(nil)
+	_ RelationStore = (*memoryUnitStore)(nil)
+	_ RelationStore = (*unionMultiRepoStore)(nil)
+)
+
+// A relationScopeSetter is a RelationFilter whose selection depends on
+// the repo and source unit that contain the relations it's applied to
+// (because relations omit the To fields that are implied by them).
+type relationScopeSetter interface {
+	withImpliedRelationRepo(repo string) RelationFilter
+	withImpliedRelationUnit(u unit.ID2) RelationFilter
+}
+
+var _ relationScopeSetter = (*byRelationToFilter)(nil)
+
+// withImpliedRelationScope returns a copy of fs whose filters are
+// applied to the relations in the given repo (if non-empty) or source
+// unit (if non-zero).
+func withImpliedRelationScope(fs []RelationFilter, repo string, u unit.ID2) []RelationFilter {
+	fCopy := make([]RelationFilter, len(fs))
+	for i, f := range fs {
+		if ss, ok := f.(relationScopeSetter); ok {
+			if repo != "" {
+				f = ss.withImpliedRelationRepo(repo)
+			}
+			if u != (unit.ID2{}) {
+				f = f.(relationScopeSetter).withImpliedRelationUnit(u)
+			}
+		}
+		fCopy[i] = f
+	}
+	return fCopy
+}
+
+func (s repoStores) Relations(f ...RelationFilter) ([]*graph.Relation, error) {
+	rss, err := openRepoStores(s.opener, f)
+	if err != nil {
+		return nil, err
+	}
+
+	var allRels []*graph.Relation
+	for _, repo := range sortedRepos(rss) {
+		rs, ok := rss[repo].(RelationStore)
+		if !ok {
+			continue
+		}
+		fCopy := withImpliedRelationScope(filtersForRepo(repo, f).([]RelationFilter), repo, unit.ID2{})
+		rels, err := rs.Relations(fCopy...)
+		if err != nil && !isStoreNotExist(err) {
+			return nil, err
+		}
+		for _, rel := range rels {
+			rel.From.Repo = repo
+			if rel.To.DefRepo == "" {
+				rel.To.DefRepo = repo
+			}
+		}
+		allRels = append(allRels, rels...)
+	}
+	return allRels, nil
+}
+
+func (s treeStores) Relations(f ...RelationFilter) ([]*graph.Relation, error) {
+	tss, err := openTreeStores(s.opener, f)
+	if err != nil {
+		return nil, err
+	}
+
+	var allRels []*graph.Relation
+	for _, commitID := range sortedCommitIDs(tss) {
+		ts, ok := tss[commitID].(RelationStore)
+		if !ok {
+			continue
+		}
+		rels, err := ts.Relations(filtersForTree(commitID, f).([]RelationFilter)...)
+		if err != nil && !isStoreNotExist(err) {
+			return nil, err
+		}
+		for _, rel := range rels {
+			rel.From.CommitID = commitID
+		}
+		allRels = append(allRels, rels...)
+	}
+	return allRels, nil
+}
+
+func (s unitStores) Relations(f ...RelationFilter) ([]*graph.Relation, error) {
+	uss, err := openUnitStores(s.opener, f)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		units    = sortedUnits(uss)
+		unitRels = make([][]*graph.Relation, len(units))
+	)
+	par := parallel.NewRun(storeFetchPar)
+	for i_, u_ := range units {
+		i, u := i_, u_
+		us, ok := uss[u].(RelationStore)
+		if !ok {
+			continue
+		}
+
+		par.Acquire()
+		go func() {
+			defer par.Release()
+			fCopy := withImpliedRelationScope(filtersForUnit(u, f).([]RelationFilter), "", u)
+			rels, err := us.Relations(fCopy...)
+			if err != nil && !isStoreNotExist(err) {
+				par.Error(err)
+				return
+			}
+			for _, rel := range rels {
+				rel.From.UnitType = u.Type
+				rel.From.Unit = u.Name
+				if rel.To.DefUnitType == "" {
+					rel.To.DefUnitType = u.Type
+				}
+				if rel.To.DefUnit == "" {
+					rel.To.DefUnit = u.Name
+				}
+			}
+			sort.Sort(graph.Relations(rels))
+			unitRels[i] = rels
+		}()
+	}
+	err = par.Wait()
+
+	var allRels []*graph.Relation
+	for _, rels := range unitRels {
+		allRels = append(allRels, rels...)
+	}
+	return allRels, err
+}
+
+func (s *memoryUnitStore) Relations(f ...RelationFilter) ([]*graph.Relation, error) {
+	if s.data == nil {
+		return nil, errUnitNoInit
+	}
+
+	var rels []*graph.Relation
+	for _, rel := range s.data.Relations {
+		if relationFilters(f).SelectRelation(rel) {
+			rels = append(rels, rel)
+		}
+	}
+	return rels, nil
+}
+
+const unitRelationsFilename = "rel.dat"
+
+// Relations implements RelationStore. Source units that were imported
+// before relations were stored have no relation data file, and
+// therefore no relations.
+func (s *fsUnitStore) Relations(fs ...RelationFilter) ([]*graph.Relation, error) {
+	rels, _, err := s.readRelations()
+	if err != nil {
+		return nil, err
+	}
+	var sel []*graph.Relation
+	for _, rel := range rels {
+		if relationFilters(fs).SelectRelation(rel) {
+			sel = append(sel, rel)
+		}
+	}
+	return sel, nil
+}
+
+// relationsAtOffsets reads the relations at the given serialized byte
+// offsets from the relation data file and returns those that match
+// the filters.
+func (s *fsUnitStore) relationsAtOffsets(ofs byteOffsets, fs []RelationFilter) (rels []*graph.Relation, err error) {
+	if len(ofs) == 0 {
+		return nil, nil
+	}
+	f, err := s.fs.Open(unitRelationsFilename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err2 := f.Close()
+		if err == nil {
+			err = err2
+		}
+	}()
+
+	for _, o := range ofs {
+		if _, err := f.Seek(o, 0); err != nil {
+			return nil, err
+		}
+		var rel graph.Relation
+		if _, err := Codec.NewDecoder(f).Decode(&rel); err != nil {
+			return nil, err
+		}
+		if relationFilters(fs).SelectRelation(&rel) {
+			rels = append(rels, &rel)
+		}
+	}
+	return rels, nil
+}
+
+// readRelations reads all relations from the relation data file and
+// returns them along with their serialized byte offsets.
+func (s *fsUnitStore) readRelations() (rels []*graph.Relation, ofs byteOffsets, err error) {
+	f, err := s.fs.Open(unitRelationsFilename)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		err2 := f.Close()
+		if err == nil {
+			err = err2
+		}
+	}()
+
+	dec := Codec.NewDecoder(f)
+	var o int64
+	for {
+		var rel graph.Relation
+		n, err := dec.Decode(&rel)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+		ofs = append(ofs, o)
+		rels = append(rels, &rel)
+		o += int64(n)
+	}
+	return rels, ofs, nil
+}
+
+// writeRelations writes the relation data file. It also tracks (in
+// ofs) the serialized byte offset where each relation's serialized
+// representation begins (which is used during index construction).
+func (s *fsUnitStore) writeRelations(rels []*graph.Relation) (ofs byteOffsets, err error) {
+	vlog.Printf("%s: writing %d relations...", s, len(rels))
+	f, err := s.fs.Create(unitRelationsFilename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err2 := f.Close()
+		if err == nil {
+			err = err2
+		}
+	}()
+
+	bw := bufio.NewWriter(f)
+	enc := Codec.NewEncoder(bw)
+	ofs = make(byteOffsets, len(rels))
+	var o uint64
+	for i, rel := range rels {
+		ofs[i] = int64(o)
+		n, err := enc.Encode(rel)
+		if err != nil {
+			return nil, err
+		}
+		o += n
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	return ofs, nil
+}
+
+// Relations implements RelationStore.
+func (s *indexedUnitStore) Relations(fs ...RelationFilter) ([]*graph.Relation, error) {
+	// Try to find an index that covers this query.
+	if xname, bx := bestCoverageIndex(s.indexes, fs, isRelationIndex); bx != nil {
+		err := prepareIndex(s.fs, xname, bx)
+		if _, notExist := err.(*errIndexNotExist); notExist {
+			// The source unit was imported before relations were
+			// indexed.
+			vlog.Printf("indexedUnitStore.Relations(%v): Index %q does not exist; performing full scan.", fs, xname)
+			return s.fsUnitStore.Relations(fs...)
+		} else if err != nil {
+			return nil, err
+		}
+		vlog.Printf("indexedUnitStore.Relations(%v): Found covering index %q (%v).", fs, xname, bx)
+		ofs, err := bx.(relationIndex).Relations(fs...)
+		if err != nil {
+			return nil, err
+		}
+		return s.relationsAtOffsets(ofs, fs)
+	}
+
+	// Fall back to full scan.
+	return s.fsUnitStore.Relations(fs...)
+}
+
+func (s *unionMultiRepoStore) Relations(f ...RelationFilter) ([]*graph.Relation, error) {
+	owned, err := s.owners(f)
+	if err != nil {
+		return nil, err
+	}
+
+	storeRels := make([][]*graph.Relation, len(s.stores))
+	par := parallel.NewRun(storeFetchPar)
+	for i_, ss_ := range s.stores {
+		i := i_
+		ss, ok := ss_.(RelationStore)
+		if !ok || len(owned[i]) == 0 {
+			continue
+		}
+		par.Acquire()
+		go func() {
+			defer par.Release()
+			rels, err := ss.Relations(filtersForUnionMember(f, owned[i]).([]RelationFilter)...)
+			if err != nil && !isStoreNotExist(err) {
+				par.Error(err)
+				return
+			}
+			storeRels[i] = rels
+		}()
+	}
+	if err := par.Wait(); err != nil {
+		return nil, err
+	}
+
+	var allRels []*graph.Relation
+	for _, rels := range storeRels {
+		allRels = append(allRels, rels...)
+	}
+	sort.Stable(relationsInStoreOrder(allRels))
+	return allRels, nil
+}
+
+// relationsInStoreOrder sorts relations returned by a MultiRepoStore
+// by the repo and commit ID that contain them (like the other results
+// of multi-repo stores), preserving the order of relations within
+// each version.
+type relationsInStoreOrder []*graph.Relation
+
+func (v relationsInStoreOrder) Len() int      { return len(v) }
+func (v relationsInStoreOrder) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v relationsInStoreOrder) Less(i, j int) bool {
+	return compareStrings(v[i].From.Repo, v[j].From.Repo, v[i].From.CommitID, v[j].From.CommitID) < 0
+}
@@ -0,0 +1,161 @@
+package store
+
+import (
+	"io"
+	"sync"
+
+	"github.com/alecthomas/binary"
+	"github.com/gogo/protobuf/proto"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/store/phtable"
+)
+
+// relationsToIndex makes it fast to determine which relations (within
+// a source unit) point to a def (e.g., to find a def's implementers).
+type relationsToIndex struct {
+	phtable *phtable.CHD
+	ready   bool
+	sync.RWMutex
+}
+
+var _ interface {
+	Index
+	mmapIndex
+	relationIndex
+	relationIndexBuilder
+} = (*relationsToIndex)(nil)
+
+var c_relationsToIndex_getByDef = &counter{count: new(int64)}
+
+func (x *relationsToIndex) String() string { return "relationsToIndex" }
+
+func (x *relationsToIndex) getByDef(def graph.RefDefKey) (byteOffsets, bool, error) {
+	c_relationsToIndex_getByDef.increment()
+	if x.phtable == nil {
+		panic("phtable not built/read")
+	}
+
+	k, err := proto.Marshal(&def)
+	if err != nil {
+		return nil, false, err
+	}
+
+	v := x.phtable.Get(k)
+	if v == nil {
+		return nil, false, nil
+	}
+
+	var ofs byteOffsets
+	if err := binary.Unmarshal(v, &ofs); err != nil {
+		return nil, true, err
+	}
+	return ofs, true, nil
+}
+
+// Covers implements Index. Only ByRelationTo filters whose def is
+// fully specified are covered, because the index can't look up
+// relations to any def in a repo or source unit.
+func (x *relationsToIndex) Covers(filters interface{}) int {
+	cov := 0
+	for _, f := range storeFilters(filters) {
+		if ff, ok := f.(ByRelationToFilter); ok {
+			if def := ff.ByRelationTo(); def.DefRepo != "" && def.DefUnitType != "" && def.DefUnit != "" {
+				cov++
+			}
+		}
+	}
+	return cov
+}
+
+// Relations implements relationIndex.
+func (x *relationsToIndex) Relations(fs ...RelationFilter) (byteOffsets, error) {
+	x.RLock()
+	defer x.RUnlock()
+	for _, f := range fs {
+		if ff, ok := f.(ByRelationToFilter); ok {
+			ofs, _, err := x.getByDef(ff.withEmptyImpliedValues())
+			return ofs, err
+		}
+	}
+	return nil, nil
+}
+
+// Build implements relationIndexBuilder.
+func (x *relationsToIndex) Build(rels []*graph.Relation, ofs byteOffsets) error {
+	x.Lock()
+	defer x.Unlock()
+	vlog.Printf("relationsToIndex: building inverted def->relation index (%d relations)...", len(rels))
+	defToRelOfs := map[graph.RefDefKey]byteOffsets{}
+	for i, rel := range rels {
+		defToRelOfs[rel.To] = append(defToRelOfs[rel.To], ofs[i])
+	}
+
+	b := phtable.Builder(len(defToRelOfs))
+	for def, relOfs := range defToRelOfs {
+		v, err := binary.Marshal(relOfs)
+		if err != nil {
+			return err
+		}
+		k, err := proto.Marshal(&def)
+		if err != nil {
+			return err
+		}
+		b.Add(k, v)
+	}
+	h, err := b.Build()
+	if err != nil {
+		return err
+	}
+	x.phtable = h
+	x.ready = true
+	vlog.Printf("relationsToIndex: done building index.")
+	return nil
+}
+
+// Write implements persistedIndex.
+func (x *relationsToIndex) Write(w io.Writer) error {
+	x.RLock()
+	defer x.RUnlock()
+	if x.phtable == nil {
+		panic("no phtable to write")
+	}
+	return x.phtable.Write(w)
+}
+
+// WriteMappable implements mmapIndex.
+func (x *relationsToIndex) WriteMappable(w io.Writer) error {
+	x.RLock()
+	defer x.RUnlock()
+	if x.phtable == nil {
+		panic("no phtable to write")
+	}
+	return x.phtable.WriteMappable(w)
+}
+
+// Read implements persistedIndex.
+func (x *relationsToIndex) Read(r io.Reader) error {
+	phtable, err := phtable.Read(r)
+	x.Lock()
+	defer x.Unlock()
+	x.phtable = phtable
+	x.ready = (err == nil)
+	return err
+}
+
+// Mmap implements mmapIndex.
+func (x *relationsToIndex) Mmap(b []byte, release func()) error {
+	phtable, err := phtable.Mapped(b, false, release)
+	x.Lock()
+	defer x.Unlock()
+	x.phtable = phtable
+	x.ready = (err == nil)
+	return err
+}
+
+// Ready implements persistedIndex.
+func (x *relationsToIndex) Ready() bool {
+	x.RLock()
+	defer x.RUnlock()
+	return x.ready
+}
@@ -1,8 +1,18 @@
 package store
 
 import (
+	"bufio"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/kr/fs"
 	"sourcegraph.com/sourcegraph/rwvfs"
@@ -77,3 +87,315 @@ func (defaultRepoPaths) ListRepoPaths(vfs rwvfs.WalkableFileSystem, after string
 	}
 	return paths, nil
 }
+
+// A RepoPathsRecorder is a RepoPaths that keeps its own listing of the
+// repos in a store (instead of finding them by walking the
+// filesystem). The store calls AddRepo when it creates a repo's
+// directory.
+type RepoPathsRecorder interface {
+	RepoPaths
+
+	// AddRepo adds repo to the listing. It is a no-op if repo is
+	// already listed.
+	AddRepo(vfs rwvfs.WalkableFileSystem, repo string) error
+
+	// RemoveRepo removes repo from the listing. It is a no-op if repo
+	// is not listed.
+	RemoveRepo(vfs rwvfs.WalkableFileSystem, repo string) error
+}
+
+// HashedRepoPaths is a repo path configuration for FS-backed
+// multi-repo stores with many repos. It stores each repo in a
+// directory named after the (escaped) repo URI, underneath 2 levels
+// of directories named after the first 4 hex digits of the SHA-1 hash
+// of the URI (e.g., "a9/4a/github.com%2Ffoo%2Fbar"), so that no
+// directory has too many entries.
+//
+// Repos are listed from a sorted listing file (HashedRepoListFile)
+// instead of by walking the tree. The listing file is sorted by repo
+// URI (not by subpath), and the "after" arg to ListRepoPaths is
+// compared against the repo URIs.
+var HashedRepoPaths RepoPathsRecorder = &hashedRepoPaths{}
+
+// HashedRepoListFile is the name of the file (at the root of the
+// multi-repo store) that lists the repos stored using
+// HashedRepoPaths, one per line, in sorted order.
+const HashedRepoListFile = ".srclib-repos"
+
+type hashedRepoPaths struct {
+	mu sync.Mutex // guards updates to the listing file
+}
+
+// repoListLockTimeout is the age after which another writer's lock on
+// the listing file is considered stale. Updates to the listing file
+// take much less time than this.
+const repoListLockTimeout = time.Minute
+
+// lockRepoList acquires the lock that guards updates to the listing
+// file (from this and other processes), waiting for other writers to
+// release it.
+func (p *hashedRepoPaths) lockRepoList(vfs rwvfs.WalkableFileSystem) (unlock func() error, err error) {
+	p.mu.Lock()
+	unlockFS, err := fsLock{fs: vfs, dir: vfs.Join(storeLocksDir, "repos")}.wait(repoListLockTimeout)
+	if err != nil {
+		p.mu.Unlock()
+		return nil, err
+	}
+	return func() error {
+		defer p.mu.Unlock()
+		return unlockFS()
+	}, nil
+}
+
+// RepoToPath implements RepoPaths.
+func (*hashedRepoPaths) RepoToPath(repo string) []string {
+	h := fmt.Sprintf("%x", sha1.Sum([]byte(repo)))
+	return []string{h[0:2], h[2:4], url.QueryEscape(repo)}
+}
+
+// PathToRepo implements RepoPaths.
+func (*hashedRepoPaths) PathToRepo(path []string) string {
+	repo, err := url.QueryUnescape(path[len(path)-1])
+	if err != nil {
+		return path[len(path)-1]
+	}
+	return repo
+}
+
+// ListRepoPaths implements RepoPaths. If the listing file does not
+// exist, the store has not been created, and an error satisfying
+// os.IsNotExist is returned.
+func (p *hashedRepoPaths) ListRepoPaths(vfs rwvfs.WalkableFileSystem, after string, max int) ([][]string, error) {
+	repos, err := p.readRepoList(vfs)
+	if err != nil {
+		return nil, err
+	}
+	var i int
+	if after != "" {
+		afterRepo := p.PathToRepo(strings.Split(filepath.ToSlash(after), "/"))
+		i = sort.SearchStrings(repos, afterRepo)
+		if i < len(repos) && repos[i] == afterRepo {
+			i++
+		}
+	}
+	repos = repos[i:]
+	if max != 0 && len(repos) > max {
+		repos = repos[:max]
+	}
+	paths := make([][]string, len(repos))
+	for i, repo := range repos {
+		paths[i] = p.RepoToPath(repo)
+	}
+	return paths, nil
+}
+
+// AddRepo implements RepoPathsRecorder.
+func (p *hashedRepoPaths) AddRepo(vfs rwvfs.WalkableFileSystem, repo string) (err error) {
+	unlock, err := p.lockRepoList(vfs)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err2 := unlock(); err == nil {
+			err = err2
+		}
+	}()
+	repos, err := p.readRepoList(vfs)
+	if os.IsNotExist(err) {
+		repos = nil
+	} else if err != nil {
+		return err
+	}
+	i := sort.SearchStrings(repos, repo)
+	if i < len(repos) && repos[i] == repo {
+		return nil
+	}
+	repos = append(repos, "")
+	copy(repos[i+1:], repos[i:])
+	repos[i] = repo
+	return p.writeRepoList(vfs, repos)
+}
+
+// RemoveRepo implements RepoPathsRecorder.
+func (p *hashedRepoPaths) RemoveRepo(vfs rwvfs.WalkableFileSystem, repo string) (err error) {
+	unlock, err := p.lockRepoList(vfs)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err2 := unlock(); err == nil {
+			err = err2
+		}
+	}()
+	repos, err := p.readRepoList(vfs)
+	if os.IsNotExist(err) {
+		repos = nil
+	} else if err != nil {
+		return err
+	}
+	i := sort.SearchStrings(repos, repo)
+	if i == len(repos) || repos[i] != repo {
+		return nil
+	}
+	repos = append(repos[:i], repos[i+1:]...)
+	return p.writeRepoList(vfs, repos)
+}
+
+// readRepoList reads the sorted list of repos from the listing file.
+func (*hashedRepoPaths) readRepoList(vfs rwvfs.WalkableFileSystem) ([]string, error) {
+	f, err := vfs.Open(HashedRepoListFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var repos []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if repo := s.Text(); repo != "" {
+			repos = append(repos, repo)
+		}
+	}
+	return repos, s.Err()
+}
+
+// writeRepoList replaces the listing file. The new listing is written
+// to a temporary file that is renamed into place, so that concurrent
+// readers see either the old or the new listing.
+func (*hashedRepoPaths) writeRepoList(vfs rwvfs.WalkableFileSystem, repos []string) error {
+	return createAtomic(vfs, HashedRepoListFile, func(w io.Writer) error {
+		bw := bufio.NewWriter(w)
+		for _, repo := range repos {
+			if _, err := fmt.Fprintln(bw, repo); err != nil {
+				return err
+			}
+		}
+		return bw.Flush()
+	})
+}
+
+// MigrateRepoPaths moves the data of all repos in an FS-backed
+// multi-repo store from the layout given by the "from" RepoPaths to
+// the layout given by "to". It returns the repos that were migrated.
+//
+// Stores using either layout must not be used while the migration is
+// in progress. If the migration fails, it may be resumed by calling
+// MigrateRepoPaths again with the same arguments.
+func MigrateRepoPaths(vfs rwvfs.WalkableFileSystem, from, to RepoPaths) ([]string, error) {
+	paths, err := from.ListRepoPaths(vfs, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	setCreateParentDirs(vfs)
+
+	repos := make([]string, len(paths))
+	for i, path := range paths {
+		repo := from.PathToRepo(path)
+		repos[i] = repo
+
+		src, dst := vfs.Join(from.RepoToPath(repo)...), vfs.Join(to.RepoToPath(repo)...)
+		if src != dst {
+			vlog.Printf("Migrating repo %s from %s to %s", repo, src, dst)
+			if err := copyTree(vfs, src, dst); err != nil {
+				return nil, fmt.Errorf("migrating repo %s: %s", repo, err)
+			}
+		}
+		if to, ok := to.(RepoPathsRecorder); ok {
+			if err := to.AddRepo(vfs, repo); err != nil {
+				return nil, err
+			}
+		}
+		if src != dst {
+			if err := removeTree(vfs, src); err != nil {
+				return nil, fmt.Errorf("removing old data for repo %s: %s", repo, err)
+			}
+			if from, ok := from.(RepoPathsRecorder); ok {
+				if err := from.RemoveRepo(vfs, repo); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return repos, nil
+}
+
+// copyTree copies all files in the src dir to the dst dir.
+func copyTree(vfs rwvfs.WalkableFileSystem, src, dst string) error {
+	w := fs.WalkFS(src, vfs)
+	for w.Step() {
+		if err := w.Err(); err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(filepath.ToSlash(w.Path()), filepath.ToSlash(src)), "/")
+		target := dst
+		if rel != "" {
+			target = vfs.Join(dst, rel)
+		}
+		if w.Stat().Mode().IsDir() {
+			if err := rwvfs.MkdirAll(vfs, target); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := copyFile(vfs, w.Path(), target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(vfs rwvfs.FileSystem, src, dst string) error {
+	r, err := vfs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := vfs.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// removeTree removes the dir and all of its contents. It then removes
+// the dir's parents, up to (but not including) the root, if they are
+// empty.
+func removeTree(vfs rwvfs.WalkableFileSystem, dir string) error {
+	var files, dirs []string
+	w := fs.WalkFS(dir, vfs)
+	for w.Step() {
+		if err := w.Err(); err != nil {
+			return err
+		}
+		if w.Stat().Mode().IsDir() {
+			dirs = append(dirs, w.Path())
+		} else {
+			files = append(files, w.Path())
+		}
+	}
+	for _, f := range files {
+		if err := vfs.Remove(f); err != nil {
+			return err
+		}
+	}
+	// Remove the deepest dirs first.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := vfs.Remove(dirs[i]); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	for parent := path.Dir(filepath.ToSlash(dir)); parent != "." && parent != "/"; parent = path.Dir(parent) {
+		entries, err := vfs.ReadDir(parent)
+		if err != nil || len(entries) > 0 {
+			break
+		}
+		if err := vfs.Remove(parent); err != nil {
+			break
+		}
+	}
+	return nil
+}
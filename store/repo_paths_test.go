@@ -3,6 +3,10 @@ package store
 import (
 	"encoding/hex"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
 
 	"sourcegraph.com/sourcegraph/rwvfs"
 )
@@ -37,3 +41,123 @@ func (e *customRepoPaths) ListRepoPaths(vfs rwvfs.WalkableFileSystem, after stri
 	}
 	return paths, nil
 }
+
+func TestHashedRepoPaths(t *testing.T) {
+	fs := newTestFS()
+	if _, err := HashedRepoPaths.ListRepoPaths(fs, "", 0); !os.IsNotExist(err) {
+		t.Errorf("ListRepoPaths without listing file: got error %v, want not-exist error", err)
+	}
+	for _, repo := range []string{"r3", "github.com/a/b", "r1", "r3", "r2"} {
+		if err := HashedRepoPaths.AddRepo(fs, repo); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	listRepos := func(after string, max int) []string {
+		paths, err := HashedRepoPaths.ListRepoPaths(fs, after, max)
+		if err != nil {
+			t.Fatal(err)
+		}
+		repos := make([]string, len(paths))
+		for i, path := range paths {
+			repos[i] = HashedRepoPaths.PathToRepo(path)
+		}
+		return repos
+	}
+
+	if repos, want := listRepos("", 0), []string{"github.com/a/b", "r1", "r2", "r3"}; !reflect.DeepEqual(repos, want) {
+		t.Errorf("got repos %v, want %v", repos, want)
+	}
+	after := fs.Join(HashedRepoPaths.RepoToPath("r1")...)
+	if repos, want := listRepos(after, 1), []string{"r2"}; !reflect.DeepEqual(repos, want) {
+		t.Errorf("after r1: got repos %v, want %v", repos, want)
+	}
+
+	if err := HashedRepoPaths.RemoveRepo(fs, "r2"); err != nil {
+		t.Fatal(err)
+	}
+	if repos, want := listRepos(after, 0), []string{"r3"}; !reflect.DeepEqual(repos, want) {
+		t.Errorf("after removing r2: got repos %v, want %v", repos, want)
+	}
+}
+
+func TestHashedRepoPaths_concurrent(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "srclib-repo-paths")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	fs := OSFS(tmpDir)
+
+	// Simulate separate processes, which don't share the in-process
+	// mutex.
+	const n = 10
+	errc := make(chan error, n)
+	for i := 0; i < n; i++ {
+		repo := fmt.Sprintf("r%d", i)
+		go func() { errc <- (&hashedRepoPaths{}).AddRepo(fs, repo) }()
+	}
+	for i := 0; i < n; i++ {
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	paths, err := HashedRepoPaths.ListRepoPaths(fs, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != n {
+		t.Errorf("got %d repos, want %d", len(paths), n)
+	}
+
+	// The listing file is replaced by renaming temporary files; none
+	// should be left behind.
+	fis, err := fs.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range fis {
+		if name := fi.Name(); name != HashedRepoListFile && name != storeLocksDir {
+			t.Errorf("got leftover file %q", name)
+		}
+	}
+}
+
+func TestMigrateRepoPaths(t *testing.T) {
+	fs := newTestFS()
+	mrs := NewFSMultiRepoStore(fs, nil)
+	importPagingTestData(t, mrs)
+	want, err := mrs.Defs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, layout := range []struct{ from, to RepoPaths }{
+		{DefaultRepoPaths, HashedRepoPaths},
+		{HashedRepoPaths, DefaultRepoPaths},
+	} {
+		repos, err := MigrateRepoPaths(fs, layout.from, layout.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"r1", "r2"}; !reflect.DeepEqual(repos, want) {
+			t.Errorf("%T to %T: got migrated repos %v, want %v", layout.from, layout.to, repos, want)
+		}
+
+		if repos, err := layout.from.ListRepoPaths(fs, "", 0); err != nil {
+			t.Fatal(err)
+		} else if len(repos) != 0 {
+			t.Errorf("%T to %T: got repos %v in old layout, want none", layout.from, layout.to, repos)
+		}
+
+		mrs := NewFSMultiRepoStore(fs, &FSMultiRepoStoreConf{RepoPaths: layout.to})
+		defs, err := mrs.Defs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !deepEqual(defs, want) {
+			t.Errorf("%T to %T: got defs %v, want %v", layout.from, layout.to, defs, want)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package store
 
 import (
 	"sync"
+	"time"
 
 	"github.com/neelance/parallel"
 	"sourcegraph.com/sourcegraph/srclib/graph"
@@ -17,7 +18,8 @@ type RepoStore interface {
 
 	// TreeStore's methods call the corresponding methods on the
 	// TreeStore of each version contained within this repository. The
-	// combined results are returned (in undefined order).
+	// combined results are returned in the order described in
+	// paging.go.
 	TreeStore
 }
 
@@ -30,7 +32,9 @@ type RepoImporter interface {
 
 	// CreateVersion creates the version entry for the given commit. This signals that the commit data is
 	// ready to be queried. All other data (including indexes) needs to exist before this gets called.
-	CreateVersion(commitID string) error
+	//
+	// The metadata (if non-nil) is stored with the version entry.
+	CreateVersion(commitID string, meta *VersionMeta) error
 }
 
 type RepoIndexer interface {
@@ -64,11 +68,38 @@ type Version struct {
 	// workspace.
 	CommitID string
 
+	// Meta is the metadata that was recorded when the version was
+	// created. It is nil if no metadata was recorded.
+	Meta *VersionMeta `json:",omitempty"`
+
 	// TODO(sqs): add build metadata fields (build logs, timings, what
 	// was actually built, incremental build tracking, diff/pack
 	// compression helper info, etc.)
 }
 
+// VersionMeta describes how and when a version's data was imported.
+type VersionMeta struct {
+	// ImportedAt is when the version's data was imported.
+	ImportedAt time.Time
+
+	// Branch is the branch that the commit was on when it was
+	// imported, if known.
+	Branch string `json:",omitempty"`
+
+	// SrclibVersion is the version of srclib that imported the data.
+	SrclibVersion string `json:",omitempty"`
+
+	// Toolchains are the paths of the toolchains that produced the
+	// imported data.
+	Toolchains []string `json:",omitempty"`
+
+	// NumUnits, NumDefs, and NumRefs are the number of source units,
+	// defs, and refs that were imported.
+	NumUnits int `json:",omitempty"`
+	NumDefs  int `json:",omitempty"`
+	NumRefs  int `json:",omitempty"`
+}
+
 // IsCurrentWorkspace returns a boolean indicating whether this
 // version represents the current workspace, as opposed to a specific
 // VCS commit.
@@ -90,12 +121,8 @@ func (s repoStores) Versions(f ...VersionFilter) ([]*Version, error) {
 	}
 
 	var allVersions []*Version
-	for repo, rs := range rss {
-		if rs == nil {
-			continue
-		}
-
-		versions, err := rs.Versions(filtersForRepo(repo, f).([]VersionFilter)...)
+	for _, repo := range sortedRepos(rss) {
+		versions, err := rss[repo].Versions(filtersForRepo(repo, f).([]VersionFilter)...)
 		if err != nil && !isStoreNotExist(err) {
 			return nil, err
 		}
@@ -104,7 +131,7 @@ func (s repoStores) Versions(f ...VersionFilter) ([]*Version, error) {
 		}
 		allVersions = append(allVersions, versions...)
 	}
-	return allVersions, nil
+	return selectVersions(allVersions, f), nil
 }
 
 func (s repoStores) Units(f ...UnitFilter) ([]*unit.SourceUnit, error) {
@@ -113,17 +140,11 @@ func (s repoStores) Units(f ...UnitFilter) ([]*unit.SourceUnit, error) {
 		return nil, err
 	}
 
-	var (
-		allUnits   []*unit.SourceUnit
-		allUnitsMu sync.Mutex
-	)
+	repos := sortedRepos(rss)
+	repoUnits := make([][]*unit.SourceUnit, len(repos))
 	par := parallel.NewRun(storeFetchPar)
-	for repo_, rs_ := range rss {
-		repo, rs := repo_, rs_
-		if rs == nil {
-			continue
-		}
-
+	for i_, repo_ := range repos {
+		i, repo, rs := i_, repo_, rss[repo_]
 		par.Acquire()
 		go func() {
 			defer par.Release()
@@ -135,35 +156,49 @@ func (s repoStores) Units(f ...UnitFilter) ([]*unit.SourceUnit, error) {
 			for _, unit := range units {
 				unit.Repo = repo
 			}
-			allUnitsMu.Lock()
-			allUnits = append(allUnits, units...)
-			allUnitsMu.Unlock()
+			repoUnits[i] = units
 		}()
 	}
 	err = par.Wait()
+
+	var allUnits []*unit.SourceUnit
+	for _, units := range repoUnits {
+		allUnits = append(allUnits, units...)
+	}
 	return allUnits, err
 }
 
-func (s repoStores) Defs(f ...DefFilter) ([]*graph.Def, error) {
+func (s repoStores) Defs(f ...DefFilter) (defs []*graph.Def, err error) {
+	sp := traceOf(f).start(s.opener, "Defs")
+	defer func() { sp.end(len(defs), err) }()
+
 	rss, err := openRepoStores(s.opener, f)
 	if err != nil {
 		return nil, err
 	}
 
 	var (
-		allDefs   []*graph.Def
-		allDefsMu sync.Mutex
+		repos    = sortedRepos(rss)
+		repoDefs = make([][]*graph.Def, len(repos))
+		numDefs  int
+		mu       sync.Mutex
 	)
-	par := parallel.NewRun(storeFetchPar)
-	for repo_, rs_ := range rss {
-		repo, rs := repo_, rs_
-		if rs == nil {
-			continue
-		}
+	par := parallel.NewRun(fetchPar(f))
+	for i_, repo_ := range repos {
+		i, repo, rs := i_, repo_, rss[repo_]
 
 		par.Acquire()
+		mu.Lock()
+		full := pageFull(f, numDefs)
+		mu.Unlock()
+		if full {
+			par.Release()
+			break
+		}
+
 		go func() {
 			defer par.Release()
+			sp.opened(repo)
 			defs, err := rs.Defs(filtersForRepo(repo, f).([]DefFilter)...)
 			if err != nil && !isStoreNotExist(err) {
 				par.Error(err)
@@ -172,29 +207,39 @@ func (s repoStores) Defs(f ...DefFilter) ([]*graph.Def, error) {
 			for _, def := range defs {
 				def.Repo = repo
 			}
-			allDefsMu.Lock()
-			allDefs = append(allDefs, defs...)
-			allDefsMu.Unlock()
+			mu.Lock()
+			repoDefs[i] = defs
+			numDefs += len(defs)
+			mu.Unlock()
 		}()
 	}
 	err = par.Wait()
-	return allDefs, err
+
+	var allDefs []*graph.Def
+	for _, defs := range repoDefs {
+		allDefs = append(allDefs, defs...)
+	}
+	return pageDefs(allDefs, f), err
 }
 
-func (s repoStores) Refs(f ...RefFilter) ([]*graph.Ref, error) {
+func (s repoStores) Refs(f ...RefFilter) (refs []*graph.Ref, err error) {
+	sp := traceOf(f).start(s.opener, "Refs")
+	defer func() { sp.end(len(refs), err) }()
+
 	rss, err := openRepoStores(s.opener, f)
 	if err != nil {
 		return nil, err
 	}
 
 	var allRefs []*graph.Ref
-	for repo, rs := range rss {
-		if rs == nil {
-			continue
+	for _, repo := range sortedRepos(rss) {
+		if pageFull(f, len(allRefs)) {
+			break
 		}
 
+		sp.opened(repo)
 		setImpliedRepo(f, repo)
-		refs, err := rs.Refs(filtersForRepo(repo, f).([]RefFilter)...)
+		refs, err := rss[repo].Refs(filtersForRepo(repo, f).([]RefFilter)...)
 		if err != nil && !isStoreNotExist(err) {
 			return nil, err
 		}
@@ -206,5 +251,5 @@ func (s repoStores) Refs(f ...RefFilter) ([]*graph.Ref, error) {
 		}
 		allRefs = append(allRefs, refs...)
 	}
-	return allRefs, nil
+	return pageRefs(allRefs, f), nil
 }
@@ -40,7 +40,7 @@ func testRepoStore_Import_empty(t *testing.T, rs RepoStoreImporter) {
 			t.Fatalf("%s: Index: %s", rs, err)
 		}
 	}
-	if err := rs.CreateVersion("c"); err != nil {
+	if err := rs.CreateVersion("c", nil); err != nil {
 		t.Errorf("%s: CreateVersion(c): %s", rs, err)
 	}
 	testTreeStore_empty(t, rs)
@@ -72,7 +72,7 @@ func testRepoStore_Import(t *testing.T, rs RepoStoreImporter) {
 			t.Fatalf("%s: Index: %s", rs, err)
 		}
 	}
-	if err := rs.CreateVersion("c"); err != nil {
+	if err := rs.CreateVersion("c", nil); err != nil {
 		t.Errorf("%s: CreateVersion(c): %s", rs, err)
 	}
 }
@@ -88,7 +88,7 @@ func testRepoStore_Versions(t *testing.T, rs RepoStoreImporter) {
 				t.Fatalf("%s: Index: %s", rs, err)
 			}
 		}
-		if err := rs.CreateVersion(version); err != nil {
+		if err := rs.CreateVersion(version, nil); err != nil {
 			t.Errorf("%s: CreateVersion(%s): %s", rs, version, err)
 		}
 	}
@@ -127,7 +127,7 @@ func testRepoStore_Units(t *testing.T, rs RepoStoreImporter) {
 			t.Fatalf("%s: Index: %s", rs, err)
 		}
 	}
-	if err := rs.CreateVersion("c"); err != nil {
+	if err := rs.CreateVersion("c", nil); err != nil {
 		t.Errorf("%s: CreateVersion(c): %s", rs, err)
 	}
 
@@ -181,7 +181,7 @@ func testRepoStore_Defs(t *testing.T, rs RepoStoreImporter) {
 			t.Fatalf("%s: Index: %s", rs, err)
 		}
 	}
-	if err := rs.CreateVersion("c"); err != nil {
+	if err := rs.CreateVersion("c", nil); err != nil {
 		t.Errorf("%s: CreateVersion(c): %s", rs, err)
 	}
 
@@ -233,7 +233,7 @@ func testRepoStore_Defs_ByCommitIDs(t *testing.T, rs RepoStoreImporter) {
 				t.Fatalf("%s: Index: %s", rs, err)
 			}
 		}
-		if err := rs.CreateVersion(commitID); err != nil {
+		if err := rs.CreateVersion(commitID, nil); err != nil {
 			t.Errorf("%s: CreateVersion(%s): %s", rs, commitID, err)
 		}
 	}
@@ -273,7 +273,7 @@ func testRepoStore_Defs_ByCommitIDs_ByFile(t *testing.T, rs RepoStoreImporter) {
 				t.Fatalf("%s: Index: %s", rs, err)
 			}
 		}
-		if err := rs.CreateVersion(commitID); err != nil {
+		if err := rs.CreateVersion(commitID, nil); err != nil {
 			t.Errorf("%s: CreateVersion(%s): %s", rs, commitID, err)
 		}
 	}
@@ -323,7 +323,7 @@ func testRepoStore_Refs(t *testing.T, rs RepoStoreImporter) {
 			t.Fatalf("%s: Index: %s", rs, err)
 		}
 	}
-	if err := rs.CreateVersion("c"); err != nil {
+	if err := rs.CreateVersion("c", nil); err != nil {
 		t.Errorf("%s: CreateVersion(c): %s", rs, err)
 	}
 
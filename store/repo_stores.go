@@ -64,12 +64,31 @@ func openRepoStores(o repoStoreOpener, filters interface{}) (map[string]RepoStor
 		return nil, err
 	}
 
+	af := getAfterFilter(filters)
+
 	if repos == nil {
-		return o.openAllRepoStores()
+		rss, err := o.openAllRepoStores()
+		if err != nil || af == nil {
+			return rss, err
+		}
+
+		// Skip repos that sort entirely before the After filter's
+		// cursor. Copy the map because the opener might return its
+		// own.
+		rss2 := make(map[string]RepoStore, len(rss))
+		for repo, rs := range rss {
+			if af.repoScope(repo) >= 0 {
+				rss2[repo] = rs
+			}
+		}
+		return rss2, nil
 	}
 
 	rss := make(map[string]RepoStore, len(repos))
 	for _, repo := range repos {
+		if af != nil && af.repoScope(repo) < 0 {
+			continue
+		}
 		rss[repo] = o.openRepoStore(repo)
 	}
 	return rss, nil
@@ -85,22 +104,31 @@ func filtersForRepo(repo string, filters interface{}) interface{} {
 	repoFilters := make([]interface{}, len(sf))
 	copy(repoFilters, sf)
 
+	_, _, paged := pageBounds(sf)
+
 	d := 0 // deleted (-) and added (+) indexes in repoFilters vs. sf
 	for i, f := range sf {
 		switch f := f.(type) {
 
+		case *afterFilter, *limiter:
+			if f2, keep := forChildStore(f, paged, func(af *afterFilter) *afterFilter { return af.forRepo(repo) }); keep {
+				repoFilters[i+d] = f2
+			} else {
+				repoFilters = append(repoFilters[:i+d], repoFilters[i+d+1:]...)
+				d--
+			}
+
 		case byRepoCommitIDsFilter:
-			found := false
+			var commitIDs []string
 			for _, v := range f.ByRepoCommitIDs() {
 				if v.Repo == repo {
-					repoFilters[i+d] = ByCommitIDs(v.CommitID)
-					found = true
-					break
+					commitIDs = append(commitIDs, v.CommitID)
 				}
 			}
-			if !found {
+			if len(commitIDs) == 0 {
 				panic(fmt.Sprintf("in ByRepoCommitIDsFilter, no version.Repo == %q", repo))
 			}
+			repoFilters[i+d] = ByCommitIDs(commitIDs...)
 
 		case byReposFilter:
 			found := false
@@ -252,6 +252,12 @@ func TestFiltersForRepo(t *testing.T) {
 				"r2": []DefFilter{ByCommitIDs("c2")},
 			},
 		},
+		{
+			filters: []DefFilter{ByRepoCommitIDs(Version{Repo: "r", CommitID: "c1"}, Version{Repo: "r", CommitID: "c2"})},
+			wantByRepo: map[string]interface{}{
+				"r": []DefFilter{ByCommitIDs("c1", "c2")},
+			},
+		},
 	}
 	for _, test := range tests {
 		for repo, want := range test.wantByRepo {
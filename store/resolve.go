@@ -0,0 +1,190 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// A VersionPolicy chooses which stored version of a repo an abstract
+// def key (one with no commit ID) refers to.
+type VersionPolicy interface {
+	// ChooseVersion returns the version (from versions, all of
+	// which contain the def) that the def key should resolve to, or
+	// nil if the policy does not apply.
+	ChooseVersion(def graph.RefDefKey, versions []*Version) *Version
+}
+
+// LatestImported returns a policy that chooses the most recently
+// imported version (according to its VersionMeta). Versions without
+// metadata are considered older than versions with metadata.
+func LatestImported() VersionPolicy { return latestImportedPolicy{} }
+
+type latestImportedPolicy struct{}
+
+func (latestImportedPolicy) String() string { return "LatestImported" }
+func (latestImportedPolicy) ChooseVersion(def graph.RefDefKey, versions []*Version) *Version {
+	// Copy versions because SelectVersions sorts in place.
+	versions = append([]*Version(nil), versions...)
+	if versions := NewestVersions(1).SelectVersions(versions); len(versions) == 1 {
+		return versions[0]
+	}
+	return nil
+}
+
+// BranchHead returns a policy that chooses the most recently imported
+// version of the given branch.
+func BranchHead(branch string) VersionPolicy { return branchHeadPolicy(branch) }
+
+type branchHeadPolicy string
+
+func (p branchHeadPolicy) String() string { return fmt.Sprintf("BranchHead(%s)", string(p)) }
+func (p branchHeadPolicy) ChooseVersion(def graph.RefDefKey, versions []*Version) *Version {
+	f := ByBranch(string(p))
+	var branchVersions []*Version
+	for _, version := range versions {
+		if f.SelectVersion(version) {
+			branchVersions = append(branchVersions, version)
+		}
+	}
+	return latestImportedPolicy{}.ChooseVersion(def, branchVersions)
+}
+
+// PinnedVersions returns a policy that chooses the version of each
+// repo that was pinned by dependency resolution. The pins map is
+// keyed on repo, and each value is a revision (a commit ID or the
+// name of a branch, as in the ToRepo and ToRevSpec fields of
+// dep.ResolvedDep). If the revision is a branch name, the most
+// recently imported version of that branch is chosen.
+func PinnedVersions(pins map[string]string) VersionPolicy { return pinnedVersionsPolicy(pins) }
+
+type pinnedVersionsPolicy map[string]string
+
+func (p pinnedVersionsPolicy) String() string {
+	return fmt.Sprintf("PinnedVersions(%v)", map[string]string(p))
+}
+func (p pinnedVersionsPolicy) ChooseVersion(def graph.RefDefKey, versions []*Version) *Version {
+	rev, present := p[def.DefRepo]
+	if !present || rev == "" {
+		return nil
+	}
+	for _, version := range versions {
+		if version.CommitID == rev {
+			return version
+		}
+	}
+	return branchHeadPolicy(rev).ChooseVersion(def, versions)
+}
+
+// A DefResolver resolves abstract def keys (which refer to a def in
+// a repo but not to a specific version of the repo) to concrete defs
+// in a MultiRepoStore.
+type DefResolver struct {
+	// Store is the store that contains the defs.
+	Store MultiRepoStore
+
+	// Policies choose the version that each def key resolves
+	// to. They are tried in order; the first policy that chooses a
+	// version is used.
+	Policies []VersionPolicy
+}
+
+// NewDefResolver creates a new DefResolver that resolves def keys to
+// defs in mrs using the given policies. If no policies are given,
+// LatestImported is used.
+func NewDefResolver(mrs MultiRepoStore, policies ...VersionPolicy) *DefResolver {
+	if len(policies) == 0 {
+		policies = []VersionPolicy{LatestImported()}
+	}
+	return &DefResolver{Store: mrs, Policies: policies}
+}
+
+// errDefNotResolved is returned by (*DefResolver).Resolve if none of
+// the policies chose a version.
+var errDefNotResolved = errors.New("no version policy matched def")
+
+// IsDefNotResolved returns true if err indicates that a def key could
+// not be resolved to a version that contains the def.
+func IsDefNotResolved(err error) bool {
+	return err == errDefNotResolved
+}
+
+// Resolve returns the def that key refers to in the version of
+// key.DefRepo chosen by the resolver's policies. Only versions that
+// contain the def are considered. Because a RefDefKey on its own does
+// not know which repo it was found in, key.DefRepo must be set.
+func (r *DefResolver) Resolve(key graph.RefDefKey) (*graph.Def, error) {
+	if key.DefRepo == "" {
+		return nil, fmt.Errorf("resolve def %+v: DefRepo is empty", key)
+	}
+	if key.DefPath == "" {
+		return nil, fmt.Errorf("resolve def %+v: DefPath is empty", key)
+	}
+
+	versions, defs, err := r.candidateVersions(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		vlog.Printf("Resolve(%+v): def is not in any version", key)
+		return nil, errDefNotResolved
+	}
+
+	for _, p := range r.Policies {
+		version := p.ChooseVersion(key, versions)
+		if version == nil {
+			continue
+		}
+		vlog.Printf("Resolve(%+v): %v chose commit %s", key, p, version.CommitID)
+		return defs[version.CommitID], nil
+	}
+	return nil, errDefNotResolved
+}
+
+// candidateVersions returns the versions of key.DefRepo that contain
+// the def, sorted by commit ID, and the def in each version (keyed on
+// commit ID). If key has no unit, the def in a version is the first
+// def (in any unit) with key's path, so its unit is taken from the
+// store instead of from key.
+func (r *DefResolver) candidateVersions(key graph.RefDefKey) ([]*Version, map[string]*graph.Def, error) {
+	filters := []DefFilter{ByRepos(key.DefRepo), ByDefPath(key.DefPath)}
+	if key.DefUnitType != "" && key.DefUnit != "" {
+		filters = append(filters, ByUnits(unit.ID2{Type: key.DefUnitType, Name: key.DefUnit}))
+	}
+	defs, err := r.Store.Defs(filters...)
+	if err != nil && !isStoreNotExist(err) {
+		return nil, nil, err
+	}
+	commitDefs := map[string]*graph.Def{}
+	for _, def := range defs {
+		if _, present := commitDefs[def.CommitID]; !present {
+			commitDefs[def.CommitID] = def
+		}
+	}
+	if len(commitDefs) == 0 {
+		return nil, nil, nil
+	}
+	ids := make([]string, 0, len(commitDefs))
+	for commitID := range commitDefs {
+		ids = append(ids, commitID)
+	}
+	sort.Strings(ids)
+
+	versions, err := r.Store.Versions(ByRepos(key.DefRepo), ByCommitIDs(ids...))
+	if err != nil {
+		return nil, nil, err
+	}
+	return versions, commitDefs, nil
+}
+
+func (r *DefResolver) String() string {
+	strs := make([]string, len(r.Policies))
+	for i, p := range r.Policies {
+		strs[i] = fmt.Sprint(p)
+	}
+	return fmt.Sprintf("DefResolver(%s; %s)", r.Store, strings.Join(strs, ", "))
+}
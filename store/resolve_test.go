@@ -0,0 +1,72 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestDefResolver(t *testing.T) {
+	mrs := newMemoryMultiRepoStore()
+	t0 := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	u := &unit.SourceUnit{Key: unit.Key{Type: "t", Name: "u"}, Info: unit.Info{Files: []string{"f"}}}
+	imports := []struct {
+		commitID string
+		meta     *VersionMeta
+		defPaths []string
+	}{
+		{"c1", &VersionMeta{ImportedAt: t0, Branch: "master"}, []string{"p", "q"}},
+		{"c2", &VersionMeta{ImportedAt: t0.Add(time.Hour), Branch: "dev"}, []string{"p"}},
+		{"c3", &VersionMeta{ImportedAt: t0.Add(2 * time.Hour), Branch: "master"}, []string{"p"}},
+	}
+	for _, imp := range imports {
+		var data graph.Output
+		for _, path := range imp.defPaths {
+			data.Defs = append(data.Defs, &graph.Def{DefKey: graph.DefKey{Path: path}})
+		}
+		if err := mrs.Import("r", imp.commitID, u, data); err != nil {
+			t.Fatal(err)
+		}
+		if err := mrs.CreateVersion("r", imp.commitID, imp.meta); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		policies     []VersionPolicy
+		key          graph.RefDefKey
+		wantCommitID string // empty if the key should not resolve
+	}{
+		{nil, graph.RefDefKey{DefRepo: "r", DefUnitType: "t", DefUnit: "u", DefPath: "p"}, "c3"},
+		{nil, graph.RefDefKey{DefRepo: "r", DefPath: "p"}, "c3"},
+		{nil, graph.RefDefKey{DefRepo: "r", DefUnitType: "t", DefUnit: "u", DefPath: "q"}, "c1"},
+		{nil, graph.RefDefKey{DefRepo: "r", DefUnitType: "t", DefUnit: "u", DefPath: "x"}, ""},
+		{nil, graph.RefDefKey{DefRepo: "r2", DefUnitType: "t", DefUnit: "u", DefPath: "p"}, ""},
+		{[]VersionPolicy{BranchHead("dev")}, graph.RefDefKey{DefRepo: "r", DefPath: "p"}, "c2"},
+		{[]VersionPolicy{BranchHead("dev")}, graph.RefDefKey{DefRepo: "r", DefPath: "q"}, ""},
+		{[]VersionPolicy{BranchHead("dev"), LatestImported()}, graph.RefDefKey{DefRepo: "r", DefPath: "q"}, "c1"},
+		{[]VersionPolicy{PinnedVersions(map[string]string{"r": "c2"})}, graph.RefDefKey{DefRepo: "r", DefPath: "p"}, "c2"},
+		{[]VersionPolicy{PinnedVersions(map[string]string{"r": "master"})}, graph.RefDefKey{DefRepo: "r", DefPath: "p"}, "c3"},
+		{[]VersionPolicy{PinnedVersions(map[string]string{"r2": "c2"})}, graph.RefDefKey{DefRepo: "r", DefPath: "p"}, ""},
+	}
+	for _, test := range tests {
+		r := NewDefResolver(mrs, test.policies...)
+		def, err := r.Resolve(test.key)
+		if test.wantCommitID == "" {
+			if !IsDefNotResolved(err) {
+				t.Errorf("%s: Resolve(%+v): got def %v and error %v, want not resolved", r, test.key, def, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Resolve(%+v): %s", r, test.key, err)
+			continue
+		}
+		want := graph.DefKey{Repo: "r", CommitID: test.wantCommitID, UnitType: "t", Unit: "u", Path: test.key.DefPath}
+		if def.DefKey != want {
+			t.Errorf("%s: Resolve(%+v): got def key %+v, want %+v", r, test.key, def.DefKey, want)
+		}
+	}
+}
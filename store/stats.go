@@ -0,0 +1,378 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// StatsCounts summarizes the contents of a store, repo, version, or
+// source unit.
+//
+// Docs are stored on defs, so NumDocs counts the docs of all
+// defs. Annotations are counted only in stores that implement
+// AnnStore.
+type StatsCounts struct {
+	NumUnits int
+	NumDefs  int
+	NumRefs  int
+	NumDocs  int
+	NumAnns  int
+
+	// NumBrokenRefs is the number of refs to defs in the same repo
+	// version that do not exist in that version. Refs to defs in
+	// other repos are not counted as broken.
+	NumBrokenRefs int
+
+	// BrokenRefRatio is NumBrokenRefs divided by NumRefs (or 0 if
+	// there are no refs).
+	BrokenRefRatio float64
+
+	// DataBytes is the size of the defs, refs, relations and
+	// annotations data files.
+	DataBytes int64
+
+	// IndexBytes is the total size of the index files.
+	IndexBytes int64
+}
+
+func (c *StatsCounts) add(c2 StatsCounts) {
+	c.NumUnits += c2.NumUnits
+	c.NumDefs += c2.NumDefs
+	c.NumRefs += c2.NumRefs
+	c.NumDocs += c2.NumDocs
+	c.NumAnns += c2.NumAnns
+	c.NumBrokenRefs += c2.NumBrokenRefs
+	c.DataBytes += c2.DataBytes
+	c.IndexBytes += c2.IndexBytes
+}
+
+func (c *StatsCounts) computeRatios() {
+	if c.NumRefs > 0 {
+		c.BrokenRefRatio = float64(c.NumBrokenRefs) / float64(c.NumRefs)
+	}
+}
+
+// StoreStats summarizes the contents of a store and its repos,
+// versions, and source units.
+type StoreStats struct {
+	StatsCounts
+
+	Repos []*RepoStats
+
+	// Indexes is the size in bytes of each index (by name) that
+	// pertains to the whole store, not to a single repo.
+	Indexes map[string]int64 `json:",omitempty"`
+
+	// LargestUnits lists the source units with the most bytes on disk
+	// (data and indexes), largest first.
+	LargestUnits []*UnitStats `json:",omitempty"`
+
+	// Blobs describes the store's blob store, if it has one (see
+	// FSMultiRepoStoreConf.DedupBlobs). The size of the blobs is not
+	// included in DataBytes.
+	Blobs *BlobStats `json:",omitempty"`
+}
+
+// RepoStats summarizes the contents of a repo in a store. For a
+// RepoStore, there is a single RepoStats whose Repo is empty.
+type RepoStats struct {
+	Repo string `json:",omitempty"`
+	StatsCounts
+
+	Versions []*VersionStats
+
+	// Indexes is the size in bytes of each index (by name) that
+	// pertains to the whole repo, not to a single version.
+	Indexes map[string]int64 `json:",omitempty"`
+}
+
+// VersionStats summarizes the contents of a repo version in a store.
+type VersionStats struct {
+	Repo     string `json:",omitempty"`
+	CommitID string
+	StatsCounts
+
+	Units []*UnitStats
+
+	// Indexes is the size in bytes of each index (by name) that
+	// pertains to the whole version, not to a single source unit.
+	Indexes map[string]int64 `json:",omitempty"`
+}
+
+// UnitStats summarizes the contents of a source unit in a store.
+type UnitStats struct {
+	Repo     string `json:",omitempty"`
+	CommitID string
+	Unit     unit.ID2
+	StatsCounts
+
+	// Indexes is the size in bytes of each of the source unit's
+	// indexes (by name).
+	Indexes map[string]int64 `json:",omitempty"`
+}
+
+// Stats walks store s (a MultiRepoStore or RepoStore, or a lower-level
+// store) and returns statistics about its contents. The numLargest
+// largest source units are listed in the LargestUnits field (none if
+// numLargest is not positive).
+//
+// Stats reads all of the defs and refs in the store, so it may take a
+// long time to run on large stores.
+func Stats(s interface{}, numLargest int) (*StoreStats, error) {
+	switch s.(type) {
+	case repoStoreOpener, treeStoreOpener, unitStoreOpener:
+	default:
+		return nil, fmt.Errorf("store (type %T) does not support computing stats", s)
+	}
+
+	var st StoreStats
+	repos := map[string]*RepoStats{}
+	var allUnits []*UnitStats
+	err := walkTreeStores(s, "", func(repo, commitID string, ts TreeStore) error {
+		vs, err := treeStoreStats(ts, repo, commitID)
+		if err != nil {
+			return err
+		}
+		rs, present := repos[repo]
+		if !present {
+			rs = &RepoStats{Repo: repo}
+			repos[repo] = rs
+			st.Repos = append(st.Repos, rs)
+		}
+		rs.Versions = append(rs.Versions, vs)
+		allUnits = append(allUnits, vs.Units...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Attribute the size of each index to the store, repo, version,
+	// or unit that it pertains to.
+	xs, err := Indexes(s, IndexCriteria{}, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, x := range xs {
+		if x.Size == 0 {
+			continue
+		}
+		var indexes *map[string]int64
+		var counts *StatsCounts
+		rs := repos[x.Repo]
+		switch {
+		case rs == nil:
+			indexes, counts = &st.Indexes, &st.StatsCounts
+		case x.CommitID == "":
+			indexes, counts = &rs.Indexes, &rs.StatsCounts
+		default:
+			vs := findVersionStats(rs, x.CommitID)
+			if vs == nil {
+				continue
+			}
+			indexes, counts = &vs.Indexes, &vs.StatsCounts
+			if x.Unit != nil {
+				if us := findUnitStats(vs, *x.Unit); us != nil {
+					indexes, counts = &us.Indexes, &us.StatsCounts
+				}
+			}
+		}
+		if *indexes == nil {
+			*indexes = map[string]int64{}
+		}
+		(*indexes)[x.Name] += x.Size
+		counts.IndexBytes += x.Size
+	}
+
+	// Roll up the counts.
+	for _, rs := range st.Repos {
+		for _, vs := range rs.Versions {
+			for _, us := range vs.Units {
+				vs.add(us.StatsCounts)
+				us.computeRatios()
+			}
+			rs.add(vs.StatsCounts)
+			vs.computeRatios()
+		}
+		st.add(rs.StatsCounts)
+		rs.computeRatios()
+	}
+	st.computeRatios()
+
+	if _, ok := s.(*fsMultiRepoStore); ok {
+		bs, err := GetBlobStats(s)
+		if err != nil {
+			return nil, err
+		}
+		if bs.NumBlobs > 0 || bs.NumRefs > 0 {
+			st.Blobs = bs
+		}
+	}
+
+	sort.Stable(unitStatsBySize(allUnits))
+	if numLargest < 0 {
+		numLargest = 0
+	}
+	if len(allUnits) > numLargest {
+		allUnits = allUnits[:numLargest]
+	}
+	st.LargestUnits = allUnits
+
+	return &st, nil
+}
+
+// walkTreeStores calls fn for each tree store (in order of repo and
+// commit ID) underneath s.
+func walkTreeStores(s interface{}, repo string, fn func(repo, commitID string, ts TreeStore) error) error {
+	switch s := s.(type) {
+	case repoStoreOpener:
+		rss, err := s.openAllRepoStores()
+		if err != nil && !isStoreNotExist(err) {
+			return err
+		}
+		for _, repo := range sortedRepos(rss) {
+			if err := walkTreeStores(rss[repo], repo, fn); err != nil {
+				return err
+			}
+		}
+	case treeStoreOpener:
+		tss, err := s.openAllTreeStores()
+		if err != nil && !isStoreNotExist(err) {
+			return err
+		}
+		for _, commitID := range sortedCommitIDs(tss) {
+			if err := fn(repo, commitID, tss[commitID]); err != nil {
+				return err
+			}
+		}
+	case TreeStore:
+		return fn(repo, "", s)
+	}
+	return nil
+}
+
+// treeStoreStats computes the stats for a single version (except for
+// the size of its indexes).
+func treeStoreStats(ts TreeStore, repo, commitID string) (*VersionStats, error) {
+	vs := &VersionStats{Repo: repo, CommitID: commitID}
+	uo, ok := ts.(unitStoreOpener)
+	if !ok {
+		return vs, nil
+	}
+	uss, err := uo.openAllUnitStores()
+	if err != nil && !isStoreNotExist(err) {
+		return nil, err
+	}
+
+	// Collect all defs before counting broken refs, because refs
+	// may point to defs in other units in the same version.
+	type defKey struct{ unitType, unit, path string }
+	defs := map[defKey]struct{}{}
+	unitRefs := map[unit.ID2][]*graph.Ref{}
+	for _, u := range sortedUnits(uss) {
+		us := &UnitStats{Repo: repo, CommitID: commitID, Unit: u}
+		us.NumUnits = 1
+		vs.Units = append(vs.Units, us)
+
+		unitDefs, err := uss[u].Defs()
+		if err != nil && !isStoreNotExist(err) {
+			return nil, err
+		}
+		for _, def := range unitDefs {
+			defs[defKey{u.Type, u.Name, def.Path}] = struct{}{}
+			us.NumDocs += len(def.Docs)
+		}
+		us.NumDefs = len(unitDefs)
+
+		refs, err := uss[u].Refs()
+		if err != nil && !isStoreNotExist(err) {
+			return nil, err
+		}
+		unitRefs[u] = refs
+		us.NumRefs = len(refs)
+
+		if as, ok := uss[u].(AnnStore); ok {
+			anns, err := as.Anns()
+			if err != nil && !isStoreNotExist(err) {
+				return nil, err
+			}
+			us.NumAnns = len(anns)
+		}
+
+		if ds, ok := uss[u].(interface {
+			dataSize() (int64, error)
+		}); ok {
+			us.DataBytes, err = ds.dataSize()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, us := range vs.Units {
+		for _, ref := range unitRefs[us.Unit] {
+			if ref.DefRepo != "" && ref.DefRepo != repo {
+				continue
+			}
+			k := defKey{ref.DefUnitType, ref.DefUnit, ref.DefPath}
+			if k.unitType == "" {
+				k.unitType = us.Unit.Type
+			}
+			if k.unit == "" {
+				k.unit = us.Unit.Name
+			}
+			if _, present := defs[k]; !present {
+				us.NumBrokenRefs++
+			}
+		}
+	}
+	return vs, nil
+}
+
+// dataSize returns the total size of the unit's defs, refs, relations
+// and annotations data files.
+func (s *fsUnitStore) dataSize() (int64, error) {
+	var size int64
+	for _, name := range []string{unitDefsFilename, unitRefsFilename, unitRelationsFilename, unitAnnsFilename} {
+		fi, err := s.fs.Stat(name)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		size += fi.Size()
+	}
+	return size, nil
+}
+
+func findVersionStats(rs *RepoStats, commitID string) *VersionStats {
+	for _, vs := range rs.Versions {
+		if vs.CommitID == commitID {
+			return vs
+		}
+	}
+	return nil
+}
+
+func findUnitStats(vs *VersionStats, u unit.ID2) *UnitStats {
+	for _, us := range vs.Units {
+		if us.Unit == u {
+			return us
+		}
+	}
+	return nil
+}
+
+// unitStatsBySize sorts units by their total size on disk, largest
+// first.
+type unitStatsBySize []*UnitStats
+
+func (v unitStatsBySize) Len() int      { return len(v) }
+func (v unitStatsBySize) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v unitStatsBySize) Less(i, j int) bool {
+	return v[i].DataBytes+v[i].IndexBytes > v[j].DataBytes+v[j].IndexBytes
+}
@@ -0,0 +1,70 @@
+package store
+
+import (
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/ann"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+func TestStats(t *testing.T) {
+	for _, mrs := range []MultiRepoStoreImporter{newMemoryMultiRepoStore(), NewFSMultiRepoStore(newTestFS(), nil)} {
+		u := &unit.SourceUnit{Key: unit.Key{Type: "t", Name: "u"}, Info: unit.Info{Files: []string{"f"}}}
+		data := graph.Output{
+			Defs: []*graph.Def{
+				{DefKey: graph.DefKey{Path: "p1"}, Docs: []*graph.DefDoc{{Format: "text/plain", Data: "d"}}},
+				{DefKey: graph.DefKey{Path: "p2"}},
+			},
+			Refs: []*graph.Ref{
+				{DefPath: "p1", File: "f", Start: 1, End: 2},
+				{DefPath: "x", File: "f", Start: 2, End: 3},
+				{DefRepo: "r2", DefUnitType: "t", DefUnit: "u", DefPath: "x", File: "f", Start: 3, End: 4},
+				{DefRepo: "r", DefUnitType: "t", DefUnit: "u2", DefPath: "x", File: "f", Start: 4, End: 5},
+			},
+			Anns: []*ann.Ann{{File: "f", StartLine: 1, EndLine: 2, Type: "t"}},
+		}
+		if err := mrs.Import("r", "c", u, data); err != nil {
+			t.Fatal(err)
+		}
+		if mrs, ok := mrs.(MultiRepoIndexer); ok {
+			if err := mrs.Index("r", "c"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := mrs.CreateVersion("r", "c", nil); err != nil {
+			t.Fatal(err)
+		}
+
+		st, err := Stats(mrs, 10)
+		if err != nil {
+			t.Fatalf("%s: Stats: %s", mrs, err)
+		}
+		want := StatsCounts{NumUnits: 1, NumDefs: 2, NumRefs: 4, NumDocs: 1, NumAnns: 1, NumBrokenRefs: 2, BrokenRefRatio: 0.5}
+		got := st.StatsCounts
+		got.DataBytes, got.IndexBytes = 0, 0
+		if got != want {
+			t.Errorf("%s: got counts %+v, want %+v", mrs, got, want)
+		}
+		if len(st.Repos) != 1 || len(st.Repos[0].Versions) != 1 || len(st.Repos[0].Versions[0].Units) != 1 {
+			t.Fatalf("%s: got stats %+v, want 1 repo with 1 version with 1 unit", mrs, st)
+		}
+		if us := st.Repos[0].Versions[0].Units[0]; us.Repo != "r" || us.CommitID != "c" || us.Unit != (unit.ID2{Type: "t", Name: "u"}) {
+			t.Errorf("%s: got unit %s@%s %+v, want r@c t/u", mrs, us.Repo, us.CommitID, us.Unit)
+		}
+		if len(st.LargestUnits) != 1 {
+			t.Errorf("%s: got %d largest units, want 1", mrs, len(st.LargestUnits))
+		}
+		if _, isFS := mrs.(*fsMultiRepoStore); isFS && st.DataBytes == 0 {
+			t.Errorf("%s: got DataBytes == 0, want > 0", mrs)
+		}
+
+		st, err = Stats(mrs, -1)
+		if err != nil {
+			t.Fatalf("%s: Stats with numLargest -1: %s", mrs, err)
+		}
+		if len(st.LargestUnits) != 0 {
+			t.Errorf("%s: Stats with numLargest -1: got %d largest units, want 0", mrs, len(st.LargestUnits))
+		}
+	}
+}
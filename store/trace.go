@@ -0,0 +1,224 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"sourcegraph.com/sourcegraph/srclib/graph"
+)
+
+// A Trace collects spans that describe how a store executed a query:
+// which stores were opened, which index (if any) was chosen, and how
+// much data was read. To trace a query, pass TraceTo(t) as one of its
+// filters.
+//
+// Tracing is intended for debugging slow queries (see "srclib store
+// defs --explain"); the spans are not a stable format.
+type Trace struct {
+	// OnSpan, if set, is called with each span when it ends. It may be
+	// called concurrently.
+	OnSpan func(*Span)
+
+	mu    sync.Mutex
+	spans []*Span
+}
+
+// A Span describes a single store method call made during a traced
+// query.
+type Span struct {
+	// Store is the store that handled the call (its String() value).
+	Store string
+
+	// Op is the name of the method called ("Defs", "Refs", etc.).
+	Op string
+
+	// Opened lists the child stores (repos, commit IDs or source
+	// units) that a composite store opened and queried.
+	Opened []string `json:",omitempty"`
+
+	// Index is the name of the index that was used, and Coverage is
+	// its Covers score for the query's filters.
+	Index    string `json:",omitempty"`
+	Coverage int    `json:",omitempty"`
+
+	// FullScan is whether the store read all of its data (because no
+	// index covered the query).
+	FullScan bool `json:",omitempty"`
+
+	// Results is the number of results returned.
+	Results int
+
+	// DataFile is the data file (in a source unit's store) that was
+	// read, if any, and BytesRead is the number of bytes of defs or
+	// refs decoded from it.
+	DataFile  string `json:",omitempty"`
+	BytesRead int64  `json:",omitempty"`
+
+	Start    time.Time
+	Duration time.Duration
+
+	// Err is the error returned by the call, if any.
+	Err string `json:",omitempty"`
+
+	t  *Trace
+	mu sync.Mutex
+}
+
+func (s *Span) String() string {
+	str := fmt.Sprintf("%s.%s: %d results in %s", s.Store, s.Op, s.Results, s.Duration)
+	if len(s.Opened) > 0 {
+		str += fmt.Sprintf(", opened %d %v", len(s.Opened), s.Opened)
+	}
+	if s.Index != "" {
+		str += fmt.Sprintf(", index %q (coverage %d)", s.Index, s.Coverage)
+	}
+	if s.FullScan {
+		str += ", full scan"
+	}
+	if s.DataFile != "" {
+		str += fmt.Sprintf(", read %d bytes from %s", s.BytesRead, s.DataFile)
+	}
+	if s.Err != "" {
+		str += ", error: " + s.Err
+	}
+	return str
+}
+
+// Spans returns the spans that have ended, in the order they started.
+func (t *Trace) Spans() []*Span {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	spans := make([]*Span, len(t.spans))
+	copy(spans, t.spans)
+	sort.Stable(spansByStart(spans))
+	return spans
+}
+
+// DataRead returns the number of source unit data files read (and
+// the total number of bytes decoded from them) by the spans that have
+// ended.
+func (t *Trace) DataRead() (files int, bytes int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, s := range t.spans {
+		if s.DataFile != "" {
+			files++
+			bytes += s.BytesRead
+		}
+	}
+	return files, bytes
+}
+
+// start begins a span for a call to the op method on store. It is
+// safe to call on a nil *Trace (in which case a nil *Span, whose
+// methods are no-ops, is returned), so that callers need not check
+// whether the query is being traced.
+func (t *Trace) start(store interface{}, op string) *Span {
+	if t == nil {
+		return nil
+	}
+	return &Span{Store: fmt.Sprint(store), Op: op, Start: time.Now(), t: t}
+}
+
+// opened records that the composite store opened the named child
+// store.
+func (s *Span) opened(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.Opened = append(s.Opened, name)
+	s.mu.Unlock()
+}
+
+// usedIndex records that the named index was used to satisfy the
+// query with the given filters.
+func (s *Span) usedIndex(name string, x Index, filters interface{}) {
+	if s == nil {
+		return
+	}
+	s.Index = name
+	s.Coverage = x.Covers(filters)
+}
+
+// fullScan records that the store fell back to a full scan.
+func (s *Span) fullScan() {
+	if s == nil {
+		return
+	}
+	s.FullScan = true
+}
+
+// readsFile records that the store reads the named data file.
+func (s *Span) readsFile(name string) {
+	if s == nil {
+		return
+	}
+	s.DataFile = name
+}
+
+// read records that n bytes of data were decoded.
+func (s *Span) read(n uint64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.BytesRead += int64(n)
+	s.mu.Unlock()
+}
+
+// end ends the span and adds it to its trace.
+func (s *Span) end(results int, err error) {
+	if s == nil {
+		return
+	}
+	s.Duration = time.Since(s.Start)
+	s.Results = results
+	if err != nil {
+		s.Err = err.Error()
+	}
+	s.t.mu.Lock()
+	s.t.spans = append(s.t.spans, s)
+	s.t.mu.Unlock()
+	if s.t.OnSpan != nil {
+		s.t.OnSpan(s)
+	}
+}
+
+// TraceTo returns a filter that matches all defs and refs and causes
+// the stores that execute the query to record spans in t.
+//
+// It is not a UnitFilter, because some stores use the presence of
+// UnitFilters to decide how to execute a query, and tracing must not
+// change the query plan.
+func TraceTo(t *Trace) interface {
+	DefFilter
+	RefFilter
+} {
+	return traceFilter{t}
+}
+
+type traceFilter struct{ t *Trace }
+
+func (f traceFilter) String() string            { return "Trace" }
+func (f traceFilter) SelectDef(*graph.Def) bool { return true }
+func (f traceFilter) SelectRef(*graph.Ref) bool { return true }
+
+// traceOf returns the trace that the filters (specified with
+// TraceTo) record spans in, or nil if the query is not being traced.
+func traceOf(filters interface{}) *Trace {
+	for _, f := range storeFilters(filters) {
+		if f, ok := f.(traceFilter); ok {
+			return f.t
+		}
+	}
+	return nil
+}
+
+type spansByStart []*Span
+
+func (v spansByStart) Len() int           { return len(v) }
+func (v spansByStart) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v spansByStart) Less(i, j int) bool { return v[i].Start.Before(v[j].Start) }
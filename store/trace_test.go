@@ -0,0 +1,72 @@
+package store
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	useIndexedStore = true
+	mrs := NewFSMultiRepoStore(newTestFS(), nil)
+	importPagingTestData(t, mrs)
+
+	tests := []struct {
+		filters       []DefFilter
+		wantIndex     string // empty for a full scan
+		wantDataFiles int
+	}{
+		{[]DefFilter{ByRepos("r1"), ByCommitIDs("c1"), ByDefPath("p2")}, "path_to_def", 2},
+		{[]DefFilter{ByRepos("r1"), ByCommitIDs("c1")}, "", 2},
+	}
+	for _, test := range tests {
+		want, err := mrs.Defs(test.filters...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var tr Trace
+		var onSpanCalls int64
+		tr.OnSpan = func(*Span) { atomic.AddInt64(&onSpanCalls, 1) }
+		defs, err := mrs.Defs(append(test.filters, TraceTo(&tr))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !deepEqual(defs, want) {
+			t.Errorf("%v: got traced defs %v, want %v", test.filters, defs, want)
+		}
+
+		spans := tr.Spans()
+		if len(spans) == 0 {
+			t.Errorf("%v: got no spans", test.filters)
+			continue
+		}
+		if onSpanCalls := atomic.LoadInt64(&onSpanCalls); onSpanCalls != int64(len(spans)) {
+			t.Errorf("%v: OnSpan called %d times, want %d", test.filters, onSpanCalls, len(spans))
+		}
+		if top := spans[0]; len(top.Opened) != 1 || top.Opened[0] != "r1" || top.Results != len(want) {
+			t.Errorf("%v: got first span %s, want it to open repo r1 and return %d results", test.filters, top, len(want))
+		}
+
+		var unitSpans int
+		for _, s := range spans {
+			if !strings.HasPrefix(s.Store, "indexedUnitStore") {
+				continue
+			}
+			unitSpans++
+			if s.Index != test.wantIndex || s.FullScan != (test.wantIndex == "") {
+				t.Errorf("%v: got unit store span %s, want index %q", test.filters, s, test.wantIndex)
+			}
+			if s.Index != "" && s.Coverage == 0 {
+				t.Errorf("%v: got unit store span %s, want nonzero coverage", test.filters, s)
+			}
+		}
+		if unitSpans != 2 {
+			t.Errorf("%v: got %d unit store spans, want 2", test.filters, unitSpans)
+		}
+
+		if files, bytes := tr.DataRead(); files != test.wantDataFiles || bytes == 0 {
+			t.Errorf("%v: got %d data files read (%d bytes), want %d (and nonzero bytes)", test.filters, files, bytes, test.wantDataFiles)
+		}
+	}
+}
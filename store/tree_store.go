@@ -1,6 +1,8 @@
 package store
 
 import (
+	"sort"
+
 	"sourcegraph.com/sourcegraph/srclib/graph"
 	"sourcegraph.com/sourcegraph/srclib/unit"
 )
@@ -13,7 +15,8 @@ type TreeStore interface {
 
 	// UnitStore's methods call the corresponding methods on the
 	// UnitStore of each source unit contained within this tree. The
-	// combined results are returned (in undefined order).
+	// combined results are returned in the order described in
+	// paging.go.
 	UnitStore
 }
 
@@ -57,15 +60,12 @@ func (s treeStores) Units(f ...UnitFilter) ([]*unit.SourceUnit, error) {
 	}
 
 	var allUnits []*unit.SourceUnit
-	for commitID, ts := range tss {
-		if ts == nil {
-			continue
-		}
-
-		units, err := ts.Units(f...)
+	for _, commitID := range sortedCommitIDs(tss) {
+		units, err := tss[commitID].Units(filtersForTree(commitID, f).([]UnitFilter)...)
 		if err != nil && !isStoreNotExist(err) {
 			return nil, err
 		}
+		sort.Sort(unitsInStoreOrder(units))
 		for _, unit := range units {
 			unit.CommitID = commitID
 		}
@@ -74,19 +74,23 @@ func (s treeStores) Units(f ...UnitFilter) ([]*unit.SourceUnit, error) {
 	return allUnits, nil
 }
 
-func (s treeStores) Defs(f ...DefFilter) ([]*graph.Def, error) {
+func (s treeStores) Defs(f ...DefFilter) (defs []*graph.Def, err error) {
+	sp := traceOf(f).start(s.opener, "Defs")
+	defer func() { sp.end(len(defs), err) }()
+
 	tss, err := openTreeStores(s.opener, f)
 	if err != nil {
 		return nil, err
 	}
 
 	var allDefs []*graph.Def
-	for commitID, ts := range tss {
-		if ts == nil {
-			continue
+	for _, commitID := range sortedCommitIDs(tss) {
+		if pageFull(f, len(allDefs)) {
+			break
 		}
 
-		defs, err := ts.Defs(f...)
+		sp.opened(commitID)
+		defs, err := tss[commitID].Defs(filtersForTree(commitID, f).([]DefFilter)...)
 		if err != nil && !isStoreNotExist(err) {
 			return nil, err
 		}
@@ -95,23 +99,27 @@ func (s treeStores) Defs(f ...DefFilter) ([]*graph.Def, error) {
 		}
 		allDefs = append(allDefs, defs...)
 	}
-	return allDefs, nil
+	return pageDefs(allDefs, f), nil
 }
 
-func (s treeStores) Refs(f ...RefFilter) ([]*graph.Ref, error) {
+func (s treeStores) Refs(f ...RefFilter) (refs []*graph.Ref, err error) {
+	sp := traceOf(f).start(s.opener, "Refs")
+	defer func() { sp.end(len(refs), err) }()
+
 	tss, err := openTreeStores(s.opener, f)
 	if err != nil {
 		return nil, err
 	}
 
 	var allRefs []*graph.Ref
-	for commitID, ts := range tss {
-		if ts == nil {
-			continue
+	for _, commitID := range sortedCommitIDs(tss) {
+		if pageFull(f, len(allRefs)) {
+			break
 		}
 
+		sp.opened(commitID)
 		setImpliedCommitID(f, commitID)
-		refs, err := ts.Refs(f...)
+		refs, err := tss[commitID].Refs(filtersForTree(commitID, f).([]RefFilter)...)
 		if err != nil && !isStoreNotExist(err) {
 			return nil, err
 		}
@@ -120,5 +128,5 @@ func (s treeStores) Refs(f ...RefFilter) ([]*graph.Ref, error) {
 		}
 		allRefs = append(allRefs, refs...)
 	}
-	return allRefs, nil
+	return pageRefs(allRefs, f), nil
 }
@@ -1,5 +1,7 @@
 package store
 
+import "reflect"
+
 // scopeTrees returns a list of commit IDs that are matched by the
 // filters. If potentially all commits could match, or if enough
 // commits could potentially match that it would probably be cheaper
@@ -61,13 +63,57 @@ func openTreeStores(o treeStoreOpener, filters interface{}) (map[string]TreeStor
 		return nil, err
 	}
 
+	af := getAfterFilter(filters)
+
 	if commitIDs == nil {
-		return o.openAllTreeStores()
+		tss, err := o.openAllTreeStores()
+		if err != nil || af == nil {
+			return tss, err
+		}
+
+		// Skip trees that sort entirely before the After filter's
+		// cursor. Copy the map because the opener might return its
+		// own.
+		tss2 := make(map[string]TreeStore, len(tss))
+		for commitID, ts := range tss {
+			if af.treeScope(commitID) >= 0 {
+				tss2[commitID] = ts
+			}
+		}
+		return tss2, nil
 	}
 
 	tss := make(map[string]TreeStore, len(commitIDs))
 	for _, commitID := range commitIDs {
+		if af != nil && af.treeScope(commitID) < 0 {
+			continue
+		}
 		tss[commitID] = o.openTreeStore(commitID)
 	}
 	return tss, nil
 }
+
+// filtersForTree modifies the filters list to remove filters or
+// conditions inside filters that are guaranteed to be true or
+// unnecessary when using the filters on a call to a specific tree
+// store.
+func filtersForTree(commitID string, filters interface{}) interface{} {
+	// Copy filters so that it can be used concurrently.
+	sf := storeFilters(filters)
+	treeFilters := make([]interface{}, 0, len(sf))
+
+	_, _, paged := pageBounds(sf)
+	for _, f := range sf {
+		switch f.(type) {
+		case *afterFilter, *limiter:
+			f2, keep := forChildStore(f, paged, func(af *afterFilter) *afterFilter { return af.forTree(commitID) })
+			if !keep {
+				continue
+			}
+			f = f2
+		}
+		treeFilters = append(treeFilters, f)
+	}
+
+	return toTypedFilterSlice(reflect.TypeOf(filters), treeFilters)
+}
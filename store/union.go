@@ -0,0 +1,318 @@
+package store
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/neelance/parallel"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// A unionMultiRepoStore is a MultiRepoStore that merges the results
+// of several underlying MultiRepoStores.
+//
+// If more than one underlying store contains the same version (repo
+// and commit ID), only the data from the store with the highest
+// precedence (the earliest in the list of stores) is used.
+type unionMultiRepoStore struct {
+	stores []MultiRepoStore
+}
+
+// NewUnionMultiRepoStore creates a new MultiRepoStore that merges the
+// results of the given stores. Stores earlier in the list take
+// precedence over later stores when more than one store contains the
+// same repo and commit ID.
+func NewUnionMultiRepoStore(stores ...MultiRepoStore) MultiRepoStore {
+	return &unionMultiRepoStore{stores: stores}
+}
+
+var _ MultiRepoStore = (*unionMultiRepoStore)(nil)
+
+func (s *unionMultiRepoStore) String() string {
+	strs := make([]string, len(s.stores))
+	for i, ss := range s.stores {
+		strs[i] = fmt.Sprint(ss)
+	}
+	return fmt.Sprintf("unionMultiRepoStore(%s)", strings.Join(strs, ", "))
+}
+
+func (s *unionMultiRepoStore) Repos(f ...RepoFilter) ([]string, error) {
+	seen := map[string]struct{}{}
+	allRepos := []string{}
+	var notExist notExistErrors
+	for _, ss := range s.stores {
+		repos, err := ss.Repos(f...)
+		if err != nil {
+			if !isStoreNotExist(err) {
+				return nil, err
+			}
+			notExist = append(notExist, err)
+		}
+		for _, repo := range repos {
+			if _, seen := seen[repo]; !seen {
+				allRepos = append(allRepos, repo)
+			}
+			seen[repo] = struct{}{}
+		}
+	}
+	if err := notExist.all(s.stores); err != nil {
+		return nil, err
+	}
+	sort.Strings(allRepos)
+	return allRepos, nil
+}
+
+// notExistErrors holds the store-not-exist errors returned by the
+// underlying stores. They are ignored (an uninitialized store has no
+// data to merge) unless every underlying store returned one.
+type notExistErrors []error
+
+// all returns the first error if every store returned a
+// store-not-exist error, and nil otherwise.
+func (errs notExistErrors) all(stores []MultiRepoStore) error {
+	if len(errs) > 0 && len(errs) == len(stores) {
+		return errs[0]
+	}
+	return nil
+}
+
+// owners returns, for each underlying store (by index), the versions
+// matched by the filters that should be read from that store. Each
+// version is owned by the first store that contains it.
+func (s *unionMultiRepoStore) owners(filters interface{}) ([][]Version, error) {
+	var vfs []VersionFilter
+	for _, f := range storeFilters(filters) {
+		switch f.(type) {
+		case *limiter, *afterFilter, pageLimitFilter:
+			// These pertain to the defs or refs being listed, not to
+			// the versions that contain them.
+			continue
+		case VersionsSelector:
+			// Applied to the merged versions (see Versions), so that
+			// each store returns all of its matching versions.
+			continue
+		}
+		if vf, ok := f.(VersionFilter); ok {
+			vfs = append(vfs, vf)
+		}
+	}
+
+	owned := make([][]Version, len(s.stores))
+	seen := map[VersionKey]struct{}{}
+	var notExist notExistErrors
+	for i, ss := range s.stores {
+		versions, err := ss.Versions(vfs...)
+		if err != nil {
+			if !isStoreNotExist(err) {
+				return nil, err
+			}
+			notExist = append(notExist, err)
+		}
+		for _, version := range versions {
+			k := VersionKey{Repo: version.Repo, CommitID: version.CommitID}
+			if _, seen := seen[k]; seen {
+				continue
+			}
+			seen[k] = struct{}{}
+			owned[i] = append(owned[i], *version)
+		}
+	}
+	if err := notExist.all(s.stores); err != nil {
+		return nil, err
+	}
+	return owned, nil
+}
+
+// filtersForUnionMember modifies the filters list for a call to an
+// underlying store that owns the given versions. Because results are
+// merged from several stores, a Limit filter's offset is applied only
+// after merging; each underlying store must return its first
+// offset+limit results (in sort order).
+func filtersForUnionMember(filters interface{}, versions []Version) interface{} {
+	sf := storeFilters(filters)
+	memberFilters := make([]interface{}, 0, len(sf)+1)
+	for _, f := range sf {
+		if l, ok := f.(*limiter); ok {
+			f = pageLimitFilter(l.ofs + l.n)
+		}
+		memberFilters = append(memberFilters, f)
+	}
+	memberFilters = append(memberFilters, ByRepoCommitIDs(versions...))
+	return toTypedFilterSlice(reflect.TypeOf(filters), memberFilters)
+}
+
+// unionRange returns the range [lo, hi) of the n merged results that
+// should be returned.
+func unionRange(n int, filters interface{}) (lo, hi int) {
+	for _, f := range storeFilters(filters) {
+		if l, ok := f.(*limiter); ok {
+			return min(l.ofs, n), min(l.ofs+l.n, n)
+		}
+	}
+	return 0, n
+}
+
+func (s *unionMultiRepoStore) Versions(f ...VersionFilter) ([]*Version, error) {
+	owned, err := s.owners(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var allVersions []*Version
+	for _, versions := range owned {
+		for i := range versions {
+			allVersions = append(allVersions, &versions[i])
+		}
+	}
+	sort.Sort(versionsInStoreOrder(allVersions))
+	return selectVersions(allVersions, f), nil
+}
+
+func (s *unionMultiRepoStore) Units(f ...UnitFilter) ([]*unit.SourceUnit, error) {
+	owned, err := s.owners(f)
+	if err != nil {
+		return nil, err
+	}
+
+	storeUnits := make([][]*unit.SourceUnit, len(s.stores))
+	par := parallel.NewRun(storeFetchPar)
+	for i_, ss_ := range s.stores {
+		i, ss := i_, ss_
+		if len(owned[i]) == 0 {
+			continue
+		}
+		par.Acquire()
+		go func() {
+			defer par.Release()
+			units, err := ss.Units(filtersForUnionMember(f, owned[i]).([]UnitFilter)...)
+			if err != nil && !isStoreNotExist(err) {
+				par.Error(err)
+				return
+			}
+			storeUnits[i] = units
+		}()
+	}
+	if err := par.Wait(); err != nil {
+		return nil, err
+	}
+
+	var allUnits []*unit.SourceUnit
+	for _, units := range storeUnits {
+		allUnits = append(allUnits, units...)
+	}
+	sort.Sort(unitsInStoreOrder(allUnits))
+	return allUnits, nil
+}
+
+func (s *unionMultiRepoStore) Defs(f ...DefFilter) ([]*graph.Def, error) {
+	owned, err := s.owners(f)
+	if err != nil {
+		return nil, err
+	}
+
+	storeDefs := make([][]*graph.Def, len(s.stores))
+	par := parallel.NewRun(storeFetchPar)
+	for i_, ss_ := range s.stores {
+		i, ss := i_, ss_
+		if len(owned[i]) == 0 {
+			continue
+		}
+		par.Acquire()
+		go func() {
+			defer par.Release()
+			defs, err := ss.Defs(filtersForUnionMember(f, owned[i]).([]DefFilter)...)
+			if err != nil && !isStoreNotExist(err) {
+				par.Error(err)
+				return
+			}
+			storeDefs[i] = defs
+		}()
+	}
+	if err := par.Wait(); err != nil {
+		return nil, err
+	}
+
+	var allDefs []*graph.Def
+	for _, defs := range storeDefs {
+		allDefs = append(allDefs, defs...)
+	}
+	sorted := false
+	for _, ff := range f {
+		if sorter, ok := ff.(DefsSorter); ok {
+			sorter.DefsSort(allDefs)
+			sorted = true
+		}
+	}
+	if !sorted {
+		sort.Sort(defsInStoreOrder(allDefs))
+	}
+	lo, hi := unionRange(len(allDefs), f)
+	return allDefs[lo:hi], nil
+}
+
+func (s *unionMultiRepoStore) Refs(f ...RefFilter) ([]*graph.Ref, error) {
+	owned, err := s.owners(f)
+	if err != nil {
+		return nil, err
+	}
+
+	storeRefs := make([][]*graph.Ref, len(s.stores))
+	par := parallel.NewRun(storeFetchPar)
+	for i_, ss_ := range s.stores {
+		i, ss := i_, ss_
+		if len(owned[i]) == 0 {
+			continue
+		}
+		par.Acquire()
+		go func() {
+			defer par.Release()
+			refs, err := ss.Refs(filtersForUnionMember(f, owned[i]).([]RefFilter)...)
+			if err != nil && !isStoreNotExist(err) {
+				par.Error(err)
+				return
+			}
+			storeRefs[i] = refs
+		}()
+	}
+	if err := par.Wait(); err != nil {
+		return nil, err
+	}
+
+	var allRefs []*graph.Ref
+	for _, refs := range storeRefs {
+		allRefs = append(allRefs, refs...)
+	}
+	sort.Sort(absRefsInStoreOrder(allRefs))
+	lo, hi := unionRange(len(allRefs), f)
+	return allRefs[lo:hi], nil
+}
+
+type versionsInStoreOrder []*Version
+
+func (v versionsInStoreOrder) Len() int      { return len(v) }
+func (v versionsInStoreOrder) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v versionsInStoreOrder) Less(i, j int) bool {
+	return compareStrings(v[i].Repo, v[j].Repo, v[i].CommitID, v[j].CommitID) < 0
+}
+
+// absRefsInStoreOrder sorts refs returned by a MultiRepoStore, whose
+// DefRepo fields are always set, in the order described in
+// paging.go (in which refs to defs in the same repo have an empty
+// DefRepo).
+type absRefsInStoreOrder []*graph.Ref
+
+func (v absRefsInStoreOrder) Len() int      { return len(v) }
+func (v absRefsInStoreOrder) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v absRefsInStoreOrder) Less(i, j int) bool {
+	a, b := *v[i], *v[j]
+	if a.DefRepo == a.Repo {
+		a.DefRepo = ""
+	}
+	if b.DefRepo == b.Repo {
+		b.DefRepo = ""
+	}
+	return compareRefs(&a, &b) < 0
+}
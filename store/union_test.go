@@ -0,0 +1,106 @@
+package store
+
+import (
+	"testing"
+
+	"sourcegraph.com/sourcegraph/srclib/ann"
+	"sourcegraph.com/sourcegraph/srclib/graph"
+	"sourcegraph.com/sourcegraph/srclib/unit"
+)
+
+// unionTestStore is a union of 2 memory stores that imports each repo
+// into one of them, so that the generic MultiRepoStore tests exercise
+// merging results from several stores.
+type unionTestStore struct {
+	MultiRepoStore
+	stores [2]*memoryMultiRepoStore
+}
+
+func newUnionTestStore() *unionTestStore {
+	var s unionTestStore
+	for i := range s.stores {
+		s.stores[i] = newMemoryMultiRepoStore()
+	}
+	s.MultiRepoStore = NewUnionMultiRepoStore(s.stores[0], s.stores[1])
+	return &s
+}
+
+func (s *unionTestStore) storeFor(repo string) *memoryMultiRepoStore {
+	var sum int
+	for _, c := range []byte(repo) {
+		sum += int(c)
+	}
+	return s.stores[sum%len(s.stores)]
+}
+
+func (s *unionTestStore) Import(repo, commitID string, unit *unit.SourceUnit, data graph.Output) error {
+	return s.storeFor(repo).Import(repo, commitID, unit, data)
+}
+
+func (s *unionTestStore) CreateVersion(repo, commitID string, meta *VersionMeta) error {
+	return s.storeFor(repo).CreateVersion(repo, commitID, meta)
+}
+
+// Relations implements RelationStore (which isn't part of the
+// MultiRepoStore interface, so it isn't promoted).
+func (s *unionTestStore) Relations(f ...RelationFilter) ([]*graph.Relation, error) {
+	return s.MultiRepoStore.(RelationStore).Relations(f...)
+}
+
+// Anns implements AnnStore (which isn't part of the MultiRepoStore
+// interface, so it isn't promoted).
+func (s *unionTestStore) Anns(f ...AnnFilter) ([]*ann.Ann, error) {
+	return s.MultiRepoStore.(AnnStore).Anns(f...)
+}
+
+func TestUnionMultiRepoStore(t *testing.T) {
+	testMultiRepoStore(t, func() MultiRepoStoreImporter {
+		return newUnionTestStore()
+	})
+}
+
+func TestUnionMultiRepoStore_precedence(t *testing.T) {
+	mrs1, mrs2 := newMemoryMultiRepoStore(), newMemoryMultiRepoStore()
+	u := &unit.SourceUnit{Key: unit.Key{Type: "t", Name: "u"}, Info: unit.Info{Files: []string{"f"}}}
+	imports := []struct {
+		mrs      *memoryMultiRepoStore
+		commitID string
+		defPath  string
+	}{
+		{mrs1, "c1", "p1"},
+		{mrs2, "c1", "p2"}, // same version as in mrs1, so it's hidden
+		{mrs2, "c2", "p3"},
+	}
+	for _, imp := range imports {
+		data := graph.Output{Defs: []*graph.Def{{DefKey: graph.DefKey{Path: imp.defPath}}}}
+		if err := imp.mrs.Import("r", imp.commitID, u, data); err != nil {
+			t.Fatal(err)
+		}
+		if err := imp.mrs.CreateVersion("r", imp.commitID, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mrs := NewUnionMultiRepoStore(mrs1, mrs2)
+
+	versions, err := mrs.Versions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantVersions := []*Version{{Repo: "r", CommitID: "c1"}, {Repo: "r", CommitID: "c2"}}
+	if !deepEqual(versions, wantVersions) {
+		t.Errorf("%s: Versions(): got %v, want %v", mrs, versions, wantVersions)
+	}
+
+	defs, err := mrs.Defs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantDefs := []*graph.Def{
+		{DefKey: graph.DefKey{Repo: "r", CommitID: "c1", UnitType: "t", Unit: "u", Path: "p1"}},
+		{DefKey: graph.DefKey{Repo: "r", CommitID: "c2", UnitType: "t", Unit: "u", Path: "p3"}},
+	}
+	if !deepEqual(defs, wantDefs) {
+		t.Errorf("%s: Defs(): got defs %v, want %v", mrs, defs, wantDefs)
+	}
+}
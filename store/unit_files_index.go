@@ -21,7 +21,7 @@ type unitFilesIndex struct {
 
 var _ interface {
 	Index
-	persistedIndex
+	mmapIndex
 	unitIndexBuilder
 	unitIndex
 } = (*unitFilesIndex)(nil)
@@ -153,6 +153,14 @@ func (x *unitFilesIndex) Write(w io.Writer) error {
 	return x.phtable.Write(w)
 }
 
+// WriteMappable implements mmapIndex.
+func (x *unitFilesIndex) WriteMappable(w io.Writer) error {
+	if x.phtable == nil {
+		panic("no phtable to write")
+	}
+	return x.phtable.WriteMappable(w)
+}
+
 // Read implements persistedIndex.
 func (x *unitFilesIndex) Read(r io.Reader) error {
 	var err error
@@ -161,5 +169,13 @@ func (x *unitFilesIndex) Read(r io.Reader) error {
 	return err
 }
 
+// Mmap implements mmapIndex.
+func (x *unitFilesIndex) Mmap(b []byte, release func()) error {
+	var err error
+	x.phtable, err = phtable.Mapped(b, false, release)
+	x.ready = (err == nil)
+	return err
+}
+
 // Ready implements persistedIndex.
 func (x *unitFilesIndex) Ready() bool { return x.ready }
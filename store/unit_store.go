@@ -1,6 +1,7 @@
 package store
 
 import (
+	"sort"
 	"sync"
 
 	"github.com/neelance/parallel"
@@ -44,26 +45,37 @@ type unitStores struct {
 
 var _ UnitStore = (*unitStores)(nil)
 
-func (s unitStores) Defs(fs ...DefFilter) ([]*graph.Def, error) {
+func (s unitStores) Defs(fs ...DefFilter) (defs []*graph.Def, err error) {
+	sp := traceOf(fs).start(s.opener, "Defs")
+	defer func() { sp.end(len(defs), err) }()
+
 	uss, err := openUnitStores(s.opener, fs)
 	if err != nil {
 		return nil, err
 	}
 
 	var (
-		allDefs   []*graph.Def
-		allDefsMu sync.Mutex
+		units    = sortedUnits(uss)
+		unitDefs = make([][]*graph.Def, len(units))
+		numDefs  int
+		mu       sync.Mutex
 	)
-	par := parallel.NewRun(storeFetchPar)
-	for u_, us_ := range uss {
-		u, us := u_, us_
-		if us == nil {
-			continue
-		}
+	par := parallel.NewRun(fetchPar(fs))
+	for i_, u_ := range units {
+		i, u, us := i_, u_, uss[u_]
 
 		par.Acquire()
+		mu.Lock()
+		full := pageFull(fs, numDefs)
+		mu.Unlock()
+		if full {
+			par.Release()
+			break
+		}
+
 		go func() {
 			defer par.Release()
+			sp.opened(u.String())
 			defs, err := us.Defs(filtersForUnit(u, fs).([]DefFilter)...)
 			if err != nil && !isStoreNotExist(err) {
 				par.Error(err)
@@ -73,18 +85,30 @@ func (s unitStores) Defs(fs ...DefFilter) ([]*graph.Def, error) {
 				def.UnitType = u.Type
 				def.Unit = u.Name
 			}
-			allDefsMu.Lock()
-			allDefs = append(allDefs, defs...)
-			allDefsMu.Unlock()
+			if !hasDefsSorter(fs) {
+				sort.Sort(defsInStoreOrder(defs))
+			}
+			mu.Lock()
+			unitDefs[i] = defs
+			numDefs += len(defs)
+			mu.Unlock()
 		}()
 	}
 	err = par.Wait()
-	return allDefs, err
+
+	var allDefs []*graph.Def
+	for _, defs := range unitDefs {
+		allDefs = append(allDefs, defs...)
+	}
+	return pageDefs(allDefs, fs), err
 }
 
 var c_unitStores_Refs_last_numUnitsQueried = &counter{count: new(int64)}
 
-func (s unitStores) Refs(f ...RefFilter) ([]*graph.Ref, error) {
+func (s unitStores) Refs(f ...RefFilter) (refs []*graph.Ref, err error) {
+	sp := traceOf(f).start(s.opener, "Refs")
+	defer func() { sp.end(len(refs), err) }()
+
 	uss, err := openUnitStores(s.opener, f)
 	if err != nil {
 		return nil, err
@@ -92,24 +116,32 @@ func (s unitStores) Refs(f ...RefFilter) ([]*graph.Ref, error) {
 
 	c_unitStores_Refs_last_numUnitsQueried.set(0)
 	var (
-		allRefsMu sync.Mutex
-		allRefs   []*graph.Ref
+		units    = sortedUnits(uss)
+		unitRefs = make([][]*graph.Ref, len(units))
+		numRefs  int
+		mu       sync.Mutex
 	)
-	par := parallel.NewRun(storeFetchPar)
-	for u, us := range uss {
-		if us == nil {
-			continue
+	par := parallel.NewRun(fetchPar(f))
+	for i_, u_ := range units {
+		i, u, us := i_, u_, uss[u_]
+
+		par.Acquire()
+		mu.Lock()
+		full := pageFull(f, numRefs)
+		mu.Unlock()
+		if full {
+			par.Release()
+			break
 		}
-		u, us := u, us
 
 		c_unitStores_Refs_last_numUnitsQueried.increment()
 
-		par.Acquire()
 		go func() {
 			defer par.Release()
 			if _, moreOK := LimitRemaining(f); !moreOK {
 				return
 			}
+			sp.opened(u.String())
 			fCopy := filtersForUnit(u, f).([]RefFilter)
 			fCopy = withImpliedUnit(fCopy, u)
 
@@ -128,14 +160,21 @@ func (s unitStores) Refs(f ...RefFilter) ([]*graph.Ref, error) {
 					ref.DefUnit = u.Name
 				}
 			}
+			sort.Sort(refsInStoreOrder(refs))
 
-			allRefsMu.Lock()
-			allRefs = append(allRefs, refs...)
-			allRefsMu.Unlock()
+			mu.Lock()
+			unitRefs[i] = refs
+			numRefs += len(refs)
+			mu.Unlock()
 		}()
 	}
 	err = par.Wait()
-	return allRefs, err
+
+	var allRefs []*graph.Ref
+	for _, refs := range unitRefs {
+		allRefs = append(allRefs, refs...)
+	}
+	return pageRefs(allRefs, f), err
 }
 
 func cleanForImport(data *graph.Output, repo, unitType, unit string) {
@@ -160,6 +199,21 @@ func cleanForImport(data *graph.Output, repo, unitType, unit string) {
 			ref.DefUnit = ""
 		}
 	}
+	for _, rel := range data.Relations {
+		rel.From.Unit = ""
+		rel.From.UnitType = ""
+		rel.From.Repo = ""
+		rel.From.CommitID = ""
+		if repo != "" && rel.To.DefRepo == repo {
+			rel.To.DefRepo = ""
+		}
+		if unitType != "" && rel.To.DefUnitType == unitType {
+			rel.To.DefUnitType = ""
+		}
+		if unit != "" && rel.To.DefUnit == unit {
+			rel.To.DefUnit = ""
+		}
+	}
 	for _, doc := range data.Docs {
 		doc.Unit = ""
 		doc.UnitType = ""
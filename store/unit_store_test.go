@@ -5,6 +5,7 @@ import (
 	"sort"
 	"testing"
 
+	"sourcegraph.com/sourcegraph/srclib/ann"
 	"sourcegraph.com/sourcegraph/srclib/graph"
 )
 
@@ -19,6 +20,9 @@ func testUnitStore(t *testing.T, newFn func() UnitStoreImporter) {
 	testUnitStore_Refs(t, newFn())
 	testUnitStore_Refs_ByFiles(t, newFn())
 	testUnitStore_Refs_ByDef(t, newFn())
+	testUnitStore_Refs_ByRefKind(t, newFn())
+	testUnitStore_Relations(t, newFn())
+	testUnitStore_Anns(t, newFn())
 }
 
 func testUnitStore_uninitialized(t *testing.T, us UnitStore) {
@@ -396,6 +400,110 @@ func testUnitStore_Refs_ByDef(t *testing.T, us UnitStoreImporter) {
 	}
 }
 
+func testUnitStore_Refs_ByRefKind(t *testing.T, us UnitStoreImporter) {
+	data := graph.Output{
+		Refs: []*graph.Ref{
+			{DefPath: "p1", File: "f1", Start: 0, End: 5, Kind: graph.RefKindRead},
+			{DefPath: "p1", File: "f1", Start: 0, End: 5, Kind: graph.RefKindWrite},
+			{DefPath: "p1", File: "f1", Start: 5, End: 10, Kind: graph.RefKindWrite},
+			{DefPath: "p2", File: "f2", Start: 0, End: 5, Kind: graph.RefKindCall},
+			{DefPath: "p2", File: "f2", Start: 5, End: 10},
+		},
+	}
+	if err := us.Import(data); err != nil {
+		t.Errorf("%s: Import(data): %s", us, err)
+	}
+
+	tests := []struct {
+		filters  []RefFilter
+		wantRefs []*graph.Ref
+	}{
+		{[]RefFilter{ByRefKind(graph.RefKindWrite)}, []*graph.Ref{data.Refs[1], data.Refs[2]}},
+		{[]RefFilter{ByRefKind(graph.RefKindRead, graph.RefKindCall)}, []*graph.Ref{data.Refs[0], data.Refs[3]}},
+		{[]RefFilter{ByRefKind("")}, []*graph.Ref{data.Refs[4]}},
+		{[]RefFilter{ByRefKind(graph.RefKindWrite), ByRefDef(graph.RefDefKey{DefPath: "p1"}), ByFiles(false, "f1")}, []*graph.Ref{data.Refs[1], data.Refs[2]}},
+		{[]RefFilter{ByRefKind(graph.RefKindImport)}, nil},
+	}
+	for _, test := range tests {
+		refs, err := us.Refs(test.filters...)
+		if err != nil {
+			t.Fatalf("%s: Refs(%v): %s", us, test.filters, err)
+		}
+		sort.Sort(refsInStoreOrder(refs))
+		if !reflect.DeepEqual(refs, test.wantRefs) {
+			t.Errorf("%s: Refs(%v): got refs %v, want %v", us, test.filters, refs, test.wantRefs)
+		}
+	}
+}
+
+func testUnitStore_Relations(t *testing.T, us UnitStoreImporter) {
+	data := graph.Output{
+		Relations: []*graph.Relation{
+			{From: graph.DefKey{Path: "T"}, To: graph.RefDefKey{DefPath: "I"}, Kind: graph.RelationImplements},
+			{From: graph.DefKey{Path: "T"}, To: graph.RefDefKey{DefRepo: "r2", DefUnitType: "t", DefUnit: "u2", DefPath: "J"}, Kind: graph.RelationImplements},
+			{From: graph.DefKey{Path: "T/m"}, To: graph.RefDefKey{DefPath: "I/m"}, Kind: graph.RelationOverrides},
+			{From: graph.DefKey{Path: "S"}, To: graph.RefDefKey{DefPath: "T"}, Kind: graph.RelationExtends},
+		},
+	}
+	if err := us.Import(data); err != nil {
+		t.Errorf("%s: Import(data): %s", us, err)
+	}
+	rs, ok := us.(RelationStore)
+	if !ok {
+		t.Errorf("%s: does not implement RelationStore", us)
+		return
+	}
+
+	tests := []struct {
+		filters  []RelationFilter
+		wantRels []*graph.Relation
+	}{
+		{nil, data.Relations},
+		{[]RelationFilter{ByRelationFrom(graph.DefKey{Path: "T"})}, data.Relations[:2]},
+		{[]RelationFilter{ByRelationTo(graph.RefDefKey{DefPath: "I"})}, data.Relations[:1]},
+		{[]RelationFilter{ByRelationTo(graph.RefDefKey{DefRepo: "r2", DefUnitType: "t", DefUnit: "u2", DefPath: "J"})}, data.Relations[1:2]},
+		{[]RelationFilter{ByRelationKinds(graph.RelationOverrides, graph.RelationExtends)}, data.Relations[2:]},
+		{[]RelationFilter{ByRelationTo(graph.RefDefKey{DefPath: "I"}), ByRelationKinds(graph.RelationExtends)}, nil},
+	}
+	for _, test := range tests {
+		rels, err := rs.Relations(test.filters...)
+		if err != nil {
+			t.Fatalf("%s: Relations(%v): %s", us, test.filters, err)
+		}
+		want := append([]*graph.Relation(nil), test.wantRels...)
+		sort.Sort(graph.Relations(rels))
+		sort.Sort(graph.Relations(want))
+		if !reflect.DeepEqual(rels, want) {
+			t.Errorf("%s: Relations(%v): got relations %v, want %v", us, test.filters, rels, want)
+		}
+	}
+}
+
+func testUnitStore_Anns(t *testing.T, us UnitStoreImporter) {
+	data := graph.Output{
+		Anns: []*ann.Ann{
+			{File: "f1", StartLine: 1, EndLine: 2, Type: "t"},
+			{File: "f2", StartLine: 3, EndLine: 3, Type: ann.Link, Data: []byte(`"http://example.com"`)},
+		},
+	}
+	if err := us.Import(data); err != nil {
+		t.Errorf("%s: Import(data): %s", us, err)
+	}
+	as, ok := us.(AnnStore)
+	if !ok {
+		t.Errorf("%s: does not implement AnnStore", us)
+		return
+	}
+
+	anns, err := as.Anns()
+	if err != nil {
+		t.Fatalf("%s: Anns(): %s", us, err)
+	}
+	if !reflect.DeepEqual(anns, data.Anns) {
+		t.Errorf("%s: Anns(): got anns %v, want %v", us, anns, data.Anns)
+	}
+}
+
 func defPaths(defs []*graph.Def) []string {
 	dps := make([]string, len(defs))
 	for i, def := range defs {
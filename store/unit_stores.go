@@ -67,12 +67,31 @@ func openUnitStores(o unitStoreOpener, filters interface{}) (map[unit.ID2]UnitSt
 		return nil, err
 	}
 
+	af := getAfterFilter(filters)
+
 	if unitIDs == nil {
-		return o.openAllUnitStores()
+		uss, err := o.openAllUnitStores()
+		if err != nil || af == nil {
+			return uss, err
+		}
+
+		// Skip units that sort entirely before the After filter's
+		// cursor. Copy the map because the opener might return its
+		// own.
+		uss2 := make(map[unit.ID2]UnitStore, len(uss))
+		for u, us := range uss {
+			if af.unitScope(u) >= 0 {
+				uss2[u] = us
+			}
+		}
+		return uss2, nil
 	}
 
 	uss := make(map[unit.ID2]UnitStore, len(unitIDs))
 	for _, u := range unitIDs {
+		if af != nil && af.unitScope(u) < 0 {
+			continue
+		}
 		uss[u] = o.openUnitStore(u)
 	}
 	return uss, nil
@@ -88,10 +107,20 @@ func filtersForUnit(unit unit.ID2, filters interface{}) interface{} {
 	unitFilters := make([]interface{}, len(sf))
 	copy(unitFilters, sf)
 
+	_, _, paged := pageBounds(sf)
+
 	d := 0 // deleted (-) and added (+) indexes in unitFilters vs. sf
 	for i, f := range sf {
 		switch f := f.(type) {
 
+		case *afterFilter, *limiter:
+			if f2, keep := forChildStore(f, paged, func(af *afterFilter) *afterFilter { return af.forUnit(unit) }); keep {
+				unitFilters[i+d] = f2
+			} else {
+				unitFilters = append(unitFilters[:i+d], unitFilters[i+d+1:]...)
+				d--
+			}
+
 		case unitDefOffsetsFilter:
 			found := false
 			for u, ofs := range f {
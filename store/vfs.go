@@ -0,0 +1,102 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync/atomic"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// A Renamer is a filesystem that can atomically rename files and
+// dirs. The stores use it (if their filesystem implements it) to
+// replace files and break stale locks atomically; rwvfs.FileSystem
+// has no rename operation.
+type Renamer interface {
+	Rename(oldpath, newpath string) error
+}
+
+// OSFS returns a filesystem for the dir root on the local disk,
+// suitable for use as the filesystem of the stores in this
+// package. Unlike rwvfs.OS, it creates parent dirs as needed and
+// implements Renamer.
+func OSFS(root string) rwvfs.WalkableFileSystem {
+	fs := rwvfs.OS(root)
+	setCreateParentDirs(fs)
+	return osFS{rwvfs.Walkable(fs), root}
+}
+
+type osFS struct {
+	rwvfs.WalkableFileSystem
+	root string
+}
+
+func (fs osFS) resolve(p string) string {
+	return filepath.Join(fs.root, filepath.FromSlash(path.Clean("/"+p)))
+}
+
+func (fs osFS) Rename(oldpath, newpath string) error {
+	return os.Rename(fs.resolve(oldpath), fs.resolve(newpath))
+}
+
+// subFS returns a filesystem for the dir prefix in fs. It is like
+// rwvfs.Sub, but it preserves fs's Renamer implementation.
+func subFS(fs rwvfs.FileSystem, prefix string) rwvfs.WalkableFileSystem {
+	sub := rwvfs.Walkable(rwvfs.Sub(fs, prefix))
+	if r, ok := fs.(Renamer); ok {
+		return renamerSubFS{sub, r, prefix}
+	}
+	return sub
+}
+
+type renamerSubFS struct {
+	rwvfs.WalkableFileSystem
+	parent Renamer
+	prefix string
+}
+
+func (fs renamerSubFS) Rename(oldpath, newpath string) error {
+	return fs.parent.Rename(path.Join(fs.prefix, oldpath), path.Join(fs.prefix, newpath))
+}
+
+// tmpFileSeq makes the names of temporary files unique within this
+// process.
+var tmpFileSeq int64
+
+// createAtomic writes a file by calling write with a writer for its
+// contents. If fs is a Renamer, the contents are written to a
+// temporary file that is renamed to name after it has been written
+// successfully, so that readers never observe a partially written
+// file. Otherwise the file is written in place.
+func createAtomic(fs rwvfs.FileSystem, name string, write func(io.Writer) error) error {
+	r, ok := fs.(Renamer)
+	if !ok {
+		return createFile(fs, name, write)
+	}
+
+	tmp := fmt.Sprintf("%s.tmp-%d-%d", name, os.Getpid(), atomic.AddInt64(&tmpFileSeq, 1))
+	if err := createFile(fs, tmp, write); err != nil {
+		fs.Remove(tmp)
+		return err
+	}
+	if err := r.Rename(tmp, name); err != nil {
+		fs.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func createFile(fs rwvfs.FileSystem, name string, write func(io.Writer) error) error {
+	f, err := fs.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -1,6 +1,11 @@
 package toolchain
 
-import "sourcegraph.com/sourcegraph/srclib"
+import (
+	"fmt"
+
+	"sourcegraph.com/sourcegraph/srclib"
+	"sourcegraph.com/sourcegraph/srclib/srcpos"
+)
 
 // ToolInfo describes a tool in a toolchain.
 type ToolInfo struct {
@@ -25,6 +30,36 @@ type ToolInfo struct {
 	// TODO(sqs): determine how repository- or directory-level tools will be
 	// defined.
 	SourceUnitTypes []string `json:",omitempty"`
+
+	// OffsetEncoding is the encoding of the offsets in this tool's
+	// output ("bytes", "runes", or "utf-16"), for tools that emit
+	// graph data. The offsets are converted to byte offsets when the
+	// output is normalized.
+	//
+	// If empty, the tool is assumed to emit rune offsets, except for
+	// the graphers of a few source unit types (such as "GoPackage")
+	// that emitted byte offsets before tools could declare their
+	// offset encoding.
+	OffsetEncoding srcpos.Encoding `json:",omitempty"`
+}
+
+// LookupTool returns the definition of the tool that ref refers to,
+// from its toolchain's Srclibtoolchain file.
+var LookupTool = func(ref *srclib.ToolRef) (*ToolInfo, error) {
+	tc, err := Lookup(ref.Toolchain)
+	if err != nil {
+		return nil, err
+	}
+	c, err := tc.ReadConfig()
+	if err != nil {
+		return nil, err
+	}
+	for _, tool := range c.Tools {
+		if tool.Subcmd == ref.Subcmd {
+			return tool, nil
+		}
+	}
+	return nil, fmt.Errorf("toolchain %q has no tool %q", ref.Toolchain, ref.Subcmd)
 }
 
 // ListTools lists all tools in all available toolchains (returned by List). If